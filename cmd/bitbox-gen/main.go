@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const usage = `Usage: bitbox-gen -type <types> [flags] [file]
+
+Generate MarshalBitbox and UnmarshalBitbox methods for structs defined
+in file, so bitbox.Encode and bitbox.Decode don't use reflection for
+them. File defaults to $GOFILE, so it can be run by go generate:
+
+	//go:generate go run bytedb/cmd/bitbox-gen -type Cmd,Resp
+
+Encoding is the same as with reflection. Fields are tagged with
+bitbox:"varint" to encode integers as varints, bitbox:"-" to skip them
+and bitbox:"nocopy" to decode bytes without copying, pointing into
+decoded data. Fields of types not known to generator are encoded with
+reflection.
+
+`
+
+func main() {
+	fs := flag.NewFlagSet("bitbox-gen", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	typeList := fs.String("type", "", "comma separated list of struct types")
+	out := fs.String("o", "", "output file, <file>_bitbox.go by default")
+
+	err := fs.Parse(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	file := os.Getenv("GOFILE")
+	if fs.NArg() > 0 {
+		file = fs.Arg(0)
+	}
+
+	if file == "" || *typeList == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(file, ".go") + "_bitbox.go"
+	}
+
+	src, err := generate(file, strings.Split(*typeList, ","))
+	if err == nil {
+		err = os.WriteFile(*out, src, 0644)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bitbox-gen:", err)
+		os.Exit(1)
+	}
+}
+
+// Numbers and strings with methods of bitbox.Encoder and bitbox.Buffer.
+// Signed integers are written as unsigned ones of the same size.
+var basic = map[string]string{
+	"bool":    "Bool",
+	"uint8":   "Uint8",
+	"byte":    "Uint8",
+	"int8":    "Uint8",
+	"uint16":  "Uint16",
+	"int16":   "Uint16",
+	"uint32":  "Uint32",
+	"int32":   "Uint32",
+	"rune":    "Uint32",
+	"uint64":  "Uint64",
+	"int64":   "Uint64",
+	"uint":    "Uint64",
+	"int":     "Uint64",
+	"uintptr": "Uint64",
+	"float32": "Float32",
+	"float64": "Float64",
+	"string":  "String",
+}
+
+// Type of value returned by typed bitbox.Buffer methods.
+var returned = map[string]string{
+	"Bool":    "bool",
+	"Uint8":   "uint8",
+	"Uint16":  "uint16",
+	"Uint32":  "uint32",
+	"Uint64":  "uint64",
+	"Float32": "float32",
+	"Float64": "float64",
+	"String":  "[]byte", // read with Bytes
+}
+
+var signed = map[string]bool{"int8": true, "int16": true, "int32": true, "int64": true, "int": true, "rune": true}
+
+// Struct field to encode.
+type field struct {
+	name string
+	typ  string // as written in source
+	opt  string // bitbox tag
+}
+
+// Generate methods for types defined in file and return formatted source.
+func generate(path string, names []string) ([]byte, error) {
+	fset := token.NewFileSet()
+
+	file, err := parser.ParseFile(fset, path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	structs := map[string]*ast.StructType{}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.TypeSpec); ok {
+			if st, ok := spec.Type.(*ast.StructType); ok {
+				structs[spec.Name.Name] = st
+			}
+		}
+
+		return true
+	})
+
+	g := &generator{known: map[string]bool{}}
+
+	for _, name := range names {
+		if structs[name] == nil {
+			return nil, fmt.Errorf("struct %s not found in %s", name, path)
+		}
+
+		g.known[name] = true
+	}
+
+	g.printf("// Code generated by bitbox-gen. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", file.Name.Name)
+	g.printf("import bit %q\n", "bytedb/lib/bitbox")
+
+	for _, name := range names {
+		fields, err := structFields(structs[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		err = g.marshal(name, fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		g.unmarshal(name, fields)
+	}
+
+	return format.Source(g.buf.Bytes())
+}
+
+// Return encoded fields of struct, the same as encoded with reflection.
+func structFields(st *ast.StructType) ([]field, error) {
+	fields := []field{}
+
+	for _, f := range st.Fields.List {
+		opt := ""
+
+		if f.Tag != nil {
+			tag, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			opt = reflect.StructTag(tag).Get("bitbox")
+		}
+
+		names := []string{}
+		for _, n := range f.Names {
+			names = append(names, n.Name)
+		}
+
+		// Embedded field is named after its type
+		if len(f.Names) == 0 {
+			typ := types.ExprString(f.Type)
+			names = append(names, typ[strings.LastIndex(typ, ".")+1:])
+		}
+
+		for _, name := range names {
+			if !ast.IsExported(name) || opt == "-" {
+				continue
+			}
+
+			fields = append(fields, field{name: name, typ: types.ExprString(f.Type), opt: opt})
+		}
+	}
+
+	return fields, nil
+}
+
+type generator struct {
+	buf   bytes.Buffer
+	known map[string]bool // types methods are generated for
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) marshal(name string, fields []field) error {
+	g.printf("\nfunc (v *%s) MarshalBitbox(e *bit.Encoder) {\n", name)
+
+	for _, f := range fields {
+		method := basic[f.typ]
+
+		switch {
+		case f.opt == "varint" && method == "":
+			return fmt.Errorf("field %s: varint tag on %s", f.name, f.typ)
+
+		case f.opt == "varint" && signed[f.typ]:
+			g.printf("e.Varint(int64(v.%s))\n", f.name)
+
+		case f.opt == "varint":
+			g.printf("e.Uvarint(uint64(v.%s))\n", f.name)
+
+		case f.typ == "[]byte" || f.typ == "[]uint8":
+			g.printf("e.Bytes(v.%s)\n", f.name)
+
+		case g.known[f.typ]:
+			g.printf("v.%s.MarshalBitbox(e)\n", f.name)
+
+		case method == "":
+			g.printf("e.Encode(&v.%s)\n", f.name)
+
+		case returned[method] == f.typ || method == "String":
+			g.printf("e.%s(v.%s)\n", method, f.name)
+
+		default:
+			g.printf("e.%s(%s(v.%s))\n", method, returned[method], f.name)
+		}
+	}
+
+	g.printf("}\n")
+	return nil
+}
+
+func (g *generator) unmarshal(name string, fields []field) {
+	g.printf("\nfunc (v *%s) UnmarshalBitbox(b *bit.Buffer) error {\n", name)
+
+	for _, f := range fields {
+		method := basic[f.typ]
+
+		switch {
+		case f.opt == "varint" && signed[f.typ]:
+			g.read("Varint")
+			g.printf("if int64(%s(x)) != x {\nreturn bit.ErrOverflow\n}\n\n", f.typ)
+			g.printf("v.%s = %s(x)\n}\n\n", f.name, f.typ)
+
+		case f.opt == "varint":
+			g.read("Uvarint")
+			g.printf("if uint64(%s(x)) != x {\nreturn bit.ErrOverflow\n}\n\n", f.typ)
+			g.printf("v.%s = %s(x)\n}\n\n", f.name, f.typ)
+
+		case (f.typ == "[]byte" || f.typ == "[]uint8") && f.opt == "nocopy":
+			g.read("Bytes")
+			g.printf("v.%s = x\n}\n\n", f.name)
+
+		case f.typ == "[]byte" || f.typ == "[]uint8":
+			g.read("Bytes")
+			g.printf("v.%s = append([]byte(nil), x...)\n}\n\n", f.name)
+
+		case g.known[f.typ]:
+			g.printf("if err := v.%s.UnmarshalBitbox(b); err != nil {\nreturn err\n}\n\n", f.name)
+
+		case method == "":
+			g.printf("if err := b.Decode(&v.%s); err != nil {\nreturn err\n}\n\n", f.name)
+
+		case method == "String":
+			g.read("Bytes")
+			g.printf("v.%s = %s(x)\n}\n\n", f.name, f.typ)
+
+		case returned[method] == f.typ:
+			g.read(method)
+			g.printf("v.%s = x\n}\n\n", f.name)
+
+		default:
+			g.read(method)
+			g.printf("v.%s = %s(x)\n}\n\n", f.name, f.typ)
+		}
+	}
+
+	g.printf("return nil\n}\n")
+}
+
+// Open block reading field into x.
+func (g *generator) read(method string) {
+	g.printf("{\nx, err := b.%s()\nif err != nil {\nreturn err\n}\n\n", method)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytedb/tests"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Generated files in repository must match their sources.
+func TestGenerated(t *testing.T) {
+	directive := regexp.MustCompile(`//go:generate go run bytedb/cmd/bitbox-gen -type (\S+)`)
+
+	for _, path := range []string{"../../server/cmd.go", "../../db/quota.go", "../../db/watch.go"} {
+		src, _ := os.ReadFile(path)
+
+		m := directive.FindSubmatch(src)
+		tests.Assert(t, true, m != nil)
+
+		code, err := generate(path, strings.Split(string(m[1]), ","))
+		tests.Assert(t, nil, err)
+
+		expected, _ := os.ReadFile(strings.TrimSuffix(path, ".go") + "_bitbox.go")
+		tests.Assert(t, string(expected), string(code))
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "item.go")
+
+	os.WriteFile(path, []byte(`package item
+
+type Item struct {
+	ID     uint32 `+"`bitbox:\"varint\"`"+`
+	Delta  int64  `+"`bitbox:\"varint\"`"+`
+	Size   int16
+	Name   string
+	Data   []byte `+"`bitbox:\"nocopy\"`"+`
+	Cache  []byte `+"`bitbox:\"-\"`"+`
+	Tags   []string
+	Child  Child
+	hidden int
+}
+
+type Child struct {
+	Value []byte
+}
+`), 0644)
+
+	code, err := generate(path, []string{"Item", "Child"})
+	tests.Assert(t, nil, err)
+
+	src := string(code)
+
+	for _, line := range []string{
+		"e.Uvarint(uint64(v.ID))",
+		"e.Varint(int64(v.Delta))",
+		"e.Uint16(uint16(v.Size))",
+		"e.String(v.Name)",
+		"e.Bytes(v.Data)",
+		"e.Encode(&v.Tags)",
+		"v.Child.MarshalBitbox(e)",
+		"return bit.ErrOverflow",
+		"v.Size = int16(x)",
+		"v.Name = string(x)",
+		"v.Data = x\n",
+		"v.Value = append([]byte(nil), x...)",
+		"b.Decode(&v.Tags)",
+		"v.Child.UnmarshalBitbox(b)",
+	} {
+		tests.Assert(t, true, strings.Contains(src, line))
+	}
+
+	tests.Assert(t, false, strings.Contains(src, "Cache"))
+	tests.Assert(t, false, strings.Contains(src, "hidden"))
+
+	_, err = generate(path, []string{"Missing"})
+	tests.Assert(t, true, err != nil)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrInterrupted = errors.New("interrupted")
+
+// Max number of lines kept in history
+const HistorySize = 1000
+
+// Key codes
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyBackspace = 8
+	keyTab       = 9
+	keyEnter     = 13
+	keyCtrlU     = 21
+	keyEsc       = 27
+	keyDelete    = 127
+)
+
+// Line editor with history and tab completion. When input is not
+// a terminal, lines are read as they are, without editing.
+type Editor struct {
+	Prompt   string
+	History  []string
+	Complete func(line string) []string
+
+	in   *bufio.Reader
+	out  io.Writer
+	term *terminal // nil if input is not a terminal
+}
+
+func NewEditor(in *os.File, out io.Writer) *Editor {
+	e := &Editor{in: bufio.NewReader(in), out: out}
+
+	term, err := openTerminal(in)
+	if err == nil {
+		e.term = term
+	}
+
+	return e
+}
+
+// Read single line. Return io.EOF on Ctrl-D or end of input,
+// ErrInterrupted on Ctrl-C. Prompt is shown only on terminal.
+func (e *Editor) ReadLine() (string, error) {
+	if e.term == nil {
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	err := e.term.raw()
+	if err != nil {
+		return "", err
+	}
+	defer e.term.restore()
+
+	fmt.Fprint(e.out, e.Prompt)
+
+	line, err := e.edit()
+	fmt.Fprint(e.out, "\r\n")
+
+	return line, err
+}
+
+// Add line to history, skipping empty ones and repeats.
+func (e *Editor) AddHistory(line string) {
+	if line == "" || (len(e.History) > 0 && e.History[len(e.History)-1] == line) {
+		return
+	}
+
+	e.History = append(e.History, line)
+
+	if len(e.History) > HistorySize {
+		e.History = e.History[len(e.History)-HistorySize:]
+	}
+}
+
+// Load history from file, missing file is not an error.
+func (e *Editor) LoadHistory(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		e.AddHistory(line)
+	}
+
+	return nil
+}
+
+// Save history to file
+func (e *Editor) SaveHistory(path string) error {
+	data := strings.Join(e.History, "\n") + "\n"
+	return os.WriteFile(path, []byte(data), 0600)
+}
+
+// Edit line in raw mode until enter is pressed.
+func (e *Editor) edit() (string, error) {
+	buf := []rune{}
+	pos := 0
+
+	// Position in history, len(History) is the line being edited.
+	hist := len(e.History)
+	saved := ""
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case keyEnter, '\n':
+			return string(buf), nil
+
+		case keyCtrlC:
+			return "", ErrInterrupted
+
+		case keyCtrlD:
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+
+		case keyBackspace, keyDelete:
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+
+		case keyCtrlA:
+			pos = 0
+
+		case keyCtrlE:
+			pos = len(buf)
+
+		case keyCtrlU:
+			buf, pos = buf[pos:], 0
+
+		case keyTab:
+			buf, pos = e.complete(buf, pos)
+
+		case keyEsc:
+			seq := e.escape()
+
+			switch seq {
+			case "[A", "[B": // up, down
+				if hist == len(e.History) {
+					saved = string(buf)
+				}
+
+				if seq == "[A" && hist > 0 {
+					hist--
+				}
+
+				if seq == "[B" && hist < len(e.History) {
+					hist++
+				}
+
+				line := saved
+				if hist < len(e.History) {
+					line = e.History[hist]
+				}
+
+				buf = []rune(line)
+				pos = len(buf)
+			case "[C": // right
+				pos = min(pos+1, len(buf))
+			case "[D": // left
+				pos = max(pos-1, 0)
+			case "[H", "OH", "[1~":
+				pos = 0
+			case "[F", "OF", "[4~":
+				pos = len(buf)
+			case "[3~": // delete
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+
+		default:
+			if r < ' ' {
+				continue // ignore other control keys
+			}
+
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+
+		e.refresh(buf, pos)
+	}
+}
+
+// Read rest of escape sequence, like "[A" for up arrow.
+func (e *Editor) escape() string {
+	seq := []byte{}
+
+	for len(seq) < 4 {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			break
+		}
+
+		seq = append(seq, b)
+
+		// Sequence ends with letter or tilde
+		if len(seq) > 1 && (b >= 'A' && b <= 'Z' || b == '~') {
+			break
+		}
+	}
+
+	return string(seq)
+}
+
+// Complete text before cursor. Single match is inserted,
+// with more of them common prefix is inserted and all are listed.
+func (e *Editor) complete(buf []rune, pos int) ([]rune, int) {
+	if e.Complete == nil {
+		return buf, pos
+	}
+
+	head := string(buf[:pos])
+	matches := e.Complete(head)
+
+	if len(matches) == 0 {
+		return buf, pos
+	}
+
+	word := matches[0]
+	if len(matches) == 1 {
+		word += " "
+	}
+
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, word) {
+			word = word[:len(word)-1]
+		}
+	}
+
+	if len(matches) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(matches, "  "))
+	}
+
+	if len(word) < len(head) {
+		return buf, pos
+	}
+
+	tail := buf[pos:]
+	buf = append([]rune(word), tail...)
+
+	return buf, len([]rune(word))
+}
+
+// Redraw prompt and line, and move cursor to pos.
+func (e *Editor) refresh(buf []rune, pos int) {
+	s := "\r" + e.Prompt + string(buf) + "\x1b[K"
+
+	if back := len(buf) - pos; back > 0 {
+		s += fmt.Sprintf("\x1b[%dD", back)
+	}
+
+	fmt.Fprint(e.out, s)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytedb/tests"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Create editor reading keys from input, as if it was a terminal
+func testEditor(input string) *Editor {
+	return &Editor{
+		in:       bufio.NewReader(strings.NewReader(input)),
+		out:      io.Discard,
+		Complete: complete,
+	}
+}
+
+func TestEditorEdit(t *testing.T) {
+	// Typing, backspace and cursor movement
+	e := testEditor("gte\x7f\x7fet\x1b[D\x1b[D\x7fg\r")
+	line, _ := e.edit()
+	tests.Assert(t, "get", line)
+
+	// Ctrl-A and Ctrl-E
+	e = testEditor("et\x01g\x05 key\r")
+	line, _ = e.edit()
+	tests.Assert(t, "get key", line)
+
+	// Ctrl-U
+	e = testEditor("abc\x15get\r")
+	line, _ = e.edit()
+	tests.Assert(t, "get", line)
+
+	_, err := testEditor("\x04").edit()
+	tests.Assert(t, io.EOF, err)
+
+	_, err = testEditor("ab\x03").edit()
+	tests.Assert(t, ErrInterrupted, err)
+}
+
+func TestEditorHistory(t *testing.T) {
+	e := testEditor("\x1b[A\x1b[A\r\x1b[A\x1b[B\r")
+	e.AddHistory("stats")
+	e.AddHistory("get key")
+	e.AddHistory("get key")
+	e.AddHistory("")
+
+	tests.Assert(t, 2, len(e.History))
+
+	line, _ := e.edit()
+	tests.Assert(t, "stats", line)
+
+	// Down arrow returns to edited line
+	line, _ = e.edit()
+	tests.Assert(t, "", line)
+}
+
+func TestEditorComplete(t *testing.T) {
+	e := testEditor("ge\tkey\r")
+	line, _ := e.edit()
+	tests.Assert(t, "get key", line)
+
+	// Common prefix of "delete" and "display"
+	e = testEditor("d\tisp\t\r")
+	line, _ = e.edit()
+	tests.Assert(t, "display ", line)
+}
+
+func TestEditorHistoryFile(t *testing.T) {
+	path := t.TempDir() + "/history"
+
+	e := testEditor("")
+	tests.Assert(t, nil, e.LoadHistory(path))
+
+	e.AddHistory("get key")
+	e.AddHistory("stats")
+	e.SaveHistory(path)
+
+	e = testEditor("")
+	e.LoadHistory(path)
+	tests.AssertEqual(t, []string{"get key", "stats"}, e.History)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytedb/server"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Exit codes
+const (
+	ExitOK       = 0
+	ExitError    = 1
+	ExitNotFound = 2 // key doesn't exist, returned by get and delete
+)
+
+const usage = `Usage: bytedb-cli [flags] [command [args...]]
+
+Without command interactive shell is started. Keys have
+"coll::namespace::prefix::key" form, where "::" inside names
+is escaped as "\:\:" and other bytes can be given as \xHH.
+
+Flags:
+`
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6666", "server address")
+	format := flag.String("format", FormatText, "output format: text or json")
+	display := flag.String("display", DisplayUTF8, "how keys and values are shown: utf8, hex or base64")
+	timeout := flag.Duration("timeout", 5*time.Second, "deadline of single command")
+	history := flag.String("history", historyPath(), "history file, empty disables history")
+
+	opts := &server.ClientOptions{}
+
+	flag.BoolVar(&opts.TLS, "tls", false, "connect using TLS")
+	flag.StringVar(&opts.CAFile, "ca", "", "PEM CA bundle for verifying server")
+	flag.StringVar(&opts.ServerName, "server-name", "", "server name for TLS verification")
+	flag.StringVar(&opts.CertFile, "cert", "", "PEM client certificate for mutual TLS")
+	flag.StringVar(&opts.KeyFile, "key", "", "PEM private key for client certificate")
+	flag.StringVar(&opts.User, "user", "", "user name")
+	flag.StringVar(&opts.Password, "password", "", "user password")
+	flag.StringVar(&opts.Token, "token", "", "authentication token")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	opts.PoolSize = 1
+	opts.Timeout = *timeout
+
+	cli, err := server.NewClientWith(*addr, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't connect to %s: %s\n", *addr, err)
+		os.Exit(ExitError)
+	}
+	defer cli.Close()
+
+	sh := &Shell{Client: cli, Out: os.Stdout, Timeout: *timeout}
+
+	err = sh.setFormat(context.Background(), []string{*format})
+	if err == nil {
+		err = sh.setDisplay(context.Background(), []string{*display})
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -format or -display")
+		os.Exit(ExitError)
+	}
+
+	// One-shot mode
+	if flag.NArg() > 0 {
+		os.Exit(exitCode(sh.Run(flag.Args())))
+	}
+
+	repl(sh, *addr, *history)
+}
+
+// Run interactive shell until quit or Ctrl-D.
+func repl(sh *Shell, addr, history string) {
+	ed := NewEditor(os.Stdin, os.Stdout)
+	ed.Prompt = addr + "> "
+	ed.Complete = complete
+
+	if history != "" {
+		ed.LoadHistory(history)
+		defer ed.SaveHistory(history)
+	}
+
+	for {
+		line, err := ed.ReadLine()
+		if err == ErrInterrupted {
+			continue
+		}
+
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		ed.AddHistory(line)
+
+		args, err := splitArgs(line)
+		if err == nil {
+			err = sh.Run(args)
+		}
+
+		if err == ErrQuit {
+			return
+		}
+
+		if err != nil && err != ErrNotFound {
+			fmt.Fprintf(os.Stderr, "(error) %s\n", err)
+		}
+	}
+}
+
+// Return exit code for command error and print it.
+func exitCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, ErrQuit):
+		return ExitOK
+	case errors.Is(err, ErrNotFound):
+		return ExitNotFound
+	}
+
+	fmt.Fprintln(os.Stderr, err)
+	return ExitError
+}
+
+// Return default history path in home directory
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".bytedb_history")
+}
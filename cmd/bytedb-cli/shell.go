@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bytedb/db"
+	"bytedb/server"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrUsage    = errors.New("invalid arguments")
+	ErrNotFound = errors.New("key not found")
+	ErrQuit     = errors.New("quit")
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Display modes for keys and values
+const (
+	DisplayUTF8   = "utf8"
+	DisplayHex    = "hex"
+	DisplayBase64 = "base64"
+)
+
+// Shell runs commands with client and writes their results.
+type Shell struct {
+	Client  *server.Client
+	Out     io.Writer
+	Format  string        // text or json
+	Display string        // utf8, hex or base64
+	Timeout time.Duration // deadline of single command
+}
+
+// Shell command
+type Command struct {
+	Name  string
+	Args  string // arguments shown in help
+	Usage string
+	Run   func(sh *Shell, ctx context.Context, args []string) error
+}
+
+// All shell commands
+var Commands = []*Command{}
+
+func init() {
+	Commands = []*Command{
+		{"add", "<key> <value>", "write value, key is coll::namespace::prefix::key", (*Shell).add},
+		{"get", "<key>", "read value", (*Shell).get},
+		{"delete", "<key>", "delete key", (*Shell).delete},
+		{"scan", "<coll::namespace::prefix> [cursor] [count]", "list keys with values, starting at cursor", (*Shell).scan},
+		{"list-collections", "", "list collection hashes", (*Shell).listCollections},
+		{"stats", "", "show server statistics", (*Shell).stats},
+		{"quota", "<coll> [max-keys max-bytes max-value-size]", "show collection quota and usage or set quota, 0 is no limit", (*Shell).quota},
+		{"slowlog", "[count|reset]", "show the newest slow commands or clear slow log", (*Shell).slowLog},
+		{"backup", "<file>", "write database backup archive to file", (*Shell).backup},
+		{"format", "<text|json>", "set output format", (*Shell).setFormat},
+		{"display", "<utf8|hex|base64>", "set how keys and values are shown", (*Shell).setDisplay},
+		{"help", "", "show commands", (*Shell).help},
+		{"quit", "", "exit shell", (*Shell).quit},
+	}
+}
+
+// Find command by name
+func findCommand(name string) *Command {
+	for _, cmd := range Commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+// Run single command given as list of arguments
+func (sh *Shell) Run(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	cmd := findCommand(strings.ToLower(args[0]))
+	if cmd == nil {
+		return fmt.Errorf("unknown command: %s, try help", args[0])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sh.Timeout)
+	defer cancel()
+
+	err := cmd.Run(sh, ctx, args[1:])
+	if err == ErrUsage {
+		return fmt.Errorf("usage: %s %s", cmd.Name, cmd.Args)
+	}
+
+	return err
+}
+
+func (sh *Shell) add(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return ErrUsage
+	}
+
+	key, err := server.ParseKeyRef(args[0])
+	if err != nil {
+		return err
+	}
+
+	val, err := parseValue(args[1])
+	if err != nil {
+		return err
+	}
+
+	err = sh.Client.Add(ctx, key, val)
+	if err != nil {
+		return err
+	}
+
+	return sh.print("OK", map[string]any{"ok": true})
+}
+
+func (sh *Shell) get(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+
+	key, err := server.ParseKeyRef(args[0])
+	if err != nil {
+		return err
+	}
+
+	val, err := sh.Client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if val == nil {
+		sh.print("(nil)", map[string]any{"key": key.String(), "value": nil})
+		return ErrNotFound
+	}
+
+	return sh.print(sh.show(val), map[string]any{"key": key.String(), "value": sh.show(val)})
+}
+
+func (sh *Shell) delete(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+
+	key, err := server.ParseKeyRef(args[0])
+	if err != nil {
+		return err
+	}
+
+	ok, err := sh.Client.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		sh.print("(not found)", map[string]any{"deleted": false})
+		return ErrNotFound
+	}
+
+	return sh.print("OK", map[string]any{"deleted": true})
+}
+
+func (sh *Shell) scan(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 3 {
+		return ErrUsage
+	}
+
+	prefix, err := server.ParseKeyRef(args[0] + "::")
+	if err != nil {
+		return err
+	}
+
+	cursor, count := uint64(0), uint64(0)
+
+	if len(args) > 1 {
+		cursor, err = strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cursor: %s", args[1])
+		}
+	}
+
+	if len(args) > 2 {
+		count, err = strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid count: %s", args[2])
+		}
+	}
+
+	entries, next, err := sh.Client.Scan(ctx, prefix, cursor, uint32(count))
+	if err != nil {
+		return err
+	}
+
+	text := strings.Builder{}
+	list := []map[string]string{}
+
+	for _, e := range entries {
+		fmt.Fprintf(&text, "%s => %s\n", sh.show(e.Key), sh.show(e.Value))
+		list = append(list, map[string]string{"key": sh.show(e.Key), "value": sh.show(e.Value)})
+	}
+
+	fmt.Fprintf(&text, "cursor: %d", next)
+
+	return sh.print(text.String(), map[string]any{"cursor": strconv.FormatUint(next, 10), "entries": list})
+}
+
+func (sh *Shell) listCollections(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+
+	hashes, err := sh.Client.Collections(ctx)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{}
+	for _, h := range hashes {
+		lines = append(lines, fmt.Sprintf("%016x", h))
+	}
+
+	return sh.print(strings.Join(lines, "\n"), map[string]any{"collections": lines})
+}
+
+func (sh *Shell) stats(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+
+	s, err := sh.Client.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	stats := map[string]any{
+		"uptime":      s.Uptime,
+		"connections": s.Connections,
+		"workers":     s.Workers,
+		"collections": s.Collections,
+		"buckets":     s.Buckets,
+		"size":        s.Size,
+		"lsn":         s.LSN,
+	}
+
+	names := []string{}
+	for name := range stats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	lines := []string{}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s: %v", name, stats[name]))
+	}
+
+	return sh.print(strings.Join(lines, "\n"), stats)
+}
+
+func (sh *Shell) quota(ctx context.Context, args []string) error {
+	if len(args) != 1 && len(args) != 4 {
+		return ErrUsage
+	}
+
+	if len(args) == 4 {
+		limits := []uint64{}
+
+		for _, arg := range args[1:] {
+			n, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid limit: %s", arg)
+			}
+
+			limits = append(limits, n)
+		}
+
+		q := db.Quota{MaxKeys: limits[0], MaxBytes: limits[1], MaxValueSize: limits[2]}
+
+		err := sh.Client.SetQuota(ctx, args[0], q)
+		if err != nil {
+			return err
+		}
+
+		return sh.print("OK", map[string]any{"ok": true})
+	}
+
+	info, err := sh.Client.Quota(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	res := map[string]any{
+		"max_keys":       info.Quota.MaxKeys,
+		"max_bytes":      info.Quota.MaxBytes,
+		"max_value_size": info.Quota.MaxValueSize,
+		"keys":           info.Usage.Keys,
+		"bytes":          info.Usage.Bytes,
+		"disk":           info.Usage.Disk,
+	}
+
+	lines := []string{
+		fmt.Sprintf("keys: %d / %s", info.Usage.Keys, limit(info.Quota.MaxKeys)),
+		fmt.Sprintf("bytes: %d / %s", info.Usage.Bytes, limit(info.Quota.MaxBytes)),
+		fmt.Sprintf("max value size: %s", limit(info.Quota.MaxValueSize)),
+		fmt.Sprintf("disk: %d", info.Usage.Disk),
+	}
+
+	return sh.print(strings.Join(lines, "\n"), res)
+}
+
+// Format quota limit, 0 is no limit.
+func limit(n uint64) string {
+	if n == 0 {
+		return "unlimited"
+	}
+
+	return strconv.FormatUint(n, 10)
+}
+
+func (sh *Shell) slowLog(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return ErrUsage
+	}
+
+	if len(args) == 1 && args[0] == "reset" {
+		err := sh.Client.ResetSlowLog(ctx)
+		if err != nil {
+			return err
+		}
+
+		return sh.print("OK", map[string]any{"ok": true})
+	}
+
+	count := 10
+
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid count: %s", args[0])
+		}
+
+		count = n
+	}
+
+	entries, err := sh.Client.SlowLog(ctx, count)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{}
+	list := []map[string]any{}
+
+	for _, e := range entries {
+		start := time.Unix(0, e.Time).UTC().Format(time.RFC3339Nano)
+
+		lines = append(lines, fmt.Sprintf("%d %s %s %s %s %s (queue %s, index %s, blocks %s, wal %s)",
+			e.ID, start, e.Addr, server.CmdName(e.Cmd), sh.show(e.Key), e.Duration, e.Queue, e.Index, e.Blocks, e.Wal))
+
+		list = append(list, map[string]any{
+			"id":       e.ID,
+			"time":     start,
+			"addr":     e.Addr,
+			"cmd":      server.CmdName(e.Cmd),
+			"key":      sh.show(e.Key),
+			"duration": e.Duration.Microseconds(),
+			"queue":    e.Queue.Microseconds(),
+			"index":    e.Index.Microseconds(),
+			"blocks":   e.Blocks.Microseconds(),
+			"wal":      e.Wal.Microseconds(),
+		})
+	}
+
+	return sh.print(strings.Join(lines, "\n"), map[string]any{"entries": list})
+}
+
+func (sh *Shell) backup(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+
+	// Partial archive is never left under the final name.
+	tmp := args[0] + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	// Backup of big database takes longer than command timeout.
+	err = sh.Client.Backup(context.Background(), f)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	f.Close()
+
+	if err == nil {
+		err = os.Rename(tmp, args[0])
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return sh.print("OK", map[string]any{"ok": true, "file": args[0]})
+}
+
+func (sh *Shell) setFormat(ctx context.Context, args []string) error {
+	if len(args) != 1 || (args[0] != FormatText && args[0] != FormatJSON) {
+		return ErrUsage
+	}
+
+	sh.Format = args[0]
+	return nil
+}
+
+func (sh *Shell) setDisplay(ctx context.Context, args []string) error {
+	if len(args) != 1 || (args[0] != DisplayUTF8 && args[0] != DisplayHex && args[0] != DisplayBase64) {
+		return ErrUsage
+	}
+
+	sh.Display = args[0]
+	return nil
+}
+
+func (sh *Shell) help(ctx context.Context, args []string) error {
+	for _, cmd := range Commands {
+		fmt.Fprintf(sh.Out, "  %-18s %-45s %s\n", cmd.Name, cmd.Args, cmd.Usage)
+	}
+
+	fmt.Fprintln(sh.Out, "\nValues prefixed with hex: or base64: are decoded before writing.")
+	return nil
+}
+
+func (sh *Shell) quit(ctx context.Context, args []string) error {
+	return ErrQuit
+}
+
+// Write text or JSON result, depending on output format
+func (sh *Shell) print(text string, obj any) error {
+	if sh.Format == FormatJSON {
+		return json.NewEncoder(sh.Out).Encode(obj)
+	}
+
+	if text != "" {
+		fmt.Fprintln(sh.Out, text)
+	}
+
+	return nil
+}
+
+// Return bytes in current display mode
+func (sh *Shell) show(data []byte) string {
+	switch sh.Display {
+	case DisplayHex:
+		return hex.EncodeToString(data)
+	case DisplayBase64:
+		return base64.StdEncoding.EncodeToString(data)
+	}
+
+	return escapeUTF8(data)
+}
+
+// Return printable UTF-8 text as is. Backslash, invalid UTF-8 and
+// non-printable bytes are escaped, so binary data is visible.
+func escapeUTF8(data []byte) string {
+	s := strings.Builder{}
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+
+		switch {
+		case r == '\\':
+			s.WriteString(`\\`)
+		case r == utf8.RuneError || !unicode.IsPrint(r):
+			for _, b := range data[:size] {
+				fmt.Fprintf(&s, `\x%02x`, b)
+			}
+		default:
+			s.Write(data[:size])
+		}
+
+		data = data[size:]
+	}
+
+	return s.String()
+}
+
+// Parse value argument, "hex:" and "base64:" prefixes
+// select encoding, other values are used as is.
+func parseValue(arg string) ([]byte, error) {
+	if data, ok := strings.CutPrefix(arg, "hex:"); ok {
+		return hex.DecodeString(data)
+	}
+
+	if data, ok := strings.CutPrefix(arg, "base64:"); ok {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	return []byte(arg), nil
+}
+
+// Split line into arguments separated by spaces. Single and double
+// quotes group arguments containing spaces, backslashes are kept,
+// so they can be used in key escapes.
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	arg := strings.Builder{}
+
+	inArg := false
+	quote := rune(0)
+
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+// Return commands starting with the first word of line. Only the
+// command name is completed, so arguments return no candidates.
+func complete(line string) []string {
+	if strings.ContainsAny(line, " \t") {
+		return nil
+	}
+
+	matches := []string{}
+
+	for _, cmd := range Commands {
+		if strings.HasPrefix(cmd.Name, strings.ToLower(line)) {
+			matches = append(matches, cmd.Name)
+		}
+	}
+
+	return matches
+}
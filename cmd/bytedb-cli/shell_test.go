@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytedb/db"
+	"bytedb/server"
+	"bytedb/tests"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start server and return shell connected to it
+func runShell(t *testing.T) (*Shell, *bytes.Buffer) {
+	database, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.NewServer(database)
+	srv.SlowThreshold = time.Nanosecond // every command is slow
+	srv.RunWorkers(2)
+
+	sock, _ := net.Listen("tcp", "127.0.0.1:0")
+
+	go func() {
+		for {
+			c, err := sock.Accept()
+			if err != nil {
+				return
+			}
+
+			go srv.HandleConn(server.NewConn(c))
+		}
+	}()
+
+	cli, err := server.NewClient(sock.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cli.Close()
+		sock.Close()
+		srv.Close()
+	})
+
+	out := &bytes.Buffer{}
+	sh := &Shell{Client: cli, Out: out, Format: FormatText, Display: DisplayUTF8, Timeout: time.Second}
+
+	return sh, out
+}
+
+func TestShellCommands(t *testing.T) {
+	sh, out := runShell(t)
+
+	tests.Assert(t, nil, sh.Run([]string{"add", "c::n::p::key_1", "hello"}))
+	tests.Assert(t, nil, sh.Run([]string{"add", `c::n::p::key\:\:2`, "hex:00ff"}))
+
+	out.Reset()
+	sh.Run([]string{"get", "c::n::p::key_1"})
+	tests.Assert(t, "hello\n", out.String())
+
+	out.Reset()
+	sh.Run([]string{"get", `c::n::p::key\:\:2`})
+	tests.Assert(t, `\x00\xff`+"\n", out.String())
+
+	sh.Display = DisplayHex
+	out.Reset()
+	sh.Run([]string{"get", `c::n::p::key\:\:2`})
+	tests.Assert(t, "00ff\n", out.String())
+
+	tests.Assert(t, ErrNotFound, sh.Run([]string{"get", "c::n::p::missing"}))
+	tests.Assert(t, ErrNotFound, sh.Run([]string{"delete", "c::n::p::missing"}))
+	tests.Assert(t, nil, sh.Run([]string{"delete", "c::n::p::key_1"}))
+
+	err := sh.Run([]string{"get"})
+	tests.Assert(t, "usage: get <key>", err.Error())
+
+	err = sh.Run([]string{"merge"})
+	tests.AssertNot(t, nil, err)
+}
+
+func TestShellJSON(t *testing.T) {
+	sh, out := runShell(t)
+	sh.Format = FormatJSON
+	sh.Display = DisplayBase64
+
+	sh.Run([]string{"add", "c::n::p::key_1", "val"})
+	sh.Run([]string{"add", "c::n::p::key_2", "val"})
+
+	out.Reset()
+	sh.Run([]string{"scan", "c::n::p"})
+
+	res := struct {
+		Cursor  string
+		Entries []map[string]string
+	}{}
+
+	tests.Assert(t, nil, json.Unmarshal(out.Bytes(), &res))
+	tests.Assert(t, "0", res.Cursor)
+	tests.Assert(t, 2, len(res.Entries))
+	tests.Assert(t, "dmFs", res.Entries[0]["value"])
+
+	out.Reset()
+	sh.Run([]string{"list-collections"})
+	hash := fmt.Sprintf("%016x", server.Hash([]byte("c")))
+	tests.Assert(t, `{"collections":["`+hash+`"]}`+"\n", out.String())
+
+	out.Reset()
+	sh.Run([]string{"stats"})
+
+	stats := map[string]float64{}
+	json.Unmarshal(out.Bytes(), &stats)
+	tests.Assert(t, float64(1), stats["collections"])
+
+	out.Reset()
+	sh.Run([]string{"slowlog", "1"})
+
+	slow := struct{ Entries []map[string]any }{}
+	json.Unmarshal(out.Bytes(), &slow)
+	tests.Assert(t, 1, len(slow.Entries))
+	tests.Assert(t, "stats", slow.Entries[0]["cmd"])
+
+	out.Reset()
+	tests.Assert(t, nil, sh.Run([]string{"quota", "c", "3", "0", "10"}))
+	tests.Assert(t, nil, sh.Run([]string{"add", "c::n::p::key_3", "val"}))
+
+	err := sh.Run([]string{"add", "c::n::p::key_4", "val"})
+	tests.Assert(t, true, errors.Is(err, db.ErrQuotaExceeded))
+
+	out.Reset()
+	sh.Run([]string{"quota", "c"})
+
+	quota := map[string]float64{}
+	json.Unmarshal(out.Bytes(), &quota)
+	tests.Assert(t, float64(3), quota["max_keys"])
+	tests.Assert(t, float64(3), quota["keys"])
+
+	path := t.TempDir() + "/backup.tar"
+	tests.Assert(t, nil, sh.Run([]string{"backup", path}))
+
+	f, _ := os.Open(path)
+	defer f.Close()
+
+	tests.Assert(t, nil, db.Restore(f, t.TempDir()+"/restored"))
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, _ := splitArgs(`add  "c::n::p::my key" 'a "b"' c\:d`)
+	tests.AssertEqual(t, []string{"add", "c::n::p::my key", `a "b"`, `c\:d`}, args)
+
+	args, _ = splitArgs(`get ""`)
+	tests.AssertEqual(t, []string{"get", ""}, args)
+
+	_, err := splitArgs(`get "key`)
+	tests.AssertNot(t, nil, err)
+}
+
+func TestEscapeUTF8(t *testing.T) {
+	tests.Assert(t, "zażółć", escapeUTF8([]byte("zażółć")))
+	tests.Assert(t, `a\\b\x00\xff\x0a`, escapeUTF8([]byte("a\\b\x00\xff\n")))
+}
+
+func TestComplete(t *testing.T) {
+	tests.AssertEqual(t, []string{"delete", "display"}, complete("d"))
+	tests.AssertEqual(t, []string{"list-collections"}, complete("LIST"))
+	tests.Assert(t, 0, len(complete("get c::")))
+	tests.Assert(t, true, strings.HasPrefix(Commands[0].Name, "add"))
+}
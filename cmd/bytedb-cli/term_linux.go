@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Terminal with its original settings
+type terminal struct {
+	fd    uintptr
+	state syscall.Termios
+}
+
+// Return terminal for file, fails if file is not a terminal.
+func openTerminal(f *os.File) (*terminal, error) {
+	t := &terminal{fd: f.Fd()}
+
+	err := ioctl(t.fd, syscall.TCGETS, &t.state)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Switch to raw mode, input is read byte by byte without echo.
+func (t *terminal) raw() error {
+	state := t.state
+
+	state.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.ISTRIP | syscall.BRKINT
+	state.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	state.Cc[syscall.VMIN] = 1
+	state.Cc[syscall.VTIME] = 0
+
+	return ioctl(t.fd, syscall.TCSETS, &state)
+}
+
+// Restore original settings
+func (t *terminal) restore() error {
+	return ioctl(t.fd, syscall.TCSETS, &t.state)
+}
+
+func ioctl(fd, req uintptr, state *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(state)))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
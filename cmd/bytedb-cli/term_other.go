@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Line editing is supported only on Linux, other
+// systems read lines without history and completion.
+type terminal struct{}
+
+func openTerminal(f *os.File) (*terminal, error) {
+	return nil, errors.New("terminal is not supported")
+}
+
+func (t *terminal) raw() error {
+	return nil
+}
+
+func (t *terminal) restore() error {
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytedb/db"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const usage = `Usage: bytedb-dump export [flags] <data dir>
+       bytedb-dump import [flags] <data dir>
+
+Export keys of stopped database as JSON Lines, or import them. Each
+line has collection, namespace and prefix hash, key and value in
+base64, ttl in milliseconds and format version.
+
+Names given in filters are hashed, hashes can be given as 0x<16 hex digits>.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:], os.Stdout)
+	case "import":
+		err = runImport(os.Args[2:], os.Stdin, os.Stdout)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Export keys to file or w.
+func runExport(args []string, w io.Writer) error {
+	filter := &db.ExportFilter{}
+
+	fs := newFlagSet("export")
+	fs.Var((*hashList)(&filter.Collections), "collection", "export only this collection, can be repeated")
+	fs.Var((*hashList)(&filter.Namespaces), "namespace", "export only this namespace, can be repeated")
+	fs.Var((*hashList)(&filter.Prefixes), "prefix", "export only this prefix, can be repeated")
+	out := fs.String("o", "", "output file, stdout by default")
+
+	root, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	database, err := db.Open(root)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	_, err = database.Export(w, filter)
+	return err
+}
+
+// Import keys from file or r and print summary to w.
+func runImport(args []string, r io.Reader, w io.Writer) error {
+	opts := &db.ImportOptions{}
+
+	fs := newFlagSet("import")
+	mode := fs.String("mode", "upsert", `"upsert" overwrites existing keys, "skip-existing" keeps them`)
+	fs.IntVar(&opts.BatchSize, "batch", db.DefaultImportBatch, "number of lines validated before they are written")
+	in := fs.String("i", "", "input file, stdin by default")
+
+	root, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	opts.Mode, err = db.ParseImportMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	database, err := db.Open(root)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	stats, err := database.Import(r, opts)
+	fmt.Fprintf(w, "%d lines, %d written, %d skipped\n", stats.Lines, stats.Written, stats.Skipped)
+
+	return err
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage+"\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// Parse flags and return data directory.
+func parseArgs(fs *flag.FlagSet, args []string) (string, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return "", err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", flag.ErrHelp
+	}
+
+	return fs.Arg(0), nil
+}
+
+// List of hashes given by names or as hex numbers.
+type hashList []uint64
+
+func (l *hashList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	s := []string{}
+	for _, h := range *l {
+		s = append(s, fmt.Sprintf("0x%016x", h))
+	}
+
+	return strings.Join(s, ",")
+}
+
+func (l *hashList) Set(value string) error {
+	*l = append(*l, parseHash(value))
+	return nil
+}
+
+// Return hash of name, or the hash itself if it's given as 0x<16 hex digits>.
+func parseHash(value string) uint64 {
+	hex, ok := strings.CutPrefix(value, "0x")
+	if ok && len(hex) == 16 {
+		hash, err := strconv.ParseUint(hex, 16, 64)
+		if err == nil {
+			return hash
+		}
+	}
+
+	return db.Hash([]byte(value))
+}
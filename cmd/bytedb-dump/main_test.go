@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytedb/db"
+	"bytedb/tests"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	root := t.TempDir()
+
+	database, _ := db.Open(root)
+
+	for _, coll := range []string{"users", "orders"} {
+		key := db.NewKey([]byte("key_1"), []byte("val_1"))
+		key.Collection = db.Hash([]byte(coll))
+		database.Put(key)
+	}
+
+	database.Close()
+
+	out := &bytes.Buffer{}
+	tests.Assert(t, nil, runExport([]string{"-collection", "users", root}, out))
+	tests.Assert(t, 1, strings.Count(out.String(), "\n"))
+
+	hash := fmt.Sprintf("%016x", db.Hash([]byte("users")))
+	tests.Assert(t, true, strings.Contains(out.String(), `"collection":"`+hash+`"`))
+
+	// The same collection given by hash
+	byHash := &bytes.Buffer{}
+	runExport([]string{"-collection", "0x" + hash, root}, byHash)
+	tests.Assert(t, out.String(), byHash.String())
+
+	target := t.TempDir()
+	summary := &bytes.Buffer{}
+
+	tests.Assert(t, nil, runImport([]string{"-mode", "skip-existing", target}, bytes.NewReader(out.Bytes()), summary))
+	tests.Assert(t, "1 lines, 1 written, 0 skipped\n", summary.String())
+
+	summary.Reset()
+	runImport([]string{"-mode", "skip-existing", target}, bytes.NewReader(out.Bytes()), summary)
+	tests.Assert(t, "1 lines, 0 written, 1 skipped\n", summary.String())
+
+	tests.AssertNot(t, nil, runImport([]string{"-mode", "replace", target}, &bytes.Buffer{}, summary))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytedb/db"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes
+const (
+	ExitOK       = 0
+	ExitDamaged  = 1 // errors found, or left after repair
+	ExitError    = 2 // check couldn't run
+	ExitRepaired = 3 // errors found and repaired
+)
+
+const usage = `Usage: bytedb-fsck [flags] <data dir>
+
+Check bucket files and wal of stopped database. Without repair
+flags nothing is changed.
+
+Flags:
+`
+
+func main() {
+	opts := &db.RepairOptions{}
+
+	flag.BoolVar(&opts.RebuildIndex, "rebuild-index", false, "rebuild index of damaged bucket files from their value blocks, originals are kept as .bak")
+	flag.BoolVar(&opts.TruncateWal, "truncate-wal", false, "truncate wal at the last valid record")
+	warnings := flag.Bool("warnings", false, "show warnings, like orphaned value blocks")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(ExitError)
+	}
+
+	os.Exit(run(os.Stdout, flag.Arg(0), opts, *warnings))
+}
+
+// Check database and repair it if requested. Return exit code.
+func run(w io.Writer, root string, opts *db.RepairOptions, warnings bool) int {
+	_, err := os.Stat(root + db.CollectionsPath)
+	if err != nil {
+		fmt.Fprintf(w, "not a database: %s\n", err)
+		return ExitError
+	}
+
+	r, err := db.Check(root)
+	if err != nil {
+		fmt.Fprintf(w, "check failed: %s\n", err)
+		return ExitError
+	}
+
+	report(w, r, warnings)
+
+	if r.OK() {
+		return ExitOK
+	}
+
+	if !opts.RebuildIndex && !opts.TruncateWal {
+		return ExitDamaged
+	}
+
+	fmt.Fprintln(w, "\nrepairing...")
+
+	r, err = db.Repair(root, opts)
+	if err != nil {
+		fmt.Fprintf(w, "repair failed: %s\n", err)
+		return ExitError
+	}
+
+	report(w, r, warnings)
+
+	if !r.OK() {
+		return ExitDamaged
+	}
+
+	return ExitRepaired
+}
+
+// Print problems and summary
+func report(w io.Writer, r *db.Report, warnings bool) {
+	for _, p := range r.Errors {
+		fmt.Fprintf(w, "error: %s\n", p)
+	}
+
+	if warnings {
+		for _, p := range r.Warnings {
+			fmt.Fprintf(w, "warning: %s\n", p)
+		}
+	}
+
+	fmt.Fprintf(w, "%d files, %d keys, %d wal records: %d errors, %d warnings\n",
+		r.Files, r.Keys, r.Records, len(r.Errors), len(r.Warnings))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytedb/db"
+	"bytedb/db/wal"
+	"bytedb/tests"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+
+	database, _ := db.Open(root)
+	database.Put(db.NewKey([]byte("key_1"), []byte("val_1")))
+	database.Close()
+
+	out := &bytes.Buffer{}
+	tests.Assert(t, ExitOK, run(out, root, &db.RepairOptions{}, false))
+
+	// Internal database keeps quota and usage of the collection
+	tests.Assert(t, true, strings.Contains(out.String(), "3 files, 3 keys"))
+
+	// Damaged record at the end of wal
+	w, _ := wal.Open(root+db.WalPath, db.DefaultWalSize)
+	w.Write([]byte{1, 2, 3})
+	w.Close()
+
+	out.Reset()
+	tests.Assert(t, ExitDamaged, run(out, root, &db.RepairOptions{}, false))
+	tests.Assert(t, ExitRepaired, run(out, root, &db.RepairOptions{TruncateWal: true}, false))
+	tests.Assert(t, ExitOK, run(out, root, &db.RepairOptions{}, false))
+
+	tests.Assert(t, ExitError, run(out, t.TempDir(), &db.RepairOptions{}, false))
+}
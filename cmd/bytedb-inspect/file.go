@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytedb/db"
+	bit "bytedb/lib/bitbox"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Block types
+const (
+	typeHeader  = "header"
+	typeIndex   = "index"
+	typeValue   = "value"   // first block of live key
+	typeSpan    = "span"    // next blocks of live key
+	typeDeleted = "deleted" // blocks of deleted key
+	typeFree    = "free"    // not referenced by index
+)
+
+// Dump bucket file: header, index blocks and usage of every block.
+func inspectFile(w io.Writer, path string, opts *Options) error {
+	f, err := db.OpenFileReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	count := uint32(f.BlockCount())
+
+	printf(w, "file: %s", path)
+	printf(w, "size: %d bytes, %d blocks", f.Size(), count)
+	printf(w, "header: index offset %d, index blocks %d", f.IndexOffset, f.IndexBlocks)
+
+	if rest := f.Size() % db.BlockSize; rest != 0 {
+		printf(w, "warning: %d bytes after the last full block", rest)
+	}
+
+	first, last := f.IndexOffset, f.IndexOffset+f.IndexBlocks-1
+
+	if f.IndexBlocks == 0 || first < 2 || uint64(first)+uint64(f.IndexBlocks)-1 > uint64(count) {
+		printf(w, "warning: index location in header is invalid")
+		first, last = 1, 0
+	}
+
+	types := map[uint32]string{1: typeHeader}
+
+	for id := first; id <= last; id++ {
+		types[id] = typeIndex
+	}
+
+	for id := first; id <= last; id++ {
+		b := db.NewBlock(id)
+
+		_, err := f.Read(b)
+		if err != nil {
+			return err
+		}
+
+		h, keys := db.DecodeIndexBlock(b)
+
+		// Skip empty blocks, most of them are empty in small files.
+		if opts.Index && (h.Keys > 0 || h.Tombstones > 0) {
+			printf(w, "\nindex block %d: keys %d, tombstones %d", id, h.Keys, h.Tombstones)
+			printf(w, "  %-5s %-16s %-8s %-5s %-5s %s", "slot", "hash", "offset", "pos", "span", "flags")
+		}
+
+		for pos, idx := range keys {
+			typ := typeValue
+			if idx.Flag&db.FlagDeleted != 0 {
+				typ = typeDeleted
+			}
+
+			for n := uint32(0); n < uint32(idx.Span); n++ {
+				if types[idx.Offset+n] == "" || types[idx.Offset+n] == typeDeleted {
+					types[idx.Offset+n] = typ
+				}
+
+				if typ == typeValue {
+					typ = typeSpan
+				}
+			}
+
+			if !opts.Index {
+				continue
+			}
+
+			line := fmt.Sprintf("  %-5d %016x %-8d %-5d %-5d %s", pos, idx.Hash, idx.Offset, idx.Pos, idx.Span, flags(idx.Flag))
+
+			if opts.Keys {
+				line += "  " + describeKey(f, idx, count)
+			}
+
+			printf(w, "%s", line)
+		}
+	}
+
+	if !opts.Blocks {
+		return nil
+	}
+
+	printf(w, "\n  %-8s %-5s %s", "block", "used", "type")
+
+	for id := uint32(1); id <= count; id++ {
+		b := db.NewBlock(id)
+
+		_, err := f.Read(b)
+		if err != nil {
+			return err
+		}
+
+		typ := types[id]
+		if typ == "" {
+			typ = typeFree
+		}
+
+		printf(w, "  %-8d %-5d %s", id, b.Used(), typ)
+	}
+
+	return nil
+}
+
+// Return names of index flags
+func flags(flag uint16) string {
+	if flag == 0 {
+		return "-"
+	}
+
+	s := ""
+	if flag&db.FlagDeleted != 0 {
+		s = "deleted"
+	}
+
+	if rest := flag &^ db.FlagDeleted; rest != 0 {
+		s += fmt.Sprintf(" unknown(%#x)", rest)
+	}
+
+	return s
+}
+
+// Decode key pointed by index and return its description.
+func describeKey(f *db.File, idx *db.IndexKey, count uint32) string {
+	if idx.Span == 0 || idx.Offset < 2 || idx.Offset+uint32(idx.Span)-1 > count {
+		return "span outside of file"
+	}
+
+	data := []byte{}
+
+	for id := idx.Offset; id < idx.Offset+uint32(idx.Span); id++ {
+		b := db.NewBlock(id)
+
+		_, err := f.Read(b)
+		if err != nil {
+			return err.Error()
+		}
+
+		data = append(data, b.Data...)
+	}
+
+	if int(idx.Pos) >= len(data) {
+		return "position outside of block"
+	}
+
+	key, err := decodeKey(data[idx.Pos:])
+	if err != nil {
+		return err.Error()
+	}
+
+	s := fmt.Sprintf("key %s, value %d bytes", quote(key.Name), len(key.Value))
+
+	if db.Hash(key.Name) != idx.Hash {
+		s += ", hash mismatch"
+	}
+
+	if key.Expire != 0 {
+		s += ", expires " + time.Unix(0, key.Expire).UTC().Format(time.RFC3339)
+	}
+
+	if key.ContentType != "" {
+		s += ", type " + quote([]byte(key.ContentType))
+	}
+
+	return s
+}
+
+// Decode key from value blocks. Damaged data can point past
+// the end of buffer, so it's reported as error.
+func decodeKey(data []byte) (*db.Key, error) {
+	key := &db.Key{}
+
+	buf := bit.NewBuffer(data)
+
+	err := buf.Decode(&key.Name, &key.Value, &key.Expire)
+	if err == nil && buf.Len() >= 4 {
+		err = buf.Decode(&key.ContentType)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("can't decode key")
+	}
+
+	return key, nil
+}
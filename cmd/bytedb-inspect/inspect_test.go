@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytedb/db"
+	"bytedb/db/wal"
+	"bytedb/tests"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInspectFile(t *testing.T) {
+	path := t.TempDir() + "/test.bck"
+
+	b, _ := db.OpenBucket(path)
+	b.Put(db.NewKey([]byte("key_1"), []byte("val_1")))
+	b.Put(db.NewKey([]byte("key_2"), []byte("val_2")))
+	b.Delete(db.NewKey([]byte("key_2"), nil))
+	b.Close()
+
+	out := &bytes.Buffer{}
+	err := inspectFile(out, path, &Options{Blocks: true, Index: true, Keys: true})
+	tests.Assert(t, nil, err)
+
+	res := out.String()
+	tests.Assert(t, true, strings.Contains(res, "header: index offset 2, index blocks 10"))
+	tests.Assert(t, true, strings.Contains(res, `key "key_1", value 5 bytes`))
+	tests.Assert(t, true, strings.Contains(res, "deleted"))
+	tests.Assert(t, true, strings.Contains(res, "  1        5     header"))
+	tests.Assert(t, true, strings.Contains(res, "value"))
+
+	// Damaged header
+	os.WriteFile(path, make([]byte, 3*db.BlockSize+10), 0644)
+
+	out.Reset()
+	inspectFile(out, path, &Options{Blocks: true})
+	tests.Assert(t, true, strings.Contains(out.String(), "index location in header is invalid"))
+	tests.Assert(t, true, strings.Contains(out.String(), "10 bytes after the last full block"))
+}
+
+func TestInspectWal(t *testing.T) {
+	dir := t.TempDir()
+
+	w, _ := wal.Open(dir, 1_000)
+	w.Write((&wal.Record{LSN: 1, Type: wal.RecPut, Key: []byte("key_1"), Value: []byte("val_1")}).Encode())
+	w.Write((&wal.Record{LSN: 2, Type: wal.RecDelete, Key: []byte("key_1")}).Encode())
+
+	// Key length points past the end of record
+	w.Write(append(make([]byte, 33), 0xff, 0xff, 0xff, 0x7f))
+	w.Close()
+
+	out := &bytes.Buffer{}
+	err := inspectWal(out, dir, &Options{Values: true})
+	tests.Assert(t, nil, err)
+
+	res := out.String()
+	tests.Assert(t, true, strings.Contains(res, `lsn 1 put 0000000000000000/0000000000000000/0000000000000000 key "key_1", value 5 bytes "val_1"`))
+	tests.Assert(t, true, strings.Contains(res, `lsn 2 delete`))
+	tests.Assert(t, true, strings.Contains(res, "37 bytes, can't decode record"))
+	tests.Assert(t, true, strings.Contains(res, "3 records"))
+}
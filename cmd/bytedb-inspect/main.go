@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `Usage: bytedb-inspect [flags] file <path.bck>
+       bytedb-inspect [flags] wal <wal dir or segment.wal>
+
+Dump content of bucket files and wal segments. Files are opened
+read-only, so it's safe to run it on a live or damaged database.
+
+Flags:
+`
+
+// Inspect options
+type Options struct {
+	Blocks bool // show usage of every block
+	Index  bool // show index entries
+	Keys   bool // decode key of every index entry
+	Values bool // show wal values
+}
+
+func main() {
+	opts := &Options{}
+
+	flag.BoolVar(&opts.Blocks, "blocks", true, "show usage of every block")
+	flag.BoolVar(&opts.Index, "index", true, "show index blocks and their entries")
+	flag.BoolVar(&opts.Keys, "keys", false, "decode key name, value size and expiration of index entries")
+	flag.BoolVar(&opts.Values, "values", false, "show values of wal records")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	path := flag.Arg(1)
+
+	switch flag.Arg(0) {
+	case "file":
+		err = inspectFile(os.Stdout, path, opts)
+	case "wal":
+		err = inspectWal(os.Stdout, path, opts)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Return bytes as quoted string, binary data is escaped
+func quote(data []byte) string {
+	return fmt.Sprintf("%q", data)
+}
+
+// Write formatted line
+func printf(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, format+"\n", args...)
+}
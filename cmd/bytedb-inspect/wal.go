@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytedb/db/wal"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Return name of record type
+func recordType(typ uint8) string {
+	switch typ {
+	case wal.RecPut:
+		return "put"
+	case wal.RecDelete:
+		return "delete"
+	case wal.RecCheckpoint:
+		return "checkpoint"
+	}
+
+	return fmt.Sprintf("unknown(%d)", typ)
+}
+
+// Dump records of all segments in wal directory, or of single segment.
+func inspectWal(w io.Writer, path string, opts *Options) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{path}
+
+	if info.IsDir() {
+		segs, err := wal.Segments(path)
+		if err != nil {
+			return err
+		}
+
+		paths = paths[:0]
+		for _, seq := range segs {
+			paths = append(paths, wal.SegmentPath(path, seq))
+		}
+	}
+
+	for _, p := range paths {
+		printf(w, "segment: %s", p)
+
+		count := 0
+
+		_, err := wal.ReadSegment(p, func(off int, log []byte) {
+			count++
+			printf(w, "  %s", describeRecord(off, log, opts))
+		})
+
+		if err != nil {
+			printf(w, "  error: %s", err)
+		}
+
+		printf(w, "  %d records\n", count)
+	}
+
+	return nil
+}
+
+// Decode record and return its description
+func describeRecord(off int, log []byte, opts *Options) string {
+	rec, err := decodeRecord(log)
+	if err != nil {
+		return fmt.Sprintf("offset %d: %d bytes, %s", off, len(log), err)
+	}
+
+	s := fmt.Sprintf("offset %d: lsn %d %s", off, rec.LSN, recordType(rec.Type))
+
+	if rec.Type == wal.RecCheckpoint {
+		return s
+	}
+
+	s += fmt.Sprintf(" %016x/%016x/%016x key %s", rec.Collection, rec.Namespace, rec.Prefix, quote(rec.Key))
+
+	if rec.Type == wal.RecPut {
+		s += fmt.Sprintf(", value %d bytes", len(rec.Value))
+
+		if opts.Values {
+			s += " " + quote(rec.Value)
+		}
+	}
+
+	if rec.Expire != 0 {
+		s += ", expires " + time.Unix(0, rec.Expire).UTC().Format(time.RFC3339)
+	}
+
+	if rec.ContentType != "" {
+		s += ", type " + quote([]byte(rec.ContentType))
+	}
+
+	return s
+}
+
+// Decode wal record, damaged data is reported as error.
+func decodeRecord(log []byte) (*wal.Record, error) {
+	rec, err := wal.ParseRecord(log)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode record")
+	}
+
+	return rec, nil
+}
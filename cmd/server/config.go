@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bufio"
+	"bytedb/db"
+	"bytedb/db/wal"
+	"bytedb/server"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server configuration. Options can be set in config file
+// and overridden by command line flags with the same name.
+type Config struct {
+	Listen          []string      // addresses in "ip:port" form
+	Data            string        // database root directory
+	Workers         int           // number of file workers
+	WalSegmentSize  int64         // size of wal segment in bytes
+	WalSync         string        // wal durability mode: interval, always, none
+	CacheBlocks     int           // max number of cached blocks per file
+	LogLevel        string        // debug, info or error
+	MaxKeySize      int           // max key size in bytes
+	MaxValueSize    int           // max value size in bytes
+	ShutdownTimeout time.Duration // how long in-flight commands can run after signal
+	TLSCert         string        // server certificate, enables TLS on all listeners
+	TLSKey          string        // server private key
+	TLSClientCA     string        // CA bundle for verifying client certificates (mTLS)
+	Auth            bool          // require AUTH before other commands
+	AuthAdminFile   string        // file with password for bootstrapping "admin" user
+	RedisListen     []string      // addresses of RESP listeners, empty disables them
+	RedisCollection string        // collection for Redis keys without "::" path
+	RedisNamespace  string        // namespace for Redis keys without "::" path
+	HTTPListen      []string      // addresses of HTTP gateway listeners, empty disables them
+	AdminListen     []string      // addresses serving /metrics, empty disables them
+	QueueSize       int           // max number of commands waiting for single worker
+	MaxInFlight     int           // max number of commands running at once, 0 means no limit
+	MaxConns        int           // max number of open connections, 0 means no limit
+	RateLimit       float64       // commands per second of single client host, 0 means no limit
+	RateBurst       int           // commands client can send at once above rate-limit
+	SlowThreshold   time.Duration // commands slower than this are kept in slow log
+	SlowLogSize     int           // max number of commands in slow log
+	WalRetain       int64         // size of checkpointed wal kept for replicas
+	ReplicaOf       string        // address of primary, makes server read-only replica
+	ReplicaUser     string        // user for authenticating to primary
+	ReplicaPassFile string        // file with password of replica-user
+	ReplicaTLSCA    string        // CA bundle for verifying primary, enables TLS
+}
+
+// Config option, its description and default value.
+type Option struct {
+	Name  string
+	Usage string
+	Value string
+}
+
+// Command line flag of option. Options with "true" or "false" default
+// are bool flags, they can be set without value like other bool flags.
+type optionFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *optionFlag) String() string {
+	return f.value
+}
+
+func (f *optionFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *optionFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// Register flags of all options in flag set
+func registerOptions(fs *flag.FlagSet) {
+	for _, opt := range Options {
+		isBool := opt.Value == "true" || opt.Value == "false"
+		fs.Var(&optionFlag{opt.Value, isBool}, opt.Name, opt.Usage)
+	}
+}
+
+// All supported options
+var Options = []Option{
+	{"listen", "comma separated list of addresses to listen on", "127.0.0.1:6666"},
+	{"data", "database root directory", "./data"},
+	{"workers", "number of file workers", "1000"},
+	{"wal-segment-size", "size of wal segment, accepts KB, MB and GB suffixes", "16MB"},
+	{"wal-sync", "wal durability mode: interval, always or none", "interval"},
+	{"cache-blocks", "max number of 4KB blocks cached per file, 0 means no limit", "1024"},
+	{"log-level", "log level: debug, info or error", "info"},
+	{"max-key-size", "max key size, accepts KB, MB and GB suffixes", "1KB"},
+	{"max-value-size", "max value size, accepts KB, MB and GB suffixes", "1MB"},
+	{"shutdown-timeout", "how long in-flight commands can run after SIGTERM", "10s"},
+	{"tls-cert", "PEM certificate file, enables TLS on all listeners", ""},
+	{"tls-key", "PEM private key file for tls-cert", ""},
+	{"tls-client-ca", "PEM CA bundle, clients must present certificate signed by it", ""},
+	{"auth", "require clients to authenticate before running commands", "false"},
+	{"auth-admin-file", "file with password for \"admin\" user, created on startup if missing", ""},
+	{"redis-listen", "comma separated list of addresses for Redis protocol (RESP) listeners", ""},
+	{"redis-collection", "default collection for Redis keys", "default"},
+	{"redis-namespace", "default namespace for Redis keys", "default"},
+	{"http-listen", "comma separated list of addresses for HTTP/JSON gateway", ""},
+	{"admin-listen", "comma separated list of addresses serving Prometheus metrics at /metrics", ""},
+	{"queue-size", "max number of commands waiting for single worker", "100"},
+	{"max-in-flight", "max number of commands running at once, 0 means no limit", "10000"},
+	{"max-connections", "max number of open connections, 0 means no limit", "10000"},
+	{"rate-limit", "commands per second allowed for single client host, 0 means no limit", "0"},
+	{"rate-burst", "commands client can send at once above rate-limit", "100"},
+	{"slowlog-threshold", "commands slower than this are kept in slow log, 0 disables it", "10ms"},
+	{"slowlog-size", "max number of commands kept in slow log", "128"},
+	{"wal-retain", "size of checkpointed wal kept for replicas, accepts KB, MB and GB suffixes", "0"},
+	{"replica-of", "address of primary server, runs this server as read-only replica", ""},
+	{"replica-user", "user for authenticating to primary, must have admin permission", ""},
+	{"replica-password-file", "file with password of replica-user", ""},
+	{"replica-tls-ca", "PEM CA bundle for verifying primary, enables TLS to primary", ""},
+}
+
+// Return config with default values
+func DefaultConfig() *Config {
+	c := &Config{}
+
+	for _, opt := range Options {
+		err := c.Set(opt.Name, opt.Value)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return c
+}
+
+// Set option from its string form
+func (c *Config) Set(name, value string) error {
+	var err error
+
+	switch name {
+	case "listen":
+		c.Listen, err = splitList(value)
+	case "data":
+		c.Data = value
+	case "workers":
+		c.Workers, err = strconv.Atoi(value)
+	case "wal-segment-size":
+		c.WalSegmentSize, err = parseSize(value)
+	case "wal-sync":
+		c.WalSync = value
+	case "cache-blocks":
+		c.CacheBlocks, err = strconv.Atoi(value)
+	case "log-level":
+		c.LogLevel = value
+	case "max-key-size":
+		size, e := parseSize(value)
+		c.MaxKeySize, err = int(size), e
+	case "max-value-size":
+		size, e := parseSize(value)
+		c.MaxValueSize, err = int(size), e
+	case "shutdown-timeout":
+		c.ShutdownTimeout, err = time.ParseDuration(value)
+	case "tls-cert":
+		c.TLSCert = value
+	case "tls-key":
+		c.TLSKey = value
+	case "tls-client-ca":
+		c.TLSClientCA = value
+	case "auth":
+		c.Auth, err = strconv.ParseBool(value)
+	case "auth-admin-file":
+		c.AuthAdminFile = value
+	case "redis-listen":
+		c.RedisListen, err = splitList(value)
+	case "redis-collection":
+		c.RedisCollection = value
+	case "redis-namespace":
+		c.RedisNamespace = value
+	case "http-listen":
+		c.HTTPListen, err = splitList(value)
+	case "admin-listen":
+		c.AdminListen, err = splitList(value)
+	case "queue-size":
+		c.QueueSize, err = strconv.Atoi(value)
+	case "max-in-flight":
+		c.MaxInFlight, err = strconv.Atoi(value)
+	case "max-connections":
+		c.MaxConns, err = strconv.Atoi(value)
+	case "rate-limit":
+		c.RateLimit, err = strconv.ParseFloat(value, 64)
+	case "rate-burst":
+		c.RateBurst, err = strconv.Atoi(value)
+	case "slowlog-threshold":
+		c.SlowThreshold, err = time.ParseDuration(value)
+	case "slowlog-size":
+		c.SlowLogSize, err = strconv.Atoi(value)
+	case "wal-retain":
+		c.WalRetain, err = parseSize(value)
+	case "replica-of":
+		c.ReplicaOf = value
+	case "replica-user":
+		c.ReplicaUser = value
+	case "replica-password-file":
+		c.ReplicaPassFile = value
+	case "replica-tls-ca":
+		c.ReplicaTLSCA = value
+	default:
+		return fmt.Errorf("unknown option: %s", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %q", name, value)
+	}
+
+	return nil
+}
+
+// Load options from config file. Format is a flat list of "name = value"
+// lines, values can be quoted strings, numbers or lists of strings:
+//
+//	# comment
+//	listen = ["127.0.0.1:6666", "10.0.0.1:6666"]
+//	data = "/var/lib/bytedb"
+//	workers = 1000
+func (c *Config) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected name = value", path, line)
+		}
+
+		value, err = parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+
+		err = c.Set(strings.TrimSpace(name), value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Check if all options have sane values
+func (c *Config) Validate() error {
+	if len(c.Listen) == 0 {
+		return fmt.Errorf("listen: at least one address is required")
+	}
+
+	for _, addr := range c.Listen {
+		_, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("listen: %s", err)
+		}
+	}
+
+	for _, addr := range c.RedisListen {
+		_, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("redis-listen: %s", err)
+		}
+	}
+
+	for _, addr := range c.HTTPListen {
+		_, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("http-listen: %s", err)
+		}
+	}
+
+	for _, addr := range c.AdminListen {
+		_, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("admin-listen: %s", err)
+		}
+	}
+
+	if c.Data == "" {
+		return fmt.Errorf("data: directory is required")
+	}
+
+	if c.Workers <= 0 {
+		return fmt.Errorf("workers: must be greater than 0")
+	}
+
+	if c.WalSegmentSize < 64<<10 || c.WalSegmentSize > 1<<30 {
+		return fmt.Errorf("wal-segment-size: must be between 64KB and 1GB")
+	}
+
+	_, err := wal.ParseSyncMode(c.WalSync)
+	if err != nil {
+		return fmt.Errorf("wal-sync: %s", err)
+	}
+
+	if c.CacheBlocks < 0 {
+		return fmt.Errorf("cache-blocks: can't be negative")
+	}
+
+	_, err = parseLevel(c.LogLevel)
+	if err != nil {
+		return fmt.Errorf("log-level: %s", err)
+	}
+
+	if c.MaxKeySize <= 0 || c.MaxValueSize <= 0 {
+		return fmt.Errorf("max-key-size and max-value-size: must be greater than 0")
+	}
+
+	// Wal record must fit into a single segment.
+	if int64(c.MaxKeySize+c.MaxValueSize) > c.WalSegmentSize/2 {
+		return fmt.Errorf("max-key-size + max-value-size: must be at most half of wal-segment-size")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown-timeout: must be greater than 0")
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls-cert and tls-key: both must be set")
+	}
+
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		return fmt.Errorf("tls-client-ca: requires tls-cert and tls-key")
+	}
+
+	if c.AuthAdminFile != "" && !c.Auth {
+		return fmt.Errorf("auth-admin-file: requires auth")
+	}
+
+	if len(c.RedisListen) > 0 && (c.RedisCollection == "" || c.RedisNamespace == "") {
+		return fmt.Errorf("redis-collection and redis-namespace: can't be empty")
+	}
+
+	if c.QueueSize <= 0 {
+		return fmt.Errorf("queue-size: must be greater than 0")
+	}
+
+	if c.MaxInFlight < 0 || c.MaxConns < 0 {
+		return fmt.Errorf("max-in-flight and max-connections: can't be negative")
+	}
+
+	if c.RateLimit < 0 || c.RateBurst <= 0 {
+		return fmt.Errorf("rate-limit can't be negative and rate-burst must be greater than 0")
+	}
+
+	if c.SlowThreshold < 0 {
+		return fmt.Errorf("slowlog-threshold: can't be negative")
+	}
+
+	if c.SlowLogSize <= 0 {
+		return fmt.Errorf("slowlog-size: must be greater than 0")
+	}
+
+	if c.WalRetain < 0 {
+		return fmt.Errorf("wal-retain: can't be negative")
+	}
+
+	if c.ReplicaOf != "" {
+		_, _, err := net.SplitHostPort(c.ReplicaOf)
+		if err != nil {
+			return fmt.Errorf("replica-of: %s", err)
+		}
+	}
+
+	if c.ReplicaOf == "" && (c.ReplicaUser != "" || c.ReplicaPassFile != "" || c.ReplicaTLSCA != "") {
+		return fmt.Errorf("replica-user, replica-password-file and replica-tls-ca: require replica-of")
+	}
+
+	if (c.ReplicaUser == "") != (c.ReplicaPassFile == "") {
+		return fmt.Errorf("replica-user and replica-password-file: both must be set")
+	}
+
+	return nil
+}
+
+// Return TLS options, nil if TLS is disabled
+func (c *Config) TLSOptions() *server.TLSOptions {
+	if c.TLSCert == "" {
+		return nil
+	}
+
+	return &server.TLSOptions{
+		CertFile:     c.TLSCert,
+		KeyFile:      c.TLSKey,
+		ClientCAFile: c.TLSClientCA,
+	}
+}
+
+// Return database options
+func (c *Config) DBOptions() *db.Options {
+	opts := db.DefaultOptions()
+
+	opts.WalSegmentSize = c.WalSegmentSize
+	opts.WalSync, _ = wal.ParseSyncMode(c.WalSync)
+	opts.CacheBlocks = c.CacheBlocks
+	opts.WalRetain = c.WalRetain
+	opts.Replica = c.ReplicaOf != ""
+
+	return opts
+}
+
+// Return options for connecting to primary, nil if server isn't replica
+func (c *Config) ReplicaOptions() (*server.ClientOptions, error) {
+	if c.ReplicaOf == "" {
+		return nil, nil
+	}
+
+	opts := server.DefaultClientOptions()
+	opts.User = c.ReplicaUser
+	opts.TLS = c.ReplicaTLSCA != ""
+	opts.CAFile = c.ReplicaTLSCA
+
+	if c.ReplicaPassFile != "" {
+		password, err := os.ReadFile(c.ReplicaPassFile)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Password = strings.TrimSpace(string(password))
+	}
+
+	return opts, nil
+}
+
+// Return config in config file format
+func (c *Config) String() string {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "listen = [%s]\n", quoteList(c.Listen))
+	fmt.Fprintf(b, "data = %q\n", c.Data)
+	fmt.Fprintf(b, "workers = %d\n", c.Workers)
+	fmt.Fprintf(b, "wal-segment-size = %d\n", c.WalSegmentSize)
+	fmt.Fprintf(b, "wal-sync = %q\n", c.WalSync)
+	fmt.Fprintf(b, "cache-blocks = %d\n", c.CacheBlocks)
+	fmt.Fprintf(b, "log-level = %q\n", c.LogLevel)
+	fmt.Fprintf(b, "max-key-size = %d\n", c.MaxKeySize)
+	fmt.Fprintf(b, "max-value-size = %d\n", c.MaxValueSize)
+	fmt.Fprintf(b, "shutdown-timeout = %q\n", c.ShutdownTimeout)
+	fmt.Fprintf(b, "tls-cert = %q\n", c.TLSCert)
+	fmt.Fprintf(b, "tls-key = %q\n", c.TLSKey)
+	fmt.Fprintf(b, "tls-client-ca = %q\n", c.TLSClientCA)
+	fmt.Fprintf(b, "auth = %t\n", c.Auth)
+	fmt.Fprintf(b, "auth-admin-file = %q\n", c.AuthAdminFile)
+	fmt.Fprintf(b, "redis-listen = [%s]\n", quoteList(c.RedisListen))
+	fmt.Fprintf(b, "redis-collection = %q\n", c.RedisCollection)
+	fmt.Fprintf(b, "redis-namespace = %q\n", c.RedisNamespace)
+	fmt.Fprintf(b, "http-listen = [%s]\n", quoteList(c.HTTPListen))
+	fmt.Fprintf(b, "admin-listen = [%s]\n", quoteList(c.AdminListen))
+	fmt.Fprintf(b, "queue-size = %d\n", c.QueueSize)
+	fmt.Fprintf(b, "max-in-flight = %d\n", c.MaxInFlight)
+	fmt.Fprintf(b, "max-connections = %d\n", c.MaxConns)
+	fmt.Fprintf(b, "rate-limit = %s\n", strconv.FormatFloat(c.RateLimit, 'g', -1, 64))
+	fmt.Fprintf(b, "rate-burst = %d\n", c.RateBurst)
+	fmt.Fprintf(b, "slowlog-threshold = %q\n", c.SlowThreshold)
+	fmt.Fprintf(b, "slowlog-size = %d\n", c.SlowLogSize)
+	fmt.Fprintf(b, "wal-retain = %d\n", c.WalRetain)
+	fmt.Fprintf(b, "replica-of = %q\n", c.ReplicaOf)
+	fmt.Fprintf(b, "replica-user = %q\n", c.ReplicaUser)
+	fmt.Fprintf(b, "replica-password-file = %q\n", c.ReplicaPassFile)
+	fmt.Fprintf(b, "replica-tls-ca = %q\n", c.ReplicaTLSCA)
+
+	return b.String()
+}
+
+// Convert config file value to option string form.
+// Lists are converted to comma separated quoted strings.
+func parseValue(value string) (string, error) {
+	if strings.HasPrefix(value, "[") {
+		if !strings.HasSuffix(value, "]") {
+			return "", fmt.Errorf("unterminated list: %s", value)
+		}
+
+		items, err := splitList(value[1 : len(value)-1])
+		if err != nil {
+			return "", err
+		}
+
+		return quoteList(items), nil
+	}
+
+	if strings.HasPrefix(value, `"`) {
+		return strconv.Unquote(value)
+	}
+
+	return value, nil
+}
+
+// Parse size with optional KB, MB or GB suffix
+func parseSize(value string) (int64, error) {
+	mul := int64(1)
+
+	for suffix, m := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		num, ok := strings.CutSuffix(value, suffix)
+		if ok {
+			value, mul = num, m
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if size > math.MaxInt64/mul || size < math.MinInt64/mul {
+		return 0, fmt.Errorf("size out of range: %s", value)
+	}
+
+	return size * mul, nil
+}
+
+// Return list in config file format, without brackets
+func quoteList(list []string) string {
+	items := []string{}
+	for _, item := range list {
+		items = append(items, strconv.Quote(item))
+	}
+
+	return strings.Join(items, ", ")
+}
+
+// Split comma separated list, ignoring empty items. Items can be
+// quoted strings, commas inside them don't split the list.
+func splitList(value string) ([]string, error) {
+	list := []string{}
+
+	for {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return list, nil
+		}
+
+		item := ""
+
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string: %s", value)
+			}
+
+			item, _ = strconv.Unquote(quoted)
+			value = strings.TrimSpace(value[len(quoted):])
+
+			if value != "" && !strings.HasPrefix(value, ",") {
+				return nil, fmt.Errorf("expected comma after %s", quoted)
+			}
+
+			value = strings.TrimPrefix(value, ",")
+		} else {
+			item, value, _ = strings.Cut(value, ",")
+			item = strings.TrimSpace(item)
+		}
+
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytedb/tests"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfigLoad(t *testing.T) {
+	data := `
+# test config
+listen = ["127.0.0.1:7000", "127.0.0.1:7001"]
+data = "/tmp/bytedb"
+workers = 8
+wal-segment-size = 4MB
+wal-sync = "always"
+shutdown-timeout = "3s"
+`
+	os.WriteFile("test.conf", []byte(data), 0644)
+	defer os.Remove("test.conf")
+
+	cfg := DefaultConfig()
+	err := cfg.Load("test.conf")
+
+	tests.Assert(t, nil, err)
+	tests.AssertEqual(t, []string{"127.0.0.1:7000", "127.0.0.1:7001"}, cfg.Listen)
+	tests.Assert(t, "/tmp/bytedb", cfg.Data)
+	tests.Assert(t, 8, cfg.Workers)
+	tests.Assert(t, 4<<20, cfg.WalSegmentSize)
+	tests.Assert(t, "always", cfg.WalSync)
+	tests.Assert(t, 3*time.Second, cfg.ShutdownTimeout)
+	tests.Assert(t, nil, cfg.Validate())
+}
+
+func TestConfigPrint(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listen = []string{"127.0.0.1:7000", "127.0.0.1:7001"}
+
+	os.WriteFile("test.conf", []byte(cfg.String()), 0644)
+	defer os.Remove("test.conf")
+
+	loaded := DefaultConfig()
+	loaded.Load("test.conf")
+
+	tests.AssertEqual(t, cfg, loaded)
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	tests.Assert(t, nil, cfg.Validate())
+
+	cfg.Workers = 0
+	tests.AssertNot(t, nil, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.WalSync = "sometimes"
+	tests.AssertNot(t, nil, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.Listen = []string{"localhost"}
+	tests.AssertNot(t, nil, cfg.Validate())
+}
+
+func TestConfigValidateTLS(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TLSCert = "server.crt"
+	tests.AssertNot(t, nil, cfg.Validate())
+
+	cfg.TLSKey = "server.key"
+	tests.Assert(t, nil, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.TLSClientCA = "ca.crt"
+	tests.AssertNot(t, nil, cfg.Validate())
+}
+
+func TestConfigValidateReplica(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ReplicaUser = "replica"
+	tests.AssertNot(t, nil, cfg.Validate())
+
+	cfg.ReplicaOf = "127.0.0.1:6666"
+	tests.AssertNot(t, nil, cfg.Validate())
+
+	cfg.ReplicaPassFile = "replica.pass"
+	tests.Assert(t, nil, cfg.Validate())
+
+	cfg.WalRetain = -1
+	tests.AssertNot(t, nil, cfg.Validate())
+}
+
+func TestConfigFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerOptions(fs)
+
+	// Bool options don't need value
+	err := fs.Parse([]string{"-auth", "-workers", "8"})
+	tests.Assert(t, nil, err)
+	tests.Assert(t, "true", fs.Lookup("auth").Value.String())
+	tests.Assert(t, "8", fs.Lookup("workers").Value.String())
+}
+
+func TestConfigValues(t *testing.T) {
+	cfg := DefaultConfig()
+
+	// Commas in quoted items don't split them
+	os.WriteFile("test.conf", []byte(`data = "a,b"`+"\n"+`admin-listen = ["127.0.0.1:9100", "[::1]:9100", "x,y"]`), 0644)
+	defer os.Remove("test.conf")
+
+	tests.Assert(t, nil, cfg.Load("test.conf"))
+	tests.Assert(t, "a,b", cfg.Data)
+	tests.AssertEqual(t, []string{"127.0.0.1:9100", "[::1]:9100", "x,y"}, cfg.AdminListen)
+
+	tests.AssertNot(t, nil, cfg.Set("listen", `"127.0.0.1:7000`))
+
+	// Sizes can't overflow
+	tests.AssertNot(t, nil, cfg.Set("wal-retain", "9007199254740992GB"))
+	tests.Assert(t, nil, cfg.Set("wal-retain", "8GB"))
+	tests.Assert(t, int64(8<<30), cfg.WalRetain)
+}
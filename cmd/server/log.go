@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Log levels
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelError
+)
+
+// Current log level
+var level = LevelInfo
+
+func parseLevel(name string) (int, error) {
+	switch name {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "error":
+		return LevelError, nil
+	}
+
+	return 0, fmt.Errorf("unknown level: %q", name)
+}
+
+func debugf(format string, args ...any) {
+	if level <= LevelDebug {
+		log.Printf(format, args...)
+	}
+}
+
+func infof(format string, args ...any) {
+	if level <= LevelInfo {
+		log.Printf(format, args...)
+	}
+}
+
+func errorf(format string, args ...any) {
+	log.Printf(format, args...)
+}
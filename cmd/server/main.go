@@ -1,91 +1,392 @@
 package main
 
 import (
-	bit "bytedb/lib/bitbox"
+	"bytedb/db"
+	"bytedb/lib/metrics"
 	"bytedb/server"
-	"log"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Exit codes
+const (
+	ExitOK     = 0
+	ExitError  = 1
+	ExitForced = 2 // shutdown timeout expired, connections were closed
 )
 
 func main() {
-	log.Println("Starting ByteDB server")
+	cfg, err := parseConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitError)
+	}
 
-	// create main server
-	sock, _ := server.Run("127.0.0.1:6666")
+	level, _ = parseLevel(cfg.LogLevel)
+	infof("Starting ByteDB server")
+
+	// open main database
+	database, err := db.OpenWith(cfg.Data, cfg.DBOptions())
+	if err != nil {
+		errorf("can't open database: %s", err)
+		os.Exit(ExitError)
+	}
 
 	// run workers
-	srv := server.NewServer()
-	srv.RunWorkers(1_000)
+	srv := server.NewServer(database)
+	srv.MaxKeySize = cfg.MaxKeySize
+	srv.MaxValueSize = cfg.MaxValueSize
+	srv.ReadOnly = cfg.ReplicaOf != ""
+	srv.QueueSize = cfg.QueueSize
+	srv.MaxInFlight = cfg.MaxInFlight
+	srv.MaxConns = cfg.MaxConns
+	srv.RateLimit = cfg.RateLimit
+	srv.RateBurst = cfg.RateBurst
+	srv.SlowThreshold = cfg.SlowThreshold
+	srv.SlowLogSize = cfg.SlowLogSize
+	srv.RunWorkers(cfg.Workers)
 
-	// main server loop
-	for {
-		c, err := sock.Accept()
+	if cfg.Auth {
+		srv.Auth = server.NewAuth(database.Internals())
+
+		err = bootstrapAdmin(cfg, srv.Auth)
 		if err != nil {
-			log.Println("connection error:", err)
-			continue
+			errorf("can't create admin user: %s", err)
+			os.Exit(ExitError)
 		}
+	}
 
-		conn := server.NewConn(c)
+	// create listeners
+	lns := []*listener{}
+	handle := func(conn *server.Conn) { handleConn(srv, conn) }
 
-		// Each connection is run in separate goroutine.
-		// Later we will use poll/epoll together with goroutine pool.
-		// I assume that we won't have more than 10k connections at a time.
-		go handleConn(srv, conn)
+	for _, addr := range cfg.Listen {
+		ln, err := listen(cfg, addr, handle)
+		if err != nil {
+			errorf("can't listen on %s: %s", addr, err)
+			os.Exit(ExitError)
+		}
+
+		infof("Listening on %s", addr)
+		lns = append(lns, ln)
+	}
+
+	redis := server.NewRedis(srv, cfg.RedisCollection, cfg.RedisNamespace)
+
+	handleRedis := func(conn *server.Conn) { handleRedisConn(srv, redis, conn) }
+
+	for _, addr := range cfg.RedisListen {
+		ln, err := listen(cfg, addr, handleRedis)
+		if err != nil {
+			errorf("can't listen on %s: %s", addr, err)
+			os.Exit(ExitError)
+		}
+
+		infof("Listening for Redis clients on %s", addr)
+		lns = append(lns, ln)
+	}
+
+	gateway := &http.Server{Handler: server.NewHTTP(srv)}
+	httpSocks := []net.Listener{}
+
+	for _, addr := range cfg.HTTPListen {
+		ln, err := listen(cfg, addr, nil)
+		if err != nil {
+			errorf("can't listen on %s: %s", addr, err)
+			os.Exit(ExitError)
+		}
+
+		infof("Listening for HTTP clients on %s", addr)
+		httpSocks = append(httpSocks, ln.sock)
+	}
+
+	srv.RegisterMetrics(metrics.Default)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+
+	admin := &http.Server{Handler: mux}
+	adminSocks := []net.Listener{}
+
+	for _, addr := range cfg.AdminListen {
+		ln, err := listen(cfg, addr, nil)
+		if err != nil {
+			errorf("can't listen on %s: %s", addr, err)
+			os.Exit(ExitError)
+		}
+
+		infof("Serving metrics on %s", addr)
+		adminSocks = append(adminSocks, ln.sock)
+	}
+
+	replica, err := newReplica(cfg, database)
+	if err != nil {
+		errorf("can't create replica: %s", err)
+		os.Exit(ExitError)
+	}
+
+	// stop accepting connections on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	wg := sync.WaitGroup{}
+
+	if replica != nil {
+		infof("Replicating from %s", cfg.ReplicaOf)
+
+		// Replication must stop before database is closed.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replica.Run(ctx)
+		}()
+	}
+
+	for _, ln := range lns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(ctx, srv, ln)
+		}()
+	}
+
+	for _, sock := range httpSocks {
+		go gateway.Serve(sock)
+	}
+
+	for _, sock := range adminSocks {
+		go admin.Serve(sock)
 	}
+
+	<-ctx.Done()
+	infof("Shutting down ByteDB server")
+
+	for _, ln := range lns {
+		ln.sock.Close()
+	}
+
+	wg.Wait()
+	admin.Close()
+
+	os.Exit(shutdown(cfg, srv, gateway))
 }
 
-func handleConn(srv *server.Server, conn *server.Conn) {
-	log.Println("handling connection...")
+// Parse command line flags and load config file if given.
+// Flags take precedence over config file.
+func parseConfig() (*Config, error) {
+	path := flag.String("config", "", "path to config file")
+	printCfg := flag.Bool("print-config", false, "print effective config and exit")
 
-	// close connection on exit
-	defer conn.Close()
+	registerOptions(flag.CommandLine)
+	flag.Parse()
 
-	buf := make([]byte, 2048)
+	cfg := DefaultConfig()
 
-	for {
-		// read command from user
-		n, err := conn.Read(buf)
+	if *path != "" {
+		err := cfg.Load(*path)
 		if err != nil {
-			log.Println("Read error or client closed:", err)
+			return nil, err
+		}
+	}
+
+	var err error
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" || err != nil {
 			return
 		}
 
-		log.Printf("read %d bytes from user", n)
-		buf := bit.NewBuffer(buf)
+		err = cfg.Set(f.Name, f.Value.String())
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+
+	if *printCfg {
+		fmt.Print(cfg)
+		os.Exit(ExitOK)
+	}
+
+	return cfg, nil
+}
+
+// Create "admin" user with full permissions if it doesn't exist yet.
+func bootstrapAdmin(cfg *Config, auth *server.Auth) error {
+	if cfg.AuthAdminFile == "" {
+		return nil
+	}
+
+	u, err := auth.User("admin")
+	if err != nil || u != nil {
+		return err
+	}
+
+	password, err := os.ReadFile(cfg.AuthAdminFile)
+	if err != nil {
+		return err
+	}
+
+	err = auth.AddUser("admin", strings.TrimSpace(string(password)))
+	if err != nil {
+		return err
+	}
+
+	infof("Created admin user")
+
+	rule := server.Rule{Collection: server.Any, Namespace: server.Any, Perm: server.PermAdmin}
+	return auth.Grant("admin", rule)
+}
+
+// Create replica of primary server, nil if server isn't replica.
+func newReplica(cfg *Config, database *db.DB) (*server.Replica, error) {
+	opts, err := cfg.ReplicaOptions()
+	if err != nil || opts == nil {
+		return nil, err
+	}
+
+	replica, err := server.NewReplica(database, cfg.ReplicaOf, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	replica.OnError = func(internal bool, err error) {
+		errorf("replication error: %s", err)
+	}
+
+	return replica, nil
+}
+
+// Listening socket and handler for its connections
+type listener struct {
+	sock   net.Listener
+	handle func(*server.Conn)
+}
+
+// Create listener, using TLS if it's enabled.
+func listen(cfg *Config, addr string, handle func(*server.Conn)) (*listener, error) {
+	var sock net.Listener
+	var err error
+
+	opts := cfg.TLSOptions()
+
+	if opts == nil {
+		sock, err = server.Run(addr)
+	} else {
+		tlsCfg, e := server.NewTLSConfig(opts)
+		if e != nil {
+			return nil, e
+		}
+
+		sock, err = server.RunTLS(addr, tlsCfg)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &listener{sock: sock, handle: handle}, nil
+}
 
-		// decode command length
-		cmdLen := uint32(0)
-		buf.Decode(&cmdLen)
+// Main server loop, accept connections until listener is closed.
+func serve(ctx context.Context, srv *server.Server, ln *listener) {
+	for {
+		c, err := ln.sock.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 
-		// parse command
-		cmd := server.DecodeCmd(buf)
+			errorf("connection error: %s", err)
+			continue
+		}
+
+		conn := server.NewConn(c)
 
-		// run command
-		_, err = RunCmd(srv, cmd, conn)
+		err = srv.AddConn(conn)
 		if err != nil {
-			log.Println(err)
-			// send err resp to user
+			debugf("rejected connection from %s: %s", conn.RemoteAddr(), err)
+			conn.Close()
 			continue
 		}
 
-		// wait for response
-		res := <-conn.Resp
-		log.Println(res)
+		// Each connection is run in separate goroutine.
+		// Later we will use poll/epoll together with goroutine pool.
+		// I assume that we won't have more than 10k connections at a time.
+		go ln.handle(conn)
 	}
 }
 
-// Run command
-func RunCmd(srv *server.Server, cmd *server.Cmd, conn *server.Conn) ([]byte, error) {
-	switch cmd.Type {
-	case server.CmdAdd:
-		log.Println("Add new key")
+// Drain connections, stop workers and close database.
+// Return process exit code.
+func shutdown(cfg *Config, srv *server.Server, gateway *http.Server) int {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	code := ExitOK
 
-		// send the write request to the file worker
-		srv.SendToWorker(cmd)
+	// HTTP requests run commands on workers, so they must finish first.
+	err := gateway.Shutdown(ctx)
+	if err != nil {
+		gateway.Close()
+	}
+
+	// Drain even if ctx expired, remaining connections are closed.
+	e := srv.Drain(ctx)
+	if e != nil {
+		err = e
+	}
 
-		return nil, nil
-	default:
-		log.Printf("unknown command: %d", cmd.Type)
+	if err != nil {
+		errorf("forced shutdown: %s", err)
+		code = ExitForced
 	}
 
-	return nil, nil
+	err = srv.Close()
+	if err != nil {
+		errorf("can't close server: %s", err)
+		return ExitError
+	}
+
+	infof("ByteDB server stopped")
+	return code
+}
+
+func handleConn(srv *server.Server, conn *server.Conn) {
+	debugf("handling connection...")
+
+	// close connection on exit
+	defer srv.RemoveConn(conn)
+	defer conn.Close()
+
+	err := srv.HandleConn(conn)
+	if err != nil {
+		debugf("Read error or client closed: %s", err)
+	}
+}
+
+func handleRedisConn(srv *server.Server, redis *server.Redis, conn *server.Conn) {
+	debugf("handling Redis connection...")
+
+	// close connection on exit
+	defer srv.RemoveConn(conn)
+	defer conn.Close()
+
+	err := redis.HandleConn(conn)
+	if err != nil {
+		debugf("Read error or client closed: %s", err)
+	}
 }
@@ -0,0 +1,459 @@
+package db
+
+import (
+	"archive/tar"
+	"bytedb/db/wal"
+	bit "bytedb/lib/bitbox"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ManifestName    = "MANIFEST.json"
+	ManifestVersion = 1
+
+	// Directories of main and internal database in archive
+	BackupData     = "data"
+	BackupInternal = "internal"
+
+	// Wal records included in backup, stored in database directory
+	BackupWal = "/wal.log"
+)
+
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Backup manifest, it's the last file in archive.
+type Manifest struct {
+	Version     int            `json:"version"`
+	Created     time.Time      `json:"created"`
+	LSN         uint64         `json:"lsn"`          // snapshot lsn of main database
+	InternalLSN uint64         `json:"internal_lsn"` // snapshot lsn of internal database
+	Files       []ManifestFile `json:"files"`
+}
+
+// File stored in backup
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Write consistent backup of database to w, as tar archive.
+//
+// Bucket files change on disk only during checkpoint, so backup copies
+// them as of the last checkpoint, together with wal records up to the
+// snapshot lsn. Writes aren't blocked, but checkpoints are postponed
+// until backup is done, so wal grows in the meantime.
+func (db *DB) Backup(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	m := &Manifest{Version: ManifestVersion, Created: time.Now().UTC()}
+
+	var err error
+
+	m.LSN, err = db.backup(tw, BackupData, m)
+	if err != nil {
+		return err
+	}
+
+	if db.internals != nil {
+		m.InternalLSN, err = db.internals.backup(tw, BackupInternal, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = writeEntry(tw, ManifestName, int64(len(data)), bytes.NewReader(data), nil)
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// Write bucket files and wal records of single database to archive.
+// Return snapshot lsn.
+func (db *DB) backup(tw *tar.Writer, dir string, m *Manifest) (uint64, error) {
+	db.files.RLock()
+	defer db.files.RUnlock()
+
+	db.mu.Lock()
+	lsn := db.lsn
+	db.mu.Unlock()
+
+	err := filepath.WalkDir(db.root+CollectionsPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ExtBucket) {
+			return err
+		}
+
+		rel, err := filepath.Rel(db.root, path)
+		if err != nil {
+			return err
+		}
+
+		return backupFile(tw, dir+"/"+filepath.ToSlash(rel), path, m)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	logs, err := db.snapshotWal(lsn)
+	if err != nil {
+		return 0, err
+	}
+
+	err = writeEntry(tw, dir+BackupWal, int64(len(logs)), bytes.NewReader(logs), m)
+	return lsn, err
+}
+
+// Return wal records up to lsn, with length prefixes like in wal segment.
+// Records after lsn can be written concurrently, so reading stops at the
+// first record which is newer or incomplete.
+func (db *DB) snapshotWal(lsn uint64) ([]byte, error) {
+	segs, err := db.wal.Segments()
+	if err != nil {
+		return nil, err
+	}
+
+	logs := []byte{}
+	last := uint64(0)
+	done := false
+
+	for _, seq := range segs {
+		_, err := wal.ReadSegment(wal.SegmentPath(db.root+WalPath, seq), func(off int, log []byte) {
+			if done {
+				return
+			}
+
+			if wal.VerifyRecord(log) != nil {
+				done = true
+				return
+			}
+
+			rec := wal.DecodeRecord(log)
+			if rec.LSN > lsn || rec.LSN < last {
+				done = true
+				return
+			}
+
+			last = rec.LSN
+			size := uint32(len(log))
+
+			logs = append(logs, bit.Encode(&size)...)
+			logs = append(logs, log...)
+		})
+
+		if err != nil && !errors.Is(err, wal.ErrTruncated) {
+			return nil, err
+		}
+
+		if done {
+			break
+		}
+	}
+
+	return logs, nil
+}
+
+// Copy file to archive.
+func backupFile(tw *tar.Writer, name, path string, m *Manifest) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeEntry(tw, name, info.Size(), f, m)
+}
+
+// Write archive entry and add it to manifest, if given.
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader, m *Manifest) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+
+	_, err = io.CopyN(io.MultiWriter(tw, h), r, size)
+	if err != nil {
+		return err
+	}
+
+	if m != nil {
+		m.Files = append(m.Files, ManifestFile{name, size, hex.EncodeToString(h.Sum(nil))})
+	}
+
+	return nil
+}
+
+// Restore database from backup archive into path, which must not
+// exist or be empty. Archive is unpacked to temporary directory and
+// verified against its manifest first. Wal records from backup are
+// then applied to bucket files, so restored database is at snapshot lsn.
+func Restore(archive io.Reader, path string) error {
+	entries, err := os.ReadDir(path)
+	if err == nil && len(entries) > 0 {
+		return fmt.Errorf("restore: %s is not empty", path)
+	}
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	tmp := path + ".restore"
+
+	err = os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = restore(archive, tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	os.Remove(path) // empty directory
+	return os.Rename(tmp, path)
+}
+
+func restore(archive io.Reader, dir string) error {
+	m, files, err := unpack(archive, dir)
+	if err != nil {
+		return err
+	}
+
+	err = verify(m, files)
+	if err != nil {
+		return err
+	}
+
+	err = replayBackup(dir+"/"+BackupInternal, m.InternalLSN)
+	if err != nil {
+		return err
+	}
+
+	return replayBackup(dir, m.LSN)
+}
+
+// Unpack archive into dir. Main database is stored directly in dir,
+// internal one in its subdirectory. Return manifest and checksums
+// of unpacked files.
+func unpack(archive io.Reader, dir string) (*Manifest, map[string]ManifestFile, error) {
+	tr := tar.NewReader(archive)
+	files := map[string]ManifestFile{}
+
+	var m *Manifest
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalidBackup, hdr.Name)
+		}
+
+		if hdr.Name == ManifestName {
+			m = &Manifest{}
+
+			err = json.NewDecoder(tr).Decode(m)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: manifest: %s", ErrInvalidBackup, err)
+			}
+
+			continue
+		}
+
+		rel, ok := entryPath(hdr.Name)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unexpected file %s", ErrInvalidBackup, hdr.Name)
+		}
+
+		if _, ok := files[hdr.Name]; ok {
+			return nil, nil, fmt.Errorf("%w: duplicate file %s", ErrInvalidBackup, hdr.Name)
+		}
+
+		files[hdr.Name], err = unpackFile(tr, hdr, filepath.Join(dir, rel))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if m == nil {
+		return nil, nil, fmt.Errorf("%w: missing manifest", ErrInvalidBackup)
+	}
+
+	return m, files, nil
+}
+
+// Return path of archive entry relative to restored database root.
+// Only bucket files and wal records are expected.
+func entryPath(name string) (string, bool) {
+	dir, rel, ok := strings.Cut(name, "/")
+	if !ok || (dir != BackupData && dir != BackupInternal) || !filepath.IsLocal(rel) {
+		return "", false
+	}
+
+	if rel != BackupWal[1:] && !strings.HasPrefix(rel, CollectionsPath[1:]) {
+		return "", false
+	}
+
+	if dir == BackupInternal {
+		rel = BackupInternal + "/" + rel
+	}
+
+	return rel, true
+}
+
+// Write archive entry to path and return its checksum.
+func unpackFile(r io.Reader, hdr *tar.Header, path string) (ManifestFile, error) {
+	file := ManifestFile{Name: hdr.Name}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return file, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return file, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	file.Size, err = io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return file, err
+	}
+
+	file.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return file, f.Sync()
+}
+
+// Check that unpacked files match manifest.
+func verify(m *Manifest, files map[string]ManifestFile) error {
+	if m.Version != ManifestVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidBackup, m.Version)
+	}
+
+	listed := map[string]bool{}
+
+	for _, mf := range m.Files {
+		f, ok := files[mf.Name]
+		if !ok {
+			return fmt.Errorf("%w: missing file %s", ErrInvalidBackup, mf.Name)
+		}
+
+		if f != mf {
+			return fmt.Errorf("%w: %s: size or checksum mismatch", ErrInvalidBackup, mf.Name)
+		}
+
+		listed[mf.Name] = true
+	}
+
+	for name := range files {
+		if !listed[name] {
+			return fmt.Errorf("%w: %s is not in manifest", ErrInvalidBackup, name)
+		}
+	}
+
+	return nil
+}
+
+// Apply wal records from backup to bucket files in dir. Records keep their
+// lsn, so restored database continues from snapshot lsn.
+func replayBackup(dir string, lsn uint64) error {
+	db, err := open(dir, DefaultOptions(), nil)
+	if err != nil {
+		return err
+	}
+
+	var first error
+
+	_, err = wal.ReadSegment(dir+BackupWal, func(off int, log []byte) {
+		if first != nil {
+			return
+		}
+
+		first = wal.VerifyRecord(log)
+		if first != nil {
+			return
+		}
+
+		rec := wal.DecodeRecord(log)
+		if rec.Type == wal.RecCheckpoint {
+			return
+		}
+
+		db.mu.Lock()
+		db.lsn = rec.LSN - 1
+		db.mu.Unlock()
+
+		_, first = db.change(rec.Type, recordKey(rec), rec.Time, nil)
+	})
+
+	// Internal database is missing in backups of databases without it.
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+
+	if err == nil {
+		err = first
+	}
+
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	db.mu.Lock()
+	db.lsn = max(db.lsn, lsn)
+	db.mu.Unlock()
+
+	err = db.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(dir + BackupWal)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"bytedb/tests"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	opts := DefaultOptions()
+	opts.WalSegmentSize = 4096
+
+	db, _ := OpenWith("./testdb", opts)
+	defer os.RemoveAll("./testdb")
+	defer os.RemoveAll("./restoredb")
+
+	// Part of keys is checkpointed, the rest is only in wal.
+	for i := 0; i < 200; i++ {
+		db.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d", i))))
+	}
+
+	db.DeleteKey(NewKey([]byte("key_0"), nil))
+	db.Internals().Put(NewKey([]byte("user"), []byte("admin")))
+
+	buf := &bytes.Buffer{}
+	tests.Assert(t, nil, db.Backup(buf))
+
+	// Changes after backup aren't restored.
+	db.Put(NewKey([]byte("key_1"), []byte("new")))
+	db.Put(NewKey([]byte("key_200"), []byte("val_200")))
+
+	db.mu.Lock()
+	lsn := db.lsn - 2
+	db.mu.Unlock()
+
+	db.Close()
+
+	tests.Assert(t, nil, Restore(buf, "./restoredb"))
+
+	db, _ = Open("./restoredb")
+	defer db.Close()
+
+	for i := 0; i < 201; i++ {
+		val, _ := db.Get(NewKey([]byte(fmt.Sprintf("key_%d", i)), nil))
+
+		if i == 0 || i == 200 {
+			tests.AssertEqual(t, []byte(nil), val)
+		} else {
+			tests.AssertEqual(t, []byte(fmt.Sprintf("val_%d", i)), val)
+		}
+	}
+
+	val, _ := db.Internals().Get(NewKey([]byte("user"), nil))
+	tests.AssertEqual(t, []byte("admin"), val)
+
+	stats, _ := db.Stats()
+	tests.Assert(t, lsn, stats.LSN)
+
+	_, err := os.Stat("./restoredb" + BackupWal)
+	tests.Assert(t, true, errors.Is(err, os.ErrNotExist))
+}
+
+func TestBackupPostponesCheckpoint(t *testing.T) {
+	opts := DefaultOptions()
+	opts.WalSegmentSize = 4096
+
+	db, _ := OpenWith("./testdb", opts)
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	// Simulate running backup, writes must not wait for it.
+	db.files.RLock()
+
+	for i := 0; i < 200; i++ {
+		db.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte("val")))
+	}
+
+	tests.Assert(t, true, db.wal.Count() > 1)
+	db.files.RUnlock()
+
+	db.Put(NewKey([]byte("key"), []byte("val")))
+	tests.Assert(t, 1, db.wal.Count())
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer os.RemoveAll("./restoredb")
+
+	db.Put(NewKey([]byte("key_1"), []byte("val_1")))
+	db.Checkpoint()
+
+	buf := &bytes.Buffer{}
+	db.Backup(buf)
+	db.Close()
+
+	// Damage value of key in bucket file, it's the first file in archive.
+	data := bytes.Clone(buf.Bytes())
+	pos := bytes.Index(data, []byte("val_1"))
+	data[pos] = 'X'
+
+	err := Restore(bytes.NewReader(data), "./restoredb")
+	tests.Assert(t, true, errors.Is(err, ErrInvalidBackup))
+
+	_, err = os.Stat("./restoredb")
+	tests.Assert(t, true, errors.Is(err, os.ErrNotExist))
+
+	// Database can't be restored over existing one.
+	err = Restore(bytes.NewReader(buf.Bytes()), "./testdb")
+	tests.AssertNot(t, nil, err)
+}
@@ -3,9 +3,10 @@ package db
 const BlockSize = 4096
 
 type Block struct {
-	ID   uint32 // position in file
-	Off  uint16 // current offset
-	Data []byte // raw 4 KB data
+	ID    uint32 // position in file
+	Off   uint16 // current offset
+	Data  []byte // raw 4 KB data
+	Dirty bool   // modified since last flush
 }
 
 // Create new block
@@ -24,6 +25,7 @@ func (b *Block) Write(buf []byte) int {
 
 	n := copy(b.Data[b.Off:], buf)
 	b.Off += uint16(n)
+	b.Dirty = true
 
 	return n
 }
@@ -43,3 +45,19 @@ func (b *Block) Read(offset int, dst []byte) int {
 func (b *Block) SpaceLeft() int {
 	return BlockSize - int(b.Off)
 }
+
+// Return number of used bytes. Offset isn't stored on disk, so for
+// blocks read from file it's the position after the last non-zero byte.
+func (b *Block) Used() int {
+	if b.Off > 0 {
+		return int(b.Off)
+	}
+
+	for i := len(b.Data) - 1; i >= 0; i-- {
+		if b.Data[i] != 0 {
+			return i + 1
+		}
+	}
+
+	return 0
+}
@@ -39,3 +39,15 @@ func BenchmarkMapIteration30K(b *testing.B) {
 		}
 	}
 }
+
+func TestBlockUsed(t *testing.T) {
+	b := NewBlock(1)
+	tests.Assert(t, 0, b.Used())
+
+	b.Write([]byte{1, 2, 3})
+	tests.Assert(t, 3, b.Used())
+
+	// Offset is lost when block is read from disk
+	b.Off = 0
+	tests.Assert(t, 3, b.Used())
+}
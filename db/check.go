@@ -0,0 +1,472 @@
+package db
+
+import (
+	"bytedb/db/wal"
+	bit "bytedb/lib/bitbox"
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidKV = errors.New("invalid key record")
+	ErrChecksum  = errors.New("key checksum mismatch")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Return checksum of encoded key. Records written by older
+// versions have no checksum, it's read as 0 from block padding.
+func checksum(data []byte) uint32 {
+	return crc32.Checksum(data, crcTable)
+}
+
+// Problem found by Check
+type Problem struct {
+	Path  string
+	Block uint32 // 0 if problem isn't related to a single block
+	Msg   string
+}
+
+func (p Problem) String() string {
+	if p.Block == 0 {
+		return fmt.Sprintf("%s: %s", p.Path, p.Msg)
+	}
+
+	return fmt.Sprintf("%s: block %d: %s", p.Path, p.Block, p.Msg)
+}
+
+// Result of Check. Errors mean data is damaged, warnings
+// are harmless, like space not reclaimed after updates.
+type Report struct {
+	Files    int // checked bucket files
+	Keys     int // live keys
+	Records  int // wal records
+	Errors   []Problem
+	Warnings []Problem
+
+	damaged map[string]bool // bucket files with errors
+}
+
+// Check if no errors were found
+func (r *Report) OK() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *Report) errorf(path string, block uint32, format string, args ...any) {
+	r.Errors = append(r.Errors, Problem{path, block, fmt.Sprintf(format, args...)})
+
+	if strings.HasSuffix(path, ExtBucket) {
+		if r.damaged == nil {
+			r.damaged = map[string]bool{}
+		}
+
+		r.damaged[path] = true
+	}
+}
+
+func (r *Report) warnf(path string, block uint32, format string, args ...any) {
+	r.Warnings = append(r.Warnings, Problem{path, block, fmt.Sprintf(format, args...)})
+}
+
+// Check database in root directory, including internal database. All
+// bucket files and wal segments are verified. Database must not be open,
+// its files are read directly.
+func Check(root string) (*Report, error) {
+	r := &Report{}
+
+	for _, dir := range []string{root, root + "/internal"} {
+		err := check(dir, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Check bucket files and wal of single database.
+func check(root string, r *Report) error {
+	err := filepath.WalkDir(root+CollectionsPath, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ExtBucket) {
+			return err
+		}
+
+		return CheckFile(path, r)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return checkWal(root+WalPath, r, false)
+}
+
+// Check single bucket file and add found problems to report.
+func CheckFile(path string, r *Report) error {
+	f, err := OpenFileReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer f.file.Close()
+
+	r.Files++
+	count := uint32(f.BlockCount())
+
+	if rest := f.Size() % BlockSize; rest != 0 {
+		r.errorf(path, 0, "%d bytes after the last full block", rest)
+	}
+
+	first, last := f.IndexOffset, f.IndexOffset+f.IndexBlocks-1
+
+	if f.IndexBlocks == 0 || first <= DefaultHeaderBlocks || uint64(first)+uint64(f.IndexBlocks)-1 > uint64(count) {
+		r.errorf(path, 1, "invalid header: index offset %d, index blocks %d, file has %d blocks", f.IndexOffset, f.IndexBlocks, count)
+		return nil
+	}
+
+	index := &Index{file: f, FirstID: first, LastID: last, Headers: map[uint32]*IndexHeader{}}
+
+	// Index blocks, read once, they are needed for probing.
+	headers := map[uint32]*IndexHeader{}
+	entries := map[uint32][]*IndexKey{}
+
+	for id := first; id <= last; id++ {
+		b, err := f.readBlock(id)
+		if err != nil {
+			return err
+		}
+
+		headers[id], entries[id] = DecodeIndexBlock(b)
+	}
+
+	used := map[uint32]bool{} // blocks referenced by index
+	records := []record{}     // records of live keys
+	names := map[uint64][][]byte{}
+
+	for id := first; id <= last; id++ {
+		h := headers[id]
+		deleted := 0
+
+		if int(h.Keys) > IndexPerBlock {
+			r.errorf(path, id, "index header has %d keys, max %d", h.Keys, IndexPerBlock)
+		}
+
+		for pos, idx := range entries[id] {
+			if idx.Flag&FlagDeleted != 0 {
+				deleted++
+			}
+
+			if idx.Flag&^FlagDeleted != 0 {
+				r.warnf(path, id, "slot %d: unknown flags %#x", pos, idx.Flag)
+			}
+
+			end := uint64(idx.Offset) + uint64(idx.Span)
+
+			if idx.Span == 0 || idx.Offset <= DefaultHeaderBlocks || end-1 > uint64(count) || (idx.Offset <= last && uint32(end-1) >= first) {
+				r.errorf(path, id, "slot %d: span %d-%d is outside of value blocks", pos, idx.Offset, end-1)
+				continue
+			}
+
+			for n := idx.Offset; uint64(n) < end; n++ {
+				used[n] = true
+			}
+
+			if idx.Flag&FlagDeleted != 0 {
+				continue
+			}
+
+			r.Keys++
+
+			key, size, err := checkKV(f, idx)
+			if err != nil {
+				r.errorf(path, id, "slot %d: %s", pos, err)
+				continue
+			}
+
+			start := uint64(idx.Offset)*BlockSize + uint64(idx.Pos)
+			records = append(records, record{start, start + uint64(size), id, pos, idx.Hash})
+
+			for _, name := range names[idx.Hash] {
+				if string(name) == string(key.Name) {
+					r.errorf(path, id, "slot %d: duplicate key %q", pos, key.Name)
+				}
+			}
+
+			names[idx.Hash] = append(names[idx.Hash], key.Name)
+
+			// Lookup stops at the first block that is not full.
+			for home := index.BlockID(idx.Hash); home != id; home = index.next(home) {
+				if int(headers[home].Keys) < IndexPerBlock {
+					r.errorf(path, id, "slot %d: key %q can't be found, block %d before it isn't full", pos, key.Name, home)
+					break
+				}
+			}
+		}
+
+		if int(h.Tombstones) != deleted {
+			r.errorf(path, id, "index header has %d tombstones, found %d deleted keys", h.Tombstones, deleted)
+		}
+	}
+
+	// Records are packed, live ones must not share any bytes.
+	slices.SortFunc(records, func(a, b record) int { return cmp.Compare(a.start, b.start) })
+
+	for i := 1; i < len(records); i++ {
+		if prev, rec := records[i-1], records[i]; rec.start < prev.end {
+			r.errorf(path, rec.block, "slot %d: record overlaps record of key with hash %016x", rec.slot, prev.hash)
+		}
+	}
+
+	orphans := []uint32{}
+
+	for id := last + 1; id <= count; id++ {
+		if used[id] {
+			continue
+		}
+
+		b, err := f.readBlock(id)
+		if err != nil {
+			return err
+		}
+
+		if b.Used() > 0 {
+			orphans = append(orphans, id)
+		}
+	}
+
+	// Old index blocks are zeroed when index grows, but
+	// value blocks can be there too.
+	for id := uint32(DefaultHeaderBlocks + 1); id < first; id++ {
+		if used[id] {
+			continue
+		}
+
+		b, err := f.readBlock(id)
+		if err != nil {
+			return err
+		}
+
+		if b.Used() > 0 {
+			orphans = append(orphans, id)
+		}
+	}
+
+	if len(orphans) > 0 {
+		r.warnf(path, 0, "%d orphaned value blocks, not referenced by index: %s", len(orphans), blockList(orphans))
+	}
+
+	return nil
+}
+
+// Bytes of file used by record of live key, and index slot pointing to it.
+type record struct {
+	start, end uint64
+	block      uint32
+	slot       int
+	hash       uint64
+}
+
+// Read key pointed by index and check if it's valid.
+// Return key and size of its record.
+func checkKV(f *File, idx *IndexKey) (*Key, int, error) {
+	data := []byte{}
+
+	for id := idx.Offset; id < idx.Offset+uint32(idx.Span); id++ {
+		b, err := f.readBlock(id)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		data = append(data, b.Data...)
+	}
+
+	if int(idx.Pos) >= len(data) {
+		return nil, 0, fmt.Errorf("position %d is outside of block", idx.Pos)
+	}
+
+	key, size, err := decodeKV(data[idx.Pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if Hash(key.Name) != idx.Hash {
+		return nil, 0, fmt.Errorf("key %q has hash %016x, index has %016x", key.Name, Hash(key.Name), idx.Hash)
+	}
+
+	if blocks := (int(idx.Pos) + size + BlockSize - 1) / BlockSize; blocks != int(idx.Span) {
+		return nil, 0, fmt.Errorf("key %q needs %d blocks, index span is %d", key.Name, blocks, idx.Span)
+	}
+
+	return key, size, nil
+}
+
+// Decode key record and verify its checksum. Return key
+// and size of record, without padding.
+func decodeKV(data []byte) (*Key, int, error) {
+	key := &Key{}
+	buf := bit.NewBuffer(data)
+
+	for _, field := range []*[]byte{&key.Name, &key.Value} {
+		size := uint32(0)
+		if buf.Len() < 4 {
+			return nil, 0, ErrInvalidKV
+		}
+
+		buf.Decode(&size)
+
+		if uint64(buf.Len()) < uint64(size) {
+			return nil, 0, ErrInvalidKV
+		}
+
+		data, _ := buf.Take(int(size))
+		*field = append([]byte{}, data...)
+	}
+
+	if buf.Len() < 8 {
+		return nil, 0, ErrInvalidKV
+	}
+
+	buf.Decode(&key.Expire)
+
+	// Record without content type and checksum ends exactly at block end.
+	if buf.Len() < 4 {
+		return key, len(data) - buf.Len(), nil
+	}
+
+	ctype, err := buf.Bytes()
+	if err != nil {
+		return nil, 0, ErrInvalidKV
+	}
+
+	key.ContentType = string(ctype)
+	size := len(data) - buf.Len()
+
+	if buf.Len() < 4 {
+		return key, size, nil
+	}
+
+	sum := uint32(0)
+	buf.Decode(&sum)
+
+	if sum == 0 {
+		return key, size, nil // written by older version
+	}
+
+	if sum != checksum(data[:size]) {
+		return nil, 0, ErrChecksum
+	}
+
+	return key, size + 4, nil
+}
+
+// Check all wal segments. If truncate is set, first invalid
+// record and everything after it is removed.
+func checkWal(dir string, r *Report, truncate bool) error {
+	segs, err := wal.Segments(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lsn := uint64(0)
+
+	for i, seq := range segs {
+		path := wal.SegmentPath(dir, seq)
+		bad := -1
+
+		end, err := wal.ReadSegment(path, func(off int, log []byte) {
+			if bad >= 0 {
+				return
+			}
+
+			err := wal.VerifyRecord(log)
+			if err != nil {
+				r.errorf(path, 0, "offset %d: %s", off, err)
+				bad = off
+				return
+			}
+
+			rec := wal.DecodeRecord(log)
+			if rec.LSN < lsn {
+				r.errorf(path, 0, "offset %d: lsn %d after %d", off, rec.LSN, lsn)
+				bad = off
+				return
+			}
+
+			lsn = rec.LSN
+			r.Records++
+		})
+
+		if errors.Is(err, wal.ErrTruncated) {
+			r.errorf(path, 0, "%s", err)
+
+			if bad < 0 {
+				bad = end
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if bad < 0 || !truncate {
+			continue
+		}
+
+		err = wal.TruncateSegment(path, bad)
+		if err != nil {
+			return err
+		}
+
+		// Later segments can't be replayed without missing records.
+		for _, next := range segs[i+1:] {
+			err = os.Remove(wal.SegmentPath(dir, next))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// Return string with list of block ids, long lists are shortened.
+func blockList(ids []uint32) string {
+	const max = 10
+
+	s := []string{}
+	for _, id := range ids[:min(len(ids), max)] {
+		s = append(s, fmt.Sprint(id))
+	}
+
+	if len(ids) > max {
+		s = append(s, "...")
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// Read block from disk, skipping cache.
+func (f *File) readBlock(id uint32) (*Block, error) {
+	b := NewBlock(id)
+
+	_, err := f.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
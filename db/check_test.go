@@ -0,0 +1,204 @@
+package db
+
+import (
+	"bytedb/db/wal"
+	"bytedb/tests"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Create database with some keys and return its root and bucket file.
+func checkDB(t *testing.T) (string, string) {
+	root := t.TempDir()
+
+	db, _ := Open(root)
+	for i := 0; i < 10; i++ {
+		db.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte("val")))
+	}
+
+	db.DeleteKey(NewKey([]byte("key_0"), nil))
+	db.Put(NewKey([]byte("key_1"), []byte("new")))
+	db.Close()
+
+	files, _ := filepath.Glob(root + "/collections/*/*/*" + ExtBucket)
+	return root, files[0]
+}
+
+// Overwrite bytes in file at offset
+func patch(path string, off int64, data []byte) {
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	f.WriteAt(data, off)
+	f.Close()
+}
+
+// Return report as single string
+func problems(r *Report) string {
+	s := []string{}
+	for _, p := range append(r.Errors, r.Warnings...) {
+		s = append(s, p.String())
+	}
+
+	return strings.Join(s, "\n")
+}
+
+func TestCheck(t *testing.T) {
+	root, _ := checkDB(t)
+
+	r, err := Check(root)
+	tests.Assert(t, nil, err)
+	tests.Assert(t, true, r.OK())
+
+	// Internal database keeps quota and usage of the collection
+	tests.Assert(t, 3, r.Files)
+	tests.Assert(t, 9+2, r.Keys)
+
+	// Old value of key_1 shares block with live keys
+	tests.Assert(t, 0, len(r.Warnings))
+
+	// Value too big to be packed leaves its block unused after update
+	db, _ := Open(root)
+	db.Put(NewKey([]byte("big"), make([]byte, BlockSize-100)))
+	db.Put(NewKey([]byte("big"), make([]byte, BlockSize-100)))
+	db.Close()
+
+	r, _ = Check(root)
+	tests.Assert(t, true, r.OK())
+	tests.Assert(t, true, strings.Contains(problems(r), "1 orphaned value blocks"))
+}
+
+// Return index entry of key with given name
+func findIndex(path string, name string) *IndexKey {
+	f, _ := OpenFileReadOnly(path)
+	defer f.Close()
+
+	for id := f.IndexOffset; id < f.IndexOffset+f.IndexBlocks; id++ {
+		b, _ := f.readBlock(id)
+
+		_, keys := DecodeIndexBlock(b)
+		for _, idx := range keys {
+			if idx.Hash == Hash([]byte(name)) && idx.Flag&FlagDeleted == 0 {
+				return idx
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestCheckValue(t *testing.T) {
+	root, path := checkDB(t)
+
+	// Change value of key_2, it's after lengths and name of record
+	idx := findIndex(path, "key_2")
+	patch(path, int64(idx.Offset-1)*BlockSize+int64(idx.Pos)+14, []byte("x"))
+
+	r, _ := Check(root)
+	tests.Assert(t, false, r.OK())
+	tests.Assert(t, true, strings.Contains(problems(r), ErrChecksum.Error()))
+}
+
+func TestCheckIndex(t *testing.T) {
+	root, path := checkDB(t)
+
+	f, _ := OpenFileReadOnly(path)
+
+	// Break span of the first live key
+	for id := f.IndexOffset; id < f.IndexOffset+f.IndexBlocks; id++ {
+		b, _ := f.readBlock(id)
+
+		_, keys := DecodeIndexBlock(b)
+		for pos, idx := range keys {
+			if idx.Flag&FlagDeleted == 0 {
+				idx.Span = 1000
+				writeIndex(b, pos, idx)
+				patch(path, int64(id-1)*BlockSize, b.Data)
+
+				id = f.IndexOffset + f.IndexBlocks
+				break
+			}
+		}
+	}
+
+	f.Close()
+
+	r, _ := Check(root)
+	tests.Assert(t, false, r.OK())
+	tests.Assert(t, true, strings.Contains(problems(r), "is outside of value blocks"))
+
+	// Header pointing past the end of file
+	patch(path, 0, []byte{100})
+
+	r, _ = Check(root)
+	tests.Assert(t, true, strings.Contains(problems(r), "invalid header"))
+}
+
+func TestRebuildIndex(t *testing.T) {
+	root, path := checkDB(t)
+
+	// Destroy index entries of one block, keep header
+	f, _ := OpenFileReadOnly(path)
+	for id := f.IndexOffset; id < f.IndexOffset+f.IndexBlocks; id++ {
+		b, _ := f.readBlock(id)
+		_, keys := DecodeIndexBlock(b)
+
+		for pos, idx := range keys {
+			if idx.Flag&FlagDeleted == 0 {
+				idx.Hash++
+				writeIndex(b, pos, idx)
+			}
+		}
+
+		patch(path, int64(id-1)*BlockSize, b.Data)
+	}
+	f.Close()
+
+	r, _ := Check(root)
+	tests.Assert(t, false, r.OK())
+
+	r, err := Repair(root, &RepairOptions{RebuildIndex: true})
+	tests.Assert(t, nil, err)
+	tests.Assert(t, true, r.OK())
+	tests.Assert(t, 9+2, r.Keys)
+
+	_, err = os.Stat(path + ExtBackup)
+	tests.Assert(t, nil, err)
+
+	db, _ := Open(root)
+	defer db.Close()
+
+	// Latest value wins, deleted key stays deleted
+	val, _ := db.Get(NewKey([]byte("key_1"), nil))
+	tests.AssertEqual(t, []byte("new"), val)
+
+	val, _ = db.Get(NewKey([]byte("key_0"), nil))
+	tests.AssertEqual(t, []byte(nil), val)
+
+	val, _ = db.Get(NewKey([]byte("key_9"), nil))
+	tests.AssertEqual(t, []byte("val"), val)
+}
+
+func TestRepairWal(t *testing.T) {
+	root := t.TempDir()
+
+	w, _ := wal.Open(root+WalPath, 1_000)
+	w.Write((&wal.Record{LSN: 1, Type: wal.RecPut, Key: []byte("key_1")}).Encode())
+
+	rec := (&wal.Record{LSN: 2, Type: wal.RecPut, Key: []byte("key_2")}).Encode()
+	rec[len(rec)-1]++
+	w.Write(rec)
+
+	w.Write((&wal.Record{LSN: 3, Type: wal.RecPut, Key: []byte("key_3")}).Encode())
+	w.Close()
+
+	r, _ := Check(root)
+	tests.Assert(t, false, r.OK())
+	tests.Assert(t, true, strings.Contains(problems(r), wal.ErrChecksum.Error()))
+
+	r, err := Repair(root, &RepairOptions{TruncateWal: true})
+	tests.Assert(t, nil, err)
+	tests.Assert(t, true, r.OK())
+	tests.Assert(t, 1, r.Records)
+}
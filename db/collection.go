@@ -1,7 +1,9 @@
 package db
 
 import (
+	"fmt"
 	"sync"
+	"time"
 )
 
 const (
@@ -14,21 +16,138 @@ type Collection struct {
 	Hash uint64
 	Path string
 
+	// Max number of blocks cached per bucket file, 0 means no limit.
+	CacheBlocks int
+
 	mu      sync.RWMutex
-	Buckets map[uint64]Bucket
+	Buckets map[string]*Bucket
+
+	// Quota and usage, see DB.Quota and DB.Usage. Dirty
+	// is set when usage changed since it was saved.
+	usageMu sync.Mutex
+	quota   Quota
+	usage   Usage
+	dirty   bool
+	swept   time.Time // last sweep of expired keys, see DB.sweep
 }
 
 // Open collection from disk. Create it if necessary.
 func OpenCollection(hash uint64, path string) *Collection {
-	return &Collection{Hash: hash, Path: path}
+	return &Collection{Hash: hash, Path: path, Buckets: make(map[string]*Bucket)}
 }
 
-// Load file from disk. Create file if it doesn't exist.
-func (c *Collection) LoadFile(path string, hash uint64) (*File, error) {
-	f, err := OpenFile(path)
+// Return bucket for namespace and prefix. Load it from disk if necessary.
+func (c *Collection) Bucket(namespace, prefix uint64) (*Bucket, error) {
+	path := fmt.Sprintf("%s/%016x/%016x%s", c.Path, namespace, prefix, ExtBucket)
+
+	c.mu.RLock()
+	b, ok := c.Buckets[path]
+	c.mu.RUnlock()
+
+	if ok {
+		return b, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Someone could load it in the meantime.
+	b, ok = c.Buckets[path]
+	if ok {
+		return b, nil
+	}
+
+	b, err := OpenBucket(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return f, nil
+	b.CacheBlocks = c.CacheBlocks
+
+	c.Buckets[path] = b
+	return b, nil
+}
+
+// Write key to collection
+func (c *Collection) Put(key *Key) error {
+	b, err := c.Bucket(key.Namespace, key.Prefix)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(key)
+}
+
+// Read key value from collection. Return nil if key doesn't exist.
+func (c *Collection) Get(key *Key) ([]byte, error) {
+	b, err := c.Bucket(key.Namespace, key.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Get(key)
+}
+
+// Read whole key from collection. Return nil if key doesn't exist.
+func (c *Collection) Lookup(key *Key) (*Key, error) {
+	b, err := c.Bucket(key.Namespace, key.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Lookup(key)
+}
+
+// Scan keys of namespace and prefix, see Bucket.Scan.
+func (c *Collection) Scan(namespace, prefix, cursor uint64, count int) ([]*Key, uint64, error) {
+	b, err := c.Bucket(namespace, prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return b.Scan(cursor, count)
+}
+
+// Delete key from collection. Return false if key didn't exist.
+func (c *Collection) Delete(key *Key) (bool, error) {
+	b, err := c.Bucket(key.Namespace, key.Prefix)
+	if err != nil {
+		return false, err
+	}
+
+	return b.Delete(key)
+}
+
+// Flush dirty blocks of all buckets to disk.
+func (c *Collection) Flush() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, b := range c.Buckets {
+		err := b.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush and close all buckets.
+func (c *Collection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var first error
+
+	for path, b := range c.Buckets {
+		err := b.Close()
+		if err != nil && first == nil {
+			first = err
+		}
+
+		delete(c.Buckets, path)
+	}
+
+	return first
 }
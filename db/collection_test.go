@@ -1,21 +1,37 @@
 package db
 
 import (
-	"bytedb/collection"
+	"bytedb/tests"
 	"fmt"
 	"os"
-	"path/filepath"
 	"testing"
 )
 
 func TestCollectionAdd(t *testing.T) {
-	coll := OpenCollection("test")
+	coll := OpenCollection(Hash([]byte("test")), "./test")
 	defer os.RemoveAll("./test")
 
-	k := collection.NewKey([]byte("Key_1"))
-	v := []byte("Val_1")
+	k := NewKey([]byte("Key_1"), []byte("Val_1"))
+	coll.Put(k)
 
-	coll.Add(k, v)
+	val, _ := coll.Get(k)
+	tests.AssertEqual(t, []byte("Val_1"), val)
+}
+
+func TestCollectionIndexGrow(t *testing.T) {
+	coll := OpenCollection(Hash([]byte("test")), "./test")
+	defer os.RemoveAll("./test")
+
+	// More keys than default index blocks can hold.
+	n := DefaultIndexBlocks*IndexPerBlock + 100
+
+	for i := 0; i < n; i++ {
+		k := NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d", i)))
+		coll.Put(k)
+	}
 
-	fmt.Println(filepath.Clean(coll.Dir))
+	for i := 0; i < n; i++ {
+		val, _ := coll.Get(NewKey([]byte(fmt.Sprintf("key_%d", i)), nil))
+		tests.AssertEqual(t, []byte(fmt.Sprintf("val_%d", i)), val)
+	}
 }
@@ -1,32 +1,631 @@
 package db
 
 import (
+	"bytedb/db/wal"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	CollectionsPath = "/collections/"
+	WalPath         = "/wal"
+	WalArchivePath  = "/wal-archive"
 )
 
+const (
+	DefaultWalSize     = 16 << 20 // 16 MB
+	DefaultWalInterval = 20       // sync interval in milliseconds
+	DefaultCacheBlocks = 1024     // 4 MB per file
+)
+
+// Database options
+type Options struct {
+	WalSegmentSize int64        // size of single wal segment in bytes
+	WalSync        wal.SyncMode // when wal is synced to disk
+	CacheBlocks    int          // max number of blocks cached per file, 0 means no limit
+
+	// Size of old wal segments kept after checkpoint, so replicas can
+	// catch up after disconnect. 0 means segments are removed.
+	WalRetain int64
+
+	// Database is a replica of other database. Collection usage is
+	// counted when collection is loaded and never saved, because
+	// internal database is a copy too.
+	Replica bool
+}
+
+// Return default database options
+func DefaultOptions() *Options {
+	return &Options{
+		WalSegmentSize: DefaultWalSize,
+		WalSync:        wal.SyncInterval,
+		CacheBlocks:    DefaultCacheBlocks,
+	}
+}
+
 // Main database class
 type DB struct {
 	// Database root directory.
 	root string
 
 	internals *DB
+	opts      *Options
+
+	mu          sync.Mutex
+	collections map[uint64]*Collection
+
+	// Writers hold read lock, checkpoint holds write lock.
+	cp sync.RWMutex
+
+	// Backup holds read lock while copying files, flushes hold write lock.
+	files sync.RWMutex
+
+	wal   *wal.Wal
+	lsn   uint64 // last log sequence number
+	cpLSN uint64 // lsn of the last checkpoint
+
+	// Saved collection usage was checked, see checkUsage.
+	usageChecked bool
+
+	// Followers notified about new wal records, guarded by mu.
+	subs map[*subscriber]struct{}
 }
 
-// Open database.
+// Open database with default options.
 func Open(path string) (*DB, error) {
+	return OpenWith(path, DefaultOptions())
+}
+
+// Open database.
+func OpenWith(path string, opts *Options) (*DB, error) {
 	// Create main database and internal one
-	internal := path + "/internal"
-	err := os.MkdirAll(internal, 0755)
+	internals, err := open(path+"/internal", opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := open(path, opts, internals)
+	if err != nil {
+		internals.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Open database in given directory and replay its wal.
+// Collection usage is tracked only if internals are given.
+func open(path string, opts *Options, internals *DB) (*DB, error) {
+	err := os.MkdirAll(path+CollectionsPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := wal.Open(path+WalPath, opts.WalSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Mode = opts.WalSync
+
+	if opts.WalRetain > 0 {
+		w.Archive = path + WalArchivePath
+		w.ArchiveSize = opts.WalRetain
+	}
+
+	db := &DB{
+		root:        path,
+		internals:   internals,
+		opts:        opts,
+		wal:         w,
+		collections: make(map[uint64]*Collection),
+	}
+
+	err = db.replay()
 	if err != nil {
+		w.Close()
 		return nil, err
 	}
 
-	internals := &DB{root: internal}
-	return &DB{root: path, internals: internals}, nil
+	go w.Start(DefaultWalInterval)
+	return db, nil
+}
+
+// Apply all changes from wal that didn't make it to data files.
+// After clean shutdown wal contains only checkpoint record.
+func (db *DB) replay() error {
+	var first error
+
+	// Record damaged on disk or torn by crash and all records after
+	// it are dropped, the same way as Repair does it.
+	err := db.wal.Map(func(log []byte) bool {
+		if wal.VerifyRecord(log) != nil {
+			return false
+		}
+
+		rec := wal.DecodeRecord(log)
+		db.lsn = rec.LSN
+
+		if rec.Type == wal.RecCheckpoint {
+			db.cpLSN = rec.LSN
+			return true
+		}
+
+		if first == nil {
+			first = db.apply(rec.Type, recordKey(rec))
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return first
+}
+
+// Return key changed by wal record.
+func recordKey(rec *wal.Record) *Key {
+	key := NewKey(rec.Key, rec.Value)
+	key.Collection = rec.Collection
+	key.Namespace = rec.Namespace
+	key.Prefix = rec.Prefix
+	key.Expire = rec.Expire
+	key.ContentType = rec.ContentType
+
+	return key
+}
+
+// Return collection for the given hash. Load it from disk if necessary.
+func (db *DB) Collection(hash uint64) (*Collection, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	coll, ok := db.collections[hash]
+	if ok {
+		return coll, nil
+	}
+
+	path := fmt.Sprintf("%s%s%016x", db.root, CollectionsPath, hash)
+
+	coll = OpenCollection(hash, path)
+	coll.CacheBlocks = db.opts.CacheBlocks
+
+	if db.internals != nil {
+		err := db.loadUsage(coll)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db.collections[hash] = coll
+
+	return coll, nil
+}
+
+// Write key to database. Change is logged to wal before it's applied.
+func (db *DB) Put(key *Key) error {
+	_, err := db.change(wal.RecPut, key, 0, nil)
+	return err
+}
+
+// Delete key from database. Return false if key didn't exist or was expired.
+func (db *DB) DeleteKey(key *Key) (bool, error) {
+	return db.change(wal.RecDelete, key, 0, nil)
+}
+
+// Change key depending on its current state, atomically with other changes
+// of the key. Fn gets existing key, nil if it doesn't exist or is expired,
+// sets value and expiration time of key and returns wal.RecPut or
+// wal.RecDelete, or 0 to keep key as it is. Fn can be called more than once.
+// Return false if nothing was changed.
+func (db *DB) Update(key *Key, fn func(old *Key) (uint8, error)) (bool, error) {
+	return db.change(0, key, 0, func(old *Key) (uint8, error) {
+		if old != nil && old.Expired() {
+			old = nil
+		}
+
+		return fn(old)
+	})
+}
+
+// Log and apply change. Return false if there was nothing to change.
+// Commit time is kept for changes copied from other database, 0 means now.
+// If update is given, it returns type of change based on existing key,
+// including expired one, see logApply.
+func (db *DB) change(typ uint8, key *Key, at int64, update func(old *Key) (uint8, error)) (bool, error) {
+	ok, err := db.logApply(typ, key, at, update)
+
+	// Expired keys count against quota until they are deleted,
+	// so they are swept before put is rejected.
+	if errors.Is(err, ErrQuotaExceeded) && db.sweep(key.Collection) {
+		ok, err = db.logApply(typ, key, at, update)
+	}
+
+	if err != nil {
+		return ok, err
+	}
+
+	// Wal moved to next segment, it's time to flush dirty blocks.
+	// Checkpoint is skipped while backup is running.
+	if db.wal.Count() > 1 && db.files.TryLock() {
+		defer db.files.Unlock()
+		return ok, db.checkpoint()
+	}
+
+	return ok, nil
+}
+
+// Type of change is returned by update if it's given, 0 means no change.
+func (db *DB) logApply(typ uint8, key *Key, at int64, update func(old *Key) (uint8, error)) (bool, error) {
+	db.cp.RLock()
+	defer db.cp.RUnlock()
+
+	coll, b, err := db.bucket(key)
+	if err != nil {
+		return false, err
+	}
+
+	// Hold bucket lock, so changes are applied in wal order.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Existing key is needed for deletes and for tracking usage.
+	var old *Key
+
+	if typ == wal.RecDelete || update != nil || db.internals != nil {
+		old, err = b.find(key)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if update != nil {
+		typ, err = update(old)
+		if err != nil || typ == 0 {
+			return false, err
+		}
+	}
+
+	live := true
+
+	// Don't log deletes of missing keys. Expired ones are still
+	// deleted, but reported as missing.
+	if typ == wal.RecDelete {
+		if old == nil {
+			return false, nil
+		}
+
+		live = !old.Expired()
+	}
+
+	// Quotas are enforced only for new changes, not for ones
+	// copied from other database.
+	keys, bytes := usageChange(typ, key, old)
+
+	if db.internals != nil {
+		err = coll.use(key, keys, bytes, typ == wal.RecPut && at == 0)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	start := time.Now()
+
+	err = db.log(&wal.Record{
+		Type:        typ,
+		Collection:  key.Collection,
+		Namespace:   key.Namespace,
+		Prefix:      key.Prefix,
+		Key:         key.Name,
+		Value:       key.Value,
+		Expire:      key.Expire,
+		ContentType: key.ContentType,
+		Time:        at,
+	})
+
+	key.Trace.addWal(start)
+
+	if err != nil {
+		if db.internals != nil {
+			coll.use(key, -keys, -bytes, false)
+		}
+
+		return false, err
+	}
+
+	// Expired keys deleted by update are reported as deleted,
+	// other ones as missing.
+	ok, err := applyTo(b, typ, key)
+	return ok && (live || update != nil), err
+}
+
+// Read key value. Return nil if key doesn't exist.
+func (db *DB) Get(key *Key) ([]byte, error) {
+	coll, err := db.Collection(key.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return coll.Get(key)
+}
+
+// Read whole key, including its expiration time.
+// Return nil if key doesn't exist or is expired.
+func (db *DB) Lookup(key *Key) (*Key, error) {
+	coll, err := db.Collection(key.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return coll.Lookup(key)
+}
+
+// Scan keys with given collection, namespace and prefix, see Bucket.Scan.
+func (db *DB) Scan(collection, namespace, prefix, cursor uint64, count int) ([]*Key, uint64, error) {
+	coll, err := db.Collection(collection)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return coll.Scan(namespace, prefix, cursor, count)
+}
+
+// Return hashes of all collections stored on disk, sorted.
+func (db *DB) Collections() ([]uint64, error) {
+	entries, err := os.ReadDir(db.root + CollectionsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := []uint64{}
+
+	for _, e := range entries {
+		hash, err := strconv.ParseUint(e.Name(), 16, 64)
+		if err != nil || !e.IsDir() {
+			continue // not a collection
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	slices.Sort(hashes)
+	return hashes, nil
+}
+
+// Database statistics
+type Stats struct {
+	Collections uint64 // number of collections
+	Buckets     uint64 // number of bucket files
+	Size        uint64 // size of bucket files on disk, blocks not flushed yet are not counted
+	LSN         uint64 // last log sequence number
+}
+
+// Return database statistics. Bucket files are counted on disk.
+func (db *DB) Stats() (*Stats, error) {
+	colls, err := db.Collections()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{Collections: uint64(len(colls))}
+
+	err = filepath.WalkDir(db.root+CollectionsPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ExtBucket) {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		stats.Buckets++
+		stats.Size += uint64(info.Size())
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	stats.LSN = db.lsn
+	db.mu.Unlock()
+
+	return stats, nil
+}
+
+// Return last log sequence number.
+func (db *DB) LSN() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.lsn
+}
+
+// Return internal database, used for storing metadata like users.
+func (db *DB) Internals() *DB {
+	return db.internals
+}
+
+// Return collection and bucket for the given key.
+func (db *DB) bucket(key *Key) (*Collection, *Bucket, error) {
+	coll, err := db.Collection(key.Collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := coll.Bucket(key.Namespace, key.Prefix)
+	return coll, b, err
+}
+
+// Apply change without logging it.
+func (db *DB) apply(typ uint8, key *Key) error {
+	coll, b, err := db.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if db.internals != nil {
+		old, err := b.find(key)
+		if err != nil {
+			return err
+		}
+
+		keys, bytes := usageChange(typ, key, old)
+		coll.use(key, keys, bytes, false)
+	}
+
+	_, err = applyTo(b, typ, key)
+	return err
+}
+
+// Apply change to bucket. Caller must hold bucket lock.
+func applyTo(b *Bucket, typ uint8, key *Key) (bool, error) {
+	switch typ {
+	case wal.RecPut:
+		return true, b.put(key)
+	case wal.RecDelete:
+		return b.delete(key)
+	}
+
+	return false, fmt.Errorf("unknown change type: %d", typ)
+}
+
+// Assign next LSN to record and write it to wal.
+func (db *DB) log(rec *wal.Record) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.lsn++
+	rec.LSN = db.lsn
+
+	if rec.Time == 0 {
+		rec.Time = time.Now().UnixNano()
+	}
+
+	data := rec.Encode()
+
+	err := db.wal.Write(data)
+	if err != nil {
+		return err
+	}
+
+	db.publish(data)
+	return nil
+}
+
+// Flush dirty blocks of all collections and truncate wal.
+// Writes are blocked until it's done, it waits for running backup.
+func (db *DB) Checkpoint() error {
+	db.files.Lock()
+	defer db.files.Unlock()
+
+	return db.checkpoint()
+}
+
+func (db *DB) checkpoint() error {
+	db.cp.Lock()
+	defer db.cp.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	save, err := db.beginUsage()
+	if err != nil {
+		return err
+	}
+
+	for _, coll := range db.collections {
+		err := coll.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	if save {
+		err = db.saveUsage()
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.truncate()
+}
+
+// Replace all logs with checkpoint record.
+// Data files must be synced before calling it.
+func (db *DB) truncate() error {
+	cp := &wal.Record{LSN: db.lsn, Type: wal.RecCheckpoint}
+	db.cpLSN = db.lsn
+
+	return db.wal.Reset(cp.Encode())
+}
+
+// Close database. All pending wal logs are synced, dirty blocks are
+// flushed and wal is truncated to a checkpoint, so the next Open
+// doesn't need to replay anything.
+func (db *DB) Close() error {
+	// Stop periodic sync
+	db.wal.Stop()
+
+	db.files.Lock()
+	defer db.files.Unlock()
+
+	db.cp.Lock()
+	defer db.cp.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	save, first := db.beginUsage()
+
+	for _, coll := range db.collections {
+		err := coll.Close()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	if save && first == nil {
+		first = db.saveUsage()
+	}
+
+	clear(db.collections)
+
+	// Data files are synced, we can drop logs. If any of them failed
+	// we must keep logs for replay.
+	if first == nil {
+		first = db.truncate()
+	}
+
+	err := db.wal.Close()
+	if err != nil && first == nil {
+		first = err
+	}
+
+	if db.internals != nil {
+		err = db.internals.Close()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
 }
 
 // Delete the entire database
@@ -0,0 +1,321 @@
+package db
+
+import (
+	"bytedb/db/wal"
+	"bytedb/tests"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDBReopen(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+
+	k := NewKey([]byte("key_1"), []byte("val_1"))
+	db.Put(k)
+	db.Close()
+
+	db, _ = Open("./testdb")
+	defer db.Close()
+
+	val, _ := db.Get(k)
+	tests.AssertEqual(t, []byte("val_1"), val)
+}
+
+func TestDBReplay(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+
+	k := NewKey([]byte("key_1"), []byte("val_1"))
+	db.Put(k)
+
+	// Simulate crash, data files are never flushed.
+	db.wal.Stop()
+	db.wal.Close()
+	db.internals.Close()
+
+	db, _ = Open("./testdb")
+	defer db.Close()
+
+	val, _ := db.Get(k)
+	tests.AssertEqual(t, []byte("val_1"), val)
+}
+
+func TestDBReplayDamaged(t *testing.T) {
+	root := t.TempDir()
+	db, _ := Open(root)
+
+	for i := 1; i <= 3; i++ {
+		db.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d", i))))
+	}
+
+	db.wal.Stop()
+	db.wal.Close()
+	db.internals.Close()
+
+	// Value of key_2 is damaged on disk
+	path := wal.SegmentPath(root+WalPath, 1)
+	data, _ := os.ReadFile(path)
+	data[bytes.Index(data, []byte("val_2"))] = 'x'
+	os.WriteFile(path, data, 0644)
+
+	// Damaged record and records after it are dropped
+	db, _ = Open(root)
+
+	val, _ := db.Get(NewKey([]byte("key_1"), nil))
+	tests.AssertEqual(t, []byte("val_1"), val)
+
+	for _, name := range []string{"key_2", "key_3"} {
+		val, _ = db.Get(NewKey([]byte(name), nil))
+		tests.AssertEqual(t, []byte(nil), val)
+	}
+
+	db.Put(NewKey([]byte("key_4"), []byte("val_4")))
+
+	db.wal.Stop()
+	db.wal.Close()
+	db.internals.Close()
+
+	// New records are written in place of dropped ones
+	db, _ = Open(root)
+
+	val, _ = db.Get(NewKey([]byte("key_4"), nil))
+	tests.AssertEqual(t, []byte("val_4"), val)
+	db.Close()
+
+	r, _ := Check(root)
+	tests.Assert(t, true, r.OK())
+}
+
+func TestDBContentType(t *testing.T) {
+	root := t.TempDir()
+	db, _ := Open(root)
+
+	key := NewKey([]byte("key_1"), []byte("{}"))
+	key.ContentType = "application/json"
+	db.Put(key)
+
+	db.wal.Stop()
+	db.wal.Close()
+	db.internals.Close()
+
+	// Type is replayed from wal and stored in bucket file
+	for i := 0; i < 2; i++ {
+		db, _ = Open(root)
+
+		kv, _ := db.Lookup(NewKey([]byte("key_1"), nil))
+		tests.Assert(t, "application/json", kv.ContentType)
+		db.Close()
+	}
+
+	r, _ := Check(root)
+	tests.Assert(t, true, r.OK())
+}
+
+func TestDBCheckpoint(t *testing.T) {
+	opts := DefaultOptions()
+	opts.WalSegmentSize = 4096
+	opts.CacheBlocks = 16
+
+	db, _ := OpenWith("./testdb", opts)
+	defer os.RemoveAll("./testdb")
+
+	// Logs will span many segments, each one triggering checkpoint.
+	for i := 0; i < 1000; i++ {
+		db.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d", i))))
+	}
+
+	tests.Assert(t, 1, db.wal.Count())
+	db.Close()
+
+	db, _ = OpenWith("./testdb", opts)
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		val, _ := db.Get(NewKey([]byte(fmt.Sprintf("key_%d", i)), nil))
+		tests.AssertEqual(t, []byte(fmt.Sprintf("val_%d", i)), val)
+	}
+}
+
+func TestDBDeleteKey(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+
+	k := NewKey([]byte("key_1"), []byte("val_1"))
+	db.Put(k)
+
+	ok, _ := db.DeleteKey(k)
+	tests.Assert(t, true, ok)
+
+	ok, _ = db.DeleteKey(k)
+	tests.Assert(t, false, ok)
+
+	// Simulate crash, delete must be replayed from wal.
+	db.wal.Stop()
+	db.wal.Close()
+	db.internals.Close()
+
+	db, _ = Open("./testdb")
+	defer db.Close()
+
+	val, _ := db.Get(k)
+	tests.AssertEqual(t, []byte(nil), val)
+}
+
+func TestDBExpire(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	k1 := NewKey([]byte("key_1"), []byte("val_1"))
+	k1.Expire = time.Now().Add(-time.Second).UnixNano()
+	db.Put(k1)
+
+	k2 := NewKey([]byte("key_2"), []byte("val_2"))
+	k2.Expire = time.Now().Add(time.Hour).UnixNano()
+	db.Put(k2)
+
+	val, _ := db.Get(k1)
+	tests.AssertEqual(t, []byte(nil), val)
+
+	kv, _ := db.Lookup(k2)
+	tests.Assert(t, k2.Expire, kv.Expire)
+
+	// Expired key is removed, but reported as missing.
+	ok, _ := db.DeleteKey(k1)
+	tests.Assert(t, false, ok)
+}
+
+func TestDBUpdate(t *testing.T) {
+	db, _ := Open(t.TempDir())
+	defer db.Close()
+
+	incr := func() {
+		key := NewKey([]byte("counter"), nil)
+
+		db.Update(key, func(old *Key) (uint8, error) {
+			n := 0
+			if old != nil {
+				n, _ = strconv.Atoi(string(old.Value))
+			}
+
+			key.Value = []byte(strconv.Itoa(n + 1))
+			return wal.RecPut, nil
+		})
+	}
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				incr()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	val, _ := db.Get(NewKey([]byte("counter"), nil))
+	tests.Assert(t, "100", string(val))
+
+	// Nothing is logged when update keeps key
+	lsn := db.LSN()
+
+	ok, err := db.Update(NewKey([]byte("counter"), nil), func(old *Key) (uint8, error) {
+		return 0, nil
+	})
+
+	tests.Assert(t, false, ok)
+	tests.Assert(t, nil, err)
+	tests.Assert(t, lsn, db.LSN())
+}
+
+func TestDBScan(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		db.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte("val")))
+	}
+
+	db.DeleteKey(NewKey([]byte("key_0"), nil))
+
+	found := map[string]bool{}
+	cursor := uint64(0)
+
+	for {
+		keys, next, err := db.Scan(0, 0, 0, cursor, 100)
+		tests.Assert(t, nil, err)
+
+		for _, k := range keys {
+			found[string(k.Name)] = true
+		}
+
+		if next == 0 {
+			break
+		}
+
+		cursor = next
+	}
+
+	tests.Assert(t, 999, len(found))
+	tests.Assert(t, false, found["key_0"])
+}
+
+func TestDBStats(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		key := NewKey([]byte("key"), []byte("val"))
+		key.Collection = uint64(i + 1)
+		db.Put(key)
+	}
+
+	colls, err := db.Collections()
+	tests.Assert(t, nil, err)
+	tests.AssertEqual(t, []uint64{1, 2, 3}, colls)
+
+	// Flush blocks, so they are counted in size
+	db.Checkpoint()
+
+	stats, err := db.Stats()
+	tests.Assert(t, nil, err)
+	tests.Assert(t, uint64(3), stats.Collections)
+	tests.Assert(t, uint64(3), stats.Buckets)
+	tests.Assert(t, uint64(3), stats.LSN)
+	tests.Assert(t, true, stats.Size > 0)
+}
+
+func TestTrace(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	key := NewKey([]byte("key"), []byte("val"))
+	key.Trace = &Trace{}
+
+	db.Put(key)
+	tests.Assert(t, true, key.Trace.Wal > 0)
+	tests.Assert(t, true, key.Trace.Blocks > 0)
+	tests.Assert(t, true, key.Trace.Index > 0)
+
+	key = NewKey([]byte("key"), nil)
+	key.Trace = &Trace{}
+
+	db.Get(key)
+	tests.Assert(t, time.Duration(0), key.Trace.Wal)
+	tests.Assert(t, true, key.Trace.Index > 0)
+}
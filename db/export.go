@@ -0,0 +1,354 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of export format, written in every line
+const ExportVersion = 1
+
+const (
+	ExportScanCount    = 1000 // keys read from bucket at once
+	DefaultImportBatch = 1000
+)
+
+var ErrInvalidExport = errors.New("invalid export line")
+
+// Single key in export, stored as one line of JSON. Hashes are written
+// as 16 hex digits, key and value are base64 encoded.
+type ExportKey struct {
+	Collection string `json:"collection"`
+	Namespace  string `json:"namespace"`
+	Prefix     string `json:"prefix"`
+	Key        []byte `json:"key"`
+	Value      []byte `json:"value"`
+	TTL        int64  `json:"ttl"` // milliseconds until key expires, 0 if it never does
+	Type       string `json:"content_type,omitempty"`
+	Version    int    `json:"version"`
+}
+
+// Keys included in export. Empty list matches everything.
+type ExportFilter struct {
+	Collections []uint64
+	Namespaces  []uint64
+	Prefixes    []uint64
+}
+
+func matchHash(hashes []uint64, hash uint64) bool {
+	return len(hashes) == 0 || slices.Contains(hashes, hash)
+}
+
+// Write all live keys matching filter to w as JSON Lines, return number
+// of written keys. Export isn't a snapshot, keys changed while it's
+// running may or may not be included.
+func (db *DB) Export(w io.Writer, filter *ExportFilter) (int, error) {
+	if filter == nil {
+		filter = &ExportFilter{}
+	}
+
+	colls, err := db.Collections()
+	if err != nil {
+		return 0, err
+	}
+
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	count := 0
+
+	for _, coll := range colls {
+		if !matchHash(filter.Collections, coll) {
+			continue
+		}
+
+		buckets, err := db.buckets(coll)
+		if err != nil {
+			return count, err
+		}
+
+		for _, b := range buckets {
+			ns, prefix := b[0], b[1]
+
+			if !matchHash(filter.Namespaces, ns) || !matchHash(filter.Prefixes, prefix) {
+				continue
+			}
+
+			n, err := db.exportBucket(enc, coll, ns, prefix)
+			count += n
+
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+
+	return count, out.Flush()
+}
+
+// Return namespace and prefix of all buckets of collection stored on disk.
+func (db *DB) buckets(coll uint64) ([][2]uint64, error) {
+	dir := fmt.Sprintf("%s%s%016x", db.root, CollectionsPath, coll)
+
+	namespaces, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := [][2]uint64{}
+
+	for _, ns := range namespaces {
+		nsHash, err := strconv.ParseUint(ns.Name(), 16, 64)
+		if err != nil || !ns.IsDir() {
+			continue
+		}
+
+		files, err := os.ReadDir(dir + "/" + ns.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			name, ok := strings.CutSuffix(f.Name(), ExtBucket)
+			if !ok {
+				continue
+			}
+
+			prefix, err := strconv.ParseUint(name, 16, 64)
+			if err != nil {
+				continue
+			}
+
+			buckets = append(buckets, [2]uint64{nsHash, prefix})
+		}
+	}
+
+	return buckets, nil
+}
+
+// Write all keys of bucket.
+func (db *DB) exportBucket(enc *json.Encoder, coll, ns, prefix uint64) (int, error) {
+	count := 0
+	cursor := uint64(0)
+
+	for {
+		keys, next, err := db.Scan(coll, ns, prefix, cursor, ExportScanCount)
+		if err != nil {
+			return count, err
+		}
+
+		for _, k := range keys {
+			line := &ExportKey{
+				Collection: fmt.Sprintf("%016x", coll),
+				Namespace:  fmt.Sprintf("%016x", ns),
+				Prefix:     fmt.Sprintf("%016x", prefix),
+				Key:        k.Name,
+				Value:      k.Value,
+				Type:       k.ContentType,
+				Version:    ExportVersion,
+			}
+
+			if k.Expire != 0 {
+				// Key expiring in less than a millisecond still has ttl.
+				line.TTL = max(1, time.Until(time.Unix(0, k.Expire)).Milliseconds())
+			}
+
+			err = enc.Encode(line)
+			if err != nil {
+				return count, err
+			}
+
+			count++
+		}
+
+		if next == 0 {
+			return count, nil
+		}
+
+		cursor = next
+	}
+}
+
+// Import modes
+const (
+	ImportUpsert       ImportMode = iota // overwrite existing keys
+	ImportSkipExisting                   // keep existing keys unchanged
+)
+
+type ImportMode uint8
+
+// Parse import mode name: "upsert" or "skip-existing".
+func ParseImportMode(name string) (ImportMode, error) {
+	switch name {
+	case "upsert":
+		return ImportUpsert, nil
+	case "skip-existing":
+		return ImportSkipExisting, nil
+	}
+
+	return 0, fmt.Errorf("unknown import mode: %q", name)
+}
+
+func (m ImportMode) String() string {
+	if m == ImportSkipExisting {
+		return "skip-existing"
+	}
+
+	return "upsert"
+}
+
+// Import options
+type ImportOptions struct {
+	Mode ImportMode
+
+	// Number of lines decoded and validated before any of them is
+	// written, so invalid line stops import before its batch is applied.
+	BatchSize int
+}
+
+// Import result
+type ImportStats struct {
+	Lines   int // keys read
+	Written int
+	Skipped int // existing keys in skip-existing mode
+}
+
+// Read keys written by Export and write them to database. Import stops
+// at the first invalid line, batches before it are already written.
+func (db *DB) Import(r io.Reader, opts *ImportOptions) (*ImportStats, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	size := opts.BatchSize
+	if size <= 0 {
+		size = DefaultImportBatch
+	}
+
+	in := bufio.NewReader(r)
+	stats := &ImportStats{}
+
+	batch := make([]*Key, 0, size)
+	line := 0
+
+	for {
+		data, err := in.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return stats, err
+		}
+
+		eof := err == io.EOF
+		line++
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			key, err := decodeExportKey(data)
+			if err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+
+			batch = append(batch, key)
+		}
+
+		if len(batch) == size || (eof && len(batch) > 0) {
+			err = db.importBatch(batch, opts.Mode, stats)
+			if err != nil {
+				return stats, err
+			}
+
+			batch = batch[:0]
+		}
+
+		if eof {
+			return stats, nil
+		}
+	}
+}
+
+// Write decoded keys.
+func (db *DB) importBatch(keys []*Key, mode ImportMode, stats *ImportStats) error {
+	for _, key := range keys {
+		stats.Lines++
+
+		if mode == ImportSkipExisting {
+			kv, err := db.Lookup(key)
+			if err != nil {
+				return err
+			}
+
+			if kv != nil {
+				stats.Skipped++
+				continue
+			}
+		}
+
+		err := db.Put(key)
+		if err != nil {
+			return err
+		}
+
+		stats.Written++
+	}
+
+	return nil
+}
+
+// Decode and validate single export line.
+func decodeExportKey(data []byte) (*Key, error) {
+	line := &ExportKey{}
+
+	err := json.Unmarshal(data, line)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExport, err)
+	}
+
+	if line.Version < 1 || line.Version > ExportVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidExport, line.Version)
+	}
+
+	if line.TTL < 0 {
+		return nil, fmt.Errorf("%w: negative ttl", ErrInvalidExport)
+	}
+
+	key := NewKey(line.Key, line.Value)
+
+	key.Collection, err = parseExportHash("collection", line.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Namespace, err = parseExportHash("namespace", line.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Prefix, err = parseExportHash("prefix", line.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if line.TTL > 0 {
+		key.Expire = time.Now().Add(time.Duration(line.TTL) * time.Millisecond).UnixNano()
+	}
+
+	key.ContentType = line.Type
+
+	return key, nil
+}
+
+// Parse hash written as 16 hex digits.
+func parseExportHash(field, value string) (uint64, error) {
+	hash, err := strconv.ParseUint(value, 16, 64)
+	if err != nil || len(value) != 16 {
+		return 0, fmt.Errorf("%w: %s must be 16 hex digits", ErrInvalidExport, field)
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"bytedb/tests"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer os.RemoveAll("./importdb")
+
+	for i := 0; i < 100; i++ {
+		key := NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte{0, byte(i), 0xff})
+		key.Collection = uint64(i % 2)
+		key.Prefix = uint64(i % 5)
+		db.Put(key)
+	}
+
+	ttl := NewKey([]byte("ttl"), []byte("val"))
+	ttl.Expire = time.Now().Add(time.Hour).UnixNano()
+	db.Put(ttl)
+
+	expired := NewKey([]byte("expired"), []byte("val"))
+	expired.Expire = time.Now().Add(-time.Hour).UnixNano()
+	db.Put(expired)
+
+	buf := &bytes.Buffer{}
+	n, err := db.Export(buf, nil)
+	tests.Assert(t, nil, err)
+	tests.Assert(t, 101, n)
+	tests.Assert(t, 101, strings.Count(buf.String(), "\n"))
+
+	// Filter by collection and prefix
+	filtered := &bytes.Buffer{}
+	n, _ = db.Export(filtered, &ExportFilter{Collections: []uint64{1}, Prefixes: []uint64{1, 3}})
+	tests.Assert(t, 20, n)
+
+	db.Close()
+
+	db, _ = Open("./importdb")
+	defer db.Close()
+
+	stats, err := db.Import(buf, &ImportOptions{BatchSize: 7})
+	tests.Assert(t, nil, err)
+	tests.Assert(t, 101, stats.Lines)
+	tests.Assert(t, 101, stats.Written)
+
+	key := NewKey([]byte("key_7"), nil)
+	key.Collection, key.Prefix = 1, 2
+
+	val, _ := db.Get(key)
+	tests.AssertEqual(t, []byte{0, 7, 0xff}, val)
+
+	kv, _ := db.Lookup(NewKey([]byte("ttl"), nil))
+	tests.Assert(t, true, kv.Expire > time.Now().Add(59*time.Minute).UnixNano())
+
+	val, _ = db.Get(expired)
+	tests.AssertEqual(t, []byte(nil), val)
+}
+
+func TestImportModes(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	db.Put(NewKey([]byte("a"), []byte("old")))
+
+	lines := `{"collection":"0000000000000000","namespace":"0000000000000000","prefix":"0000000000000000","key":"YQ==","value":"bmV3","ttl":0,"version":1}
+{"collection":"0000000000000000","namespace":"0000000000000000","prefix":"0000000000000000","key":"Yg==","value":"bmV3","ttl":0,"version":1}
+`
+
+	stats, err := db.Import(strings.NewReader(lines), &ImportOptions{Mode: ImportSkipExisting})
+	tests.Assert(t, nil, err)
+	tests.Assert(t, 1, stats.Written)
+	tests.Assert(t, 1, stats.Skipped)
+
+	val, _ := db.Get(NewKey([]byte("a"), nil))
+	tests.AssertEqual(t, []byte("old"), val)
+
+	db.Import(strings.NewReader(lines), &ImportOptions{Mode: ImportUpsert})
+
+	val, _ = db.Get(NewKey([]byte("a"), nil))
+	tests.AssertEqual(t, []byte("new"), val)
+}
+
+func TestImportInvalid(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	valid := `{"collection":"0000000000000000","namespace":"0000000000000000","prefix":"0000000000000000","key":"YQ==","value":"","version":1}`
+
+	for _, line := range []string{
+		`{"collection":"0","namespace":"0000000000000000","prefix":"0000000000000000","key":"YQ==","version":1}`,
+		`{"collection":"0000000000000000","namespace":"0000000000000000","prefix":"0000000000000000","key":"YQ=="}`,
+		`{"collection":"0000000000000000","namespace":"0000000000000000","prefix":"0000000000000000","key":"YQ==","ttl":-1,"version":1}`,
+		`not json`,
+	} {
+		// Invalid line stops import before its batch is written.
+		stats, err := db.Import(strings.NewReader(valid+"\n"+line), nil)
+		tests.Assert(t, true, errors.Is(err, ErrInvalidExport))
+		tests.Assert(t, true, strings.HasPrefix(err.Error(), "line 2:"))
+		tests.Assert(t, 0, stats.Written)
+	}
+
+	_, err := ParseImportMode("replace")
+	tests.AssertNot(t, nil, err)
+}
@@ -2,7 +2,6 @@ package db
 
 import (
 	bit "bytedb/lib/bitbox"
-	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
@@ -21,9 +20,17 @@ type File struct {
 	file *os.File
 	Hash uint64
 
+	// Max number of cached blocks, 0 means no limit. Dirty blocks
+	// are never evicted, so cache can grow past it between flushes.
+	CacheBlocks int
+
 	mu        sync.Mutex
 	lastBlock *Block
+	tail      *Block // last block records were written to
 	blocks    map[uint32]*Block
+
+	// Old index blocks, zeroed after header pointing to new index is written.
+	retired []uint32
 }
 
 // Open database file.
@@ -37,14 +44,94 @@ func OpenFile(path string) (*File, error) {
 	}
 
 	flags := os.O_CREATE | os.O_RDWR
-	f, err := os.OpenFile(filepath.Ext(path), flags, os.ModePerm)
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
 
 	file := &File{
 		file:   f,
 		blocks: make(map[uint32]*Block, 10),
 	}
 
-	return file, err
+	if file.BlockCount() == 0 {
+		err = file.init()
+	} else {
+		err = file.readHeader()
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Open existing file for reading only, used by offline tools. Header is
+// read but not checked and blocks are not cached, so damaged and big
+// files can be read too.
+func OpenFileReadOnly(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{file: f, blocks: make(map[uint32]*Block)}
+	file.lastBlock = &Block{ID: uint32(file.BlockCount())}
+
+	if file.BlockCount() > 0 {
+		b := NewBlock(1)
+
+		_, err = file.Read(b)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		bit.NewBuffer(b.Data).Decode(&file.IndexOffset, &file.IndexBlocks)
+	}
+
+	return file, nil
+}
+
+// Write header and allocate index blocks for empty file.
+func (f *File) init() error {
+	f.lastBlock = NewBlock(DefaultHeaderBlocks)
+	f.Append(f.lastBlock)
+
+	f.IndexOffset = DefaultHeaderBlocks + 1
+	f.IndexBlocks = DefaultIndexBlocks
+	f.Alloc(DefaultIndexBlocks)
+
+	return f.writeHeader()
+}
+
+// Read header from the first block.
+func (f *File) readHeader() error {
+	b, err := f.Block(1)
+	if err != nil {
+		return err
+	}
+
+	bit.NewBuffer(b.Data).Decode(&f.IndexOffset, &f.IndexBlocks)
+
+	// Last block is the one we will be appending after.
+	f.lastBlock, err = f.Block(uint32(f.BlockCount()))
+	return err
+}
+
+// Write header to the first block.
+func (f *File) writeHeader() error {
+	b, err := f.Block(1)
+	if err != nil {
+		return err
+	}
+
+	copy(b.Data, bit.Encode(&f.IndexOffset, &f.IndexBlocks))
+	b.Dirty = true
+
+	return nil
 }
 
 // Resize file
@@ -72,43 +159,128 @@ func (f *File) BlockCount() int64 {
 	return f.Size() / BlockSize
 }
 
-// Write key-val to blockso
-func (f *File) WriteKV(key *Key, val []byte) error {
-	// Write data
-	_, idx := f.Write(f.lastBlock, val)
+// Return ID of the last allocated block
+func (f *File) LastID() uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	fmt.Println("index block: ", idx)
-	return nil
+	return f.lastBlock.ID
+}
+
+// Write key-val to blocks, followed by checksum of encoded key. Record is
+// packed after the previous one if it fits into the rest of its block,
+// otherwise new blocks are allocated. Return index pointing to record.
+func (f *File) WriteKV(key *Key) (*IndexKey, error) {
+	data := bit.Encode(&key.Name, &key.Value, &key.Expire, &key.ContentType)
+
+	sum := checksum(data)
+	data = append(data, bit.Encode(&sum)...)
+
+	// Blocks allocated after tail, like index ones, can't be written to.
+	f.mu.Lock()
+	block := f.tail
+	if block != f.lastBlock || len(data) > block.SpaceLeft() {
+		block = nil
+	}
+	f.mu.Unlock()
+
+	if block == nil {
+		block = f.Alloc(1)
+	}
+
+	pos := block.Off
+
+	_, idx := f.Write(block, data)
+	idx.Hash = key.Hash
+	idx.Pos = pos
+
+	f.mu.Lock()
+	f.tail = f.lastBlock
+	f.mu.Unlock()
+
+	return idx, nil
+}
+
+// Read key-val from blocks pointed by index.
+func (f *File) ReadKV(idx *IndexKey) (*Key, error) {
+	data, err := f.ReadSpan(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(idx.Pos) >= len(data) {
+		return nil, ErrInvalidKV
+	}
+
+	key := &Key{Hash: idx.Hash}
+	buf := bit.NewBuffer(data[idx.Pos:])
+
+	err = buf.Decode(&key.Name, &key.Value, &key.Expire)
+	if err != nil {
+		return nil, err
+	}
+
+	// Records written by older versions end after expire time,
+	// possibly at the end of block.
+	if buf.Len() >= 4 {
+		err = buf.Decode(&key.ContentType)
+	}
+
+	return key, err
 }
 
 // Write data to blocks, starting at offset.
-// Return index and number of bytes written.
+// Return total number of bytes written and index.
 func (f *File) Write(offset *Block, data []byte) (int, *IndexKey) {
 	idx := &IndexKey{Offset: offset.ID, Span: 1}
 	buf := bit.NewBuffer(data)
-	n := int(0)
+	total := 0
 
 	for {
-		n = offset.Write(buf.Data())
+		n := offset.Write(buf.Data())
 		buf.Consume(n)
+		total += n
 
 		if buf.Len() == 0 {
 			break
 		}
 
 		// We need another block
-		b := NewBlock(offset.ID + 1)
-		f.Append(b)
+		offset = f.Alloc(1)
 
 		// Increment number of blocks used
 		idx.Span++
 	}
 
-	return n, idx
+	return total, idx
+}
+
+// Allocate n new blocks at the end of file.
+// Return the first one.
+func (f *File) Alloc(n int) *Block {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	first := f.lastBlock.ID + 1
+
+	for i := 0; i < n; i++ {
+		b := NewBlock(first + uint32(i))
+		b.Dirty = true
+		f.append(b)
+	}
+
+	return f.blocks[first]
 }
 
 // Append block to file
 func (f *File) Append(b *Block) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.append(b)
+}
+
+func (f *File) append(b *Block) {
 	f.lastBlock = b
 	f.blocks[b.ID] = b
 }
@@ -118,16 +290,43 @@ func (f *File) ReadAt(dst []byte, off int64) (int, error) {
 	return f.file.ReadAt(dst, off)
 }
 
+// Read all blocks pointed by index and return their data.
+func (f *File) ReadSpan(idx *IndexKey) ([]byte, error) {
+	data := make([]byte, 0, int(idx.Span)*BlockSize)
+
+	for id := idx.Offset; id < idx.Offset+uint32(idx.Span); id++ {
+		b, err := f.Block(id)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, b.Data...)
+	}
+
+	return data, nil
+}
+
 // Return block from cache, read it from disk otherwise
-func (f *File) Block(id uint32) *Block {
-	// lock - read from cache
-	// unlock
+func (f *File) Block(id uint32) (*Block, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	// Read from disk
+	b, ok := f.blocks[id]
+	if ok {
+		cacheHits.Inc()
+		return b, nil
+	}
 
-	// lock - put to cache
-	// unlock
-	return nil
+	cacheMisses.Inc()
+	b = NewBlock(id)
+
+	_, err := f.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	f.blocks[id] = b
+	return b, nil
 }
 
 // Read block from file
@@ -138,3 +337,120 @@ func (f *File) Read(block *Block) (int, error) {
 	// Read block
 	return f.file.ReadAt(block.Data[:], off)
 }
+
+// Write all dirty blocks to disk and sync file.
+//
+// Blocks are written in order which keeps file valid after crash. Values and
+// new index blocks are synced first, then header pointing to them and only
+// then old index blocks are zeroed, so either old or new index is on disk.
+func (f *File) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	retired := map[uint32]bool{}
+	for _, id := range f.retired {
+		retired[id] = true
+	}
+
+	err := f.flush(func(b *Block) bool { return b.ID != 1 && !retired[b.ID] })
+	if err != nil {
+		return err
+	}
+
+	if b := f.blocks[1]; b != nil && b.Dirty {
+		err = f.flush(func(b *Block) bool { return b.ID == 1 })
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(f.retired) > 0 {
+		err = f.zeroRetired()
+		if err != nil {
+			return err
+		}
+	}
+
+	f.evict()
+	return nil
+}
+
+// Write dirty blocks selected by fn and sync file.
+func (f *File) flush(fn func(b *Block) bool) error {
+	for _, b := range f.blocks {
+		if !b.Dirty || !fn(b) {
+			continue
+		}
+
+		off := int64((b.ID - 1) * BlockSize)
+
+		_, err := f.file.WriteAt(b.Data, off)
+		if err != nil {
+			return err
+		}
+
+		b.Dirty = false
+	}
+
+	return f.file.Sync()
+}
+
+// Zero old index blocks, they might not be cached anymore.
+func (f *File) zeroRetired() error {
+	zero := make([]byte, BlockSize)
+
+	for _, id := range f.retired {
+		if b, ok := f.blocks[id]; ok {
+			clear(b.Data)
+			b.Dirty = false
+		}
+
+		_, err := f.file.WriteAt(zero, int64((id-1)*BlockSize))
+		if err != nil {
+			return err
+		}
+	}
+
+	f.retired = nil
+	return f.file.Sync()
+}
+
+// Mark index blocks as old, they are zeroed by Flush.
+func (f *File) retire(first, last uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for id := first; id <= last; id++ {
+		f.retired = append(f.retired, id)
+	}
+}
+
+// Drop clean blocks from cache until we are within the limit.
+func (f *File) evict() {
+	if f.CacheBlocks <= 0 {
+		return
+	}
+
+	for id, b := range f.blocks {
+		if len(f.blocks) <= f.CacheBlocks {
+			return
+		}
+
+		if b.Dirty || b == f.lastBlock {
+			continue
+		}
+
+		delete(f.blocks, id)
+	}
+}
+
+// Flush and close file.
+func (f *File) Close() error {
+	err := f.Flush()
+	if err != nil {
+		f.file.Close()
+		return err
+	}
+
+	return f.file.Close()
+}
@@ -1,11 +1,179 @@
 package db
 
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
 // Bucket file
 type Bucket struct {
 	*File
 	index *Index
+
+	mu sync.Mutex
 }
 
-func (b *Bucket) Write(key *Key) (int, error) {
-	return 0, nil
+// Open bucket file, create it if necessary.
+func OpenBucket(path string) (*Bucket, error) {
+	f, err := OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bucket{File: f, index: NewIndex(f)}, nil
+}
+
+// Write key to bucket, replacing existing one.
+func (b *Bucket) Put(key *Key) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.put(key)
+}
+
+func (b *Bucket) put(key *Key) error {
+	start := time.Now()
+
+	idx, err := b.WriteKV(key)
+	key.Trace.addBlocks(start)
+
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	defer key.Trace.addIndex(start)
+
+	_, err = b.index.Add(idx, b.match(key))
+	return err
+}
+
+// Read key value from bucket. Return nil if key doesn't exist or is expired.
+func (b *Bucket) Get(key *Key) ([]byte, error) {
+	kv, err := b.Lookup(key)
+	if err != nil || kv == nil {
+		return nil, err
+	}
+
+	return kv.Value, nil
+}
+
+// Read whole key from bucket. Return nil if key doesn't exist or is expired.
+func (b *Bucket) Lookup(key *Key) (*Key, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kv, err := b.find(key)
+	if err != nil || kv == nil || kv.Expired() {
+		return nil, err
+	}
+
+	return kv, nil
+}
+
+// Read whole key from bucket, including expired one. Return nil
+// if key doesn't exist. Caller must hold bucket lock.
+func (b *Bucket) find(key *Key) (*Key, error) {
+	start := time.Now()
+
+	idx, err := b.index.Get(key.Hash, b.match(key))
+	key.Trace.addIndex(start)
+
+	if err != nil || idx == nil {
+		return nil, err
+	}
+
+	start = time.Now()
+	defer key.Trace.addBlocks(start)
+
+	return b.ReadKV(idx)
+}
+
+// Return up to count keys, starting at cursor, and cursor for the next call.
+// Scan starts and ends with 0 cursor. Expired keys are skipped, so fewer
+// keys can be returned even if scan is not done.
+func (b *Bucket) Scan(cursor uint64, count int) ([]*Key, uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	idxs, next, err := b.index.Scan(cursor, count)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys := []*Key{}
+
+	for _, idx := range idxs {
+		kv, err := b.ReadKV(idx)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !kv.Expired() {
+			keys = append(keys, kv)
+		}
+	}
+
+	return keys, next, nil
+}
+
+// Return expired keys of bucket.
+func (b *Bucket) expired() ([]*Key, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	keys := []*Key{}
+	cursor := uint64(0)
+
+	for {
+		idxs, next, err := b.index.Scan(cursor, 1000)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, idx := range idxs {
+			kv, err := b.ReadKV(idx)
+			if err != nil {
+				return nil, err
+			}
+
+			if kv.Expired() {
+				keys = append(keys, kv)
+			}
+		}
+
+		if next == 0 {
+			return keys, nil
+		}
+
+		cursor = next
+	}
+}
+
+// Delete key from bucket. Return false if key didn't exist.
+func (b *Bucket) Delete(key *Key) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.delete(key)
+}
+
+func (b *Bucket) delete(key *Key) (bool, error) {
+	defer key.Trace.addIndex(time.Now())
+
+	idx, err := b.index.Delete(key.Hash, b.match(key))
+	return idx != nil, err
+}
+
+// Return function checking if index points to the given key.
+func (b *Bucket) match(key *Key) func(*IndexKey) bool {
+	return func(idx *IndexKey) bool {
+		kv, err := b.ReadKV(idx)
+		if err != nil {
+			return false
+		}
+
+		return bytes.Equal(kv.Name, key.Name)
+	}
 }
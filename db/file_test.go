@@ -1,7 +1,124 @@
 package db
 
-import "testing"
+import (
+	"bytedb/tests"
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
 
 func TestFileOpen(t *testing.T) {
 
 }
+
+func TestFileReadOnly(t *testing.T) {
+	path := t.TempDir() + "/test.bck"
+
+	b, _ := OpenBucket(path)
+	b.Put(NewKey([]byte("key_1"), []byte("val_1")))
+	b.Put(NewKey([]byte("key_2"), []byte("val_2")))
+	b.Delete(NewKey([]byte("key_2"), nil))
+	b.Close()
+
+	f, err := OpenFileReadOnly(path)
+	tests.Assert(t, nil, err)
+	tests.Assert(t, uint32(DefaultHeaderBlocks+1), f.IndexOffset)
+	tests.Assert(t, uint32(DefaultIndexBlocks), f.IndexBlocks)
+
+	keys, tombstones := 0, 0
+
+	for id := f.IndexOffset; id < f.IndexOffset+f.IndexBlocks; id++ {
+		block := NewBlock(id)
+		f.Read(block)
+
+		h, idxs := DecodeIndexBlock(block)
+		keys += int(h.Keys)
+		tombstones += int(h.Tombstones)
+
+		tests.Assert(t, int(h.Keys), len(idxs))
+	}
+
+	tests.Assert(t, 2, keys)
+	tests.Assert(t, 1, tombstones)
+
+	// File can't be written
+	_, err = f.file.Write([]byte{1})
+	tests.AssertNot(t, nil, err)
+
+	_, err = OpenFileReadOnly(t.TempDir() + "/missing.bck")
+	tests.Assert(t, true, os.IsNotExist(err))
+}
+
+func TestFilePackKV(t *testing.T) {
+	path := t.TempDir() + "/test.bck"
+
+	b, _ := OpenBucket(path)
+	b.Put(NewKey([]byte("key_0"), []byte("val_0")))
+	count := b.BlockCount()
+
+	// Small keys share the last value block
+	for i := 1; i < 100; i++ {
+		b.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d", i))))
+	}
+
+	tests.Assert(t, count, b.BlockCount())
+
+	// Key that doesn't fit continues in new blocks
+	big := bytes.Repeat([]byte("x"), 2*BlockSize)
+	b.Put(NewKey([]byte("big"), big))
+	b.Put(NewKey([]byte("key_100"), []byte("val_100")))
+	b.Close()
+
+	b, _ = OpenBucket(path)
+	defer b.Close()
+
+	for i := 0; i <= 100; i++ {
+		val, _ := b.Get(NewKey([]byte(fmt.Sprintf("key_%d", i)), nil))
+		tests.AssertEqual(t, []byte(fmt.Sprintf("val_%d", i)), val)
+	}
+
+	val, _ := b.Get(NewKey([]byte("big"), nil))
+	tests.AssertEqual(t, big, val)
+
+	r := &Report{}
+	tests.Assert(t, nil, CheckFile(path, r))
+	tests.Assert(t, true, r.OK())
+}
+
+func TestFileFlushGrow(t *testing.T) {
+	path := t.TempDir() + "/test.bck"
+
+	b, _ := OpenBucket(path)
+	b.Put(NewKey([]byte("key_0"), []byte("val_0")))
+	b.Flush()
+
+	n := 1
+	for ; b.IndexOffset == DefaultHeaderBlocks+1; n++ {
+		b.Put(NewKey([]byte(fmt.Sprintf("key_%d", n)), []byte("val")))
+	}
+
+	// Crash before header is written leaves the old index
+	old := map[uint32]bool{}
+	for _, id := range b.retired {
+		old[id] = true
+	}
+
+	b.flush(func(b *Block) bool { return b.ID != 1 && !old[b.ID] })
+
+	r := &Report{}
+	tests.Assert(t, nil, CheckFile(path, r))
+	tests.Assert(t, true, r.OK())
+	tests.Assert(t, 1, r.Keys)
+
+	// Old index blocks are zeroed after header is switched
+	tests.Assert(t, nil, b.Flush())
+
+	r = &Report{}
+	tests.Assert(t, nil, CheckFile(path, r))
+	tests.Assert(t, true, r.OK())
+	tests.Assert(t, n, r.Keys)
+	tests.Assert(t, 0, len(r.Warnings))
+
+	b.Close()
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"bytedb/db/wal"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+const (
+	FollowBuffer    = 4096    // new records buffered for single follower
+	FollowBatchSize = 1 << 20 // max size of records passed to follower at once
+)
+
+var (
+	ErrNotRetained = errors.New("wal records are no longer retained")
+	ErrLSNGap      = errors.New("wal records are missing")
+	ErrLSNAhead    = errors.New("lsn is ahead of database")
+)
+
+// Follower waiting for new wal records.
+type subscriber struct {
+	logs chan []byte // closed when follower is too slow
+}
+
+func (db *DB) subscribe() *subscriber {
+	sub := &subscriber{logs: make(chan []byte, FollowBuffer)}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.subs == nil {
+		db.subs = map[*subscriber]struct{}{}
+	}
+
+	db.subs[sub] = struct{}{}
+	return sub
+}
+
+func (db *DB) unsubscribe(sub *subscriber) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.subs[sub]; ok {
+		delete(db.subs, sub)
+		close(sub.logs)
+	}
+}
+
+// Send record to followers. Slow ones are dropped, they read
+// missed records from disk. Caller must hold db.mu.
+func (db *DB) publish(log []byte) {
+	for sub := range db.subs {
+		select {
+		case sub.logs <- log:
+		default:
+			delete(db.subs, sub)
+			close(sub.logs)
+		}
+	}
+}
+
+// Pass wal records newer than lsn to fn, in batches, and keep passing new
+// ones as they are written, until ctx is done or fn returns error. Records
+// already checkpointed are read from archived segments, ErrNotRetained is
+// returned if they were removed. Checkpoint records are skipped.
+func (db *DB) Follow(ctx context.Context, lsn uint64, fn func(logs [][]byte) error) error {
+	if last := db.LSN(); lsn > last {
+		return fmt.Errorf("%w: %d, database is at %d", ErrLSNAhead, lsn, last)
+	}
+
+	for {
+		// Subscribe first, so no record is missed between reading
+		// wal from disk and waiting for new ones.
+		sub := db.subscribe()
+
+		err := db.readLogs(&lsn, fn)
+		if err == nil {
+			err = db.tail(ctx, sub, &lsn, fn)
+		}
+
+		db.unsubscribe(sub)
+
+		if !errors.Is(err, errSlowFollower) {
+			return err
+		}
+	}
+}
+
+var errSlowFollower = errors.New("follower is too slow")
+
+// Pass records from archived and current wal segments.
+func (db *DB) readLogs(lsn *uint64, fn func(logs [][]byte) error) error {
+	files, err := db.openSegments()
+	if err != nil {
+		return err
+	}
+
+	defer closeFiles(files)
+
+	b := &logBatch{fn: fn}
+	done := false
+
+	for _, f := range files {
+		_, err := wal.ReadSegmentFile(f, func(off int, log []byte) {
+			if done || b.err != nil {
+				return
+			}
+
+			// Record can be written concurrently at the end of segment,
+			// it will be passed by tail.
+			if wal.VerifyRecord(log) != nil {
+				done = true
+				return
+			}
+
+			rec := wal.DecodeRecord(log)
+
+			switch {
+			case rec.LSN <= *lsn:
+			case rec.Type == wal.RecCheckpoint || rec.LSN != *lsn+1:
+				b.err = fmt.Errorf("%w: next lsn is %d, found %d", ErrNotRetained, *lsn+1, rec.LSN)
+			default:
+				b.add(log)
+				*lsn = rec.LSN
+			}
+		})
+
+		if err != nil && !errors.Is(err, wal.ErrTruncated) {
+			return err
+		}
+
+		if done || b.err != nil {
+			break
+		}
+	}
+
+	return b.flush()
+}
+
+// Open archived and current wal segments, oldest first. Segments aren't
+// moved while they are opened, but they are read after lock is released,
+// so slow follower doesn't block checkpoint.
+func (db *DB) openSegments() ([]*os.File, error) {
+	db.files.RLock()
+	defer db.files.RUnlock()
+
+	paths := []string{}
+
+	for _, dir := range []string{db.root + WalArchivePath, db.root + WalPath} {
+		segs, err := wal.Segments(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, seq := range segs {
+			paths = append(paths, wal.SegmentPath(dir, seq))
+		}
+	}
+
+	files := []*os.File{}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// Pass new records as they are written.
+func (db *DB) tail(ctx context.Context, sub *subscriber, lsn *uint64, fn func(logs [][]byte) error) error {
+	for {
+		b := &logBatch{fn: fn}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case log, ok := <-sub.logs:
+			if !ok {
+				return errSlowFollower
+			}
+
+			// Take everything that is buffered.
+			for ok && b.err == nil {
+				rec := wal.DecodeRecord(log)
+
+				if rec.LSN > *lsn+1 {
+					b.err = errSlowFollower
+				} else if rec.LSN == *lsn+1 {
+					b.add(log)
+					*lsn = rec.LSN
+				}
+
+				select {
+				case log, ok = <-sub.logs:
+				default:
+					ok = false
+				}
+			}
+		}
+
+		err := b.flush()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Records passed to follower at once.
+type logBatch struct {
+	fn   func(logs [][]byte) error
+	logs [][]byte
+	size int
+	err  error
+}
+
+func (b *logBatch) add(log []byte) {
+	b.logs = append(b.logs, log)
+	b.size += len(log)
+
+	if b.size >= FollowBatchSize {
+		b.flush()
+	}
+}
+
+// Pass collected records, return the first error.
+func (b *logBatch) flush() error {
+	if b.err == nil && len(b.logs) > 0 {
+		b.err = b.fn(b.logs)
+	}
+
+	b.logs, b.size = nil, 0
+	return b.err
+}
+
+// Log and apply wal record written by other database, keeping its lsn.
+// Records already applied are skipped, ErrLSNGap is returned if some
+// records are missing.
+func (db *DB) ApplyLog(log []byte) error {
+	err := wal.VerifyRecord(log)
+	if err != nil {
+		return err
+	}
+
+	rec := wal.DecodeRecord(log)
+	if rec.Type == wal.RecCheckpoint {
+		return nil
+	}
+
+	db.mu.Lock()
+	last := db.lsn
+	db.mu.Unlock()
+
+	if rec.LSN <= last {
+		return nil
+	}
+
+	if rec.LSN != last+1 {
+		return fmt.Errorf("%w: expected lsn %d, got %d", ErrLSNGap, last+1, rec.LSN)
+	}
+
+	_, err = db.change(rec.Type, recordKey(rec), rec.Time, nil)
+
+	// Deletes of missing keys aren't logged, lsn must move anyway.
+	db.mu.Lock()
+	db.lsn = max(db.lsn, rec.LSN)
+	db.mu.Unlock()
+
+	return err
+}
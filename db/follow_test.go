@@ -0,0 +1,158 @@
+package db
+
+import (
+	"bytedb/tests"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFollow(t *testing.T) {
+	opts := DefaultOptions()
+	opts.WalSegmentSize = 4096
+	opts.WalRetain = 1 << 20
+
+	primary, _ := OpenWith("./testdb", opts)
+	defer os.RemoveAll("./testdb")
+	defer primary.Close()
+
+	replica, _ := Open("./replicadb")
+	defer os.RemoveAll("./replicadb")
+	defer replica.Close()
+
+	// Records checkpointed before replica connects are read from archive.
+	for i := 0; i < 100; i++ {
+		primary.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte("val")))
+	}
+
+	primary.DeleteKey(NewKey([]byte("key_0"), nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- primary.Follow(ctx, replica.LSN(), func(logs [][]byte) error {
+			for _, log := range logs {
+				err := replica.ApplyLog(log)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}()
+
+	// New records are passed as they are written.
+	for i := 100; i < 200; i++ {
+		primary.Put(NewKey([]byte(fmt.Sprintf("key_%d", i)), []byte("val")))
+	}
+
+	for start := time.Now(); replica.LSN() < primary.LSN() && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	tests.Assert(t, context.Canceled, <-done)
+	tests.Assert(t, primary.LSN(), replica.LSN())
+
+	for i := 1; i < 200; i++ {
+		val, _ := replica.Get(NewKey([]byte(fmt.Sprintf("key_%d", i)), nil))
+		tests.AssertEqual(t, []byte("val"), val)
+	}
+
+	val, _ := replica.Get(NewKey([]byte("key_0"), nil))
+	tests.AssertEqual(t, []byte(nil), val)
+}
+
+func TestFollowNotRetained(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	db.Put(NewKey([]byte("key_1"), []byte("val")))
+	db.Checkpoint()
+	db.Put(NewKey([]byte("key_2"), []byte("val")))
+
+	// Checkpointed records are removed without retention.
+	err := db.Follow(context.Background(), 0, func(logs [][]byte) error { return nil })
+	tests.Assert(t, true, errors.Is(err, ErrNotRetained))
+
+	err = db.Follow(context.Background(), 3, func(logs [][]byte) error { return nil })
+	tests.Assert(t, true, errors.Is(err, ErrLSNAhead))
+
+	// Records after checkpoint are still in wal.
+	stop := errors.New("stop")
+	got := 0
+
+	err = db.Follow(context.Background(), 1, func(logs [][]byte) error {
+		got += len(logs)
+		return stop
+	})
+
+	tests.Assert(t, stop, err)
+	tests.Assert(t, 1, got)
+}
+
+func TestFollowSlow(t *testing.T) {
+	db, _ := Open(t.TempDir())
+	defer db.Close()
+
+	db.Put(NewKey([]byte("key_1"), []byte("val")))
+
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		done <- db.Follow(context.Background(), 0, func(logs [][]byte) error {
+			close(reading)
+			<-release
+
+			return errors.New("stop")
+		})
+	}()
+
+	<-reading
+
+	// Stalled follower doesn't block checkpoint
+	checkpoint := make(chan error)
+	go func() { checkpoint <- db.Checkpoint() }()
+
+	select {
+	case err := <-checkpoint:
+		tests.Assert(t, nil, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("checkpoint is blocked by follower")
+	}
+
+	close(release)
+	<-done
+}
+
+func TestApplyLogGap(t *testing.T) {
+	primary, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer primary.Close()
+
+	replica, _ := Open("./replicadb")
+	defer os.RemoveAll("./replicadb")
+	defer replica.Close()
+
+	primary.Put(NewKey([]byte("key_1"), []byte("val")))
+	primary.Put(NewKey([]byte("key_2"), []byte("val")))
+
+	logs := [][]byte{}
+	primary.wal.Map(func(log []byte) bool { logs = append(logs, log); return true })
+
+	err := replica.ApplyLog(logs[1])
+	tests.Assert(t, true, errors.Is(err, ErrLSNGap))
+
+	tests.Assert(t, nil, replica.ApplyLog(logs[0]))
+	tests.Assert(t, nil, replica.ApplyLog(logs[0]))
+	tests.Assert(t, nil, replica.ApplyLog(logs[1]))
+	tests.Assert(t, uint64(2), replica.LSN())
+}
@@ -1,22 +1,39 @@
 package db
 
 import (
-	bit "bytedb/lib/bitbox"
+	"encoding/binary"
+	"errors"
 	"sync"
 )
 
 const IndexSize = 16 // size in bytes
 
+// Number of indexes in one block, first slot is reserved for header.
+const IndexPerBlock = BlockSize/IndexSize - 1
+
+// Index flags
+const (
+	FlagDeleted uint16 = 1 << iota
+)
+
+// Number of low bits of stored flag used by flags, the rest
+// holds position of record in its first block.
+const flagBits = 4
+
+var ErrIndexFull = errors.New("index is full")
+
 // DataClass
 type IndexKey struct {
 	Hash   uint64
-	Offset uint32
-	Span   uint16
+	Offset uint32 // first block of record
+	Span   uint16 // number of blocks record is in
 	Flag   uint16
+	Pos    uint16 // position of record in first block, records are packed
 }
 
 // Index block header
 type IndexHeader struct {
+	Keys       uint8 // number of used slots, including deleted keys
 	Tombstones uint8 // number of deleted keys
 }
 
@@ -32,90 +49,428 @@ type Index struct {
 	Headers map[uint32]*IndexHeader
 }
 
-// Add index
-func (i *Index) Add(idx *IndexKey) (*Block, error) {
-	id := i.BlockID(idx)
+// Create index for file, based on file header.
+func NewIndex(file *File) *Index {
+	i := &Index{file: file, Headers: make(map[uint32]*IndexHeader)}
+
+	i.FirstID = file.IndexOffset
+	i.LastID = file.IndexOffset + file.IndexBlocks - 1
+
+	return i
+}
+
+// Find index for the given hash. Match is called for each index with the
+// same hash, so caller can resolve hash collisions.
+func (i *Index) Get(hash uint64, match func(*IndexKey) bool) (*IndexKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var found *IndexKey
+
+	err := i.probe(hash, func(b *Block, pos int, idx *IndexKey) bool {
+		if idx.Flag&FlagDeleted == 0 && idx.Hash == hash && match(idx) {
+			found = idx
+			return true
+		}
+
+		return false
+	})
+
+	return found, err
+}
+
+// Add index, replacing the one for which match returns true.
+// Return replaced index, nil if there was none.
+func (i *Index) Add(idx *IndexKey, match func(*IndexKey) bool) (*IndexKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	old, err := i.add(idx, match)
+	if err != ErrIndexFull {
+		return old, err
+	}
+
+	// No blocks left, we need to reindex
+	err = i.grow()
+	if err != nil {
+		return nil, err
+	}
+
+	return i.add(idx, match)
+}
+
+// Mark index for which match returns true as deleted.
+// Return deleted index, nil if there was none.
+func (i *Index) Delete(hash uint64, match func(*IndexKey) bool) (*IndexKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var deleted *IndexKey
+
+	err := i.probe(hash, func(b *Block, pos int, idx *IndexKey) bool {
+		if idx.Flag&FlagDeleted != 0 || idx.Hash != hash || !match(idx) {
+			return false
+		}
+
+		deleted = idx
+
+		idx.Flag |= FlagDeleted
+		writeIndex(b, pos, idx)
+
+		h := i.Header(b)
+		h.Tombstones++
+		i.setHeader(b, h)
+
+		return true
+	})
+
+	return deleted, err
+}
+
+// Call fn for each live index, stop when fn returns false.
+func (i *Index) Map(fn func(*IndexKey) bool) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for id := i.FirstID; id <= i.LastID; id++ {
+		b, err := i.Block(id)
+		if err != nil {
+			return err
+		}
+
+		h := i.Header(b)
+
+		for pos := 0; pos < int(h.Keys); pos++ {
+			idx := readIndex(b, pos)
+			if idx.Flag&FlagDeleted != 0 {
+				continue
+			}
+
+			if !fn(idx) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// Return up to count live indexes, starting at cursor, and cursor for the
+// next call. Cursor is a slot number, 0 starts a new scan and is returned
+// when scan is done. Indexes are moved when index grows, so scan running
+// at that time can miss or repeat some of them.
+func (i *Index) Scan(cursor uint64, count int) ([]*IndexKey, uint64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	keys := []*IndexKey{}
+	total := uint64(i.Count()) * IndexPerBlock
+
+	if count < 1 {
+		count = 1
+	}
+
+	for cursor < total {
+		id := i.FirstID + uint32(cursor/IndexPerBlock)
+		pos := int(cursor % IndexPerBlock)
+
+		if len(keys) >= count {
+			return keys, cursor, nil
+		}
+
+		b, err := i.Block(id)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		h := i.Header(b)
+		if pos >= int(h.Keys) {
+			// Skip unused slots of this block.
+			cursor += uint64(IndexPerBlock - pos)
+			continue
+		}
+
+		idx := readIndex(b, pos)
+		if idx.Flag&FlagDeleted == 0 {
+			keys = append(keys, idx)
+		}
+
+		cursor++
+	}
+
+	return keys, 0, nil
+}
+
+func (i *Index) add(idx *IndexKey, match func(*IndexKey) bool) (*IndexKey, error) {
+	var old *IndexKey
+	var free *Block
+	pos := 0
+
+	err := i.probe(idx.Hash, func(b *Block, p int, cur *IndexKey) bool {
+		if cur.Flag&FlagDeleted != 0 {
+			// Remember first deleted slot, we can reuse it.
+			if free == nil {
+				free, pos = b, p
+			}
+			return false
+		}
+
+		if cur.Hash == idx.Hash && match(cur) {
+			old, free, pos = cur, b, p
+			return true
+		}
+
+		return false
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case old != nil:
+		// Replace existing key
+		writeIndex(free, pos, idx)
+
+	case free != nil:
+		// Reuse deleted slot
+		writeIndex(free, pos, idx)
+
+		h := i.Header(free)
+		h.Tombstones--
+		i.setHeader(free, h)
+
+	default:
+		// Append to the first block with some space left
+		b, err := i.last(idx.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if b == nil {
+			return nil, ErrIndexFull
+		}
+
+		h := i.Header(b)
+		writeIndex(b, int(h.Keys), idx)
+
+		h.Keys++
+		i.setHeader(b, h)
+	}
+
+	return old, nil
+}
+
+// Walk index blocks, starting from block for the given hash, and call fn for
+// each index until it returns true. Walk stops at the first block that is
+// not full, keys are never stored past it.
+func (i *Index) probe(hash uint64, fn func(b *Block, pos int, idx *IndexKey) bool) error {
+	id := i.BlockID(hash)
+	n := uint32(0)
+
+	defer func() { indexProbes.Observe(float64(n)) }()
 
-	for {
-		// get block
+	for n < i.Count() {
+		b, err := i.Block(id)
+		if err != nil {
+			return err
+		}
+
+		n++
+		h := i.Header(b)
+
+		for pos := 0; pos < int(h.Keys); pos++ {
+			if fn(b, pos, readIndex(b, pos)) {
+				return nil
+			}
+		}
+
+		if int(h.Keys) < IndexPerBlock {
+			return nil
+		}
+
+		id = i.next(id)
+	}
+
+	return nil
+}
+
+// Return first block with free slot for the given hash, nil if all are full.
+func (i *Index) last(hash uint64) (*Block, error) {
+	id := i.BlockID(hash)
+
+	for n := uint32(0); n < i.Count(); n++ {
 		b, err := i.Block(id)
 		if err != nil {
 			return nil, err
 		}
 
-		// write to it
-		ok := i.write(idx, b)
-		if ok {
-			// successfully written
-			return nil, nil
+		if int(i.Header(b).Keys) < IndexPerBlock {
+			return b, nil
+		}
+
+		id = i.next(id)
+	}
+
+	return nil, nil
+}
+
+// Double number of index blocks and rehash all keys. New index blocks
+// are allocated at the end of file, old ones are zeroed by Flush.
+func (i *Index) grow() error {
+	keys := []*IndexKey{}
+
+	for id := i.FirstID; id <= i.LastID; id++ {
+		b, err := i.Block(id)
+		if err != nil {
+			return err
+		}
+
+		h := i.Header(b)
+		for pos := 0; pos < int(h.Keys); pos++ {
+			idx := readIndex(b, pos)
+			if idx.Flag&FlagDeleted == 0 {
+				keys = append(keys, idx)
+			}
 		}
 
-		// block was full, iterate and try next one
-		id++
-		if id > i.LastID {
-			// no blocks left, we need to return and reindex
-			return nil, nil
+		delete(i.Headers, id)
+	}
+
+	i.file.retire(i.FirstID, i.LastID)
+
+	count := i.Count() * 2
+	first := i.file.Alloc(int(count))
+
+	i.FirstID = first.ID
+	i.LastID = first.ID + count - 1
+
+	i.file.IndexOffset = i.FirstID
+	i.file.IndexBlocks = count
+
+	err := i.file.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	// Keys are unique, there is nothing to replace.
+	none := func(*IndexKey) bool { return false }
+
+	for _, idx := range keys {
+		_, err := i.add(idx, none)
+		if err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
 // Get block ID for index
-func (i *Index) BlockID(idx *IndexKey) uint32 {
+func (i *Index) BlockID(hash uint64) uint32 {
 	id := i.FirstID
-	id += uint32(idx.Hash % uint64(i.LastID))
+	id += uint32(hash % uint64(i.Count()))
 
 	return id
 }
 
-// Write index to block. It will write whole index or nothing at all.
-// Return false if nothing was written.
-func (i *Index) write(idx *IndexKey, block *Block) bool {
-	i.mu.Lock()
-	defer i.mu.Unlock()
+// Return number of index blocks
+func (i *Index) Count() uint32 {
+	return i.LastID - i.FirstID + 1
+}
 
-	if i.SpaceLeft(block) {
-		// write data
-		return true
+// Return ID of the next index block, wrapping around.
+func (i *Index) next(id uint32) uint32 {
+	if id >= i.LastID {
+		return i.FirstID
 	}
 
-	return false
+	return id + 1
 }
 
 // Get index block from file
-func (i *Index) Block(offset uint32) (*Block, error) {
-	// read block from memory
-	b := i.file.blocks[offset]
+func (i *Index) Block(id uint32) (*Block, error) {
+	return i.file.Block(id)
+}
 
-	if b != nil && i.SpaceLeft(b) {
-		// block found, return it
-		return b, nil
+// Return header for index block
+func (i *Index) Header(b *Block) *IndexHeader {
+	h, ok := i.Headers[b.ID]
+	if ok {
+		return h
 	}
 
-	// read it from file
-	_, err := i.file.Read(b)
-	if err != nil {
-		return nil, err
-	}
+	h = readHeader(b)
 
-	// read headers
-	h := &IndexHeader{}
-	b.Read(0, bit.BytesPtr(&h))
 	i.Headers[b.ID] = h
-
-	return b, nil
+	return h
 }
 
-func (i *Index) Header(id uint32) *IndexHeader {
-	return nil
+// Store header in index block
+func (i *Index) setHeader(b *Block, h *IndexHeader) {
+	i.Headers[b.ID] = h
+
+	b.Data[0], b.Data[1] = h.Keys, h.Tombstones
+	b.Off = uint16(IndexSize * (int(h.Keys) + 1))
+	b.Dirty = true
 }
 
 // Check if block has enough space for index
 func (i *Index) SpaceLeft(block *Block) bool {
-	h := i.Header(block.ID)
+	h := i.Header(block)
 
-	if h.Tombstones > 0 || block.SpaceLeft() >= IndexSize {
+	if h.Tombstones > 0 || int(h.Keys) < IndexPerBlock {
 		return true
 	}
 
 	return false
 }
+
+// Read header of index block
+func readHeader(b *Block) *IndexHeader {
+	data := [2]byte{}
+	b.Read(0, data[:])
+
+	return &IndexHeader{Keys: data[0], Tombstones: data[1]}
+}
+
+// Read index from given slot. Index is stored as little-endian
+// Hash, Offset, Span and Flag, with Pos in high bits of Flag.
+func readIndex(b *Block, pos int) *IndexKey {
+	data := [IndexSize]byte{}
+	b.Read(IndexSize*(pos+1), data[:])
+
+	flag := binary.LittleEndian.Uint16(data[14:])
+
+	return &IndexKey{
+		Hash:   binary.LittleEndian.Uint64(data[0:]),
+		Offset: binary.LittleEndian.Uint32(data[8:]),
+		Span:   binary.LittleEndian.Uint16(data[12:]),
+		Flag:   flag & (1<<flagBits - 1),
+		Pos:    flag >> flagBits,
+	}
+}
+
+// Decode header and used slots of index block, including deleted ones.
+func DecodeIndexBlock(b *Block) (*IndexHeader, []*IndexKey) {
+	h := readHeader(b)
+
+	keys := []*IndexKey{}
+	for pos := 0; pos < min(int(h.Keys), IndexPerBlock); pos++ {
+		keys = append(keys, readIndex(b, pos))
+	}
+
+	return h, keys
+}
+
+// Write index to given slot
+func writeIndex(b *Block, pos int, idx *IndexKey) {
+	data := b.Data[IndexSize*(pos+1):]
+
+	binary.LittleEndian.PutUint64(data[0:], idx.Hash)
+	binary.LittleEndian.PutUint32(data[8:], idx.Offset)
+	binary.LittleEndian.PutUint16(data[12:], idx.Span)
+	binary.LittleEndian.PutUint16(data[14:], idx.Flag|idx.Pos<<flagBits)
+
+	b.Dirty = true
+}
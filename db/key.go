@@ -1,26 +1,44 @@
 package db
 
-import "hash/fnv"
+import (
+	"hash/fnv"
+	"time"
+)
 
 type Key struct {
 	// Collection
-	Collection uint32
-	Namespace  uint32
+	Collection uint64
+	Namespace  uint64
 
 	// Directory
 	Dir1 uint8
 	Dir2 uint8
 
 	// File
-	Prefix uint32
+	Prefix uint64
 	Hash   uint64
 
 	Name  []byte
 	Value []byte
+
+	// Expiration time in unix nanoseconds, 0 means key never expires.
+	Expire int64
+
+	// Media type of value, like "application/json", empty if not known.
+	// It's stored with value, so it's gone when value is replaced.
+	ContentType string
+
+	// Timing of operation on key, nil disables tracing.
+	Trace *Trace
 }
 
 func NewKey(key, val []byte) *Key {
-	return &Key{Value: val}
+	return &Key{Name: key, Hash: Hash(key), Value: val}
+}
+
+// Check if key is expired.
+func (k *Key) Expired() bool {
+	return k.Expire != 0 && time.Now().UnixNano() >= k.Expire
 }
 
 // Compute 64 bit hash
@@ -0,0 +1,11 @@
+package db
+
+import "bytedb/lib/metrics"
+
+var (
+	cacheHits   = metrics.Default.Counter("bytedb_cache_hits_total", "Number of blocks found in cache.")
+	cacheMisses = metrics.Default.Counter("bytedb_cache_misses_total", "Number of blocks read from disk.")
+
+	indexProbes = metrics.Default.Histogram("bytedb_index_probe_blocks", "Number of index blocks read by single probe.",
+		[]float64{1, 2, 4, 8, 16, 32, 64})
+)
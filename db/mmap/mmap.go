@@ -0,0 +1,141 @@
+package mmap
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Memory mapped file.
+type Mmap struct {
+	Data []byte
+
+	ReadOffset  int
+	WriteOffset int
+
+	file   *os.File
+	offset int64
+}
+
+// Map file into memory, starting at offset.
+// File will be extended if it's smaller than requested size.
+func Open(file *os.File, size int, offset int64) (*Mmap, error) {
+	m := &Mmap{file: file, offset: offset}
+
+	err := m.mmap(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Resize underlying file and remap it.
+func (m *Mmap) Resize(size int64) error {
+	err := m.munmap()
+	if err != nil {
+		return err
+	}
+
+	err = m.file.Truncate(m.offset + size)
+	if err != nil {
+		return err
+	}
+
+	return m.mmap(int(size))
+}
+
+// Write data at current write offset.
+// Return number of bytes written, partial writes are possible.
+func (m *Mmap) Write(data []byte) int {
+	n := copy(m.Data[m.WriteOffset:], data)
+	m.WriteOffset += n
+
+	return n
+}
+
+// Copy data from current read offset into dst.
+// Return number of bytes copied.
+func (m *Mmap) ReadTo(dst []byte) int {
+	n := copy(dst, m.Data[m.ReadOffset:])
+	m.ReadOffset += n
+
+	return n
+}
+
+// Read next n bytes, starting from read offset.
+// Returned slice points directly to mapped memory.
+func (m *Mmap) Read(n int) ([]byte, error) {
+	if m.ReadOffset+n > len(m.Data) {
+		return nil, fmt.Errorf("read out of range: offset %d, len %d, size %d", m.ReadOffset, n, len(m.Data))
+	}
+
+	data := m.Data[m.ReadOffset : m.ReadOffset+n]
+	m.ReadOffset += n
+
+	return data, nil
+}
+
+// Flush mapped memory to file.
+func (m *Mmap) Sync() error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, m.ptr(), uintptr(len(m.Data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// Unmap memory and close file.
+func (m *Mmap) Close() error {
+	err := m.munmap()
+	if err != nil {
+		return err
+	}
+
+	return m.file.Close()
+}
+
+func (m *Mmap) mmap(size int) error {
+	info, err := m.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Accessing mapped memory past the end of file will cause SIGBUS.
+	if info.Size() < m.offset+int64(size) {
+		err = m.file.Truncate(m.offset + int64(size))
+		if err != nil {
+			return err
+		}
+	}
+
+	flags := syscall.PROT_READ | syscall.PROT_WRITE
+	data, err := syscall.Mmap(int(m.file.Fd()), m.offset, size, flags, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+
+	m.Data = data
+	return nil
+}
+
+func (m *Mmap) munmap() error {
+	if m.Data == nil {
+		return nil
+	}
+
+	err := syscall.Munmap(m.Data)
+	m.Data = nil
+
+	return err
+}
+
+func (m *Mmap) ptr() uintptr {
+	if len(m.Data) == 0 {
+		return 0
+	}
+
+	return uintptr(unsafe.Pointer(&m.Data[0]))
+}
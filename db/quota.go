@@ -0,0 +1,539 @@
+package db
+
+import (
+	"bytedb/db/wal"
+	bit "bytedb/lib/bitbox"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:generate go run bytedb/cmd/bitbox-gen -type Quota,Usage
+
+var (
+	ErrQuotaExceeded = errors.New("QUOTA_EXCEEDED")
+	ErrNoInternals   = errors.New("database has no internal database")
+)
+
+// Where quotas and usage are kept in internal database
+var (
+	quotaCollection = Hash([]byte("quota"))
+	limitsNamespace = Hash([]byte("limits"))
+	usageNamespace  = Hash([]byte("usage"))
+)
+
+// Collection limits, 0 means no limit.
+type Quota struct {
+	MaxKeys      uint64
+	MaxBytes     uint64 // max logical size, see Usage.Bytes
+	MaxValueSize uint64
+}
+
+// Collection usage. Usage is tracked only by databases with internal
+// database, where it's saved on checkpoint. Expired keys count until
+// they are deleted, they are swept when put would exceed quota.
+type Usage struct {
+	Keys  uint64 // number of keys, including expired ones
+	Bytes uint64 // logical size, sum of key names and values
+	Disk  uint64 // size of bucket files, updated on checkpoint
+}
+
+// Check if change of usage by keys and bytes is allowed for key.
+// Changes lowering usage are always allowed.
+func (q *Quota) check(u *Usage, key *Key, keys, bytes int64) error {
+	if q.MaxValueSize > 0 && uint64(len(key.Value)) > q.MaxValueSize {
+		return fmt.Errorf("%w: value has %d bytes, max is %d", ErrQuotaExceeded, len(key.Value), q.MaxValueSize)
+	}
+
+	if q.MaxKeys > 0 && keys > 0 && u.Keys+uint64(keys) > q.MaxKeys {
+		return fmt.Errorf("%w: collection has max %d keys", ErrQuotaExceeded, q.MaxKeys)
+	}
+
+	if q.MaxBytes > 0 && bytes > 0 && u.Bytes+uint64(bytes) > q.MaxBytes {
+		return fmt.Errorf("%w: collection has max %d bytes", ErrQuotaExceeded, q.MaxBytes)
+	}
+
+	return nil
+}
+
+// Set collection quota. It's saved in internal database right away.
+func (db *DB) SetQuota(collection uint64, q Quota) error {
+	if db.internals == nil {
+		return ErrNoInternals
+	}
+
+	coll, err := db.Collection(collection)
+	if err != nil {
+		return err
+	}
+
+	coll.usageMu.Lock()
+	defer coll.usageMu.Unlock()
+
+	err = db.internals.Put(quotaKey(limitsNamespace, collection, bit.Encode(&q)))
+	if err != nil {
+		return err
+	}
+
+	coll.quota = q
+	return nil
+}
+
+// Return collection quota.
+func (db *DB) Quota(collection uint64) (Quota, error) {
+	if db.internals == nil {
+		return Quota{}, ErrNoInternals
+	}
+
+	coll, err := db.Collection(collection)
+	if err != nil {
+		return Quota{}, err
+	}
+
+	coll.usageMu.Lock()
+	defer coll.usageMu.Unlock()
+
+	return coll.quota, nil
+}
+
+// Return collection usage.
+func (db *DB) Usage(collection uint64) (Usage, error) {
+	if db.internals == nil {
+		return Usage{}, ErrNoInternals
+	}
+
+	coll, err := db.Collection(collection)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	coll.usageMu.Lock()
+	defer coll.usageMu.Unlock()
+
+	return coll.usage, nil
+}
+
+// Load collection quota and usage from internal database.
+// Caller must hold db.mu.
+func (db *DB) loadUsage(coll *Collection) error {
+	val, err := db.internals.Get(quotaKey(limitsNamespace, coll.Hash, nil))
+	if err != nil {
+		return err
+	}
+
+	bit.NewBuffer(val).Decode(&coll.quota)
+
+	// Replicas always count keys, usage in their internal
+	// database belongs to the other one.
+	if !db.opts.Replica {
+		err = db.checkUsage()
+		if err != nil {
+			return err
+		}
+
+		val, err = db.internals.Get(quotaKey(usageNamespace, coll.Hash, nil))
+		if err != nil || val != nil {
+			bit.NewBuffer(val).Decode(&coll.usage)
+
+			return err
+		}
+	}
+
+	coll.usage, err = coll.count()
+	return err
+}
+
+// Saved usage matches bucket files only if it was saved by the last
+// checkpoint. After crash during checkpoint or after restore it doesn't,
+// so it's dropped and keys are counted when collections are loaded.
+// Check is done once, caller must hold db.mu.
+func (db *DB) checkUsage() error {
+	if db.usageChecked {
+		return nil
+	}
+
+	val, err := db.internals.Get(usageMarkKey(nil))
+	if err != nil {
+		return err
+	}
+
+	lsn, done := uint64(0), false
+	bit.NewBuffer(val).Decode(&lsn, &done)
+
+	if !done || lsn != db.cpLSN {
+		err = db.dropUsage()
+		if err != nil {
+			return err
+		}
+	}
+
+	db.usageChecked = true
+	return nil
+}
+
+// Delete all saved usage records.
+func (db *DB) dropUsage() error {
+	keys := []*Key{}
+	cursor := uint64(0)
+
+	for {
+		list, next, err := db.internals.Scan(quotaCollection, usageNamespace, 0, cursor, 1000)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, list...)
+
+		if next == 0 {
+			break
+		}
+
+		cursor = next
+	}
+
+	for _, key := range keys {
+		key.Collection = quotaCollection
+		key.Namespace = usageNamespace
+
+		_, err := db.internals.DeleteKey(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Prepare saving usage before dirty blocks are flushed. Saved usage
+// is marked as pending, so it isn't trusted if checkpoint doesn't
+// finish. Return false if no usage changed. Caller must hold db.mu
+// and checkpoint lock.
+func (db *DB) beginUsage() (bool, error) {
+	if db.internals == nil || !db.usageChanged() {
+		return false, nil
+	}
+
+	// Replicas don't write to internal database, it's a copy too.
+	if db.opts.Replica {
+		return true, nil
+	}
+
+	err := db.checkUsage()
+	if err != nil {
+		return false, err
+	}
+
+	return true, db.markUsage(false)
+}
+
+// Save usage of changed collections after dirty blocks were flushed
+// and mark it as matching checkpoint at current lsn.
+func (db *DB) saveUsage() error {
+	for _, coll := range db.collections {
+		err := db.saveCollUsage(coll)
+		if err != nil {
+			return err
+		}
+	}
+
+	if db.opts.Replica {
+		return nil
+	}
+
+	return db.markUsage(true)
+}
+
+// Write lsn of checkpoint usage is saved on, synced to disk.
+func (db *DB) markUsage(done bool) error {
+	err := db.internals.Put(usageMarkKey(bit.Encode(&db.lsn, &done)))
+	if err != nil {
+		return err
+	}
+
+	return db.internals.wal.Sync()
+}
+
+// Check if usage of any collection changed since it was saved.
+func (db *DB) usageChanged() bool {
+	for _, coll := range db.collections {
+		coll.usageMu.Lock()
+		dirty := coll.dirty
+		coll.usageMu.Unlock()
+
+		if dirty {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (db *DB) saveCollUsage(coll *Collection) error {
+	coll.usageMu.Lock()
+	defer coll.usageMu.Unlock()
+
+	if !coll.dirty {
+		return nil
+	}
+
+	disk, err := coll.diskSize()
+	if err != nil {
+		return err
+	}
+
+	coll.usage.Disk = disk
+	coll.dirty = false
+
+	if db.opts.Replica {
+		return nil
+	}
+
+	return db.internals.Put(quotaKey(usageNamespace, coll.Hash, bit.Encode(&coll.usage)))
+}
+
+// Return key of record with lsn of checkpoint usage was saved on.
+func usageMarkKey(val []byte) *Key {
+	key := NewKey([]byte("checkpoint"), val)
+	key.Collection = quotaCollection
+	key.Namespace = usageNamespace
+
+	return key
+}
+
+// Return key for quota or usage record of collection.
+func quotaKey(namespace, collection uint64, val []byte) *Key {
+	name := binary.BigEndian.AppendUint64(nil, collection)
+
+	key := NewKey(name, val)
+	key.Collection = quotaCollection
+	key.Namespace = namespace
+
+	return key
+}
+
+// Return change of key count and logical size made by logging key.
+// Old is the existing key, nil if there isn't one.
+func usageChange(typ uint8, key, old *Key) (int64, int64) {
+	keys, bytes := int64(0), int64(0)
+
+	if old != nil {
+		keys--
+		bytes -= int64(len(old.Name) + len(old.Value))
+	}
+
+	if typ == wal.RecPut {
+		keys++
+		bytes += int64(len(key.Name) + len(key.Value))
+	}
+
+	return keys, bytes
+}
+
+// Add change of key count and logical size to usage. If enforce is set,
+// change is rejected with ErrQuotaExceeded when it's over quota.
+func (c *Collection) use(key *Key, keys, bytes int64, enforce bool) error {
+	c.usageMu.Lock()
+	defer c.usageMu.Unlock()
+
+	if enforce {
+		err := c.quota.check(&c.usage, key, keys, bytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.usage.Keys = addClamped(c.usage.Keys, keys)
+	c.usage.Bytes = addClamped(c.usage.Bytes, bytes)
+	c.dirty = true
+
+	return nil
+}
+
+func addClamped(n uint64, delta int64) uint64 {
+	if delta < 0 && uint64(-delta) > n {
+		return 0
+	}
+
+	return n + uint64(delta)
+}
+
+// Min time between sweeps of expired keys of collection.
+const SweepInterval = time.Second
+
+// Delete expired keys of collection, so they don't count against quota.
+// Deletes are logged, so replicas delete them too. Return false if
+// nothing was deleted or collection was swept in the last SweepInterval.
+func (db *DB) sweep(collection uint64) bool {
+	if db.internals == nil || db.opts.Replica {
+		return false
+	}
+
+	coll, err := db.Collection(collection)
+	if err != nil {
+		return false
+	}
+
+	coll.usageMu.Lock()
+	recent := time.Since(coll.swept) < SweepInterval
+	if !recent {
+		coll.swept = time.Now()
+	}
+	coll.usageMu.Unlock()
+
+	if recent {
+		return false
+	}
+
+	deleted := false
+
+	coll.walkBuckets(func(path string, size int64) error {
+		ns, prefix, ok := bucketPath(path)
+		if !ok {
+			return nil
+		}
+
+		b, err := coll.Bucket(ns, prefix)
+		if err != nil {
+			return err
+		}
+
+		keys, err := b.expired()
+		if err != nil {
+			return err
+		}
+
+		for _, kv := range keys {
+			key := NewKey(kv.Name, nil)
+			key.Collection = collection
+			key.Namespace = ns
+			key.Prefix = prefix
+
+			// Key could be changed since it was found expired
+			ok, err := db.logApply(0, key, 0, func(old *Key) (uint8, error) {
+				if old == nil || !old.Expired() {
+					return 0, nil
+				}
+
+				return wal.RecDelete, nil
+			})
+			if err != nil {
+				return err
+			}
+
+			deleted = deleted || ok
+		}
+
+		return nil
+	})
+
+	return deleted
+}
+
+// Return namespace and prefix of bucket file, path is <namespace>/<prefix>.bck.
+func bucketPath(path string) (uint64, uint64, bool) {
+	ns, err := strconv.ParseUint(filepath.Base(filepath.Dir(path)), 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	prefix, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), ExtBucket), 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return ns, prefix, true
+}
+
+// Count keys and bytes stored in bucket files of collection.
+func (c *Collection) count() (Usage, error) {
+	u := Usage{}
+
+	err := c.walkBuckets(func(path string, size int64) error {
+		u.Disk += uint64(size)
+
+		ns, prefix, ok := bucketPath(path)
+		if !ok {
+			return nil // not a bucket
+		}
+
+		b, err := c.Bucket(ns, prefix)
+		if err != nil {
+			return err
+		}
+
+		return b.count(&u)
+	})
+
+	return u, err
+}
+
+// Return size of bucket files of collection.
+func (c *Collection) diskSize() (uint64, error) {
+	size := uint64(0)
+
+	err := c.walkBuckets(func(path string, n int64) error {
+		size += uint64(n)
+		return nil
+	})
+
+	return size, err
+}
+
+// Call fn for each bucket file of collection with its size.
+func (c *Collection) walkBuckets(fn func(path string, size int64) error) error {
+	err := filepath.WalkDir(c.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ExtBucket) {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		return fn(path, info.Size())
+	})
+
+	// Collection has no files yet
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Add keys stored in bucket to usage.
+func (b *Bucket) count(u *Usage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cursor := uint64(0)
+
+	for {
+		idxs, next, err := b.index.Scan(cursor, 1000)
+		if err != nil {
+			return err
+		}
+
+		for _, idx := range idxs {
+			kv, err := b.ReadKV(idx)
+			if err != nil {
+				return err
+			}
+
+			u.Keys++
+			u.Bytes += uint64(len(kv.Name) + len(kv.Value))
+		}
+
+		if next == 0 {
+			return nil
+		}
+
+		cursor = next
+	}
+}
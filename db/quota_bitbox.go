@@ -0,0 +1,79 @@
+// Code generated by bitbox-gen. DO NOT EDIT.
+
+package db
+
+import bit "bytedb/lib/bitbox"
+
+func (v *Quota) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(v.MaxKeys)
+	e.Uint64(v.MaxBytes)
+	e.Uint64(v.MaxValueSize)
+}
+
+func (v *Quota) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.MaxKeys = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.MaxBytes = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.MaxValueSize = x
+	}
+
+	return nil
+}
+
+func (v *Usage) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(v.Keys)
+	e.Uint64(v.Bytes)
+	e.Uint64(v.Disk)
+}
+
+func (v *Usage) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Keys = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Bytes = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Disk = x
+	}
+
+	return nil
+}
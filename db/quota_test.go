@@ -0,0 +1,142 @@
+package db
+
+import (
+	"bytedb/tests"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	root := t.TempDir()
+	db, _ := Open(root)
+
+	put := func(name, val string) error {
+		key := NewKey([]byte(name), []byte(val))
+		key.Collection = 1
+
+		return db.Put(key)
+	}
+
+	tests.Assert(t, nil, db.SetQuota(1, Quota{MaxKeys: 2, MaxBytes: 21, MaxValueSize: 8}))
+
+	tests.Assert(t, nil, put("key_1", "val_1"))
+	tests.Assert(t, nil, put("key_2", "val_2"))
+
+	err := put("key_3", "val_3")
+	tests.Assert(t, true, errors.Is(err, ErrQuotaExceeded))
+
+	// Existing keys can be replaced
+	tests.Assert(t, nil, put("key_1", "val_11"))
+
+	err = put("key_1", "val_11111")
+	tests.Assert(t, true, errors.Is(err, ErrQuotaExceeded))
+
+	err = put("key_1", "val_1111")
+	tests.Assert(t, true, errors.Is(err, ErrQuotaExceeded))
+
+	// Other collections aren't limited
+	tests.Assert(t, nil, db.Put(NewKey([]byte("key_3"), []byte("val_3"))))
+
+	u, _ := db.Usage(1)
+	tests.Assert(t, Usage{Keys: 2, Bytes: 21}, u)
+
+	key := NewKey([]byte("key_2"), nil)
+	key.Collection = 1
+	db.DeleteKey(key)
+
+	tests.Assert(t, nil, put("key_3", "val_3"))
+	db.Close()
+
+	// Quota and usage are saved
+	db, _ = Open(root)
+	defer db.Close()
+
+	q, _ := db.Quota(1)
+	tests.Assert(t, Quota{MaxKeys: 2, MaxBytes: 21, MaxValueSize: 8}, q)
+
+	u, _ = db.Usage(1)
+	tests.Assert(t, uint64(2), u.Keys)
+	tests.Assert(t, uint64(21), u.Bytes)
+	tests.Assert(t, true, u.Disk > 0)
+
+	err = put("key_4", "val_4")
+	tests.Assert(t, true, errors.Is(err, ErrQuotaExceeded))
+}
+
+func TestUsageAfterCrash(t *testing.T) {
+	root := t.TempDir()
+	db, _ := Open(root)
+
+	db.Put(NewKey([]byte("key_1"), []byte("val_1")))
+	db.Put(NewKey([]byte("key_2"), []byte("val_2")))
+	db.Checkpoint()
+
+	db.Put(NewKey([]byte("key_3"), []byte("val_3")))
+	db.DeleteKey(NewKey([]byte("key_1"), nil))
+
+	// Simulate crash, data files are never flushed.
+	db.wal.Stop()
+	db.wal.Close()
+	db.internals.Close()
+
+	db, _ = Open(root)
+
+	u, _ := db.Usage(0)
+	tests.Assert(t, uint64(2), u.Keys)
+	tests.Assert(t, uint64(20), u.Bytes)
+
+	// Crash during checkpoint, after flush and before usage
+	// is saved. Saved usage doesn't match files, keys are counted.
+	db.Put(NewKey([]byte("key_4"), []byte("val_4")))
+
+	coll, _ := db.Collection(0)
+
+	db.mu.Lock()
+	db.beginUsage()
+	db.mu.Unlock()
+
+	coll.Flush()
+
+	db.wal.Stop()
+	db.wal.Close()
+	db.internals.Close()
+
+	db, _ = Open(root)
+	defer db.Close()
+
+	u, _ = db.Usage(0)
+	tests.Assert(t, uint64(3), u.Keys)
+	tests.Assert(t, uint64(30), u.Bytes)
+}
+
+func TestQuotaExpired(t *testing.T) {
+	db, _ := Open(t.TempDir())
+	defer db.Close()
+
+	put := func(name string, expire int64) error {
+		key := NewKey([]byte(name), []byte("val"))
+		key.Collection = 1
+		key.Expire = expire
+
+		return db.Put(key)
+	}
+
+	tests.Assert(t, nil, db.SetQuota(1, Quota{MaxKeys: 2}))
+
+	tests.Assert(t, nil, put("key_1", time.Now().Add(-time.Second).UnixNano()))
+	tests.Assert(t, nil, put("key_2", 0))
+
+	// Expired key is deleted to make room
+	tests.Assert(t, nil, put("key_3", 0))
+
+	u, _ := db.Usage(1)
+	tests.Assert(t, uint64(2), u.Keys)
+
+	// Live keys aren't deleted
+	err := put("key_4", 0)
+	tests.Assert(t, true, errors.Is(err, ErrQuotaExceeded))
+
+	u, _ = db.Usage(1)
+	tests.Assert(t, uint64(2), u.Keys)
+}
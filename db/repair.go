@@ -0,0 +1,230 @@
+package db
+
+import (
+	bit "bytedb/lib/bitbox"
+	"encoding/binary"
+	"os"
+)
+
+// Extension of original file kept after index rebuild
+const ExtBackup = ".bak"
+
+// Repair options
+type RepairOptions struct {
+	RebuildIndex bool // rebuild index of damaged bucket files from their value blocks
+	TruncateWal  bool // remove first invalid wal record and all records after it
+}
+
+// Repair database in root directory. Database must not be open.
+// Return report of check run after repair.
+func Repair(root string, opts *RepairOptions) (*Report, error) {
+	r, err := Check(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.TruncateWal {
+		for _, dir := range []string{root, root + "/internal"} {
+			err = checkWal(dir+WalPath, &Report{}, true)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.RebuildIndex {
+		for path := range r.damaged {
+			_, err = RebuildIndex(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return Check(root)
+}
+
+// Rebuild bucket file from its value blocks. Keys are written to new
+// file, original one is kept with ".bak" extension. When key was written
+// more than once, the latest value wins. Keys deleted in readable part of
+// the old index stay deleted, the rest of deleted keys come back.
+// Return number of recovered keys.
+func RebuildIndex(path string) (int, error) {
+	f, err := OpenFileReadOnly(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.file.Close()
+
+	count := uint32(f.BlockCount())
+	first, last := f.IndexOffset, f.IndexOffset+f.IndexBlocks-1
+
+	valid := f.IndexBlocks > 0 && first > DefaultHeaderBlocks && uint64(first)+uint64(f.IndexBlocks)-1 <= uint64(count)
+	deleted := map[[2]uint32]bool{} // block and position of deleted records
+
+	if valid {
+		for id := first; id <= last; id++ {
+			b, err := f.readBlock(id)
+			if err != nil {
+				return 0, err
+			}
+
+			_, keys := DecodeIndexBlock(b)
+			for _, idx := range keys {
+				if idx.Flag&FlagDeleted != 0 {
+					deleted[[2]uint32{idx.Offset, uint32(idx.Pos)}] = true
+				}
+			}
+		}
+	}
+
+	// The latest record of each key, values are appended,
+	// so later records are newer.
+	latest := map[string]*Key{}
+	removed := map[string]bool{}
+
+	for id, pos := uint32(DefaultHeaderBlocks+1), 0; id <= count; {
+		if valid && id >= first && id <= last {
+			id, pos = last+1, 0
+			continue
+		}
+
+		key, n, err := f.readRecord(id, pos, count)
+		if err != nil {
+			return 0, err
+		}
+
+		// Records are packed, the rest of block is empty or damaged.
+		if key == nil {
+			id, pos = id+1, 0
+			continue
+		}
+
+		latest[string(key.Name)] = key
+		removed[string(key.Name)] = deleted[[2]uint32{id, uint32(pos)}]
+
+		id += uint32((pos + n) / BlockSize)
+		pos = (pos + n) % BlockSize
+	}
+
+	tmp := path + ".rebuild"
+	os.Remove(tmp)
+
+	b, err := OpenBucket(tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+
+	for name, key := range latest {
+		if removed[name] {
+			continue
+		}
+
+		err = b.Put(key)
+		if err != nil {
+			b.Close()
+			return 0, err
+		}
+
+		n++
+	}
+
+	err = b.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Rename(path, path+ExtBackup)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, os.Rename(tmp, path)
+}
+
+// Try to decode key record starting at position pos of block id. Return
+// nil key if there is no valid record, otherwise key and size of record.
+func (f *File) readRecord(id uint32, pos int, count uint32) (*Key, int, error) {
+	first, err := f.readBlock(id)
+	if err != nil || isZero(first.Data[pos:]) {
+		return nil, 0, err // empty block, like zeroed index block
+	}
+
+	data := first.Data[pos:]
+
+	// Read blocks until record size is known
+	need := func(size uint64) (bool, error) {
+		if size > uint64(count-id+1)*BlockSize-uint64(pos) {
+			return false, nil // record can't fit in file
+		}
+
+		for uint64(len(data)) < size {
+			next := id + uint32((pos+len(data))/BlockSize)
+			if next > count {
+				return false, nil
+			}
+
+			b, err := f.readBlock(next)
+			if err != nil {
+				return false, err
+			}
+
+			data = append(data, b.Data...)
+		}
+
+		return true, nil
+	}
+
+	size := uint64(0)
+
+	// Name, value and content type with length prefixes,
+	// expire after value and checksum at the end.
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			size += 8
+		}
+
+		ok, err := need(size + 4)
+		if !ok || err != nil {
+			return nil, 0, err
+		}
+
+		l := uint32(0)
+		bit.NewBuffer(data[size:]).Decode(&l)
+		size += 4 + uint64(l)
+	}
+
+	ok, err := need(size + 4)
+	if !ok || err != nil {
+		return nil, 0, err
+	}
+
+	key, n, err := decodeKV(data)
+	if err != nil {
+		return nil, 0, nil
+	}
+
+	// Records written by older versions have no checksum. Accept them
+	// only if padding after them is empty, other blocks are rarely like that.
+	if n < 4 || binary.LittleEndian.Uint32(data[n-4:]) != checksum(data[:n-4]) {
+		if pos != 0 || !isZero(data[n:(n+BlockSize-1)/BlockSize*BlockSize]) {
+			return nil, 0, nil
+		}
+	}
+
+	key.Hash = Hash(key.Name)
+	return key, n, nil
+}
+
+// Check if all bytes are zero
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,29 @@
+package db
+
+import "time"
+
+// Time spent in parts of single operation. It's collected
+// for keys with Trace set, see Key.Trace.
+type Trace struct {
+	Index  time.Duration // index lookups, including key comparisons
+	Blocks time.Duration // reading and writing key and value blocks
+	Wal    time.Duration // appending record to wal and syncing it
+}
+
+func (t *Trace) addIndex(start time.Time) {
+	if t != nil {
+		t.Index += time.Since(start)
+	}
+}
+
+func (t *Trace) addBlocks(start time.Time) {
+	if t != nil {
+		t.Blocks += time.Since(start)
+	}
+}
+
+func (t *Trace) addWal(start time.Time) {
+	if t != nil {
+		t.Wal += time.Since(start)
+	}
+}
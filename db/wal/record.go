@@ -0,0 +1,126 @@
+package wal
+
+import (
+	bit "bytedb/lib/bitbox"
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	ErrInvalidRecord = errors.New("invalid record")
+	ErrChecksum      = errors.New("record checksum mismatch")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Record types
+const (
+	RecPut        uint8 = 1
+	RecDelete     uint8 = 2
+	RecCheckpoint uint8 = 3
+)
+
+// Single change stored in wal.
+type Record struct {
+	LSN         uint64 // log sequence number
+	Type        uint8
+	Collection  uint64
+	Namespace   uint64
+	Prefix      uint64
+	Key         []byte
+	Value       []byte
+	Expire      int64  // key expiration time in unix nanoseconds
+	ContentType string // media type of value
+	Time        int64  // commit time in unix nanoseconds
+}
+
+// Encode record to bytes, checksum of all fields is appended.
+func (r *Record) Encode() []byte {
+	data := bit.Encode(
+		&r.LSN,
+		&r.Type,
+		&r.Collection,
+		&r.Namespace,
+		&r.Prefix,
+		&r.Key,
+		&r.Value,
+		&r.Expire,
+		&r.ContentType,
+		&r.Time,
+	)
+
+	sum := crc32.Checksum(data, crcTable)
+	return append(data, bit.Encode(&sum)...)
+}
+
+// Decode record from log
+func DecodeRecord(log []byte) *Record {
+	r, _ := ParseRecord(log)
+	return r
+}
+
+// Decode record from untrusted data, like DecodeRecord, but return
+// error if it's damaged. Decoded fields are returned along with error.
+func ParseRecord(log []byte) (*Record, error) {
+	r := &Record{}
+
+	buf := bit.NewBuffer(log)
+	err := buf.Decode(
+		&r.LSN,
+		&r.Type,
+		&r.Collection,
+		&r.Namespace,
+		&r.Prefix,
+		&r.Key,
+		&r.Value,
+		&r.Expire,
+		&r.ContentType,
+		&r.Time,
+	)
+
+	return r, err
+}
+
+// Check if log is a complete record with valid checksum.
+func VerifyRecord(log []byte) error {
+	// LSN, type, collection, namespace and prefix
+	off := 8 + 1 + 8 + 8 + 8
+
+	// Key and value with their length prefixes
+	for i := 0; i < 2; i++ {
+		if len(log) < off+4 {
+			return ErrInvalidRecord
+		}
+
+		size := uint32(0)
+		bit.NewBuffer(log[off:]).Decode(&size)
+
+		off += 4 + int(size)
+	}
+
+	// Expire and content type with its length prefix
+	off += 8
+
+	if len(log) < off+4 {
+		return ErrInvalidRecord
+	}
+
+	size := uint32(0)
+	bit.NewBuffer(log[off:]).Decode(&size)
+
+	// Commit time
+	off += 4 + int(size) + 8
+
+	if len(log) != off+4 {
+		return ErrInvalidRecord
+	}
+
+	sum := uint32(0)
+	bit.NewBuffer(log[off:]).Decode(&sum)
+
+	if sum != crc32.Checksum(log[:off], crcTable) {
+		return ErrChecksum
+	}
+
+	return nil
+}
@@ -1,30 +1,117 @@
 package wal
 
 import (
-	"bucketdb/db/mmap"
+	"bytedb/db/mmap"
+	bit "bytedb/lib/bitbox"
+	"bytedb/lib/metrics"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
-	"unsafe"
 )
 
+const Ext = ".wal"
+
+var ErrTruncated = errors.New("truncated log")
+
+var (
+	appendTime = metrics.Default.Histogram("bytedb_wal_append_seconds", "Time of appending record to wal, without sync.", metrics.LatencyBuckets)
+	syncTime   = metrics.Default.Histogram("bytedb_wal_fsync_seconds", "Time of syncing wal segment.", metrics.LatencyBuckets)
+)
+
+// Sync modes
+const (
+	SyncInterval SyncMode = iota // msync periodically in main loop
+	SyncAlways                   // msync after every write
+	SyncNone                     // leave it to OS, sync only on close
+)
+
+type SyncMode uint8
+
+// Parse sync mode name: "interval", "always" or "none".
+func ParseSyncMode(name string) (SyncMode, error) {
+	switch name {
+	case "interval":
+		return SyncInterval, nil
+	case "always":
+		return SyncAlways, nil
+	case "none":
+		return SyncNone, nil
+	}
+
+	return 0, fmt.Errorf("unknown sync mode: %q", name)
+}
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncAlways:
+		return "always"
+	case SyncNone:
+		return "none"
+	}
+
+	return "interval"
+}
+
+// Wal is a directory of segment files, each one truncated to the same size.
+// Logs are appended to the last segment, when it's full new one is created.
 type Wal struct {
-	file *mmap.Mmap
 	Logs chan []byte
+	Mode SyncMode
+
+	// Directory where segments removed by Reset are moved, so their
+	// logs can still be read. The oldest ones are removed when archive
+	// is bigger than ArchiveSize. Empty means segments are removed.
+	Archive     string
+	ArchiveSize int64
+
+	dir  string
+	size int64 // segment size
+
+	mu    sync.Mutex
+	file  *mmap.Mmap // current segment
+	seq   int        // current segment number
+	count int        // number of segments
+
+	// Closed when main loop returns.
+	done chan struct{}
 }
 
-// Open the wal file that we will be writing to.
-// Each wal file will be truncated to given size (in bytes).
-func Open(path string, size int64) (*Wal, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+// Open the wal directory that we will be writing to.
+// Each segment file will be truncated to given size (in bytes).
+func Open(dir string, size int64) (*Wal, error) {
+	err := os.MkdirAll(dir, 0755)
 	if err != nil {
 		return nil, err
 	}
 
-	mmap, err := mmap.Open(file, int(size), 0)
-	mmap.Resize(size)
+	w := &Wal{dir: dir, size: size, Logs: make(chan []byte, 1000), done: make(chan struct{})}
 
-	w := &Wal{file: mmap, Logs: make(chan []byte, 1000)}
+	// Continue writing to the last segment
+	segs, err := w.Segments()
+	if err != nil {
+		return nil, err
+	}
+
+	w.seq, w.count = 1, 1
+	if len(segs) > 0 {
+		w.seq, w.count = segs[len(segs)-1], len(segs)
+	}
+
+	w.file, err = w.openSegment(w.seq)
+	if err != nil {
+		return nil, err
+	}
+
+	// Segment could already contain logs, we must append after them.
+	w.seek()
 	return w, nil
 }
 
@@ -33,6 +120,7 @@ func Open(path string, size int64) (*Wal, error) {
 func (w *Wal) Start(timeout int) {
 	ticker := time.NewTicker(time.Duration(timeout) * time.Millisecond)
 	defer ticker.Stop()
+	defer close(w.done)
 
 	for {
 		select {
@@ -40,14 +128,18 @@ func (w *Wal) Start(timeout int) {
 		case data, open := <-w.Logs:
 			// If channel was closed, sync data and return
 			if !open {
-				w.file.Sync()
+				w.Sync()
 				return
 			}
-			w.write(data)
+			w.Write(data)
 
 		// Periodically call msync and flush data to file
 		case _ = <-ticker.C:
-			err := w.file.Sync()
+			if w.Mode != SyncInterval {
+				continue
+			}
+
+			err := w.Sync()
 			if err != nil {
 				fmt.Println(err)
 			}
@@ -55,16 +147,292 @@ func (w *Wal) Start(timeout int) {
 	}
 }
 
+// Stop main loop. All pending logs will be written
+// and synced before returning.
+func (w *Wal) Stop() {
+	close(w.Logs)
+	<-w.done
+}
+
+// Write log to wal. Log is synced right away in SyncAlways mode.
+func (w *Wal) Write(data []byte) error {
+	start := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if int64(4+len(data)) > w.size {
+		return fmt.Errorf("log too big: %d bytes, segment size %d", len(data), w.size)
+	}
+
+	// Not enough space left, move to next segment
+	if len(w.file.Data)-w.file.WriteOffset < 4+len(data) {
+		err := w.next()
+		if err != nil {
+			return err
+		}
+	}
+
+	w.write(data)
+	appendTime.ObserveSince(start)
+
+	if w.Mode == SyncAlways {
+		return w.sync()
+	}
+
+	return nil
+}
+
+// Sync current segment.
+func (w *Wal) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.sync()
+}
+
+// Remove all segments and write the given logs to a new one.
+// Main loop must not be writing concurrently.
+func (w *Wal) Reset(logs ...[]byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	segs, err := w.Segments()
+	if err != nil {
+		return err
+	}
+
+	err = w.next()
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range segs {
+		err := w.remove(seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.pruneArchive()
+	if err != nil {
+		return err
+	}
+
+	w.count = 1
+	for _, log := range logs {
+		w.write(log)
+	}
+
+	return w.sync()
+}
+
+// Remove segment or move it to archive.
+func (w *Wal) remove(seq int) error {
+	if w.Archive == "" {
+		return os.Remove(w.path(seq))
+	}
+
+	err := os.MkdirAll(w.Archive, 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(w.path(seq), SegmentPath(w.Archive, seq))
+}
+
+// Remove the oldest archived segments until archive fits into ArchiveSize.
+func (w *Wal) pruneArchive() error {
+	if w.Archive == "" {
+		return nil
+	}
+
+	segs, err := Segments(w.Archive)
+	if err != nil {
+		return err
+	}
+
+	sizes := []int64{}
+	total := int64(0)
+
+	for _, seq := range segs {
+		info, err := os.Stat(SegmentPath(w.Archive, seq))
+		if err != nil {
+			return err
+		}
+
+		sizes = append(sizes, info.Size())
+		total += info.Size()
+	}
+
+	for i := 0; i < len(segs) && total > w.ArchiveSize; i++ {
+		err := os.Remove(SegmentPath(w.Archive, segs[i]))
+		if err != nil {
+			return err
+		}
+
+		total -= sizes[i]
+	}
+
+	return nil
+}
+
+// Sync and close wal file.
+// Main loop must be stopped before calling it.
+func (w *Wal) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.sync()
+	if err != nil {
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// Return number of segments
+func (w *Wal) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.count
+}
+
+// Return sorted numbers of all segments in wal directory.
+func (w *Wal) Segments() ([]int, error) {
+	return Segments(w.dir)
+}
+
+// Return sorted numbers of all segments in directory.
+func Segments(dir string) ([]int, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segs := []int{}
+
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), Ext)
+		if !ok {
+			continue
+		}
+
+		seq, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+
+		segs = append(segs, seq)
+	}
+
+	sort.Ints(segs)
+	return segs, nil
+}
+
+// Sync current segment, caller must hold lock.
+func (w *Wal) sync() error {
+	start := time.Now()
+	defer syncTime.ObserveSince(start)
+
+	return w.file.Sync()
+}
+
+// Sync current segment and start writing to the next one.
+func (w *Wal) next() error {
+	err := w.sync()
+	if err != nil {
+		return err
+	}
+
+	err = w.file.Close()
+	if err != nil {
+		return err
+	}
+
+	w.seq++
+	w.count++
+
+	w.file, err = w.openSegment(w.seq)
+	return err
+}
+
+func (w *Wal) openSegment(seq int) (*mmap.Mmap, error) {
+	file, err := os.OpenFile(w.path(seq), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := mmap.Open(file, int(w.size), 0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Return path of segment file
+func (w *Wal) path(seq int) string {
+	return SegmentPath(w.dir, seq)
+}
+
+// Return path of segment file in directory
+func SegmentPath(dir string, seq int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d%s", seq, Ext))
+}
+
+// Read logs from segment file without opening wal, so it can be done
+// while database is running or damaged. Fn gets offset of each log.
+// Return offset after the last complete log. ErrTruncated is returned
+// if log length points past the end of file.
+func ReadSegment(path string, fn func(off int, log []byte)) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return ReadSegmentFile(f, fn)
+}
+
+// Read logs from open segment file, see ReadSegment. File stays
+// readable after segment is archived or removed.
+func ReadSegmentFile(f *os.File, fn func(off int, log []byte)) (int, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+
+	off := 0
+
+	for off+4 <= len(data) {
+		size := uint32(0)
+		bit.NewBuffer(data[off : off+4]).Decode(&size)
+
+		if size == 0 {
+			break
+		}
+
+		if off+4+int(size) > len(data) {
+			return off, fmt.Errorf("%w at offset %d", ErrTruncated, off)
+		}
+
+		fn(off, data[off+4:off+4+int(size)])
+		off += 4 + int(size)
+	}
+
+	return off, nil
+}
+
 // Write log to wal file.
 func (w *Wal) write(data []byte) {
 	// We need a length prefix for each log so we will
 	// be able to iterate them.
-	size := uint32(len(data))
-
-	ptr := (*[4]byte)(unsafe.Pointer(&size))
 	log := make([]byte, 4+len(data))
 
-	copy(log, ptr[:])
+	binary.LittleEndian.PutUint32(log, uint32(len(data)))
 	copy(log[4:], data)
 
 	n := w.file.Write(log)
@@ -73,19 +441,140 @@ func (w *Wal) write(data []byte) {
 	}
 }
 
-func (w *Wal) Map(fn func(log []byte)) error {
+// Iterate all logs in all segments, starting from the first one. Iteration
+// stops at the first log rejected by fn or cut off at the end of segment,
+// that log and all logs after it are removed and new ones are written there.
+func (w *Wal) Map(fn func(log []byte) bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	segs, err := w.Segments()
+	if err != nil {
+		return err
+	}
+
+	for i, seq := range segs {
+		file := w.file
+
+		if seq != w.seq {
+			file, err = w.openSegment(seq)
+			if err != nil {
+				return err
+			}
+		}
+
+		off, ok := mapSegment(file, fn)
+
+		if seq != w.seq {
+			file.Close()
+		}
+
+		if !ok {
+			return w.truncate(seq, off, segs[i+1:])
+		}
+	}
+
+	return nil
+}
+
+// Iterate logs in one segment. Return false and offset of the
+// first log rejected by fn or cut off at the end of segment.
+func mapSegment(file *mmap.Mmap, fn func(log []byte) bool) (int, bool) {
+	file.ReadOffset = 0
+
 	for {
-		len := uint32(0)
-		ptr := (*[4]byte)(unsafe.Pointer(&len))
+		off := file.ReadOffset
+
+		prefix := [4]byte{}
+		file.ReadTo(prefix[:])
 
-		w.file.ReadTo(ptr[:])
+		len := binary.LittleEndian.Uint32(prefix[:])
 
 		// No more logs to read
 		if len == 0 {
-			return nil
+			return off, true
+		}
+
+		// Length points past the end of segment
+		log, err := file.Read(int(len))
+		if err != nil || !fn(log) {
+			return off, false
+		}
+	}
+}
+
+// Zero segment starting at offset and remove segments after it,
+// writing continues at that offset.
+func (w *Wal) truncate(seq, off int, later []int) error {
+	if seq != w.seq {
+		err := w.file.Close()
+		if err != nil {
+			return err
+		}
+
+		w.seq = seq
+
+		w.file, err = w.openSegment(seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, next := range later {
+		err := os.Remove(w.path(next))
+		if err != nil {
+			return err
+		}
+	}
+
+	w.count -= len(later)
+
+	clear(w.file.Data[off:])
+	w.file.WriteOffset = off
+
+	return w.sync()
+}
+
+// Zero segment file starting at offset, so logs after it are dropped.
+// Used for repairing segments with damaged logs.
+func TruncateSegment(path string, off int) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if int64(off) < info.Size() {
+		_, err = f.WriteAt(make([]byte, info.Size()-int64(off)), int64(off))
+		if err != nil {
+			return err
 		}
+	}
 
-		log, _ := w.file.Read(int(len))
-		fn(log)
+	return f.Sync()
+}
+
+// Move write offset right after the last log.
+func (w *Wal) seek() {
+	off := 0
+	data := w.file.Data
+
+	for off+4 <= len(data) {
+		size := uint32(0)
+		bit.NewBuffer(data[off : off+4]).Decode(&size)
+
+		// Log cut off at the end of segment is overwritten.
+		if size == 0 || uint64(off)+4+uint64(size) > uint64(len(data)) {
+			break
+		}
+
+		off += 4 + int(size)
 	}
+
+	w.file.WriteOffset = off
 }
@@ -1,14 +1,18 @@
 package wal
 
 import (
-	"bucketdb/tests"
+	bit "bytedb/lib/bitbox"
+	"bytedb/tests"
+	"bytes"
+	"errors"
+	"hash/crc32"
 	"os"
 	"testing"
 )
 
 func TestWrite(t *testing.T) {
 	wal, _ := Open("test.wal", 14_000_000)
-	defer os.Remove("test.wal")
+	defer os.RemoveAll("test.wal")
 
 	go func() {
 		data := make([]byte, 10)
@@ -26,7 +30,7 @@ func TestWrite(t *testing.T) {
 
 func TestMap(t *testing.T) {
 	wal, _ := Open("test.wal", 2_000_000)
-	defer os.Remove("test.wal")
+	defer os.RemoveAll("test.wal")
 
 	data := []byte("Hello Wal :D")
 	for i := 0; i < 99_000; i++ {
@@ -34,8 +38,154 @@ func TestMap(t *testing.T) {
 	}
 
 	counter := 0
-	count := func(log []byte) { counter += 1 }
+	count := func(log []byte) bool { counter += 1; return true }
 
 	wal.Map(count)
 	tests.Assert(t, 99_000, counter)
 }
+
+func TestSegments(t *testing.T) {
+	wal, _ := Open("test.wal", 1_000)
+	defer os.RemoveAll("test.wal")
+
+	// 10 logs per segment
+	data := make([]byte, 96)
+	for i := 0; i < 95; i++ {
+		wal.Write(data)
+	}
+
+	segs, _ := wal.Segments()
+	tests.Assert(t, 10, len(segs))
+
+	counter := 0
+	wal.Map(func(log []byte) bool { counter += 1; return true })
+	tests.Assert(t, 95, counter)
+
+	// Reset removes all segments
+	wal.Reset(data)
+
+	segs, _ = wal.Segments()
+	tests.AssertEqual(t, []int{11}, segs)
+}
+
+func TestArchive(t *testing.T) {
+	wal, _ := Open("test.wal", 1_000)
+	defer os.RemoveAll("test.wal")
+	defer os.RemoveAll("test.archive")
+
+	wal.Archive = "test.archive"
+	wal.ArchiveSize = 3_000
+
+	// 10 logs per segment
+	data := make([]byte, 96)
+	for i := 0; i < 45; i++ {
+		wal.Write(data)
+	}
+
+	// Reset moves segments to archive, only the last 3 fit there.
+	wal.Reset(data)
+
+	segs, _ := Segments("test.archive")
+	tests.AssertEqual(t, []int{3, 4, 5}, segs)
+
+	segs, _ = wal.Segments()
+	tests.AssertEqual(t, []int{6}, segs)
+}
+
+func TestReopen(t *testing.T) {
+	wal, _ := Open("test.wal", 1_000)
+	defer os.RemoveAll("test.wal")
+
+	wal.Write([]byte("log_1"))
+	wal.Close()
+
+	wal, _ = Open("test.wal", 1_000)
+	wal.Write([]byte("log_2"))
+
+	logs := []string{}
+	wal.Map(func(log []byte) bool { logs = append(logs, string(log)); return true })
+
+	tests.AssertEqual(t, []string{"log_1", "log_2"}, logs)
+}
+
+func TestReadSegment(t *testing.T) {
+	wal, _ := Open("test.wal", 1_000)
+	defer os.RemoveAll("test.wal")
+
+	wal.Write([]byte("log_1"))
+	wal.Write([]byte("log_2"))
+	wal.Close()
+
+	logs := []string{}
+	offs := []int{}
+
+	end, err := ReadSegment(SegmentPath("test.wal", 1), func(off int, log []byte) {
+		logs = append(logs, string(log))
+		offs = append(offs, off)
+	})
+
+	tests.Assert(t, nil, err)
+	tests.AssertEqual(t, []string{"log_1", "log_2"}, logs)
+	tests.AssertEqual(t, []int{0, 9}, offs)
+	tests.Assert(t, 18, end)
+
+	// Length of last log points past the end of file
+	os.WriteFile("test.wal/00000002.wal", []byte{5, 0, 0, 0, 'a', 'b'}, 0644)
+
+	end, err = ReadSegment(SegmentPath("test.wal", 2), func(off int, log []byte) {})
+	tests.Assert(t, true, errors.Is(err, ErrTruncated))
+	tests.Assert(t, 0, end)
+}
+
+func TestRecord(t *testing.T) {
+	rec := &Record{LSN: 1, Type: RecPut, Key: []byte("key"), Value: []byte("val"), Expire: 2, Time: 3}
+	log := rec.Encode()
+
+	tests.Assert(t, nil, VerifyRecord(log))
+	tests.AssertEqual(t, rec, DecodeRecord(log))
+
+	log[len(log)-5]++
+	tests.Assert(t, ErrChecksum, VerifyRecord(log))
+
+	// Checksum and commit time are required
+	tests.Assert(t, ErrInvalidRecord, VerifyRecord(log[:len(log)-4]))
+	tests.Assert(t, ErrInvalidRecord, VerifyRecord(log[:len(log)-8-4]))
+
+	data := bit.Encode(&rec.LSN, &rec.Type, &rec.Collection, &rec.Namespace, &rec.Prefix, &rec.Key, &rec.Value, &rec.Expire)
+	sum := crc32.Checksum(data, crcTable)
+	tests.Assert(t, ErrInvalidRecord, VerifyRecord(append(data, bit.Encode(&sum)...)))
+}
+
+func TestMapTruncate(t *testing.T) {
+	wal, _ := Open("test.wal", 1_000)
+	defer os.RemoveAll("test.wal")
+
+	// 10 logs per segment
+	for i := 0; i < 25; i++ {
+		wal.Write(bytes.Repeat([]byte{byte(i)}, 96))
+	}
+
+	// Rejected log and logs after it are removed
+	wal.Map(func(log []byte) bool { return log[0] != 5 })
+
+	segs, _ := wal.Segments()
+	tests.AssertEqual(t, []int{1}, segs)
+
+	wal.Write([]byte("log_1"))
+	wal.Close()
+
+	// Length of the last log points past the end of segment
+	wal, _ = Open("test.wal", 1_000)
+	wal.file.Data[wal.file.WriteOffset] = 0xff
+	wal.file.Data[wal.file.WriteOffset+1] = 0xff
+	wal.Close()
+
+	wal, _ = Open("test.wal", 1_000)
+	wal.Write([]byte("log_2"))
+
+	logs := []string{}
+	wal.Map(func(log []byte) bool { logs = append(logs, string(log)); return true })
+
+	tests.Assert(t, 7, len(logs))
+	tests.AssertEqual(t, []string{"log_1", "log_2"}, logs[5:])
+}
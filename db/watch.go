@@ -0,0 +1,68 @@
+package db
+
+import (
+	"bytedb/db/wal"
+	"context"
+)
+
+//go:generate go run bytedb/cmd/bitbox-gen -type Event
+
+// Change of a single key, passed to watchers.
+type Event struct {
+	LSN        uint64
+	Delete     bool // key was deleted, value is nil
+	Collection uint64
+	Namespace  uint64
+	Prefix     uint64
+	Key        []byte
+	Value      []byte
+	Expire     int64 // key expiration time in unix nanoseconds
+	Time       int64 // commit time in unix nanoseconds
+}
+
+// Pass changes of keys in collection, namespace and prefix to fn, starting
+// after lsn, until ctx is done or fn returns error. Zero collection, namespace
+// or prefix matches all of them. Events are passed as they are committed, so
+// watcher can resume from lsn of the last event as long as wal is retained,
+// otherwise ErrNotRetained is returned (see Follow).
+func (db *DB) Watch(ctx context.Context, collection, namespace, prefix, lsn uint64, fn func(events []Event) error) error {
+	return db.Follow(ctx, lsn, func(logs [][]byte) error {
+		events := []Event{}
+
+		for _, log := range logs {
+			rec := wal.DecodeRecord(log)
+
+			if !matchFilter(collection, rec.Collection) || !matchFilter(namespace, rec.Namespace) || !matchFilter(prefix, rec.Prefix) {
+				continue
+			}
+
+			e := Event{
+				LSN:        rec.LSN,
+				Delete:     rec.Type == wal.RecDelete,
+				Collection: rec.Collection,
+				Namespace:  rec.Namespace,
+				Prefix:     rec.Prefix,
+				Key:        rec.Key,
+				Expire:     rec.Expire,
+				Time:       rec.Time,
+			}
+
+			if !e.Delete {
+				e.Value = rec.Value
+			}
+
+			events = append(events, e)
+		}
+
+		if len(events) == 0 {
+			return nil
+		}
+
+		return fn(events)
+	})
+}
+
+// Check if hash matches filter, zero filter matches everything.
+func matchFilter(filter, hash uint64) bool {
+	return filter == 0 || filter == hash
+}
@@ -0,0 +1,102 @@
+// Code generated by bitbox-gen. DO NOT EDIT.
+
+package db
+
+import bit "bytedb/lib/bitbox"
+
+func (v *Event) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(v.LSN)
+	e.Bool(v.Delete)
+	e.Uint64(v.Collection)
+	e.Uint64(v.Namespace)
+	e.Uint64(v.Prefix)
+	e.Bytes(v.Key)
+	e.Bytes(v.Value)
+	e.Uint64(uint64(v.Expire))
+	e.Uint64(uint64(v.Time))
+}
+
+func (v *Event) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.LSN = x
+	}
+
+	{
+		x, err := b.Bool()
+		if err != nil {
+			return err
+		}
+
+		v.Delete = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Collection = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Namespace = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Prefix = x
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Key = append([]byte(nil), x...)
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Value = append([]byte(nil), x...)
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Expire = int64(x)
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Time = int64(x)
+	}
+
+	return nil
+}
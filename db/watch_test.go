@@ -0,0 +1,72 @@
+package db
+
+import (
+	"bytedb/tests"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	db, _ := Open("./testdb")
+	defer os.RemoveAll("./testdb")
+	defer db.Close()
+
+	key := func(prefix uint64, name string, val string) *Key {
+		k := NewKey([]byte(name), []byte(val))
+		k.Collection, k.Namespace, k.Prefix = 1, 2, prefix
+		return k
+	}
+
+	db.Put(key(3, "key_1", "val_1"))
+	db.Put(key(4, "key_2", "val_2"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	events := make(chan Event, 10)
+
+	start := time.Now().UnixNano()
+
+	go func() {
+		done <- db.Watch(ctx, 1, 2, 3, 0, func(list []Event) error {
+			for _, e := range list {
+				events <- e
+			}
+
+			return nil
+		})
+	}()
+
+	db.Put(key(4, "key_3", "val_3"))
+	db.DeleteKey(key(3, "key_1", ""))
+
+	// Existing record is passed first, other prefix is skipped.
+	e := <-events
+	tests.Assert(t, uint64(1), e.LSN)
+	tests.Assert(t, false, e.Delete)
+	tests.AssertEqual(t, []byte("key_1"), e.Key)
+	tests.AssertEqual(t, []byte("val_1"), e.Value)
+
+	e = <-events
+	tests.Assert(t, uint64(4), e.LSN)
+	tests.Assert(t, true, e.Delete)
+	tests.AssertEqual(t, []byte("key_1"), e.Key)
+	tests.AssertEqual(t, []byte(nil), e.Value)
+	tests.Assert(t, true, e.Time >= start)
+
+	cancel()
+	tests.Assert(t, context.Canceled, <-done)
+
+	// Resume after lsn of the last event, zero prefix matches all.
+	stop := errors.New("stop")
+
+	err := db.Watch(context.Background(), 1, 2, 0, 1, func(list []Event) error {
+		tests.Assert(t, 3, len(list))
+		tests.AssertEqual(t, []byte("key_2"), list[0].Key)
+		return stop
+	})
+
+	tests.Assert(t, stop, err)
+}
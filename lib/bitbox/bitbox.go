@@ -1,119 +1,584 @@
 package bitbox
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
 	"reflect"
+	"slices"
 	"unsafe"
 )
 
-// Encode objects
+// Types with methods generated by cmd/bitbox-gen. Encode and Decode
+// use them instead of reflection.
+type Marshaler interface {
+	MarshalBitbox(e *Encoder)
+}
+
+type Unmarshaler interface {
+	UnmarshalBitbox(b *Buffer) error
+}
+
+var (
+	marshalerType   = reflect.TypeFor[Marshaler]()
+	unmarshalerType = reflect.TypeFor[Unmarshaler]()
+)
+
+// Host stores numbers in little-endian order, so slices of them
+// can be copied as they are in memory.
+var littleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// Encode objects. Numbers are little-endian, int, uint and uintptr take
+// 8 bytes and bools 1 byte, regardless of architecture. Strings, slices,
+// arrays and maps are prefixed with uint32 length, structs are encoded
+// field by field, skipping unexported fields, and pointers are prefixed
+// with one byte, 0 for nil and 1 otherwise. Objects themselves can be
+// pointers, nil ones are skipped.
+//
+// Integer struct fields tagged with `bitbox:"varint"` are encoded as
+// varints, zigzag for signed ones, so small values take less bytes, and
+// fields tagged with `bitbox:"-"` are skipped. See Encoder.Compact for
+// varint length prefixes and Marshaler for generated methods.
 func Encode(objects ...any) []byte {
-	buf := []byte{}
+	e := NewEncoder([]byte{})
+	e.Encode(objects...)
 
-	for _, obj := range objects {
-		val := reflect.ValueOf(obj)
-		val = reflect.Indirect(val) // indirect pointers
+	return e.Data()
+}
 
-		if !val.IsValid() {
-			continue // skip nil pointers
+func (e *Encoder) encode(val reflect.Value) {
+	if val.Kind() != reflect.Pointer && reflect.PointerTo(val.Type()).Implements(marshalerType) {
+		if !val.CanAddr() {
+			tmp := reflect.New(val.Type())
+			tmp.Elem().Set(val)
+			val = tmp.Elem()
 		}
 
-		// Encode []byte
-		if IsByteList(val) {
-			l := uint32(len(val.Bytes()))
+		val.Addr().Interface().(Marshaler).MarshalBitbox(e)
+		return
+	}
+
+	// Encode []byte
+	if IsByteList(val) {
+		e.length(val.Len())
 
-			buf = append(buf, BytesPtr(&l)...) // length prefix
-			buf = append(buf, val.Bytes()...)  // bytes
-			continue
+		if val.Kind() == reflect.Array {
+			for i := 0; i < val.Len(); i++ {
+				e.buf = append(e.buf, byte(val.Index(i).Uint()))
+			}
+
+			return
 		}
 
-		// Encode basic types
-		buf = append(buf, bytesPtr(val)...)
-		continue
+		e.buf = append(e.buf, val.Bytes()...)
+		return
 	}
 
-	return buf
+	switch val.Kind() {
+	case reflect.String:
+		e.String(val.String())
+
+	case reflect.Slice, reflect.Array:
+		e.length(val.Len())
+
+		if mem, ok := memory(val); ok {
+			e.buf = append(e.buf, mem...)
+			return
+		}
+
+		for i := 0; i < val.Len(); i++ {
+			e.encode(val.Index(i))
+		}
+
+	case reflect.Map:
+		e.length(val.Len())
+
+		// Sort entries by encoded key, so output doesn't
+		// depend on map iteration order.
+		type entry struct{ key, val []byte }
+		entries := []entry{}
+
+		iter := val.MapRange()
+		for iter.Next() {
+			k, v := &Encoder{Compact: e.Compact}, &Encoder{Compact: e.Compact}
+			k.encode(iter.Key())
+			v.encode(iter.Value())
+
+			entries = append(entries, entry{k.buf, v.buf})
+		}
+
+		slices.SortFunc(entries, func(a, b entry) int { return bytes.Compare(a.key, b.key) })
+
+		for _, entry := range entries {
+			e.buf = append(e.buf, entry.key...)
+			e.buf = append(e.buf, entry.val...)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			field := val.Type().Field(i)
+
+			switch {
+			case skipField(field):
+			case isVarint(field):
+				e.varint(val.Field(i))
+			default:
+				e.encode(val.Field(i))
+			}
+		}
+
+	case reflect.Pointer:
+		if val.IsNil() {
+			e.Uint8(0)
+			return
+		}
+
+		e.Uint8(1)
+		e.encode(val.Elem())
+
+	case reflect.Bool:
+		e.Bool(val.Bool())
+
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		e.buf = appendUint(e.buf, uint64(val.Int()), size(val.Kind()))
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr:
+		e.buf = appendUint(e.buf, val.Uint(), size(val.Kind()))
+
+	case reflect.Float32:
+		e.Uint32(math.Float32bits(float32(val.Float())))
+
+	case reflect.Float64:
+		e.Float64(val.Float())
+
+	case reflect.Complex64:
+		c := val.Complex()
+		e.Uint32(math.Float32bits(float32(real(c))))
+		e.Uint32(math.Float32bits(float32(imag(c))))
+
+	case reflect.Complex128:
+		c := val.Complex()
+		e.Float64(real(c))
+		e.Float64(imag(c))
+
+	default:
+		panic(fmt.Sprintf("bitbox: unsupported type %s", val.Type()))
+	}
 }
 
-// Decode objects
-func Decode(buf *Buffer, objects ...any) {
+// Encode integer field tagged with `bitbox:"varint"`.
+func (e *Encoder) varint(val reflect.Value) {
+	switch val.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		e.Varint(val.Int())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr:
+		e.Uvarint(val.Uint())
+	default:
+		panic(fmt.Sprintf("bitbox: varint tag on %s", val.Type()))
+	}
+}
+
+// Check if struct field is tagged with `bitbox:"varint"`.
+func isVarint(field reflect.StructField) bool {
+	return field.Tag.Get("bitbox") == "varint"
+}
+
+// Check if struct field isn't encoded, it's unexported
+// or tagged with `bitbox:"-"`.
+func skipField(field reflect.StructField) bool {
+	return !field.IsExported() || field.Tag.Get("bitbox") == "-"
+}
+
+// Decode objects, they must be pointers. See Encode for the format.
+// ErrShortBuffer is returned if data ends too early and ErrTooLarge if
+// length is over Buffer.MaxLen, objects can be partially decoded then.
+func Decode(buf *Buffer, objects ...any) error {
 	for _, obj := range objects {
+		var err error
+
 		// 1. Fast Path for basic types
 		switch v := obj.(type) {
 		case *[]byte:
-			l := uint32(0)
-			buf.Decode(&l)
-			*v = append(*v, buf.Take(int(l))...)
-			continue
+			err = decodeBytes(buf, v)
 		case *bool:
-			buf.Copy(BytesPtr(v))
-			continue
+			var b uint8
+			err = decodeUint(buf, &b, 1)
+			*v = b != 0
 		case *int8:
-			buf.Copy(BytesPtr(v))
-			continue
+			err = decodeUint(buf, v, 1)
 		case *int16:
-			buf.Copy(BytesPtr(v))
-			continue
+			err = decodeUint(buf, v, 2)
 		case *int32:
-			buf.Copy(BytesPtr(v))
-			continue
+			err = decodeUint(buf, v, 4)
 		case *int64:
-			buf.Copy(BytesPtr(v))
-			continue
+			err = decodeUint(buf, v, 8)
 		case *uint8:
-			buf.Copy(BytesPtr(v))
-			continue
+			err = decodeUint(buf, v, 1)
 		case *uint16:
-			buf.Copy(BytesPtr(v))
-			continue
+			err = decodeUint(buf, v, 2)
 		case *uint32:
-			buf.Copy(BytesPtr(v))
-			continue
+			err = decodeUint(buf, v, 4)
 		case *uint64:
-			buf.Copy(BytesPtr(v))
-			continue
+			err = decodeUint(buf, v, 8)
 		case *float32:
-			buf.Copy(BytesPtr(v))
-			continue
+			var bits uint32
+			err = decodeUint(buf, &bits, 4)
+			*v = math.Float32frombits(bits)
 		case *float64:
-			buf.Copy(BytesPtr(v))
-			continue
-		case *complex64:
-			buf.Copy(BytesPtr(v))
-			continue
-		case *complex128:
-			buf.Copy(BytesPtr(v))
-			continue
+			var bits uint64
+			err = decodeUint(buf, &bits, 8)
+			*v = math.Float64frombits(bits)
+		case *string:
+			var data []byte
+			data, err = buf.Bytes()
+			*v = string(data)
+		case Unmarshaler:
+			err = v.UnmarshalBitbox(buf)
+		default:
+			// 2. Using reflections
+			val := reflect.ValueOf(obj)
+			if val.Kind() != reflect.Pointer || val.IsNil() {
+				panic(fmt.Sprintf("bitbox: decode target must be non-nil pointer, got %T", obj))
+			}
+
+			err = decode(buf, val.Elem())
 		}
 
-		// 2. Using reflections
-		val := reflect.ValueOf(obj)
-		val = reflect.Indirect(val) // indirect pointers
+		if err != nil {
+			return err
+		}
+	}
 
-		// Decode basic types
-		buf.Copy(bytesPtr(val))
+	return nil
+}
 
-		continue
+func decodeUint[T ~int8 | ~int16 | ~int32 | ~int64 | ~uint8 | ~uint16 | ~uint32 | ~uint64](buf *Buffer, v *T, n int) error {
+	u, err := buf.uint(n)
+	if err != nil {
+		return err
 	}
+
+	*v = T(u)
+	return nil
 }
 
-// Get pointer to fixed type (including structs) and cast it to []byte.
-// When passing structs, make sure it's memory aligned.
-func BytesPtr[T any](obj *T) []byte {
-	size := unsafe.Sizeof(*obj)
-	return unsafe.Slice((*byte)(unsafe.Pointer(obj)), size)
+// Append length prefixed bytes to v.
+func decodeBytes(buf *Buffer, v *[]byte) error {
+	data, err := buf.Bytes()
+	if err != nil {
+		return err
+	}
+
+	*v = append(*v, data...)
+	return nil
+}
+
+func decode(buf *Buffer, val reflect.Value) error {
+	if val.Kind() != reflect.Pointer && val.CanAddr() && val.Addr().Type().Implements(unmarshalerType) {
+		return val.Addr().Interface().(Unmarshaler).UnmarshalBitbox(buf)
+	}
+
+	if IsByteList(val) {
+		data, err := buf.Bytes()
+		if err != nil {
+			return err
+		}
+
+		if val.Kind() == reflect.Array {
+			reflect.Copy(val, reflect.ValueOf(data))
+			return nil
+		}
+
+		val.SetBytes(bytes.Clone(data))
+		return nil
+	}
+
+	switch val.Kind() {
+	case reflect.String:
+		data, err := buf.Bytes()
+		if err != nil {
+			return err
+		}
+
+		val.SetString(string(data))
+
+	case reflect.Slice:
+		l, err := buf.length(minSize(val.Type().Elem()))
+		if err != nil {
+			return err
+		}
+
+		s := reflect.MakeSlice(val.Type(), l, l)
+
+		if mem, ok := memory(s); ok {
+			buf.Copy(mem)
+		} else {
+			for i := 0; i < l; i++ {
+				err = decode(buf, s.Index(i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		val.Set(s)
+
+	case reflect.Array:
+		l, err := buf.length(minSize(val.Type().Elem()))
+		if err != nil {
+			return err
+		}
+
+		// Elements that don't fit are decoded and dropped.
+		for i := 0; i < l; i++ {
+			elem := reflect.New(val.Type().Elem()).Elem()
+			if i < val.Len() {
+				elem = val.Index(i)
+			}
+
+			err = decode(buf, elem)
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		l, err := buf.length(minSize(val.Type().Key()) + minSize(val.Type().Elem()))
+		if err != nil {
+			return err
+		}
+
+		m := reflect.MakeMapWithSize(val.Type(), l)
+
+		for i := 0; i < l; i++ {
+			k := reflect.New(val.Type().Key()).Elem()
+			v := reflect.New(val.Type().Elem()).Elem()
+
+			err = decode(buf, k)
+			if err == nil {
+				err = decode(buf, v)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			m.SetMapIndex(k, v)
+		}
+
+		val.Set(m)
+
+	case reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			field := val.Type().Field(i)
+
+			var err error
+
+			switch {
+			case skipField(field):
+			case isVarint(field):
+				err = decodeVarint(buf, val.Field(i))
+			default:
+				err = decode(buf, val.Field(i))
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Pointer:
+		marker, err := buf.uint(1)
+		if err != nil {
+			return err
+		}
+
+		if marker == 0 {
+			val.Set(reflect.Zero(val.Type()))
+			return nil
+		}
+
+		p := reflect.New(val.Type().Elem())
+
+		err = decode(buf, p.Elem())
+		if err != nil {
+			return err
+		}
+
+		val.Set(p)
+
+	case reflect.Bool:
+		u, err := buf.uint(1)
+		if err != nil {
+			return err
+		}
+
+		val.SetBool(u != 0)
+
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		n := size(val.Kind())
+
+		u, err := buf.uint(n)
+		if err != nil {
+			return err
+		}
+
+		// Sign extend
+		shift := 64 - 8*n
+		val.SetInt(int64(u<<shift) >> shift)
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr:
+		u, err := buf.uint(size(val.Kind()))
+		if err != nil {
+			return err
+		}
+
+		val.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := buf.float(int(val.Type().Size()))
+		if err != nil {
+			return err
+		}
+
+		val.SetFloat(f)
+
+	case reflect.Complex64, reflect.Complex128:
+		n := int(val.Type().Size()) / 2
+
+		re, err := buf.float(n)
+		if err != nil {
+			return err
+		}
+
+		im, err := buf.float(n)
+		if err != nil {
+			return err
+		}
+
+		val.SetComplex(complex(re, im))
+
+	default:
+		panic(fmt.Sprintf("bitbox: unsupported type %s", val.Type()))
+	}
+
+	return nil
 }
 
-// Get pointer from reflect.Value and cast it to []byte.
-// Value must be addressable.
-func bytesPtr(val reflect.Value) []byte {
-	if !val.CanAddr() {
-		panic("value is not addressable")
+// Decode integer field tagged with `bitbox:"varint"`. Values
+// that don't fit the field are rejected with ErrOverflow.
+func decodeVarint(buf *Buffer, val reflect.Value) error {
+	switch val.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		v, err := buf.Varint()
+		if err != nil {
+			return err
+		}
+
+		if val.OverflowInt(v) {
+			return ErrOverflow
+		}
+
+		val.SetInt(v)
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr:
+		v, err := buf.Uvarint()
+		if err != nil {
+			return err
+		}
+
+		if val.OverflowUint(v) {
+			return ErrOverflow
+		}
+
+		val.SetUint(v)
+
+	default:
+		panic(fmt.Sprintf("bitbox: varint tag on %s", val.Type()))
 	}
 
-	ptr := unsafe.Pointer(val.UnsafeAddr())
-	size := val.Type().Size()
+	return nil
+}
+
+// Return min number of bytes value of type is encoded in.
+// Length prefixes and varints take at least 1 byte.
+func minSize(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String, reflect.Map:
+		return 1 // length prefix
+	case reflect.Pointer, reflect.Bool:
+		return 1
+	case reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return int(t.Size())
+	case reflect.Struct:
+		n := 0
 
-	return unsafe.Slice((*byte)(ptr), size)
+		for i := 0; i < t.NumField(); i++ {
+			switch field := t.Field(i); {
+			case skipField(field):
+			case isVarint(field):
+				n++
+			default:
+				n += minSize(field.Type)
+			}
+		}
+
+		return n
+	}
+
+	return size(t.Kind())
+}
+
+// Return encoded size of number kind.
+func size(kind reflect.Kind) int {
+	switch kind {
+	case reflect.Int8, reflect.Uint8:
+		return 1
+	case reflect.Int16, reflect.Uint16:
+		return 2
+	case reflect.Int32, reflect.Uint32:
+		return 4
+	}
+
+	return 8
+}
+
+// Append n low bytes of v in little-endian order.
+func appendUint(buf []byte, v uint64, n int) []byte {
+	for i := 0; i < n; i++ {
+		buf = append(buf, byte(v>>(8*i)))
+	}
+
+	return buf
+}
+
+// Return memory of slice or addressable array, if its elements are
+// stored exactly as they are encoded. It's true only on little-endian
+// hosts, for numbers with fixed size.
+func memory(val reflect.Value) ([]byte, bool) {
+	if !littleEndian || (val.Kind() == reflect.Array && !val.CanAddr()) || val.Len() == 0 {
+		return nil, false
+	}
+
+	switch val.Type().Elem().Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+	default:
+		return nil, false
+	}
+
+	ptr := unsafe.Pointer(val.Index(0).UnsafeAddr())
+	return unsafe.Slice((*byte)(ptr), uintptr(val.Len())*val.Type().Elem().Size()), true
+}
+
+// Get pointer to fixed type (including structs) and cast it to []byte.
+// When passing structs, make sure it's memory aligned. Memory layout
+// depends on architecture, use Encode for data shared between hosts.
+func BytesPtr[T any](obj *T) []byte {
+	size := unsafe.Sizeof(*obj)
+	return unsafe.Slice((*byte)(unsafe.Pointer(obj)), size)
 }
 
 // Check if we deal with byte slice/array
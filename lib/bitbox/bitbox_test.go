@@ -2,6 +2,7 @@ package bitbox
 
 import (
 	"bytedb/tests"
+	"errors"
 	"testing"
 )
 
@@ -10,7 +11,7 @@ func TestDecodeUin64(t *testing.T) {
 	u2 := uint64(0)
 
 	buf := Encode(&u1)
-	Decode(buf, &u2)
+	Decode(NewBuffer(buf), &u2)
 
 	tests.Assert(t, u1, u2)
 }
@@ -20,7 +21,7 @@ func TestDecodeFloat64(t *testing.T) {
 	f2 := float32(0)
 
 	buf := Encode(&f1)
-	Decode(buf, &f2)
+	Decode(NewBuffer(buf), &f2)
 
 	tests.Assert(t, f1, f2)
 }
@@ -30,7 +31,169 @@ func TestDecodeByteSlice(t *testing.T) {
 	b2 := []byte{}
 
 	buf := Encode(&b1)
-	Decode(buf, &b2)
+	Decode(NewBuffer(buf), &b2)
 
 	tests.AssertEqual(t, b1, b2)
 }
+
+func TestDecodeString(t *testing.T) {
+	s1 := "hello"
+	s2 := ""
+
+	Decode(NewBuffer(Encode(&s1)), &s2)
+	tests.Assert(t, s1, s2)
+}
+
+func TestDecodeSlices(t *testing.T) {
+	l1 := [][]uint64{{1, 2}, nil, {3}}
+	l2 := [][]uint64{}
+
+	a1 := [3]string{"a", "b", "c"}
+	a2 := [3]string{}
+
+	Decode(NewBuffer(Encode(&l1, &a1)), &l2, &a2)
+
+	tests.AssertEqual(t, [][]uint64{{1, 2}, {}, {3}}, l2)
+	tests.Assert(t, a1, a2)
+}
+
+func TestDecodeMap(t *testing.T) {
+	m1 := map[string][]int32{"a": {1}, "b": {2, 3}, "c": nil}
+	m2 := map[string][]int32{}
+
+	// Output doesn't depend on iteration order
+	data := Encode(&m1)
+	for i := 0; i < 10; i++ {
+		tests.AssertEqual(t, data, Encode(&m1))
+	}
+
+	Decode(NewBuffer(data), &m2)
+	tests.AssertEqual(t, map[string][]int32{"a": {1}, "b": {2, 3}, "c": {}}, m2)
+}
+
+type inner struct {
+	Name string
+	Tags map[uint8]bool
+}
+
+type outer struct {
+	ID      uint64
+	Inner   inner
+	Items   []inner
+	Next    *outer
+	Missing *inner
+	Data    []byte
+	Fixed   [2]byte
+
+	hidden int // unexported fields are skipped
+}
+
+func TestDecodeStruct(t *testing.T) {
+	o1 := outer{
+		ID:     1,
+		Inner:  inner{Name: "a", Tags: map[uint8]bool{1: true}},
+		Items:  []inner{{Name: "b"}, {Name: "c"}},
+		Next:   &outer{ID: 2, Data: []byte{}, Items: []inner{}},
+		Data:   []byte{1, 2},
+		Fixed:  [2]byte{3, 4},
+		hidden: 5,
+	}
+
+	o2 := outer{}
+	Decode(NewBuffer(Encode(&o1)), &o2)
+
+	o1.hidden = 0
+	o1.Items[0].Tags = map[uint8]bool{}
+	o1.Items[1].Tags = map[uint8]bool{}
+	o1.Next.Inner.Tags = map[uint8]bool{}
+
+	tests.AssertEqual(t, o1, o2)
+	tests.Assert(t, (*inner)(nil), o2.Missing)
+}
+
+func TestDecodePointer(t *testing.T) {
+	v := uint32(7)
+	p1, p2 := &v, (*uint32)(nil)
+
+	var n1, n2 *uint32 = nil, &v
+
+	Decode(NewBuffer(Encode(&p1, &n1)), &p2, &n2)
+
+	tests.Assert(t, uint32(7), *p2)
+	tests.Assert(t, (*uint32)(nil), n2)
+}
+
+func TestEncodeLittleEndian(t *testing.T) {
+	u := uint32(0x01020304)
+	i := int16(-2)
+	n := 1
+	b := true
+	f := float32(1)
+	s := []uint16{0x0102}
+
+	expected := []byte{
+		4, 3, 2, 1,
+		0xfe, 0xff,
+		1, 0, 0, 0, 0, 0, 0, 0,
+		1,
+		0, 0, 0x80, 0x3f,
+		1, 0, 0, 0, 2, 1,
+	}
+
+	data := Encode(&u, &i, &n, &b, &f, &s)
+	tests.AssertEqual(t, expected, data)
+
+	u2, i2, n2, b2, f2, s2 := uint32(0), int16(0), 0, false, float32(0), []uint16{}
+	Decode(NewBuffer(data), &u2, &i2, &n2, &b2, &f2, &s2)
+
+	tests.Assert(t, u, u2)
+	tests.Assert(t, i, i2)
+	tests.Assert(t, n, n2)
+	tests.Assert(t, b, b2)
+	tests.Assert(t, f, f2)
+	tests.AssertEqual(t, s, s2)
+}
+
+func TestDecodeShortBuffer(t *testing.T) {
+	data := Encode(uint32(7), []byte("hello"))
+
+	u, b := uint32(0), []byte{}
+	err := Decode(NewBuffer(data[:2]), &u)
+	tests.Assert(t, true, errors.Is(err, ErrShortBuffer))
+
+	err = Decode(NewBuffer(data[:len(data)-1]), &u, &b)
+	tests.Assert(t, true, errors.Is(err, ErrShortBuffer))
+
+	// Length prefix of hostile input is checked before allocating
+	hostile := []byte{0xff, 0xff, 0xff, 0x7f, 1, 2, 3}
+
+	s := []uint64{}
+	err = Decode(NewBuffer(hostile), &s)
+	tests.Assert(t, true, errors.Is(err, ErrShortBuffer))
+
+	m := map[string]string{}
+	err = Decode(NewBuffer(hostile), &m)
+	tests.Assert(t, true, errors.Is(err, ErrShortBuffer))
+
+	str := ""
+	err = Decode(NewBuffer(hostile), &str)
+	tests.Assert(t, true, errors.Is(err, ErrShortBuffer))
+}
+
+func TestDecodeMaxLen(t *testing.T) {
+	data := Encode([]byte("hello"), "world")
+
+	buf := NewBuffer(data)
+	buf.MaxLen = 5
+
+	b, s := []byte{}, ""
+	tests.Assert(t, nil, buf.Decode(&b, &s))
+	tests.AssertEqual(t, []byte("hello"), b)
+	tests.Assert(t, "world", s)
+
+	buf = NewBuffer(data)
+	buf.MaxLen = 4
+
+	err := buf.Decode(&b)
+	tests.Assert(t, true, errors.Is(err, ErrTooLarge))
+}
@@ -1,9 +1,30 @@
 package bitbox
 
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrShortBuffer = errors.New("bitbox: buffer is too short")
+	ErrTooLarge    = errors.New("bitbox: length is too large")
+	ErrOverflow    = errors.New("bitbox: varint overflows 64 bits")
+)
+
 // Simple bytes buffer that tracks it's offset
 type Buffer struct {
 	data []byte
 	off  int
+
+	// Max length of decoded strings, slices and maps, 0 means no limit.
+	// Lengths are always checked against remaining bytes, so hostile
+	// input can't allocate more than its own size.
+	MaxLen int
+
+	// Read lengths as varints, see Encoder.Compact.
+	Compact bool
 }
 
 // Create new Buffer
@@ -12,8 +33,8 @@ func NewBuffer(data []byte) *Buffer {
 }
 
 // Decode data from buffer into objects
-func (b *Buffer) Decode(objects ...any) {
-	Decode(b, objects...)
+func (b *Buffer) Decode(objects ...any) error {
+	return Decode(b, objects...)
 }
 
 // Return buffer length
@@ -29,13 +50,153 @@ func (b *Buffer) Copy(dst []byte) int {
 	return n
 }
 
+// Read n byte little-endian number.
+func (b *Buffer) uint(n int) (uint64, error) {
+	if b.Len() < n {
+		return 0, ErrShortBuffer
+	}
+
+	tmp := [8]byte{}
+	b.Copy(tmp[:n])
+
+	return binary.LittleEndian.Uint64(tmp[:]), nil
+}
+
+// Read 4 or 8 byte float.
+func (b *Buffer) float(n int) (float64, error) {
+	u, err := b.uint(n)
+	if n == 4 {
+		return float64(math.Float32frombits(uint32(u))), err
+	}
+
+	return math.Float64frombits(u), err
+}
+
+// Read length prefix of elements taking at least size bytes each.
+// Elements encoded in zero bytes are counted as one, so they can't be
+// used to make decoding loop for long.
+func (b *Buffer) length(size int) (int, error) {
+	size = max(size, 1)
+
+	var l uint64
+	var err error
+
+	if b.Compact {
+		l, err = b.Uvarint()
+	} else {
+		l, err = b.uint(4)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if b.MaxLen > 0 && l > uint64(b.MaxLen) {
+		return 0, fmt.Errorf("%w: %d, max %d", ErrTooLarge, l, b.MaxLen)
+	}
+
+	if l*uint64(size) > uint64(b.Len()) {
+		return 0, fmt.Errorf("%w: length %d, %d bytes left", ErrShortBuffer, l, b.Len())
+	}
+
+	return int(l), nil
+}
+
 // Take next N bytes from buffer.
 // This will advance offset.
-func (b *Buffer) Take(num int) []byte {
+func (b *Buffer) Take(num int) ([]byte, error) {
+	if num < 0 || num > b.Len() {
+		return nil, ErrShortBuffer
+	}
+
 	off := b.off
 	b.off += num
 
-	return b.data[off:b.off]
+	return b.data[off:b.off], nil
+}
+
+// Typed reads below don't use reflection and don't allocate.
+
+func (b *Buffer) Uint8() (uint8, error) {
+	u, err := b.uint(1)
+	return uint8(u), err
+}
+
+func (b *Buffer) Uint16() (uint16, error) {
+	u, err := b.uint(2)
+	return uint16(u), err
+}
+
+func (b *Buffer) Uint32() (uint32, error) {
+	u, err := b.uint(4)
+	return uint32(u), err
+}
+
+func (b *Buffer) Uint64() (uint64, error) {
+	return b.uint(8)
+}
+
+func (b *Buffer) Int64() (int64, error) {
+	u, err := b.uint(8)
+	return int64(u), err
+}
+
+func (b *Buffer) Float32() (float32, error) {
+	f, err := b.float(4)
+	return float32(f), err
+}
+
+func (b *Buffer) Float64() (float64, error) {
+	return b.float(8)
+}
+
+// Read unsigned varint.
+func (b *Buffer) Uvarint() (uint64, error) {
+	v, n := binary.Uvarint(b.Data())
+	return v, b.varint(n)
+}
+
+// Read signed, zigzag encoded varint.
+func (b *Buffer) Varint() (int64, error) {
+	v, n := binary.Varint(b.Data())
+	return v, b.varint(n)
+}
+
+// Advance offset by size of varint read, n is result of binary.Uvarint.
+func (b *Buffer) varint(n int) error {
+	if n == 0 {
+		return ErrShortBuffer
+	}
+
+	if n < 0 {
+		return ErrOverflow
+	}
+
+	b.off += n
+	return nil
+}
+
+func (b *Buffer) Bool() (bool, error) {
+	u, err := b.uint(1)
+	return u != 0, err
+}
+
+// Read length prefixed bytes. Returned slice points into buffer data,
+// it isn't copied, so it must not be changed or used after data is.
+func (b *Buffer) Bytes() ([]byte, error) {
+	l, err := b.length(1)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Take(l)
+}
+
+// Start reading data from the beginning, so buffer can be reused.
+// MaxLen and Compact are kept.
+func (b *Buffer) Reset(data []byte) {
+	b.data = data
+	b.off = 0
 }
 
 // Return remaining bytes from buffer
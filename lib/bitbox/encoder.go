@@ -0,0 +1,170 @@
+package bitbox
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Encoders bigger than this aren't put back to pool,
+// so a single big message doesn't stay in memory.
+const maxPooledSize = 64 * 1024
+
+var encoders = sync.Pool{
+	New: func() any { return &Encoder{buf: make([]byte, 0, 512)} },
+}
+
+// Encoder appends encoded values to its buffer, in the same format as
+// Encode. Buffer is reused after Reset, so once it has grown, encoding
+// doesn't allocate. Typed methods don't use reflection.
+type Encoder struct {
+	buf []byte
+
+	// Write lengths as varints instead of uint32, so short strings
+	// and slices have 1 byte prefix. Data must be decoded by
+	// Buffer with Compact set.
+	Compact bool
+}
+
+// Create encoder appending to buf.
+func NewEncoder(buf []byte) *Encoder {
+	return &Encoder{buf: buf}
+}
+
+// Get empty encoder from pool. It should be returned with PutEncoder
+// when its data is no longer used.
+func GetEncoder() *Encoder {
+	e := encoders.Get().(*Encoder)
+	e.Reset()
+
+	return e
+}
+
+// Return encoder to pool.
+func PutEncoder(e *Encoder) {
+	if cap(e.buf) > maxPooledSize {
+		return
+	}
+
+	encoders.Put(e)
+}
+
+// Return encoded data. It's valid until encoder is changed.
+func (e *Encoder) Data() []byte {
+	return e.buf
+}
+
+// Return length of encoded data.
+func (e *Encoder) Len() int {
+	return len(e.buf)
+}
+
+// Drop encoded data, keeping the buffer. Compact is turned off.
+func (e *Encoder) Reset() {
+	e.buf = e.buf[:0]
+	e.Compact = false
+}
+
+func (e *Encoder) Uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *Encoder) Uint16(v uint16) {
+	e.buf = binary.LittleEndian.AppendUint16(e.buf, v)
+}
+
+func (e *Encoder) Uint32(v uint32) {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *Encoder) Uint64(v uint64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+func (e *Encoder) Int64(v int64) {
+	e.Uint64(uint64(v))
+}
+
+func (e *Encoder) Float32(v float32) {
+	e.Uint32(math.Float32bits(v))
+}
+
+func (e *Encoder) Float64(v float64) {
+	e.Uint64(math.Float64bits(v))
+}
+
+// Append unsigned varint, 1 byte for values below 128.
+func (e *Encoder) Uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+// Append signed varint, zigzag encoded, so small negative
+// values are short too.
+func (e *Encoder) Varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Uint8(1)
+	} else {
+		e.Uint8(0)
+	}
+}
+
+// Append length prefixed bytes.
+func (e *Encoder) Bytes(v []byte) {
+	e.length(len(v))
+	e.buf = append(e.buf, v...)
+}
+
+// Append length prefixed string.
+func (e *Encoder) String(v string) {
+	e.length(len(v))
+	e.buf = append(e.buf, v...)
+}
+
+// Append length prefix.
+func (e *Encoder) length(n int) {
+	if e.Compact {
+		e.Uvarint(uint64(n))
+	} else {
+		e.Uint32(uint32(n))
+	}
+}
+
+// Encode objects using reflection, see Encode.
+func (e *Encoder) Encode(objects ...any) {
+	for _, obj := range objects {
+		val := reflect.ValueOf(obj)
+
+		if m, ok := obj.(Marshaler); ok && (val.Kind() != reflect.Pointer || !val.IsNil()) {
+			m.MarshalBitbox(e)
+			continue
+		}
+
+		val = reflect.Indirect(val)
+
+		if !val.IsValid() {
+			continue // skip nil pointers
+		}
+
+		e.encode(val)
+	}
+}
+
+// Reserve space for uint32 length prefix and return its offset.
+// It's set by Patch, once data after it is encoded. Reserved prefix
+// is always uint32, even in compact mode.
+func (e *Encoder) Reserve() int {
+	off := len(e.buf)
+	e.buf = append(e.buf, 0, 0, 0, 0)
+
+	return off
+}
+
+// Set length prefix reserved at off to length of data encoded after it.
+func (e *Encoder) Patch(off int) {
+	binary.LittleEndian.PutUint32(e.buf[off:], uint32(len(e.buf)-off-4))
+}
@@ -0,0 +1,302 @@
+package bitbox
+
+import (
+	"bytedb/tests"
+	"errors"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	type item struct {
+		Name string
+		Tags []string
+	}
+
+	it := item{Name: "john", Tags: []string{"a", "b"}}
+
+	e := NewEncoder(nil)
+	e.Uint8(1)
+	e.Uint16(2)
+	e.Uint32(3)
+	e.Uint64(4)
+	e.Int64(-5)
+	e.Float64(1.5)
+	e.Bool(true)
+	e.Bytes([]byte("key"))
+	e.String("value")
+	e.Encode(&it)
+
+	// Typed methods match reflection
+	expected := Encode(uint8(1), uint16(2), uint32(3), uint64(4), int64(-5), 1.5, true, []byte("key"), "value", &it)
+	tests.AssertEqual(t, expected, e.Data())
+
+	buf := NewBuffer(e.Data())
+
+	u8, _ := buf.Uint8()
+	u16, _ := buf.Uint16()
+	u32, _ := buf.Uint32()
+	u64, _ := buf.Uint64()
+	i64, _ := buf.Int64()
+	f64, _ := buf.Float64()
+	b, _ := buf.Bool()
+	key, _ := buf.Bytes()
+	val, _ := buf.Bytes()
+
+	tests.Assert(t, uint8(1), u8)
+	tests.Assert(t, uint16(2), u16)
+	tests.Assert(t, uint32(3), u32)
+	tests.Assert(t, uint64(4), u64)
+	tests.Assert(t, int64(-5), i64)
+	tests.Assert(t, 1.5, f64)
+	tests.Assert(t, true, b)
+	tests.AssertEqual(t, []byte("key"), key)
+	tests.Assert(t, "value", string(val))
+
+	it2 := item{}
+	tests.Assert(t, nil, buf.Decode(&it2))
+	tests.AssertEqual(t, it, it2)
+
+	// Bytes aren't copied
+	tests.Assert(t, &e.Data()[len(expected)-len(Encode(&it))-5], &val[0])
+}
+
+func TestEncoderPatch(t *testing.T) {
+	e := NewEncoder(nil)
+
+	off := e.Reserve()
+	e.String("hello")
+	e.Patch(off)
+
+	tests.AssertEqual(t, Encode(Encode("hello")), e.Data())
+
+	e.Reset()
+	tests.Assert(t, 0, e.Len())
+}
+
+func TestEncoderAllocs(t *testing.T) {
+	key := []byte("key_1")
+	data := make([]byte, 100)
+
+	e := GetEncoder()
+	defer PutEncoder(e)
+
+	buf := NewBuffer(nil)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		e.Reset()
+
+		off := e.Reserve()
+		e.Uint8(1)
+		e.Uint64(2)
+		e.Bytes(key)
+		e.Bytes(data)
+		e.Patch(off)
+
+		buf.Reset(e.Data())
+		buf.Uint32()
+		buf.Uint8()
+		buf.Uint64()
+		buf.Bytes()
+		buf.Bytes()
+	})
+
+	tests.Assert(t, 0.0, allocs)
+}
+
+func BenchmarkEncode(b *testing.B) {
+	key, data := []byte("key_1"), make([]byte, 100)
+	typ, coll := uint8(1), uint64(2)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		Encode(Encode(&typ, &coll, &key, &data))
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	key, data := []byte("key_1"), make([]byte, 100)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		e := GetEncoder()
+
+		off := e.Reserve()
+		e.Uint8(1)
+		e.Uint64(2)
+		e.Bytes(key)
+		e.Bytes(data)
+		e.Patch(off)
+
+		PutEncoder(e)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	data := Encode(uint8(1), uint64(2), []byte("key_1"), make([]byte, 100))
+	typ, coll := uint8(0), uint64(0)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		key, val := []byte{}, []byte{}
+		NewBuffer(data).Decode(&typ, &coll, &key, &val)
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	data := Encode(uint8(1), uint64(2), []byte("key_1"), make([]byte, 100))
+	buf := NewBuffer(nil)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset(data)
+
+		buf.Uint8()
+		buf.Uint64()
+		buf.Bytes()
+		buf.Bytes()
+	}
+}
+
+func TestVarint(t *testing.T) {
+	e := NewEncoder(nil)
+	e.Uvarint(1)
+	e.Uvarint(300)
+	e.Varint(-1)
+	e.Varint(-300)
+
+	tests.AssertEqual(t, []byte{1, 0xac, 0x02, 1, 0xd7, 0x04}, e.Data())
+
+	buf := NewBuffer(e.Data())
+
+	u1, _ := buf.Uvarint()
+	u2, _ := buf.Uvarint()
+	i1, _ := buf.Varint()
+	i2, _ := buf.Varint()
+
+	tests.Assert(t, uint64(1), u1)
+	tests.Assert(t, uint64(300), u2)
+	tests.Assert(t, int64(-1), i1)
+	tests.Assert(t, int64(-300), i2)
+
+	_, err := buf.Uvarint()
+	tests.Assert(t, ErrShortBuffer, err)
+
+	_, err = NewBuffer([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}).Uvarint()
+	tests.Assert(t, ErrOverflow, err)
+}
+
+func TestVarintTag(t *testing.T) {
+	type item struct {
+		ID    uint64 `bitbox:"varint"`
+		Delta int32  `bitbox:"varint"`
+		Size  uint16
+	}
+
+	it := item{ID: 5, Delta: -2, Size: 7}
+
+	data := Encode(&it)
+	tests.AssertEqual(t, []byte{5, 3, 7, 0}, data)
+
+	it2 := item{}
+	tests.Assert(t, nil, Decode(NewBuffer(data), &it2))
+	tests.Assert(t, it, it2)
+
+	// Value doesn't fit the field
+	type small struct {
+		N uint8 `bitbox:"varint"`
+	}
+
+	err := Decode(NewBuffer(Encode(&item{ID: 300})), &small{})
+	tests.Assert(t, ErrOverflow, err)
+}
+
+func TestCompact(t *testing.T) {
+	type item struct {
+		Name string
+		Tags map[string][]byte
+	}
+
+	it := item{Name: "john", Tags: map[string][]byte{"a": []byte("b")}}
+
+	e := NewEncoder(nil)
+	e.Compact = true
+	e.Bytes([]byte("key"))
+	e.Encode(&it)
+
+	tests.AssertEqual(t, []byte{3, 'k', 'e', 'y', 4, 'j', 'o', 'h', 'n', 1, 1, 'a', 1, 'b'}, e.Data())
+
+	buf := NewBuffer(e.Data())
+	buf.Compact = true
+
+	key, it2 := []byte{}, item{}
+	tests.Assert(t, nil, buf.Decode(&key, &it2))
+	tests.AssertEqual(t, []byte("key"), key)
+	tests.AssertEqual(t, it, it2)
+
+	// Hostile length is checked in compact mode too
+	buf = NewBuffer([]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 1})
+	buf.Compact = true
+
+	err := buf.Decode(&key)
+	tests.Assert(t, true, errors.Is(err, ErrShortBuffer))
+}
+
+// Point encoded in reverse order, so it's different from reflection.
+type point struct {
+	X, Y uint16
+}
+
+func (p *point) MarshalBitbox(e *Encoder) {
+	e.Uint16(p.Y)
+	e.Uint16(p.X)
+}
+
+func (p *point) UnmarshalBitbox(b *Buffer) error {
+	var err error
+
+	if p.Y, err = b.Uint16(); err == nil {
+		p.X, err = b.Uint16()
+	}
+
+	return err
+}
+
+func TestMarshaler(t *testing.T) {
+	type shape struct {
+		Center point
+		Points []point
+		Names  map[string]point
+		Skip   int `bitbox:"-"`
+	}
+
+	s := shape{
+		Center: point{1, 2},
+		Points: []point{{3, 4}},
+		Names:  map[string]point{"a": {5, 6}},
+		Skip:   7,
+	}
+
+	p := point{1, 2}
+	tests.AssertEqual(t, []byte{2, 0, 1, 0}, Encode(&p))
+
+	data := Encode(&s)
+	tests.AssertEqual(t, []byte{
+		2, 0, 1, 0,
+		1, 0, 0, 0, 4, 0, 3, 0,
+		1, 0, 0, 0, 1, 0, 0, 0, 'a', 6, 0, 5, 0,
+	}, data)
+
+	s2 := shape{}
+	tests.Assert(t, nil, Decode(NewBuffer(data), &s2))
+
+	s.Skip = 0
+	tests.AssertEqual(t, s, s2)
+
+	p2 := point{}
+	tests.Assert(t, nil, Decode(NewBuffer(Encode(&p)), &p2))
+	tests.Assert(t, p, p2)
+}
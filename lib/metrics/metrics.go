@@ -0,0 +1,314 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Registry used by all bytedb packages.
+var Default = NewRegistry()
+
+// Upper bounds of latency buckets, in seconds.
+var LatencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005,
+	0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Set of metrics written in Prometheus text format.
+// Metrics with the same name form a family, each one
+// of them has different labels.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	metrics map[string]metric // by labels
+}
+
+type metric interface {
+	write(w io.Writer, name, labels string)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Return counter with the given name and labels, creating it if it
+// doesn't exist. Labels are name and value pairs.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return r.get(name, help, "counter", labels, func() metric { return &Counter{} }).(*Counter)
+}
+
+// Return gauge with the given name and labels, creating it if it doesn't exist.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return r.get(name, help, "gauge", labels, func() metric { return &Gauge{} }).(*Gauge)
+}
+
+// Register gauge whose value is returned by fn when metrics are
+// written. Gauge registered before with the same labels is replaced.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64, labels ...string) {
+	r.get(name, help, "gauge", labels, func() metric { return gaugeFunc(fn) })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.families[name].metrics[formatLabels(labels)] = gaugeFunc(fn)
+}
+
+// Return histogram with the given name, labels and bucket upper bounds,
+// creating it if it doesn't exist.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return r.get(name, help, "histogram", labels, func() metric { return newHistogram(buckets) }).(*Histogram)
+}
+
+func (r *Registry) get(name, help, typ string, labels []string, create func() metric) metric {
+	if len(labels)%2 != 0 {
+		panic("metrics: labels must be name and value pairs: " + name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ, metrics: map[string]metric{}}
+		r.families[name] = f
+	}
+
+	if f.typ != typ {
+		panic(fmt.Sprintf("metrics: %s is %s, not %s", name, f.typ, typ))
+	}
+
+	key := formatLabels(labels)
+
+	m, ok := f.metrics[key]
+	if !ok {
+		m = create()
+		f.metrics[key] = m
+	}
+
+	return m
+}
+
+// Write all metrics in Prometheus text format, sorted by name and labels.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+
+	names := []string{}
+	for name := range r.families {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	// Copy families, so gauge functions are called without lock.
+	families := []family{}
+
+	for _, name := range names {
+		f := *r.families[name]
+		f.metrics = map[string]metric{}
+
+		for labels, m := range r.families[name].metrics {
+			f.metrics[labels] = m
+		}
+
+		families = append(families, f)
+	}
+
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escape(f.help, false))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+
+		keys := []string{}
+		for labels := range f.metrics {
+			keys = append(keys, labels)
+		}
+
+		slices.Sort(keys)
+
+		for _, labels := range keys {
+			f.metrics[labels].write(bw, f.name, labels)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Serve metrics over HTTP.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Counter only goes up.
+type Counter struct {
+	val atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.val.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.val.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.val.Load()
+}
+
+func (c *Counter) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %d\n", name, wrap(labels), c.Value())
+}
+
+// Gauge can go up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, wrap(labels), formatFloat(g.Value()))
+}
+
+type gaugeFunc func() float64
+
+func (g gaugeFunc) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, wrap(labels), formatFloat(g()))
+}
+
+// Histogram counts observed values in buckets.
+type Histogram struct {
+	bounds []float64
+	counts []atomic.Uint64 // per bucket, the last one is +Inf
+	count  atomic.Uint64
+	sum    atomic.Uint64 // float bits
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	bounds = slices.Clone(bounds)
+	slices.Sort(bounds)
+
+	return &Histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	addFloat(&h.sum, v)
+}
+
+// Observe seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Return number of observed values.
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+
+	total := uint64(0)
+
+	for i := range h.counts {
+		total += h.counts[i].Load()
+
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, prefix, le, total)
+	}
+
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, wrap(labels), formatFloat(math.Float64frombits(h.sum.Load())))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, wrap(labels), total)
+}
+
+// Add v to float stored as bits.
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Format label pairs as `name="value",...`, without braces.
+func formatLabels(labels []string) string {
+	parts := []string{}
+
+	for i := 0; i < len(labels); i += 2 {
+		parts = append(parts, labels[i]+`="`+escape(labels[i+1], true)+`"`)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func wrap(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+// Escape backslashes and new lines, and quotes in label values.
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+
+	return s
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"bytedb/tests"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+
+	r.Counter("requests_total", "Number of requests.", "cmd", "get").Add(2)
+	r.Counter("requests_total", "Number of requests.", "cmd", "get").Inc()
+	r.Counter("requests_total", "Number of requests.", "cmd", `a"b`).Inc()
+	r.Gauge("queue", "Queue depth.").Set(1.5)
+	r.GaugeFunc("size", "Size.", func() float64 { return 10 })
+
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	b := &strings.Builder{}
+	tests.Assert(t, nil, r.Write(b))
+
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.6
+latency_seconds_count 3
+# HELP queue Queue depth.
+# TYPE queue gauge
+queue 1.5
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{cmd="a\"b"} 1
+requests_total{cmd="get"} 3
+# HELP size Size.
+# TYPE size gauge
+size 10
+`
+
+	tests.Assert(t, expected, b.String())
+}
+
+func TestTypeMismatch(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("metric", "")
+
+	defer func() {
+		tests.AssertNot(t, nil, recover())
+	}()
+
+	r.Gauge("metric", "")
+}
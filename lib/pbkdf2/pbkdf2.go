@@ -0,0 +1,42 @@
+package pbkdf2
+
+import (
+	"crypto/hmac"
+	"hash"
+)
+
+// Derive key from password and salt as defined in RFC 8018.
+func Key(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+
+	blocks := (keyLen + size - 1) / size
+	key := make([]byte, 0, blocks*size)
+
+	u := make([]byte, size)
+	t := make([]byte, size)
+
+	for block := 1; block <= blocks; block++ {
+		// U1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		copy(t, u)
+
+		// Un = PRF(password, Un-1), T = U1 ^ U2 ^ ... ^ Un
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
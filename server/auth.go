@@ -0,0 +1,522 @@
+package server
+
+import (
+	"bytedb/db"
+	bit "bytedb/lib/bitbox"
+	"bytedb/lib/pbkdf2"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Permission levels, each one includes the lower ones.
+const (
+	PermNone  uint8 = 0
+	PermRead  uint8 = 1
+	PermWrite uint8 = 2
+	PermAdmin uint8 = 3
+)
+
+// Matches any collection or namespace in rules.
+const Any uint64 = 0
+
+// Password hashing parameters
+const (
+	KDFIterations = 100_000
+	KDFKeySize    = 32
+	SaltSize      = 16
+	TokenSize     = 32
+)
+
+var (
+	ErrAuthRequired = errors.New("authentication required")
+	ErrAuthFailed   = errors.New("invalid credentials")
+	ErrForbidden    = errors.New("permission denied")
+	ErrNoUser       = errors.New("user doesn't exist")
+)
+
+// Salt used to hash passwords of users that don't exist.
+var dummySalt = make([]byte, SaltSize)
+
+// Where users and tokens are kept in internal database
+var (
+	authCollection  = Hash([]byte("auth"))
+	usersNamespace  = Hash([]byte("users"))
+	tokensNamespace = Hash([]byte("tokens"))
+)
+
+// Access rule for collection and namespace, Any matches all of them.
+type Rule struct {
+	Collection uint64
+	Namespace  uint64
+	Perm       uint8
+}
+
+type User struct {
+	Name  string
+	Salt  []byte
+	Hash  []byte // password hash, empty for token-only users
+	Rules []Rule
+}
+
+// Return user permission for collection and namespace.
+// The most specific matching rule wins, so it can also restrict broader ones.
+func (u *User) Perm(collection, namespace uint64) uint8 {
+	perm, best := PermNone, -1
+
+	for _, r := range u.Rules {
+		if r.Collection != Any && r.Collection != collection {
+			continue
+		}
+
+		if r.Namespace != Any && r.Namespace != namespace {
+			continue
+		}
+
+		score := 0
+		if r.Collection != Any {
+			score += 2
+		}
+
+		if r.Namespace != Any {
+			score += 1
+		}
+
+		if score > best {
+			perm, best = r.Perm, score
+		}
+	}
+
+	return perm
+}
+
+// Encode user for storage
+func (u *User) Encode() []byte {
+	name := []byte(u.Name)
+	rules := []byte{}
+
+	for _, r := range u.Rules {
+		rules = append(rules, bit.Encode(&r.Collection, &r.Namespace, &r.Perm)...)
+	}
+
+	return bit.Encode(&name, &u.Salt, &u.Hash, &rules)
+}
+
+func DecodeUser(data []byte) *User {
+	u := &User{}
+	name, rules := []byte{}, []byte{}
+
+	bit.NewBuffer(data).Decode(&name, &u.Salt, &u.Hash, &rules)
+	u.Name = string(name)
+
+	buf := bit.NewBuffer(rules)
+	for buf.Len() > 0 {
+		r := Rule{}
+		if buf.Decode(&r.Collection, &r.Namespace, &r.Perm) != nil {
+			break
+		}
+
+		u.Rules = append(u.Rules, r)
+	}
+
+	return u
+}
+
+// Auth manages users, their tokens and access rules.
+// Everything is stored in internal database.
+type Auth struct {
+	db *db.DB
+
+	// Serializes read-modify-write of users
+	mu sync.Mutex
+}
+
+func NewAuth(db *db.DB) *Auth {
+	return &Auth{db: db}
+}
+
+// Return user by name, nil if it doesn't exist.
+func (a *Auth) User(name string) (*User, error) {
+	data, err := a.db.Get(userKey(name, nil))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	return DecodeUser(data), nil
+}
+
+// Create user or change password of existing one.
+// Empty password disables password login, only tokens can be used.
+func (a *Auth) AddUser(name, password string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, err := a.User(name)
+	if err != nil {
+		return err
+	}
+
+	if u == nil {
+		u = &User{Name: name}
+	}
+
+	u.Salt, u.Hash = nil, nil
+
+	if password != "" {
+		u.Salt = make([]byte, SaltSize)
+		rand.Read(u.Salt)
+
+		u.Hash = hashPassword([]byte(password), u.Salt)
+	}
+
+	return a.db.Put(userKey(name, u.Encode()))
+}
+
+// Delete user. Its tokens and open sessions stop working right away.
+func (a *Auth) DeleteUser(name string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ok, err := a.db.DeleteKey(userKey(name, nil))
+	if err != nil {
+		return ok, err
+	}
+
+	// Tokens point to user by name, they would work
+	// again for new user with the same name.
+	return ok, a.deleteTokens(name)
+}
+
+// Delete all tokens of user.
+func (a *Auth) deleteTokens(name string) error {
+	keys := []*db.Key{}
+	cursor := uint64(0)
+
+	for {
+		list, next, err := a.db.Scan(authCollection, tokensNamespace, 0, cursor, 1000)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range list {
+			if string(key.Value) == name {
+				keys = append(keys, key)
+			}
+		}
+
+		if next == 0 {
+			break
+		}
+
+		cursor = next
+	}
+
+	for _, key := range keys {
+		key.Collection = authCollection
+		key.Namespace = tokensNamespace
+
+		_, err := a.db.DeleteKey(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Set user permission for collection and namespace,
+// PermNone removes the rule.
+func (a *Auth) Grant(name string, rule Rule) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, err := a.User(name)
+	if err != nil {
+		return err
+	}
+
+	if u == nil {
+		return ErrNoUser
+	}
+
+	rules := []Rule{}
+	for _, r := range u.Rules {
+		if r.Collection != rule.Collection || r.Namespace != rule.Namespace {
+			rules = append(rules, r)
+		}
+	}
+
+	if rule.Perm != PermNone {
+		rules = append(rules, rule)
+	}
+
+	u.Rules = rules
+	return a.db.Put(userKey(name, u.Encode()))
+}
+
+// Check user password.
+func (a *Auth) Login(name string, password []byte) (*User, error) {
+	u, err := a.User(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Password is hashed for missing users too, so timing
+	// doesn't tell which user names exist.
+	if u == nil || len(u.Hash) == 0 {
+		hashPassword(password, dummySalt)
+		return nil, ErrAuthFailed
+	}
+
+	hash := hashPassword(password, u.Salt)
+	if subtle.ConstantTimeCompare(hash, u.Hash) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	return u, nil
+}
+
+// Create new token for user. Only token hash is stored,
+// so it can't be retrieved later.
+func (a *Auth) NewToken(name string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, err := a.User(name)
+	if err != nil {
+		return "", err
+	}
+
+	if u == nil {
+		return "", ErrNoUser
+	}
+
+	raw := make([]byte, TokenSize)
+	rand.Read(raw)
+
+	token := hex.EncodeToString(raw)
+
+	err = a.db.Put(tokenKey(token, []byte(name)))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Return user owning the token.
+func (a *Auth) LoginToken(token []byte) (*User, error) {
+	name, err := a.db.Get(tokenKey(string(token), nil))
+	if err != nil {
+		return nil, err
+	}
+
+	if name == nil {
+		return nil, ErrAuthFailed
+	}
+
+	u, err := a.User(string(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil {
+		return nil, ErrAuthFailed
+	}
+
+	return u, nil
+}
+
+// Delete token. Return false if it didn't exist.
+func (a *Auth) RevokeToken(token string) (bool, error) {
+	return a.db.DeleteKey(tokenKey(token, nil))
+}
+
+// Log connection in with password, or with token if name is empty.
+func (a *Auth) Authenticate(conn *Conn, name string, secret []byte) error {
+	lsn := a.db.LSN()
+
+	var u *User
+	var err error
+
+	if name == "" {
+		u, err = a.LoginToken(secret)
+	} else {
+		u, err = a.Login(name, secret)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	conn.User = u
+	conn.auth = session{lsn: lsn}
+
+	if name == "" {
+		conn.auth.token = append([]byte{}, secret...)
+	}
+
+	return nil
+}
+
+// Reload user of connection if users or tokens changed since it was loaded,
+// so deleted users, revoked tokens and new rules take effect in open sessions.
+// User is set to nil if it no longer exists, its password was changed or
+// token was revoked. Changes are detected by lsn of internal database, so
+// changes copied from primary are seen by replicas too.
+func (a *Auth) Refresh(conn *Conn) error {
+	lsn := a.db.LSN()
+
+	if conn.User == nil || conn.auth.lsn == lsn {
+		return nil
+	}
+
+	var u *User
+	var err error
+
+	if conn.auth.token != nil {
+		u, err = a.LoginToken(conn.auth.token)
+	} else {
+		u, err = a.User(conn.User.Name)
+
+		// Password changed, or user was deleted and created again
+		if u != nil && !bytes.Equal(u.Salt, conn.User.Salt) {
+			u = nil
+		}
+	}
+
+	if errors.Is(err, ErrAuthFailed) {
+		u, err = nil, nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	conn.User = u
+	conn.auth.lsn = lsn
+
+	return nil
+}
+
+// Authenticated session of connection.
+type session struct {
+	lsn   uint64 // lsn of internal database user was loaded at
+	token []byte // token used to log in, nil for password
+}
+
+// Return key for user record
+func userKey(name string, val []byte) *db.Key {
+	key := db.NewKey([]byte(name), val)
+	key.Collection = authCollection
+	key.Namespace = usersNamespace
+
+	return key
+}
+
+// Return key for token record, tokens are stored hashed.
+func tokenKey(token string, val []byte) *db.Key {
+	hash := sha256.Sum256([]byte(token))
+
+	key := db.NewKey(hash[:], val)
+	key.Collection = authCollection
+	key.Namespace = tokensNamespace
+
+	return key
+}
+
+func hashPassword(password, salt []byte) []byte {
+	return pbkdf2.Key(password, salt, KDFIterations, KDFKeySize, sha256.New)
+}
+
+// Check if connection is allowed to run command.
+// Everything is allowed when authentication is disabled.
+func (s *Server) Authorize(conn *Conn, cmd *Cmd) error {
+	if s.Auth == nil || cmd.Type == CmdAuth || cmd.Type == CmdPing {
+		return nil
+	}
+
+	err := s.Auth.Refresh(conn)
+	if err != nil {
+		return err
+	}
+
+	if conn.User == nil {
+		return ErrAuthRequired
+	}
+
+	perm := PermAdmin
+	coll, ns := Any, Any
+
+	switch cmd.Type {
+	case CmdAdd, CmdDelete, CmdSet, CmdIncr, CmdExpire:
+		perm, coll, ns = PermWrite, cmd.Collection, cmd.Namespace
+	case CmdGet, CmdScan, CmdWatch, CmdLookup:
+		perm, coll, ns = PermRead, cmd.Collection, cmd.Namespace
+	case CmdGrant:
+		// Collection admins can manage access to their collections.
+		coll, ns = cmd.Collection, cmd.Namespace
+	}
+
+	if conn.User.Perm(coll, ns) < perm {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// Run authentication and user management commands.
+func (s *Server) RunAuthCmd(cmd *Cmd, conn *Conn) *Resp {
+	if s.Auth == nil {
+		return errResp(errors.New("authentication is disabled"))
+	}
+
+	var err error
+	var data []byte
+
+	name := string(cmd.Key)
+
+	switch cmd.Type {
+	case CmdAuth:
+		err = s.Auth.Authenticate(conn, name, cmd.Data)
+
+	case CmdUser:
+		err = s.Auth.AddUser(name, string(cmd.Data))
+
+	case CmdDeleteUser:
+		ok, e := s.Auth.DeleteUser(name)
+		if e == nil && !ok {
+			e = ErrNoUser
+		}
+		err = e
+
+	case CmdToken:
+		token, e := s.Auth.NewToken(name)
+		data, err = []byte(token), e
+
+	case CmdRevokeToken:
+		_, err = s.Auth.RevokeToken(string(cmd.Data))
+
+	case CmdGrant:
+		if len(cmd.Data) != 1 || cmd.Data[0] > PermAdmin {
+			return errResp(errors.New("invalid permission"))
+		}
+
+		rule := Rule{Collection: cmd.Collection, Namespace: cmd.Namespace, Perm: cmd.Data[0]}
+		err = s.Auth.Grant(name, rule)
+
+	default:
+		err = fmt.Errorf("unknown command: %d", cmd.Type)
+	}
+
+	if err != nil {
+		return errResp(err)
+	}
+
+	return &Resp{Status: StatusOK, Data: data}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytedb/db"
+	"bytedb/tests"
+	"testing"
+	"time"
+)
+
+func openAuth(t *testing.T) *Auth {
+	database, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { database.Close() })
+	return NewAuth(database.Internals())
+}
+
+func TestAuthLogin(t *testing.T) {
+	auth := openAuth(t)
+	auth.AddUser("john", "secret")
+
+	u, err := auth.Login("john", []byte("secret"))
+	tests.Assert(t, nil, err)
+	tests.Assert(t, "john", u.Name)
+
+	_, err = auth.Login("john", []byte("wrong"))
+	tests.Assert(t, ErrAuthFailed, err)
+
+	_, err = auth.Login("bob", []byte("secret"))
+	tests.Assert(t, ErrAuthFailed, err)
+}
+
+func TestAuthToken(t *testing.T) {
+	auth := openAuth(t)
+	auth.AddUser("john", "")
+
+	token, _ := auth.NewToken("john")
+
+	u, err := auth.LoginToken([]byte(token))
+	tests.Assert(t, nil, err)
+	tests.Assert(t, "john", u.Name)
+
+	// Password login is disabled for token-only users
+	_, err = auth.Login("john", []byte(""))
+	tests.Assert(t, ErrAuthFailed, err)
+
+	auth.RevokeToken(token)
+
+	_, err = auth.LoginToken([]byte(token))
+	tests.Assert(t, ErrAuthFailed, err)
+}
+
+func TestAuthDeleteUser(t *testing.T) {
+	auth := openAuth(t)
+	auth.AddUser("john", "secret")
+	token, _ := auth.NewToken("john")
+
+	auth.DeleteUser("john")
+
+	_, err := auth.LoginToken([]byte(token))
+	tests.Assert(t, ErrAuthFailed, err)
+
+	// Tokens are deleted with user, new one with the same name can't use them
+	auth.AddUser("john", "other")
+
+	_, err = auth.LoginToken([]byte(token))
+	tests.Assert(t, ErrAuthFailed, err)
+}
+
+func TestAuthorize(t *testing.T) {
+	auth := openAuth(t)
+	auth.AddUser("john", "secret")
+
+	orders := Hash([]byte("orders"))
+	eu, us := Hash([]byte("eu")), Hash([]byte("us"))
+
+	auth.Grant("john", Rule{Collection: orders, Namespace: Any, Perm: PermRead})
+	auth.Grant("john", Rule{Collection: orders, Namespace: eu, Perm: PermWrite})
+
+	srv := &Server{Auth: auth}
+	conn := &Conn{}
+
+	add := &Cmd{Type: CmdAdd, Collection: orders, Namespace: eu}
+	tests.Assert(t, ErrAuthRequired, srv.Authorize(conn, add))
+
+	conn.User, _ = auth.Login("john", []byte("secret"))
+	tests.Assert(t, nil, srv.Authorize(conn, add))
+
+	// Only read access to other namespaces
+	add.Namespace = us
+	tests.Assert(t, ErrForbidden, srv.Authorize(conn, add))
+
+	// No access to other collections
+	add.Collection = Hash([]byte("users"))
+	tests.Assert(t, ErrForbidden, srv.Authorize(conn, add))
+
+	// User management requires global admin
+	user := &Cmd{Type: CmdUser, Key: []byte("bob")}
+	tests.Assert(t, ErrForbidden, srv.Authorize(conn, user))
+
+	auth.Grant("john", Rule{Collection: Any, Namespace: Any, Perm: PermAdmin})
+	conn.User, _ = auth.Login("john", []byte("secret"))
+	tests.Assert(t, nil, srv.Authorize(conn, user))
+}
+
+func TestAuthSession(t *testing.T) {
+	auth := openAuth(t)
+	auth.AddUser("john", "secret")
+	auth.AddUser("anna", "")
+	auth.Grant("john", Rule{Perm: PermRead})
+
+	token, _ := auth.NewToken("anna")
+
+	srv := &Server{Auth: auth}
+	john, anna := &Conn{}, &Conn{}
+
+	tests.Assert(t, nil, auth.Authenticate(john, "john", []byte("secret")))
+	tests.Assert(t, nil, auth.Authenticate(anna, "", []byte(token)))
+
+	add := &Cmd{Type: CmdAdd}
+	tests.Assert(t, ErrForbidden, srv.Authorize(john, add))
+
+	// New rules apply to open session
+	auth.Grant("john", Rule{Perm: PermWrite})
+	tests.Assert(t, nil, srv.Authorize(john, add))
+
+	// Deleted user and revoked token end sessions
+	auth.DeleteUser("john")
+	tests.Assert(t, ErrAuthRequired, srv.Authorize(john, add))
+
+	auth.RevokeToken(token)
+	tests.Assert(t, ErrAuthRequired, srv.Authorize(anna, &Cmd{Type: CmdGet}))
+
+	// User created again with the same name doesn't get old sessions
+	auth.AddUser("bob", "secret")
+
+	bob := &Conn{}
+	auth.Authenticate(bob, "bob", []byte("secret"))
+
+	auth.DeleteUser("bob")
+	auth.AddUser("bob", "secret")
+	auth.Grant("bob", Rule{Perm: PermAdmin})
+
+	tests.Assert(t, ErrAuthRequired, srv.Authorize(bob, add))
+}
+
+func TestAuthLoginTiming(t *testing.T) {
+	auth := openAuth(t)
+	auth.AddUser("john", "secret")
+
+	measure := func(name string) time.Duration {
+		start := time.Now()
+		auth.Login(name, []byte("wrong"))
+
+		return time.Since(start)
+	}
+
+	// Missing user takes as long as wrong password, KDF runs in both cases
+	existing, missing := measure("john"), measure("bob")
+	tests.Assert(t, true, missing > existing/4)
+}
@@ -1,68 +1,809 @@
 package server
 
 import (
+	"bytedb/db"
 	bit "bytedb/lib/bitbox"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"strings"
+	"sync"
+	"time"
 )
 
+var (
+	ErrNotFound     = errors.New("key not found")
+	ErrClientClosed = errors.New("client is closed")
+)
+
+// Default client options
+const (
+	DefaultPoolSize       = 8
+	DefaultDialTimeout    = 5 * time.Second
+	DefaultTimeout        = 5 * time.Second
+	DefaultRetries        = 3
+	DefaultRetryBackoff   = 50 * time.Millisecond
+	DefaultMaxBackoff     = 2 * time.Second
+	DefaultHealthInterval = 30 * time.Second
+	DefaultMaxRespSize    = 64 << 20
+)
+
+// Client with connection pool. It's safe for concurrent use.
+//
+// Every call takes context, its deadline is used for the call. Calls
+// without deadline get Timeout from options. Idempotent commands (get,
+// add, delete, scan and ping) are retried with backoff when connection
+// fails, broken connections are replaced with new ones.
 type Client struct {
-	conn *Conn
+	addr string
+	opts *ClientOptions
+	tls  *tls.Config
+
+	idle chan *poolConn // idle connections
+	sem  chan struct{}  // one slot for each open connection
+	done chan struct{}  // closed by Close
+	once sync.Once
+
+	mu    sync.Mutex
+	creds credentials
+	gen   int // incremented each time credentials change
+}
+
+// Client options
+type ClientOptions struct {
+	// Enable TLS. Server is verified using system CA pool
+	// unless CAFile is set.
+	TLS        bool
+	CAFile     string
+	ServerName string // defaults to host from address
+
+	// Client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+
+	// Credentials used by each new connection, either
+	// user and password or token.
+	User     string
+	Password string
+	Token    string
+
+	PoolSize       int           // max number of open connections
+	DialTimeout    time.Duration // max time for opening connection
+	Timeout        time.Duration // deadline for calls whose context has none
+	Retries        int           // max number of retries of idempotent commands, negative disables retries
+	RetryBackoff   time.Duration // first retry delay, doubled on each retry
+	MaxBackoff     time.Duration // max retry delay
+	HealthInterval time.Duration // how often idle connections are checked, negative disables checks
+	MaxRespSize    int           // max size of response
+
+	// Send commands in compact encoding, with varint lengths of
+	// key and data. It saves 6 bytes per command and response.
+	Compact bool
+}
+
+// Return options with defaults
+func DefaultClientOptions() *ClientOptions {
+	return &ClientOptions{
+		PoolSize:       DefaultPoolSize,
+		DialTimeout:    DefaultDialTimeout,
+		Timeout:        DefaultTimeout,
+		Retries:        DefaultRetries,
+		RetryBackoff:   DefaultRetryBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+		HealthInterval: DefaultHealthInterval,
+		MaxRespSize:    DefaultMaxRespSize,
+	}
+}
+
+type credentials struct {
+	user     string
+	password string
+	token    string
 }
 
-// Create new client.
+// Pooled connection
+type poolConn struct {
+	*Conn
+	gen int // credentials generation used for authentication
+}
+
+// Connection failure, command can be retried on another connection.
+type connError struct {
+	err error
+}
+
+func (e *connError) Error() string {
+	return e.err.Error()
+}
+
+// Create new client with default options.
 func NewClient(addr string) (*Client, error) {
-	conn, err := Connect(addr)
-	return &Client{conn: conn}, err
+	return NewClientWith(addr, DefaultClientOptions())
 }
 
-// Send ADD command to server.
-// Key format is "coll::namespace::prefix::key".
-func (c *Client) Add(key string, val []byte) ([]byte, error) {
-	parts := strings.Split(key, "::")
+// Create new client with options, zero options get default values.
+// One connection is opened right away, so unreachable server
+// is reported immediately.
+func NewClientWith(addr string, opts *ClientOptions) (*Client, error) {
+	c, err := newClient(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.DialTimeout)
+	defer cancel()
+
+	conn, err := c.get(ctx)
+	if err != nil {
+		var cerr *connError
+		if errors.As(err, &cerr) {
+			err = cerr.err
+		}
+
+		return nil, err
+	}
+
+	c.put(conn, nil)
+
+	if c.opts.HealthInterval > 0 {
+		go c.checkHealth()
+	}
+
+	return c, nil
+}
+
+// Create client without connecting to server.
+func newClient(addr string, opts *ClientOptions) (*Client, error) {
+	o := *opts
+	o.setDefaults()
+
+	c := &Client{
+		addr:  addr,
+		opts:  &o,
+		idle:  make(chan *poolConn, o.PoolSize),
+		sem:   make(chan struct{}, o.PoolSize),
+		done:  make(chan struct{}),
+		creds: credentials{user: o.User, password: o.Password, token: o.Token},
+	}
+
+	if o.TLS {
+		cfg, err := o.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		c.tls = cfg
+	}
+
+	return c, nil
+}
+
+// Fill zero options with defaults
+func (o *ClientOptions) setDefaults() {
+	def := DefaultClientOptions()
+
+	if o.PoolSize <= 0 {
+		o.PoolSize = def.PoolSize
+	}
+
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = def.DialTimeout
+	}
+
+	if o.Timeout <= 0 {
+		o.Timeout = def.Timeout
+	}
+
+	if o.Retries == 0 {
+		o.Retries = def.Retries
+	}
+
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = def.RetryBackoff
+	}
+
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = def.MaxBackoff
+	}
+
+	if o.HealthInterval == 0 {
+		o.HealthInterval = def.HealthInterval
+	}
+
+	if o.MaxRespSize <= 0 {
+		o.MaxRespSize = def.MaxRespSize
+	}
+}
+
+// Build TLS config from options
+func (o *ClientOptions) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: o.ServerName,
+	}
 
-	if len(parts) < 4 {
-		return nil, fmt.Errorf("invalid key")
+	if o.CAFile != "" {
+		pool, err := LoadCertPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = pool
 	}
 
-	cmd := &Cmd{}
-	cmd.Type = uint8(CmdAdd)
-	cmd.Collection = Hash([]byte(parts[0]))
-	cmd.Namespace = Hash([]byte(parts[1]))
-	cmd.Prefix = Hash([]byte(parts[2]))
-	cmd.Key = []byte(parts[3])
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// Send ADD command to server.
+func (c *Client) Add(ctx context.Context, key KeyRef, val []byte) error {
+	cmd := key.cmd(CmdAdd)
 	cmd.Data = val
 
-	// prepare cmd req
-	req := bit.Encode(
-		&cmd.Type,
-		&cmd.Collection,
-		&cmd.Namespace,
-		&cmd.Prefix,
-		&cmd.Key,
-		&cmd.Data,
-	)
+	_, err := c.exec(ctx, cmd, true)
+	return err
+}
+
+// Read key value. Return nil if key doesn't exist.
+func (c *Client) Get(ctx context.Context, key KeyRef) ([]byte, error) {
+	val, err := c.exec(ctx, key.cmd(CmdGet), true)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+
+	return val, err
+}
+
+// Delete key. Return false if key didn't exist.
+func (c *Client) Delete(ctx context.Context, key KeyRef) (bool, error) {
+	_, err := c.exec(ctx, key.cmd(CmdDelete), true)
+	if err == ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Return up to count keys of prefix with their values, starting at cursor,
+// and cursor for the next call. Scan starts and ends with 0 cursor.
+// Key of prefix reference is ignored.
+func (c *Client) Scan(ctx context.Context, prefix KeyRef, cursor uint64, count uint32) ([]Entry, uint64, error) {
+	req := &ScanReq{Cursor: cursor, Count: count}
+
+	cmd := prefix.With(nil).cmd(CmdScan)
+	cmd.Data = req.Encode()
+
+	data, err := c.exec(ctx, cmd, true)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp := DecodeScanResp(data)
+	return resp.Entries, resp.Cursor, nil
+}
+
+// Check if server responds.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.exec(ctx, &Cmd{Type: CmdPing}, true)
+	return err
+}
+
+// Return hashes of all collections. Requires admin permission.
+func (c *Client) Collections(ctx context.Context) ([]uint64, error) {
+	data, err := c.exec(ctx, &Cmd{Type: CmdCollections}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeCollections(data), nil
+}
+
+// Return server statistics. Requires admin permission.
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	data, err := c.exec(ctx, &Cmd{Type: CmdStats}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeStats(data), nil
+}
+
+// Return quota and usage of collection. Requires admin permission.
+func (c *Client) Quota(ctx context.Context, collection string) (*QuotaInfo, error) {
+	data, err := c.exec(ctx, &Cmd{Type: CmdQuota, Collection: Hash([]byte(collection))}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeQuotaInfo(data), nil
+}
+
+// Set quota of collection, zero limits are disabled. Requires admin permission.
+func (c *Client) SetQuota(ctx context.Context, collection string, q db.Quota) error {
+	cmd := &Cmd{Type: CmdSetQuota, Collection: Hash([]byte(collection)), Data: EncodeQuota(&q)}
+
+	_, err := c.exec(ctx, cmd, true)
+	return err
+}
+
+// Return up to count slow commands, the newest first, 0 returns
+// all of them. Requires admin permission.
+func (c *Client) SlowLog(ctx context.Context, count int) ([]SlowEntry, error) {
+	req := &SlowLogReq{Count: uint32(count)}
+
+	data, err := c.exec(ctx, &Cmd{Type: CmdSlowLog, Data: req.Encode()}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeSlowLog(data), nil
+}
+
+// Clear slow log. Requires admin permission.
+func (c *Client) ResetSlowLog(ctx context.Context) error {
+	req := &SlowLogReq{Reset: 1}
+
+	_, err := c.exec(ctx, &Cmd{Type: CmdSlowLog, Data: req.Encode()}, true)
+	return err
+}
+
+// Write database backup archive to w. Requires admin permission.
+// Backup takes long for big databases, so it's limited only by ctx
+// deadline, default timeout isn't used.
+func (c *Client) Backup(ctx context.Context, w io.Writer) error {
+	conn, err := c.get(ctx)
+
+	var cerr *connError
+	if errors.As(err, &cerr) {
+		return cerr.err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Connection can't be reused if stream was interrupted.
+	err = c.backup(ctx, conn.Conn, w)
+	c.put(conn, err)
+
+	return err
+}
+
+func (c *Client) backup(ctx context.Context, conn *Conn, w io.Writer) error {
+	defer c.bind(ctx, conn)()
+
+	_, err := conn.Write(EncodeCmd(&Cmd{Type: CmdBackup}))
+	if err != nil {
+		return err
+	}
+
+	for {
+		data, err := conn.ReadFrame(c.opts.MaxRespSize)
+		if err != nil {
+			return err
+		}
+
+		resp := DecodeResp(bit.NewBuffer(data))
+		if resp.Status != StatusOK {
+			return fmt.Errorf("%s", resp.Data)
+		}
+
+		if len(resp.Data) == 0 {
+			return nil
+		}
+
+		_, err = w.Write(resp.Data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Pass changes of keys with prefix to fn, starting after lsn, until ctx is
+// done, fn returns error or connection fails. Empty prefix name watches the
+// whole namespace. Watch resumes from lsn of the last event as long as
+// server retains wal. It uses its own connection, not one from the pool.
+func (c *Client) Watch(ctx context.Context, prefix KeyRef, lsn uint64, fn func(events []db.Event) error) error {
+	c.mu.Lock()
+	creds := c.creds
+	c.mu.Unlock()
+
+	conn, err := c.dial(ctx, creds)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	cmd := prefix.With(nil).cmd(CmdWatch)
+	cmd.Data = (&WatchReq{LSN: lsn}).Encode()
+
+	if prefix.Prefix == "" {
+		cmd.Prefix = 0
+	}
+
+	_, err = conn.Write(EncodeCmd(cmd))
+	if err != nil {
+		return err
+	}
+
+	for ctx.Err() == nil {
+		conn.SetReadDeadline(time.Now().Add(ReplicateTimeout))
+
+		data, err := conn.ReadFrame(c.opts.MaxRespSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return err
+		}
+
+		resp := DecodeResp(bit.NewBuffer(data))
+		if resp.Status != StatusOK {
+			return fmt.Errorf("%s", resp.Data)
+		}
+
+		if len(resp.Data) == 0 {
+			continue
+		}
+
+		err = fn(DecodeEvents(resp.Data))
+		if err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Authenticate with user name and password. Credentials are
+// checked right away and used for all connections.
+func (c *Client) Auth(ctx context.Context, user, password string) error {
+	return c.login(ctx, credentials{user: user, password: password})
+}
+
+// Authenticate with token. Token is checked right away
+// and used for all connections.
+func (c *Client) AuthToken(ctx context.Context, token string) error {
+	return c.login(ctx, credentials{token: token})
+}
+
+// Create user or change its password. Requires admin permission.
+func (c *Client) AddUser(ctx context.Context, user, password string) error {
+	_, err := c.exec(ctx, &Cmd{Type: CmdUser, Key: []byte(user), Data: []byte(password)}, false)
+	return err
+}
+
+// Delete user. Requires admin permission.
+func (c *Client) DeleteUser(ctx context.Context, user string) error {
+	_, err := c.exec(ctx, &Cmd{Type: CmdDeleteUser, Key: []byte(user)}, false)
+	return err
+}
+
+// Create new token for user. Requires admin permission.
+func (c *Client) NewToken(ctx context.Context, user string) (string, error) {
+	token, err := c.exec(ctx, &Cmd{Type: CmdToken, Key: []byte(user)}, false)
+	return string(token), err
+}
+
+// Revoke token. Requires admin permission.
+func (c *Client) RevokeToken(ctx context.Context, token string) error {
+	_, err := c.exec(ctx, &Cmd{Type: CmdRevokeToken, Data: []byte(token)}, false)
+	return err
+}
+
+// Set user permission for collection and namespace, "*" matches all of them.
+// Requires admin permission for that collection and namespace.
+func (c *Client) Grant(ctx context.Context, user, collection, namespace string, perm uint8) error {
+	cmd := &Cmd{Type: CmdGrant, Key: []byte(user), Data: []byte{perm}}
+	cmd.Collection = ruleHash(collection)
+	cmd.Namespace = ruleHash(namespace)
+
+	_, err := c.exec(ctx, cmd, false)
+	return err
+}
+
+// Close idle connections. Calls in progress can still
+// finish, new ones fail with ErrClientClosed.
+func (c *Client) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+
+	for {
+		select {
+		case conn := <-c.idle:
+			c.discard(conn)
+		default:
+			return nil
+		}
+	}
+}
+
+// Check credentials on a new connection and use them from now on.
+// Pooled connections are authenticated again before their next use.
+func (c *Client) login(ctx context.Context, creds credentials) error {
+	ctx, cancel := c.deadline(ctx)
+	defer cancel()
+
+	conn, err := c.dial(ctx, creds)
+	if err != nil {
+		return err
+	}
+
+	conn.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.creds = creds
+	c.gen++
+
+	return nil
+}
+
+// Send command and wait for response. Idempotent commands
+// are retried when connection fails.
+func (c *Client) exec(ctx context.Context, cmd *Cmd, idempotent bool) ([]byte, error) {
+	ctx, cancel := c.deadline(ctx)
+	defer cancel()
+
+	backoff := c.opts.RetryBackoff
+
+	for try := 0; ; try++ {
+		data, err := c.roundTrip(ctx, cmd)
+
+		var cerr *connError
+		if !errors.As(err, &cerr) {
+			return data, err
+		}
+
+		if !idempotent || try >= c.opts.Retries || ctx.Err() != nil {
+			return nil, cerr.err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, ErrClientClosed
+		}
+
+		backoff = min(2*backoff, c.opts.MaxBackoff)
+	}
+}
+
+// Send command on pooled connection and read response.
+func (c *Client) roundTrip(ctx context.Context, cmd *Cmd) ([]byte, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.send(ctx, conn.Conn, cmd)
+	c.put(conn, err)
+
+	if err != nil {
+		return nil, &connError{err}
+	}
+
+	switch resp.Status {
+	case StatusOK:
+		return resp.Data, nil
+	case StatusNotFound:
+		return nil, ErrNotFound
+	case StatusReadOnly:
+		return nil, ErrReadOnly
+	case StatusBusy:
+		return nil, fmt.Errorf("%w: %s", ErrBusy, resp.Data)
+	case StatusQuota:
+		return nil, fmt.Errorf("%w%s", db.ErrQuotaExceeded, strings.TrimPrefix(string(resp.Data), db.ErrQuotaExceeded.Error()))
+	}
+
+	return nil, fmt.Errorf("%s", resp.Data)
+}
+
+// Write command and read response, connection deadline follows ctx.
+func (c *Client) send(ctx context.Context, conn *Conn, cmd *Cmd) (*Resp, error) {
+	defer c.bind(ctx, conn)()
+
+	e := bit.GetEncoder()
+	e.Compact = c.opts.Compact
+	cmd.Append(e)
+
+	_, err := conn.Write(e.Data())
+	bit.PutEncoder(e)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := conn.ReadFrame(c.opts.MaxRespSize)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bit.NewBuffer(data)
+	buf.Compact = c.opts.Compact
+
+	return DecodeResp(buf), nil
+}
+
+// Set connection deadline from ctx and interrupt pending IO when
+// ctx is cancelled. Returned function must be called when IO is done.
+func (c *Client) bind(ctx context.Context, conn *Conn) func() {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+
+	return func() { stop() }
+}
+
+// Return idle connection or open new one if pool is not full.
+// Connections authenticated with old credentials are replaced.
+func (c *Client) get(ctx context.Context) (*poolConn, error) {
+	var conn *poolConn
+
+	select {
+	case <-c.done:
+		return nil, ErrClientClosed
+	case conn = <-c.idle:
+	default:
+		select {
+		case conn = <-c.idle:
+		case c.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, ErrClientClosed
+		}
+	}
+
+	c.mu.Lock()
+	creds, gen := c.creds, c.gen
+	c.mu.Unlock()
+
+	if conn != nil && conn.gen == gen {
+		return conn, nil
+	}
+
+	// Reuse pool slot of outdated connection.
+	if conn != nil {
+		conn.Close()
+	}
+
+	conn, err := c.dial(ctx, creds)
+	if err != nil {
+		<-c.sem
+		return nil, &connError{err}
+	}
 
-	// add length prefix
-	req = bit.Encode(req)
+	conn.gen = gen
+	return conn, nil
+}
 
-	// send cmd to server
-	n, err := c.conn.Write(req)
+// Return connection to pool, broken connections are closed.
+func (c *Client) put(conn *poolConn, err error) {
+	if err != nil {
+		c.discard(conn)
+		return
+	}
+
+	select {
+	case <-c.done:
+		c.discard(conn)
+	default:
+		c.idle <- conn
+	}
+}
+
+// Close connection and free its pool slot.
+func (c *Client) discard(conn *poolConn) {
+	conn.Close()
+	<-c.sem
+}
+
+// Open new connection and authenticate it.
+func (c *Client) dial(ctx context.Context, creds credentials) (*poolConn, error) {
+	dctx, cancel := context.WithTimeout(ctx, c.opts.DialTimeout)
+	defer cancel()
+
+	conn, err := ConnectContext(dctx, c.addr, c.tls)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("sending: ", cmd.Collection, cmd.Namespace, cmd.Prefix, cmd.Key)
-	log.Printf("bytes send: %d", n)
+	if creds == (credentials{}) {
+		return &poolConn{Conn: conn}, nil
+	}
+
+	cmd := &Cmd{Type: CmdAuth, Key: []byte(creds.user), Data: []byte(creds.password)}
+	if creds.token != "" {
+		cmd.Key, cmd.Data = nil, []byte(creds.token)
+	}
+
+	resp, err := c.send(ctx, conn, cmd)
+	if err == nil && resp.Status != StatusOK {
+		err = fmt.Errorf("%s", resp.Data)
+	}
 
-	// read response
-	res := make([]byte, 1024)
-	_, err = c.conn.Read(res)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	fmt.Println(res)
-	return res, nil
+	return &poolConn{Conn: conn}, nil
+}
+
+// Add default timeout to ctx if it has no deadline.
+func (c *Client) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	_, ok := ctx.Deadline()
+	if ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, c.opts.Timeout)
+}
+
+// Periodically ping idle connections and close broken ones,
+// so calls don't fail on connections closed by server.
+func (c *Client) checkHealth() {
+	ticker := time.NewTicker(c.opts.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+
+		for n := len(c.idle); n > 0; n-- {
+			var conn *poolConn
+
+			select {
+			case conn = <-c.idle:
+			default:
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+			_, err := c.send(ctx, conn.Conn, &Cmd{Type: CmdPing})
+			cancel()
+
+			c.put(conn, err)
+		}
+	}
+}
+
+// Hash collection or namespace name used in rules.
+func ruleHash(name string) uint64 {
+	if name == "*" {
+		return Any
+	}
+
+	return Hash([]byte(name))
 }
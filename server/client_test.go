@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytedb/db"
+	"bytedb/tests"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// Prefix used by client tests
+var ref = PrefixRef("c", "n", "p")
+
+func TestClientConnectError(t *testing.T) {
+	// Reserve port and close it, so nobody listens there.
+	sock, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := sock.Addr().String()
+	sock.Close()
+
+	_, err := NewClient(addr)
+	tests.AssertNot(t, nil, err)
+}
+
+func TestClientCommands(t *testing.T) {
+	_, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	tests.Assert(t, nil, cli.Ping(ctx))
+
+	for i := 0; i < 10; i++ {
+		cli.Add(ctx, ref.WithString(fmt.Sprintf("key_%d", i)), []byte("val"))
+	}
+
+	// Bigger than any read buffer
+	big := make([]byte, 100_000)
+	tests.Assert(t, nil, cli.Add(ctx, NewKeyRef("c", "n", "big", []byte("key")), big))
+
+	val, _ := cli.Get(ctx, NewKeyRef("c", "n", "big", []byte("key")))
+	tests.Assert(t, len(big), len(val))
+
+	entries, cursor, err := cli.Scan(ctx, ref, 0, 100)
+	tests.Assert(t, nil, err)
+	tests.Assert(t, 10, len(entries))
+	tests.Assert(t, uint64(0), cursor)
+
+	ok, _ := cli.Delete(ctx, ref.WithString("key_1"))
+	tests.Assert(t, true, ok)
+
+	val, err = cli.Get(ctx, ref.WithString("key_1"))
+	tests.Assert(t, nil, err)
+	tests.AssertEqual(t, []byte(nil), val)
+
+	// Binary key containing separator
+	key := NewKeyRef("c", "n", "p", []byte{0, ':', ':', 0xff})
+	cli.Add(ctx, key, []byte("bin"))
+
+	val, _ = cli.Get(ctx, key)
+	tests.AssertEqual(t, []byte("bin"), val)
+
+	colls, err := cli.Collections(ctx)
+	tests.Assert(t, nil, err)
+	tests.AssertEqual(t, []uint64{Hash([]byte("c"))}, colls)
+
+	stats, err := cli.Stats(ctx)
+	tests.Assert(t, nil, err)
+	tests.Assert(t, uint64(1), stats.Collections)
+	tests.Assert(t, uint64(2), stats.Buckets)
+}
+
+func TestClientPool(t *testing.T) {
+	_, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClientWith(addr, &ClientOptions{PoolSize: 4})
+	defer cli.Close()
+
+	tests.RunConcurrently(50, func() {
+		for i := 0; i < 20; i++ {
+			err := cli.Add(ctx, ref.WithString(fmt.Sprintf("key_%d", i)), []byte("val"))
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	tests.Assert(t, true, len(cli.idle) <= 4)
+}
+
+func TestClientReconnect(t *testing.T) {
+	srv, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	cli.Add(ctx, ref.WithString("key"), []byte("val"))
+
+	// Server closes connection, client must open new one.
+	srv.mu.RLock()
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.mu.RUnlock()
+
+	val, err := cli.Get(ctx, ref.WithString("key"))
+	tests.Assert(t, nil, err)
+	tests.AssertEqual(t, []byte("val"), val)
+}
+
+func TestClientContext(t *testing.T) {
+	// Server that never responds
+	sock, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer sock.Close()
+
+	go func() {
+		for {
+			c, err := sock.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	cli, _ := NewClientWith(sock.Addr().String(), &ClientOptions{Retries: -1})
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := cli.Get(ctx, ref.WithString("key"))
+
+	tests.AssertNot(t, nil, err)
+	tests.Assert(t, true, time.Since(start) < time.Second)
+
+	cli.Close()
+	_, err = cli.Get(context.Background(), ref.WithString("key"))
+	tests.Assert(t, true, errors.Is(err, ErrClientClosed))
+}
+
+func TestClientAuth(t *testing.T) {
+	srv, addr := runServer(t)
+	ctx := context.Background()
+
+	srv.Auth = NewAuth(srv.DB.Internals())
+	srv.Auth.AddUser("john", "secret")
+	srv.Auth.Grant("john", Rule{Collection: Any, Namespace: Any, Perm: PermWrite})
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	err := cli.Add(ctx, ref.WithString("key"), []byte("val"))
+	tests.AssertNot(t, nil, err)
+
+	tests.AssertNot(t, nil, cli.Auth(ctx, "john", "wrong"))
+	tests.Assert(t, nil, cli.Auth(ctx, "john", "secret"))
+
+	// Pooled connection is authenticated again
+	tests.Assert(t, nil, cli.Add(ctx, ref.WithString("key"), []byte("val")))
+
+	cli2, err := NewClientWith(addr, &ClientOptions{User: "john", Password: "secret"})
+	tests.Assert(t, nil, err)
+	defer cli2.Close()
+
+	tests.Assert(t, nil, cli2.Add(ctx, ref.WithString("key"), []byte("val")))
+}
+
+func TestClientBackup(t *testing.T) {
+	srv, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	// Archive is sent in many chunks
+	big := make([]byte, 100_000)
+	for i := 0; i < 30; i++ {
+		cli.Add(ctx, ref.WithString(fmt.Sprintf("key_%d", i)), big)
+	}
+
+	buf := &bytes.Buffer{}
+	tests.Assert(t, nil, cli.Backup(ctx, buf))
+	tests.Assert(t, true, buf.Len() > BackupChunkSize)
+
+	path := t.TempDir() + "/restored"
+	tests.Assert(t, nil, db.Restore(buf, path))
+
+	restored, _ := db.Open(path)
+	defer restored.Close()
+
+	cmd := ref.WithString("key_29").cmd(CmdGet)
+	key := db.NewKey(cmd.Key, nil)
+	key.Collection, key.Namespace, key.Prefix = cmd.Collection, cmd.Namespace, cmd.Prefix
+
+	val, _ := restored.Get(key)
+	tests.Assert(t, len(big), len(val))
+
+	// Backup requires admin permission
+	srv.Auth = NewAuth(srv.DB.Internals())
+	srv.Auth.AddUser("john", "secret")
+	srv.Auth.Grant("john", Rule{Collection: Any, Namespace: Any, Perm: PermWrite})
+
+	tests.Assert(t, nil, cli.Auth(ctx, "john", "secret"))
+	tests.AssertNot(t, nil, cli.Backup(ctx, &bytes.Buffer{}))
+	tests.Assert(t, nil, cli.Ping(ctx))
+}
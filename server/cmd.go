@@ -1,10 +1,127 @@
 package server
 
-import bit "bytedb/lib/bitbox"
+import (
+	"bytedb/db"
+	bit "bytedb/lib/bitbox"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//go:generate go run bytedb/cmd/bitbox-gen -type Cmd,ScanReq,ReplicateReq,WatchReq,SetReq,LookupResp,Entry,Stats,QuotaInfo,SlowEntry,SlowLogReq,Resp
 
 // All possible command types supported by server
 const (
 	CmdAdd uint8 = 1
+
+	// Key is user name and Data is password. For token
+	// authentication Key is empty and Data is token.
+	CmdAuth uint8 = 2
+
+	// User management, Key is always user name.
+	CmdUser        uint8 = 3 // create user, Data is password
+	CmdDeleteUser  uint8 = 4 // delete user
+	CmdToken       uint8 = 5 // create token, returned in response
+	CmdRevokeToken uint8 = 6 // delete token, Data is token
+	CmdGrant       uint8 = 7 // set permission for Collection and Namespace, Data is one byte perm
+
+	CmdGet    uint8 = 8  // read key, value is returned in response
+	CmdDelete uint8 = 9  // delete key
+	CmdScan   uint8 = 10 // scan keys of Prefix, Data is encoded ScanReq
+	CmdPing   uint8 = 11 // check if server responds, allowed without authentication
+
+	// Server information, both require admin permission.
+	CmdCollections uint8 = 12 // list hashes of all collections
+	CmdStats       uint8 = 13 // return encoded Stats
+
+	// Stream database backup, see Server.Backup. Requires admin
+	// permission and works only over binary protocol.
+	CmdBackup uint8 = 14
+
+	// Stream wal records to replica, Data is encoded ReplicateReq.
+	// See Server.Replicate, requires admin permission.
+	CmdReplicate uint8 = 15
+
+	// Stream changes of keys in Collection, Namespace and Prefix, zero
+	// prefix matches all prefixes. Data is encoded WatchReq, see Server.Watch.
+	CmdWatch uint8 = 16
+
+	// Return slow commands, Data is encoded SlowLogReq.
+	// Requires admin permission.
+	CmdSlowLog uint8 = 17
+
+	// Collection quotas, both require admin permission.
+	CmdQuota    uint8 = 18 // return encoded QuotaInfo of Collection
+	CmdSetQuota uint8 = 19 // set quota of Collection, Data is encoded db.Quota
+
+	// Commands reading and changing key atomically with other changes,
+	// used by Redis protocol.
+	CmdSet    uint8 = 20 // put key, Data is encoded SetReq; NotFound if condition isn't met
+	CmdIncr   uint8 = 21 // increment integer value and keep expiration, new value is returned as encoded int64
+	CmdExpire uint8 = 22 // set expiration of existing key, Data is encoded int64, see Key.Expire; past time deletes key
+	CmdLookup uint8 = 23 // read key with its expiration time, encoded LookupResp is returned
+)
+
+// Command names, used in metrics and slow log
+var cmdNames = map[uint8]string{
+	CmdAdd:         "add",
+	CmdAuth:        "auth",
+	CmdUser:        "user",
+	CmdDeleteUser:  "delete_user",
+	CmdToken:       "token",
+	CmdRevokeToken: "revoke_token",
+	CmdGrant:       "grant",
+	CmdGet:         "get",
+	CmdDelete:      "delete",
+	CmdScan:        "scan",
+	CmdPing:        "ping",
+	CmdCollections: "collections",
+	CmdStats:       "stats",
+	CmdBackup:      "backup",
+	CmdReplicate:   "replicate",
+	CmdWatch:       "watch",
+	CmdSlowLog:     "slowlog",
+	CmdQuota:       "quota",
+	CmdSetQuota:    "set_quota",
+	CmdSet:         "set",
+	CmdIncr:        "incr",
+	CmdExpire:      "expire",
+	CmdLookup:      "lookup",
+}
+
+// Return command name, "unknown" for unknown types.
+func CmdName(typ uint8) string {
+	name, ok := cmdNames[typ]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+// Size of backup chunk sent in single response
+const BackupChunkSize = 1 << 20
+
+// Size of encoded command without key and data
+const CmdHeaderSize = 1 + 8 + 8 + 8 + 4 + 4
+
+// Flag set in type of commands sent in compact encoding. Lengths of key
+// and data are varints, in the command and its response. Commands that
+// stream responses (backup, replicate and watch) are answered in
+// standard encoding.
+const CmdCompact uint8 = 0x80
+
+// Response statuses
+const (
+	StatusOK  uint8 = 0
+	StatusErr uint8 = 1
+
+	StatusNotFound uint8 = 2 // key doesn't exist
+	StatusDenied   uint8 = 3 // authentication required or permission denied
+	StatusInvalid  uint8 = 4 // unknown command or limits exceeded
+	StatusReadOnly uint8 = 5 // write sent to replica
+	StatusBusy     uint8 = 6 // server is overloaded, command can be retried later
+	StatusQuota    uint8 = 7 // write would exceed collection quota
 )
 
 // Cmd represents server command send by clients
@@ -13,21 +130,479 @@ type Cmd struct {
 	Collection uint64
 	Namespace  uint64
 	Prefix     uint64
-	Key        []byte
-	Data       []byte
+	Key        []byte `bitbox:"nocopy"`
+	Data       []byte `bitbox:"nocopy"`
+
+	// Command was received in compact encoding, see CmdCompact.
+	Compact bool `bitbox:"-"`
+}
+
+// Encode command together with length prefix
+func EncodeCmd(cmd *Cmd) []byte {
+	e := bit.NewEncoder(make([]byte, 0, PrefixLen+CmdHeaderSize+len(cmd.Key)+len(cmd.Data)))
+	cmd.Append(e)
+
+	return e.Data()
+}
+
+// Append command together with length prefix to encoder.
+// Command is sent in compact encoding if encoder is compact.
+func (cmd *Cmd) Append(e *bit.Encoder) {
+	off := e.Reserve()
+	cmd.MarshalBitbox(e)
+
+	if e.Compact {
+		e.Data()[off+PrefixLen] |= CmdCompact
+	}
+
+	e.Patch(off)
 }
 
-func DecodeCmd(buff *bit.Buffer) *Cmd {
+// Decode command received from client. Malformed commands, including
+// ones with extra bytes at the end, are rejected with error.
+func DecodeCmd(buff *bit.Buffer) (*Cmd, error) {
 	cmd := &Cmd{}
 
-	buff.Decode(
-		&cmd.Type,
-		&cmd.Collection,
-		&cmd.Namespace,
-		&cmd.Prefix,
-		&cmd.Key,
-		&cmd.Data,
-	)
+	err := cmd.Decode(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// Decode command without copying, Key and Data point into buffer data.
+func (cmd *Cmd) Decode(buff *bit.Buffer) error {
+	// Encoding of lengths must be known before they are read.
+	cmd.Compact = buff.Len() > 0 && buff.Data()[0]&CmdCompact != 0
+	buff.Compact = cmd.Compact
+
+	err := cmd.UnmarshalBitbox(buff)
+	if err != nil {
+		return fmt.Errorf("malformed command: %w", err)
+	}
+
+	if buff.Len() > 0 {
+		return fmt.Errorf("malformed command: %d extra bytes", buff.Len())
+	}
+
+	cmd.Type &^= CmdCompact
+	return nil
+}
+
+// Decode request sent in Data of command. Truncated data or extra bytes are
+// an error, so malformed request isn't run with zero values.
+func decodeData(data []byte, v any) error {
+	buf := bit.NewBuffer(data)
+
+	err := buf.Decode(v)
+	if err != nil {
+		return fmt.Errorf("malformed request: %w", err)
+	}
+
+	if buf.Len() > 0 {
+		return fmt.Errorf("malformed request: %d extra bytes", buf.Len())
+	}
+
+	return nil
+}
+
+// Number of keys returned by scan if count is not given, and max number of them.
+const (
+	ScanCount    = 100
+	ScanMaxCount = 1000
+)
+
+// Scan request, sent in Data of CmdScan.
+// Scan starts with 0 cursor and is done when 0 cursor is returned.
+type ScanReq struct {
+	Cursor uint64
+	Count  uint32
+}
+
+func (r *ScanReq) Encode() []byte {
+	return bit.Encode(r)
+}
+
+func DecodeScanReq(data []byte) (*ScanReq, error) {
+	req := &ScanReq{}
+
+	err := decodeData(data, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Replication request, sent in Data of CmdReplicate.
+type ReplicateReq struct {
+	LSN      uint64 // last lsn applied by replica, newer records are sent
+	Internal uint8  // 1 to follow internal database
+}
+
+func (r *ReplicateReq) Encode() []byte {
+	return bit.Encode(r)
+}
+
+func DecodeReplicateReq(data []byte) (*ReplicateReq, error) {
+	req := &ReplicateReq{}
+
+	err := decodeData(data, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Watch request, sent in Data of CmdWatch.
+type WatchReq struct {
+	LSN uint64 // changes after lsn are sent
+}
+
+func (r *WatchReq) Encode() []byte {
+	return bit.Encode(r)
+}
+
+func DecodeWatchReq(data []byte) (*WatchReq, error) {
+	req := &WatchReq{}
+
+	err := decodeData(data, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Conditions of CmdSet
+const (
+	SetAlways uint8 = 0
+	SetNX     uint8 = 1 // only if key doesn't exist
+	SetXX     uint8 = 2 // only if key exists
+)
+
+// Max length of content type of value
+const MaxContentType = 256
+
+// Set request, sent in Data of CmdSet.
+type SetReq struct {
+	Expire      int64 // expiration time in unix nanoseconds, 0 means never
+	Cond        uint8
+	ContentType string // media type of value, see db.Key.ContentType
+	Value       []byte `bitbox:"nocopy"`
+}
+
+func (r *SetReq) Encode() []byte {
+	return bit.Encode(r)
+}
+
+func DecodeSetReq(data []byte) (*SetReq, error) {
+	req := &SetReq{}
+
+	err := decodeData(data, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Key read by CmdLookup, sent in Data of its response.
+type LookupResp struct {
+	Value       []byte
+	Expire      int64 // expiration time in unix nanoseconds, 0 means never
+	ContentType string
+}
+
+func (r *LookupResp) Encode() []byte {
+	return bit.Encode(r)
+}
+
+func DecodeLookupResp(data []byte) *LookupResp {
+	r := &LookupResp{}
+	bit.NewBuffer(data).Decode(r)
+
+	return r
+}
+
+// Encode change events, sent in Data of CmdWatch responses.
+func EncodeEvents(events []db.Event) []byte {
+	e := bit.NewEncoder(nil)
+
+	for i := range events {
+		e.Encode(&events[i])
+	}
+
+	return e.Data()
+}
+
+func DecodeEvents(data []byte) []db.Event {
+	events := []db.Event{}
+	buf := bit.NewBuffer(data)
+
+	for buf.Len() > 0 {
+		e := db.Event{}
+		if buf.Decode(&e) != nil {
+			break
+		}
+
+		events = append(events, e)
+	}
+
+	return events
+}
+
+// Encode wal records, sent in Data of CmdReplicate responses.
+func EncodeLogs(logs [][]byte) []byte {
+	data := []byte{}
+
+	for _, log := range logs {
+		data = append(data, bit.Encode(&log)...)
+	}
+
+	return data
+}
+
+func DecodeLogs(data []byte) [][]byte {
+	logs := [][]byte{}
+	buf := bit.NewBuffer(data)
+
+	for buf.Len() > 0 {
+		log := []byte{}
+		if buf.Decode(&log) != nil {
+			break
+		}
+
+		logs = append(logs, log)
+	}
+
+	return logs
+}
+
+// Key and its value
+type Entry struct {
+	Key   []byte
+	Value []byte
+}
+
+// Scan response, sent in Data of CmdScan response.
+type ScanResp struct {
+	Cursor  uint64 // cursor for the next call
+	Entries []Entry
+}
+
+func (r *ScanResp) Encode() []byte {
+	data := bit.Encode(&r.Cursor)
+
+	for i := range r.Entries {
+		data = append(data, bit.Encode(&r.Entries[i])...)
+	}
+
+	return data
+}
+
+func DecodeScanResp(data []byte) *ScanResp {
+	resp := &ScanResp{}
+
+	buf := bit.NewBuffer(data)
+	buf.Decode(&resp.Cursor)
+
+	for buf.Len() > 0 {
+		e := Entry{}
+		if e.UnmarshalBitbox(buf) != nil {
+			break
+		}
+
+		resp.Entries = append(resp.Entries, e)
+	}
+
+	return resp
+}
+
+// Encode collection hashes, sent in Data of CmdCollections response.
+func EncodeCollections(hashes []uint64) []byte {
+	data := []byte{}
+
+	for _, h := range hashes {
+		data = append(data, bit.Encode(&h)...)
+	}
+
+	return data
+}
+
+func DecodeCollections(data []byte) []uint64 {
+	hashes := []uint64{}
+	buf := bit.NewBuffer(data)
+
+	for buf.Len() > 0 {
+		h := uint64(0)
+		if buf.Decode(&h) != nil {
+			break
+		}
+
+		hashes = append(hashes, h)
+	}
+
+	return hashes
+}
+
+// Server statistics, sent in Data of CmdStats response.
+type Stats struct {
+	Uptime      int64  // seconds since server start
+	Connections uint32 // open connections
+	Workers     uint32 // number of file workers
+	Collections uint64 // number of collections
+	Buckets     uint64 // number of bucket files
+	Size        uint64 // size of bucket files in bytes
+	LSN         uint64 // last log sequence number
+}
+
+func (s *Stats) Encode() []byte {
+	return bit.Encode(s)
+}
+
+func DecodeStats(data []byte) *Stats {
+	s := &Stats{}
+	bit.NewBuffer(data).Decode(s)
+
+	return s
+}
+
+// Quota and usage of collection
+type QuotaInfo struct {
+	Quota db.Quota
+	Usage db.Usage
+}
+
+func (q *QuotaInfo) Encode() []byte {
+	return bit.Encode(q)
+}
+
+func DecodeQuotaInfo(data []byte) *QuotaInfo {
+	q := &QuotaInfo{}
+	bit.NewBuffer(data).Decode(q)
+
+	return q
+}
+
+func EncodeQuota(q *db.Quota) []byte {
+	return bit.Encode(q)
+}
+
+func DecodeQuota(data []byte) (db.Quota, error) {
+	q := db.Quota{}
+	err := decodeData(data, &q)
+
+	return q, err
+}
+
+// Command that took longer than Server.SlowThreshold.
+type SlowEntry struct {
+	ID       uint64 // increasing id of entry
+	Time     int64  // start of command in unix nanoseconds
+	Addr     string // client address
+	Cmd      uint8
+	Key      []byte
+	Duration time.Duration // total time of command
+
+	// Time spent waiting for worker and in parts of database operation.
+	Queue  time.Duration
+	Index  time.Duration
+	Blocks time.Duration
+	Wal    time.Duration
+}
+
+// Slow log request, sent in Data of CmdSlowLog.
+type SlowLogReq struct {
+	Count uint32 // number of returned entries, 0 means all of them
+	Reset uint8  // 1 to clear slow log instead
+}
+
+func (r *SlowLogReq) Encode() []byte {
+	return bit.Encode(r)
+}
+
+func DecodeSlowLogReq(data []byte) (*SlowLogReq, error) {
+	req := &SlowLogReq{}
+
+	err := decodeData(data, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Encode slow log entries, sent in Data of CmdSlowLog response.
+func EncodeSlowLog(entries []SlowEntry) []byte {
+	data := []byte{}
+
+	for i := range entries {
+		data = append(data, bit.Encode(&entries[i])...)
+	}
+
+	return data
+}
+
+func DecodeSlowLog(data []byte) []SlowEntry {
+	entries := []SlowEntry{}
+	buf := bit.NewBuffer(data)
+
+	for buf.Len() > 0 {
+		e := SlowEntry{}
+		if e.UnmarshalBitbox(buf) != nil {
+			break
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// Resp represents server response to command
+type Resp struct {
+	Status uint8
+	Data   []byte
+
+	// Set by workers, not sent to clients.
+	trace *cmdTrace
+}
+
+// Encode response together with length prefix
+func (r *Resp) Encode() []byte {
+	e := bit.NewEncoder(make([]byte, 0, 9+len(r.Data)))
+	r.Append(e)
+
+	return e.Data()
+}
+
+// Append response together with length prefix to encoder.
+func (r *Resp) Append(e *bit.Encoder) {
+	off := e.Reserve()
+	r.MarshalBitbox(e)
+	e.Patch(off)
+}
+
+func DecodeResp(buff *bit.Buffer) *Resp {
+	resp := &Resp{}
+	resp.UnmarshalBitbox(buff)
+
+	return resp
+}
+
+// Create error response
+func errResp(err error) *Resp {
+	if errors.Is(err, db.ErrQuotaExceeded) {
+		return &Resp{Status: StatusQuota, Data: []byte(err.Error())}
+	}
+
+	return &Resp{Status: StatusErr, Data: []byte(err.Error())}
+}
 
-	return cmd
+// Create response for invalid command
+func invalidResp(format string, args ...any) *Resp {
+	return &Resp{Status: StatusInvalid, Data: []byte(fmt.Sprintf(format, args...))}
 }
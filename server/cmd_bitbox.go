@@ -0,0 +1,484 @@
+// Code generated by bitbox-gen. DO NOT EDIT.
+
+package server
+
+import bit "bytedb/lib/bitbox"
+
+func (v *Cmd) MarshalBitbox(e *bit.Encoder) {
+	e.Uint8(v.Type)
+	e.Uint64(v.Collection)
+	e.Uint64(v.Namespace)
+	e.Uint64(v.Prefix)
+	e.Bytes(v.Key)
+	e.Bytes(v.Data)
+}
+
+func (v *Cmd) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint8()
+		if err != nil {
+			return err
+		}
+
+		v.Type = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Collection = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Namespace = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Prefix = x
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Key = x
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Data = x
+	}
+
+	return nil
+}
+
+func (v *ScanReq) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(v.Cursor)
+	e.Uint32(v.Count)
+}
+
+func (v *ScanReq) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Cursor = x
+	}
+
+	{
+		x, err := b.Uint32()
+		if err != nil {
+			return err
+		}
+
+		v.Count = x
+	}
+
+	return nil
+}
+
+func (v *ReplicateReq) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(v.LSN)
+	e.Uint8(v.Internal)
+}
+
+func (v *ReplicateReq) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.LSN = x
+	}
+
+	{
+		x, err := b.Uint8()
+		if err != nil {
+			return err
+		}
+
+		v.Internal = x
+	}
+
+	return nil
+}
+
+func (v *WatchReq) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(v.LSN)
+}
+
+func (v *WatchReq) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.LSN = x
+	}
+
+	return nil
+}
+
+func (v *SetReq) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(uint64(v.Expire))
+	e.Uint8(v.Cond)
+	e.String(v.ContentType)
+	e.Bytes(v.Value)
+}
+
+func (v *SetReq) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Expire = int64(x)
+	}
+
+	{
+		x, err := b.Uint8()
+		if err != nil {
+			return err
+		}
+
+		v.Cond = x
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.ContentType = string(x)
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Value = x
+	}
+
+	return nil
+}
+
+func (v *LookupResp) MarshalBitbox(e *bit.Encoder) {
+	e.Bytes(v.Value)
+	e.Uint64(uint64(v.Expire))
+	e.String(v.ContentType)
+}
+
+func (v *LookupResp) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Value = append([]byte(nil), x...)
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Expire = int64(x)
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.ContentType = string(x)
+	}
+
+	return nil
+}
+
+func (v *Entry) MarshalBitbox(e *bit.Encoder) {
+	e.Bytes(v.Key)
+	e.Bytes(v.Value)
+}
+
+func (v *Entry) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Key = append([]byte(nil), x...)
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Value = append([]byte(nil), x...)
+	}
+
+	return nil
+}
+
+func (v *Stats) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(uint64(v.Uptime))
+	e.Uint32(v.Connections)
+	e.Uint32(v.Workers)
+	e.Uint64(v.Collections)
+	e.Uint64(v.Buckets)
+	e.Uint64(v.Size)
+	e.Uint64(v.LSN)
+}
+
+func (v *Stats) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Uptime = int64(x)
+	}
+
+	{
+		x, err := b.Uint32()
+		if err != nil {
+			return err
+		}
+
+		v.Connections = x
+	}
+
+	{
+		x, err := b.Uint32()
+		if err != nil {
+			return err
+		}
+
+		v.Workers = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Collections = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Buckets = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Size = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.LSN = x
+	}
+
+	return nil
+}
+
+func (v *QuotaInfo) MarshalBitbox(e *bit.Encoder) {
+	e.Encode(&v.Quota)
+	e.Encode(&v.Usage)
+}
+
+func (v *QuotaInfo) UnmarshalBitbox(b *bit.Buffer) error {
+	if err := b.Decode(&v.Quota); err != nil {
+		return err
+	}
+
+	if err := b.Decode(&v.Usage); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (v *SlowEntry) MarshalBitbox(e *bit.Encoder) {
+	e.Uint64(v.ID)
+	e.Uint64(uint64(v.Time))
+	e.String(v.Addr)
+	e.Uint8(v.Cmd)
+	e.Bytes(v.Key)
+	e.Encode(&v.Duration)
+	e.Encode(&v.Queue)
+	e.Encode(&v.Index)
+	e.Encode(&v.Blocks)
+	e.Encode(&v.Wal)
+}
+
+func (v *SlowEntry) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.ID = x
+	}
+
+	{
+		x, err := b.Uint64()
+		if err != nil {
+			return err
+		}
+
+		v.Time = int64(x)
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Addr = string(x)
+	}
+
+	{
+		x, err := b.Uint8()
+		if err != nil {
+			return err
+		}
+
+		v.Cmd = x
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Key = append([]byte(nil), x...)
+	}
+
+	if err := b.Decode(&v.Duration); err != nil {
+		return err
+	}
+
+	if err := b.Decode(&v.Queue); err != nil {
+		return err
+	}
+
+	if err := b.Decode(&v.Index); err != nil {
+		return err
+	}
+
+	if err := b.Decode(&v.Blocks); err != nil {
+		return err
+	}
+
+	if err := b.Decode(&v.Wal); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (v *SlowLogReq) MarshalBitbox(e *bit.Encoder) {
+	e.Uint32(v.Count)
+	e.Uint8(v.Reset)
+}
+
+func (v *SlowLogReq) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint32()
+		if err != nil {
+			return err
+		}
+
+		v.Count = x
+	}
+
+	{
+		x, err := b.Uint8()
+		if err != nil {
+			return err
+		}
+
+		v.Reset = x
+	}
+
+	return nil
+}
+
+func (v *Resp) MarshalBitbox(e *bit.Encoder) {
+	e.Uint8(v.Status)
+	e.Bytes(v.Data)
+}
+
+func (v *Resp) UnmarshalBitbox(b *bit.Buffer) error {
+	{
+		x, err := b.Uint8()
+		if err != nil {
+			return err
+		}
+
+		v.Status = x
+	}
+
+	{
+		x, err := b.Bytes()
+		if err != nil {
+			return err
+		}
+
+		v.Data = append([]byte(nil), x...)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	bit "bytedb/lib/bitbox"
+	"bytedb/tests"
+	"context"
+	"testing"
+)
+
+func TestCmdEncode(t *testing.T) {
+	cmd := &Cmd{Type: CmdAdd, Collection: 1, Namespace: 2, Prefix: 3, Key: []byte("key_1"), Data: []byte("val_1")}
+
+	data := EncodeCmd(cmd)
+
+	// Frame has the same format as reflection encoding
+	tests.AssertEqual(t, bit.Encode(bit.Encode(&cmd.Type, &cmd.Collection, &cmd.Namespace, &cmd.Prefix, &cmd.Key, &cmd.Data)), data)
+
+	cmd2, err := DecodeCmd(bit.NewBuffer(data[PrefixLen:]))
+	tests.Assert(t, nil, err)
+	tests.AssertEqual(t, cmd, cmd2)
+}
+
+func TestCmdAllocs(t *testing.T) {
+	cmd := &Cmd{Type: CmdAdd, Collection: 1, Key: []byte("key_1"), Data: make([]byte, 100)}
+
+	e := bit.GetEncoder()
+	defer bit.PutEncoder(e)
+
+	buf := bit.NewBuffer(nil)
+	cmd2 := &Cmd{}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		e.Reset()
+		cmd.Append(e)
+
+		buf.Reset(e.Data()[PrefixLen:])
+		cmd2.Decode(buf)
+	})
+
+	tests.Assert(t, 0.0, allocs)
+}
+
+func BenchmarkEncodeCmd(b *testing.B) {
+	cmd := &Cmd{Type: CmdAdd, Collection: 1, Key: []byte("key_1"), Data: make([]byte, 100)}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		e := bit.GetEncoder()
+		cmd.Append(e)
+		bit.PutEncoder(e)
+	}
+}
+
+func BenchmarkDecodeCmd(b *testing.B) {
+	data := EncodeCmd(&Cmd{Type: CmdAdd, Collection: 1, Key: []byte("key_1"), Data: make([]byte, 100)})
+
+	buf := bit.NewBuffer(nil)
+	cmd := &Cmd{}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset(data[PrefixLen:])
+		cmd.Decode(buf)
+	}
+}
+
+func TestCmdCompact(t *testing.T) {
+	cmd := &Cmd{Type: CmdAdd, Collection: 1, Key: []byte("key_1"), Data: []byte("val_1")}
+
+	e := bit.NewEncoder(nil)
+	e.Compact = true
+	cmd.Append(e)
+
+	// Lengths of key and data take 1 byte instead of 4
+	tests.Assert(t, len(EncodeCmd(cmd))-6, e.Len())
+
+	cmd2 := &Cmd{}
+	tests.Assert(t, nil, cmd2.Decode(bit.NewBuffer(e.Data()[PrefixLen:])))
+	tests.Assert(t, CmdAdd, cmd2.Type)
+	tests.Assert(t, true, cmd2.Compact)
+	tests.AssertEqual(t, cmd.Key, cmd2.Key)
+	tests.AssertEqual(t, cmd.Data, cmd2.Data)
+}
+
+func TestClientCompact(t *testing.T) {
+	_, addr := runServer(t)
+	ctx := context.Background()
+
+	opts := DefaultClientOptions()
+	opts.Compact = true
+
+	cli, err := NewClientWith(addr, opts)
+	tests.Assert(t, nil, err)
+	defer cli.Close()
+
+	key := NewKeyRef("test", "cmd", "prefix", []byte("key_1"))
+
+	tests.Assert(t, nil, cli.Add(ctx, key, []byte("Hello")))
+
+	val, err := cli.Get(ctx, key)
+	tests.Assert(t, nil, err)
+	tests.AssertEqual(t, []byte("Hello"), val)
+
+	// Standard clients are served at the same time
+	cli2, _ := NewClient(addr)
+	defer cli2.Close()
+
+	val, _ = cli2.Get(ctx, key)
+	tests.AssertEqual(t, []byte("Hello"), val)
+}
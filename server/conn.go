@@ -1,36 +1,69 @@
 package server
 
 import (
-	bit "bytedb/lib/bitbox"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"time"
 )
 
 const PrefixLen = 4
 
+var ErrFrameTooBig = errors.New("message too big")
+
 // User connection. Wrapper for net.Conn.
 type Conn struct {
 	conn net.Conn
-	Resp chan *[]byte
+	Resp chan *Resp
+
+	// Authenticated user, nil until AUTH succeeds.
+	User *User
+	auth session
+
+	// Address of client without network connection (HTTP requests).
+	addr string
 }
 
 func NewConn(conn net.Conn) *Conn {
-	c := &Conn{conn: conn, Resp: make(chan *[]byte)}
+	// Buffered, so worker never blocks on connection that is gone.
+	c := &Conn{conn: conn, Resp: make(chan *Resp, 1)}
 	return c
 }
 
 // Connect to tcp server,
 // Address should be in "ip:port" format
 func Connect(address string) (*Conn, error) {
-	con, err := net.Dial("tcp", address)
+	return ConnectContext(context.Background(), address, nil)
+}
+
+// Connect to tcp server using TLS.
+// Address should be in "ip:port" format
+func ConnectTLS(address string, cfg *tls.Config) (*Conn, error) {
+	return ConnectContext(context.Background(), address, cfg)
+}
+
+// Connect to tcp server, giving up when ctx is done.
+// TLS is used if cfg is not nil.
+func ConnectContext(ctx context.Context, address string, cfg *tls.Config) (*Conn, error) {
+	var con net.Conn
+	var err error
+
+	if cfg == nil {
+		con, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	} else {
+		con, err = (&tls.Dialer{Config: cfg}).DialContext(ctx, "tcp", address)
+	}
+
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	conn := &Conn{conn: con}
-	return conn, nil
+	return NewConn(con), nil
 }
 
 // Create Conn from file descriptor
@@ -38,47 +71,69 @@ func FromFD(fd int) *Conn {
 	file := os.NewFile(uintptr(fd), "")
 	conn, _ := net.FileConn(file)
 
-	return &Conn{conn: conn}
+	return NewConn(conn)
 }
 
-// Read from connection, blocking until all data is read.
-func (c *Conn) Read(buf []byte) (int, error) {
-	size := uint32(0)
+// Read single length prefixed message, blocking until all of it is read.
+// Messages bigger than max are rejected.
+func (c *Conn) ReadFrame(max int) ([]byte, error) {
+	prefix := [PrefixLen]byte{}
 
-	// fast path - try to read all at once
-	n, err := c.conn.Read(buf)
+	_, err := io.ReadFull(c.conn, prefix[:])
 	if err != nil {
-		return 0, err
-	}
-
-	if n < PrefixLen {
-		return 0, fmt.Errorf("not enough bytes to read pkg size: got %d bytes need %d", n, PrefixLen)
+		return nil, err
 	}
 
-	// decode msg size
-	buff := bit.NewBuffer(buf[:PrefixLen])
-	bit.Decode(buff, &size)
+	size := binary.LittleEndian.Uint32(prefix[:])
 
-	// check if we read all data
-	if uint32(n-PrefixLen) == size {
-		return n, nil // success
+	if int64(size) > int64(max) {
+		return nil, fmt.Errorf("%w: %d bytes, max %d", ErrFrameTooBig, size, max)
 	}
 
-	// we didn't get all data, try to read remaining bytes
-	total := make([]byte, size)
-	offset := copy(total, buf[PrefixLen:n])
+	buf := make([]byte, size)
 
-	n, err = io.ReadFull(c.conn, total[offset:])
+	_, err = io.ReadFull(c.conn, buf)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return n, nil // success
+	readBytes.Add(uint64(PrefixLen + size))
+	return buf, nil
+}
+
+// Read data from connection, used by stream protocols.
+func (c *Conn) Read(buf []byte) (int, error) {
+	n, err := c.conn.Read(buf)
+	readBytes.Add(uint64(n))
+
+	return n, err
 }
 
 // Write data to connection, blocking until done.
 func (c *Conn) Write(buf []byte) (int, error) {
-	return c.conn.Write(buf)
+	n, err := c.conn.Write(buf)
+	writtenBytes.Add(uint64(n))
+
+	return n, err
+}
+
+// Set deadline for pending and future reads and writes.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Set deadline for pending and future reads.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Return address of remote end
+func (c *Conn) RemoteAddr() string {
+	if c.conn == nil {
+		return c.addr
+	}
+
+	return c.conn.RemoteAddr().String()
 }
 
 // Close connection
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Limits for batch requests
+const (
+	HTTPMaxBatch     = 1000     // max number of operations
+	HTTPMaxBatchSize = 32 << 20 // max body size
+)
+
+// HTTP gateway. Requests are converted to commands and run with
+// Server.Exec, the same way as commands sent with binary protocol.
+//
+//	GET    /v1/{collection}/{namespace}/{prefix}/{key}
+//	PUT    /v1/{collection}/{namespace}/{prefix}/{key}
+//	DELETE /v1/{collection}/{namespace}/{prefix}/{key}
+//	GET    /v1/{collection}/{namespace}/{prefix}?scan={cursor}&count={count}
+//	POST   /v1/batch
+//
+// Values are sent and returned as raw bytes, in JSON they are base64 encoded.
+// Content type of PUT is stored with value and returned by GET, values
+// written by other protocols or batch are application/octet-stream.
+// When authentication is enabled, clients send token as "Bearer" or user
+// and password with "Basic" authorization.
+type HTTP struct {
+	Server *Server
+	mux    *http.ServeMux
+}
+
+// Single operation of batch request
+type BatchOp struct {
+	Op         string `json:"op"` // get, put or delete
+	Collection string `json:"collection"`
+	Namespace  string `json:"namespace"`
+	Prefix     string `json:"prefix"`
+	Key        []byte `json:"key"`
+	Value      []byte `json:"value,omitempty"`
+}
+
+// Result of batch operation, Status is HTTP status code.
+type BatchResult struct {
+	Status int    `json:"status"`
+	Value  []byte `json:"value,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchReq struct {
+	Ops []BatchOp `json:"ops"`
+}
+
+type batchResp struct {
+	Results []BatchResult `json:"results"`
+}
+
+type scanEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type scanResp struct {
+	Cursor  string      `json:"cursor"` // string, so it's not rounded by JSON parsers
+	Entries []scanEntry `json:"entries"`
+}
+
+func NewHTTP(srv *Server) *HTTP {
+	h := &HTTP{Server: srv, mux: http.NewServeMux()}
+
+	h.mux.HandleFunc("GET /v1/{collection}/{namespace}/{prefix}/{key}", h.get)
+	h.mux.HandleFunc("PUT /v1/{collection}/{namespace}/{prefix}/{key}", h.put)
+	h.mux.HandleFunc("DELETE /v1/{collection}/{namespace}/{prefix}/{key}", h.delete)
+	h.mux.HandleFunc("GET /v1/{collection}/{namespace}/{prefix}", h.scan)
+	h.mux.HandleFunc("POST /v1/batch", h.batch)
+
+	return h
+}
+
+func (h *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *HTTP) get(w http.ResponseWriter, r *http.Request) {
+	conn, ok := h.conn(w, r)
+	if !ok {
+		return
+	}
+
+	resp := h.Server.Exec(conn, h.cmd(r, CmdLookup, nil))
+	if resp.Status != StatusOK {
+		h.error(w, conn, resp)
+		return
+	}
+
+	kv := DecodeLookupResp(resp.Data)
+
+	ctype := kv.ContentType
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(kv.Value)
+}
+
+func (h *HTTP) put(w http.ResponseWriter, r *http.Request) {
+	conn, ok := h.conn(w, r)
+	if !ok {
+		return
+	}
+
+	val, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(h.Server.MaxValueSize)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	req := &SetReq{Cond: SetAlways, ContentType: r.Header.Get("Content-Type"), Value: val}
+
+	resp := h.Server.Exec(conn, h.cmd(r, CmdSet, req.Encode()))
+	if resp.Status != StatusOK {
+		h.error(w, conn, resp)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTP) delete(w http.ResponseWriter, r *http.Request) {
+	conn, ok := h.conn(w, r)
+	if !ok {
+		return
+	}
+
+	resp := h.Server.Exec(conn, h.cmd(r, CmdDelete, nil))
+	if resp.Status != StatusOK {
+		h.error(w, conn, resp)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTP) scan(w http.ResponseWriter, r *http.Request) {
+	conn, ok := h.conn(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	if !query.Has("scan") {
+		http.Error(w, "scan cursor is required", http.StatusBadRequest)
+		return
+	}
+
+	req := &ScanReq{}
+	var err error
+
+	if query.Get("scan") != "" {
+		req.Cursor, err = strconv.ParseUint(query.Get("scan"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid scan cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if query.Has("count") {
+		count, err := strconv.ParseUint(query.Get("count"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid count", http.StatusBadRequest)
+			return
+		}
+
+		req.Count = uint32(count)
+	}
+
+	resp := h.Server.Exec(conn, h.cmd(r, CmdScan, req.Encode()))
+	if resp.Status != StatusOK {
+		h.error(w, conn, resp)
+		return
+	}
+
+	scan := DecodeScanResp(resp.Data)
+	res := &scanResp{Cursor: strconv.FormatUint(scan.Cursor, 10), Entries: []scanEntry{}}
+
+	for _, e := range scan.Entries {
+		res.Entries = append(res.Entries, scanEntry{Key: e.Key, Value: e.Value})
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *HTTP) batch(w http.ResponseWriter, r *http.Request) {
+	conn, ok := h.conn(w, r)
+	if !ok {
+		return
+	}
+
+	req := &batchReq{}
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, HTTPMaxBatchSize)).Decode(req)
+	if err != nil {
+		http.Error(w, "invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Ops) > HTTPMaxBatch {
+		http.Error(w, "too many operations, max "+strconv.Itoa(HTTPMaxBatch), http.StatusBadRequest)
+		return
+	}
+
+	res := &batchResp{Results: []BatchResult{}}
+
+	for _, op := range req.Ops {
+		cmd := &Cmd{
+			Collection: Hash([]byte(op.Collection)),
+			Namespace:  Hash([]byte(op.Namespace)),
+			Prefix:     Hash([]byte(op.Prefix)),
+			Key:        op.Key,
+		}
+
+		switch op.Op {
+		case "get":
+			cmd.Type = CmdGet
+		case "put":
+			cmd.Type, cmd.Data = CmdAdd, op.Value
+		case "delete":
+			cmd.Type = CmdDelete
+		default:
+			res.Results = append(res.Results, BatchResult{Status: http.StatusBadRequest, Error: "unknown op: " + op.Op})
+			continue
+		}
+
+		resp := h.Server.Exec(conn, cmd)
+		result := BatchResult{Status: httpStatus(conn, resp)}
+
+		if resp.Status == StatusOK && cmd.Type == CmdGet {
+			result.Value = resp.Data
+		}
+
+		if resp.Status != StatusOK {
+			result.Error = string(resp.Data)
+		}
+
+		res.Results = append(res.Results, result)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Build command from request path
+func (h *HTTP) cmd(r *http.Request, typ uint8, data []byte) *Cmd {
+	cmd := &Cmd{
+		Type:       typ,
+		Collection: Hash([]byte(r.PathValue("collection"))),
+		Namespace:  Hash([]byte(r.PathValue("namespace"))),
+		Prefix:     Hash([]byte(r.PathValue("prefix"))),
+		Key:        []byte(r.PathValue("key")),
+		Data:       data,
+	}
+
+	return cmd
+}
+
+// Create connection for request and authenticate its user.
+// Error is sent to client if authentication fails.
+func (h *HTTP) conn(w http.ResponseWriter, r *http.Request) (*Conn, bool) {
+	conn := NewConn(nil)
+	conn.addr = r.RemoteAddr
+	auth := h.Server.Auth
+
+	if auth == nil {
+		return conn, true
+	}
+
+	var err error = ErrAuthRequired
+
+	if user, password, ok := r.BasicAuth(); ok {
+		err = auth.Authenticate(conn, user, []byte(password))
+	}
+
+	if token, ok := bearerToken(r); ok {
+		err = auth.Authenticate(conn, "", []byte(token))
+	}
+
+	if errors.Is(err, ErrAuthRequired) || errors.Is(err, ErrAuthFailed) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="bytedb"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return conn, true
+}
+
+// Send error response
+func (h *HTTP) error(w http.ResponseWriter, conn *Conn, resp *Resp) {
+	msg := string(resp.Data)
+	if resp.Status == StatusNotFound {
+		msg = "key not found"
+	}
+
+	http.Error(w, msg, httpStatus(conn, resp))
+}
+
+// Return HTTP status code for command response
+func httpStatus(conn *Conn, resp *Resp) int {
+	switch resp.Status {
+	case StatusOK:
+		return http.StatusOK
+	case StatusNotFound:
+		return http.StatusNotFound
+	case StatusInvalid:
+		return http.StatusBadRequest
+	case StatusReadOnly:
+		return http.StatusMethodNotAllowed
+	case StatusBusy:
+		return http.StatusServiceUnavailable
+	case StatusQuota:
+		return http.StatusInsufficientStorage
+	case StatusDenied:
+		if conn.User == nil {
+			return http.StatusUnauthorized
+		}
+
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Return token from "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || header[:len(prefix)] != prefix {
+		return "", false
+	}
+
+	return header[len(prefix):], true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
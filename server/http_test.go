@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytedb/db"
+	"bytedb/tests"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func runHTTP(t *testing.T) (*Server, func(method, url, body string) *httptest.ResponseRecorder) {
+	database, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(database)
+	srv.RunWorkers(2)
+	t.Cleanup(func() { srv.Close() })
+
+	h := NewHTTP(srv)
+
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+
+		return w
+	}
+
+	return srv, send
+}
+
+func TestHTTPKey(t *testing.T) {
+	_, send := runHTTP(t)
+
+	w := send("PUT", "/v1/users/eu/active/john", "hello")
+	tests.Assert(t, http.StatusNoContent, w.Code)
+
+	w = send("GET", "/v1/users/eu/active/john", "")
+	tests.Assert(t, http.StatusOK, w.Code)
+	tests.Assert(t, "hello", w.Body.String())
+
+	// Keys can contain escaped slashes
+	send("PUT", "/v1/users/eu/active/a%2Fb", "1")
+
+	w = send("GET", "/v1/users/eu/active/a%2Fb", "")
+	tests.Assert(t, "1", w.Body.String())
+
+	w = send("DELETE", "/v1/users/eu/active/john", "")
+	tests.Assert(t, http.StatusNoContent, w.Code)
+
+	w = send("GET", "/v1/users/eu/active/john", "")
+	tests.Assert(t, http.StatusNotFound, w.Code)
+
+	w = send("DELETE", "/v1/users/eu/active/john", "")
+	tests.Assert(t, http.StatusNotFound, w.Code)
+}
+
+func TestHTTPContentType(t *testing.T) {
+	srv, send := runHTTP(t)
+	h := NewHTTP(srv)
+
+	put := func(ctype string) int {
+		r := httptest.NewRequest("PUT", "/v1/users/eu/active/john", strings.NewReader("{}"))
+		if ctype != "" {
+			r.Header.Set("Content-Type", ctype)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	lsn := srv.DB.Internals().LSN()
+	tests.Assert(t, http.StatusNoContent, put("application/json"))
+
+	w := send("GET", "/v1/users/eu/active/john", "")
+	tests.Assert(t, "application/json", w.Header().Get("Content-Type"))
+	tests.Assert(t, "{}", w.Body.String())
+
+	// Type is stored with value, internal database isn't changed
+	tests.Assert(t, lsn, srv.DB.Internals().LSN())
+
+	// Value written by other protocol has no type, even the same one
+	key := db.NewKey([]byte("john"), []byte("{}"))
+	key.Collection, key.Namespace, key.Prefix = Hash([]byte("users")), Hash([]byte("eu")), Hash([]byte("active"))
+
+	srv.DB.DeleteKey(key)
+	srv.DB.Put(key)
+
+	w = send("GET", "/v1/users/eu/active/john", "")
+	tests.Assert(t, "application/octet-stream", w.Header().Get("Content-Type"))
+
+	put("text/plain")
+	put("")
+
+	w = send("GET", "/v1/users/eu/active/john", "")
+	tests.Assert(t, "application/octet-stream", w.Header().Get("Content-Type"))
+
+	tests.Assert(t, http.StatusBadRequest, put(strings.Repeat("x", MaxContentType+1)))
+}
+
+func TestHTTPScan(t *testing.T) {
+	_, send := runHTTP(t)
+
+	for i := 0; i < 25; i++ {
+		send("PUT", fmt.Sprintf("/v1/users/eu/active/user_%d", i), "1")
+	}
+
+	found := 0
+	cursor := "0"
+
+	for {
+		w := send("GET", "/v1/users/eu/active?scan="+cursor+"&count=10", "")
+		tests.Assert(t, http.StatusOK, w.Code)
+
+		res := &scanResp{}
+		json.Unmarshal(w.Body.Bytes(), res)
+
+		found += len(res.Entries)
+		cursor = res.Cursor
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	tests.Assert(t, 25, found)
+
+	w := send("GET", "/v1/users/eu/active", "")
+	tests.Assert(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHTTPBatch(t *testing.T) {
+	_, send := runHTTP(t)
+
+	body := `{"ops": [
+		{"op": "put", "collection": "c", "namespace": "n", "prefix": "p", "key": "a2V5", "value": "dmFs"},
+		{"op": "get", "collection": "c", "namespace": "n", "prefix": "p", "key": "a2V5"},
+		{"op": "delete", "collection": "c", "namespace": "n", "prefix": "p", "key": "bWlzc2luZw=="},
+		{"op": "merge"}
+	]}`
+
+	w := send("POST", "/v1/batch", body)
+	tests.Assert(t, http.StatusOK, w.Code)
+
+	res := &batchResp{}
+	json.Unmarshal(w.Body.Bytes(), res)
+
+	tests.Assert(t, 4, len(res.Results))
+	tests.Assert(t, http.StatusOK, res.Results[0].Status)
+	tests.Assert(t, "val", string(res.Results[1].Value))
+	tests.Assert(t, http.StatusNotFound, res.Results[2].Status)
+	tests.Assert(t, http.StatusBadRequest, res.Results[3].Status)
+}
+
+func TestHTTPAuth(t *testing.T) {
+	srv, send := runHTTP(t)
+
+	srv.Auth = NewAuth(srv.DB.Internals())
+	srv.Auth.AddUser("john", "")
+	srv.Auth.Grant("john", Rule{Collection: Hash([]byte("users")), Namespace: Any, Perm: PermRead})
+
+	token, _ := srv.Auth.NewToken("john")
+
+	w := send("GET", "/v1/users/eu/active/john", "")
+	tests.Assert(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest("GET", "/v1/users/eu/active/john", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	NewHTTP(srv).ServeHTTP(w, req)
+	tests.Assert(t, http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest("PUT", "/v1/users/eu/active/john", strings.NewReader("1"))
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w = httptest.NewRecorder()
+	NewHTTP(srv).ServeHTTP(w, req)
+	tests.Assert(t, http.StatusForbidden, w.Code)
+}
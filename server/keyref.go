@@ -0,0 +1,154 @@
+package server
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidKeyRef = errors.New("invalid key reference")
+
+// Full path to a key. Collection, namespace and prefix are names,
+// server gets their hashes. Key can be any byte sequence.
+type KeyRef struct {
+	Collection string
+	Namespace  string
+	Prefix     string
+	Key        []byte
+}
+
+// Create reference to key.
+func NewKeyRef(collection, namespace, prefix string, key []byte) KeyRef {
+	return KeyRef{Collection: collection, Namespace: namespace, Prefix: prefix, Key: key}
+}
+
+// Create reference to prefix, without key. Used for scans
+// and as base for keys with the same prefix:
+//
+//	users := server.PrefixRef("users", "eu", "active")
+//	cli.Get(ctx, users.With([]byte("john")))
+func PrefixRef(collection, namespace, prefix string) KeyRef {
+	return KeyRef{Collection: collection, Namespace: namespace, Prefix: prefix}
+}
+
+// Return copy of reference with different key.
+func (r KeyRef) With(key []byte) KeyRef {
+	r.Key = key
+	return r
+}
+
+// Return copy of reference with different string key.
+func (r KeyRef) WithString(key string) KeyRef {
+	return r.With([]byte(key))
+}
+
+// Create command for referenced key.
+func (r KeyRef) cmd(typ uint8) *Cmd {
+	return &Cmd{
+		Type:       typ,
+		Collection: Hash([]byte(r.Collection)),
+		Namespace:  Hash([]byte(r.Namespace)),
+		Prefix:     Hash([]byte(r.Prefix)),
+		Key:        r.Key,
+	}
+}
+
+// Return reference in "coll::namespace::prefix::key" form. Colons and
+// backslashes are escaped with backslash, other bytes outside printable
+// ASCII as \xHH, so ParseKeyRef returns the same reference for any key.
+func (r KeyRef) String() string {
+	var s strings.Builder
+
+	for i, part := range [][]byte{[]byte(r.Collection), []byte(r.Namespace), []byte(r.Prefix), r.Key} {
+		if i > 0 {
+			s.WriteString("::")
+		}
+
+		escape(&s, part)
+	}
+
+	return s.String()
+}
+
+// Parse "coll::namespace::prefix::key" string. Every part can use
+// \:, \\ and \xHH escapes, unescaped "::" separates parts.
+func ParseKeyRef(str string) (KeyRef, error) {
+	parts := [][]byte{}
+	part := []byte{}
+
+	for i := 0; i < len(str); i++ {
+		switch {
+		case str[i] == '\\':
+			if i+1 >= len(str) {
+				return KeyRef{}, ErrInvalidKeyRef
+			}
+
+			i++
+
+			switch str[i] {
+			case '\\', ':':
+				part = append(part, str[i])
+			case 'x':
+				if i+2 >= len(str) {
+					return KeyRef{}, ErrInvalidKeyRef
+				}
+
+				hi, ok1 := unhex(str[i+1])
+				lo, ok2 := unhex(str[i+2])
+				if !ok1 || !ok2 {
+					return KeyRef{}, ErrInvalidKeyRef
+				}
+
+				part = append(part, hi<<4|lo)
+				i += 2
+			default:
+				return KeyRef{}, ErrInvalidKeyRef
+			}
+		case str[i] == ':' && i+1 < len(str) && str[i+1] == ':':
+			parts = append(parts, part)
+			part = []byte{}
+			i++
+		default:
+			part = append(part, str[i])
+		}
+	}
+
+	parts = append(parts, part)
+
+	if len(parts) != 4 {
+		return KeyRef{}, ErrInvalidKeyRef
+	}
+
+	return NewKeyRef(string(parts[0]), string(parts[1]), string(parts[2]), parts[3]), nil
+}
+
+// Write escaped part of key reference
+func escape(s *strings.Builder, part []byte) {
+	const digits = "0123456789abcdef"
+
+	for _, b := range part {
+		switch {
+		case b == '\\' || b == ':':
+			s.WriteByte('\\')
+			s.WriteByte(b)
+		case b < 0x20 || b > 0x7e:
+			s.WriteString(`\x`)
+			s.WriteByte(digits[b>>4])
+			s.WriteByte(digits[b&0xf])
+		default:
+			s.WriteByte(b)
+		}
+	}
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytedb/tests"
+	"math/rand"
+	"testing"
+)
+
+func TestKeyRefString(t *testing.T) {
+	ref := NewKeyRef("users", "eu", "active", []byte("john"))
+	tests.Assert(t, "users::eu::active::john", ref.String())
+
+	ref = NewKeyRef("a::b", "c:d", `e\f`, []byte{0, 'k', 0xff})
+	tests.Assert(t, `a\:\:b::c\:d::e\\f::\x00k\xff`, ref.String())
+}
+
+func TestParseKeyRef(t *testing.T) {
+	ref, err := ParseKeyRef("users::eu::active::john")
+	tests.Assert(t, nil, err)
+	tests.Assert(t, "users", ref.Collection)
+	tests.Assert(t, "eu", ref.Namespace)
+	tests.Assert(t, "active", ref.Prefix)
+	tests.AssertEqual(t, []byte("john"), ref.Key)
+
+	// Single colon doesn't have to be escaped
+	ref, _ = ParseKeyRef("c::n::p::a:b")
+	tests.AssertEqual(t, []byte("a:b"), ref.Key)
+
+	for _, str := range []string{"c::n::p", "c::n::p::k::x", `c::n::p::\`, `c::n::p::\x0`, `c::n::p::\xzz`, `c::n::p::\n`} {
+		_, err = ParseKeyRef(str)
+		tests.Assert(t, ErrInvalidKeyRef, err)
+	}
+}
+
+func TestKeyRefRoundTrip(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		key := make([]byte, rand.Intn(20))
+		rand.Read(key)
+
+		ref := NewKeyRef("c::", ":n", `\p`, key)
+
+		parsed, err := ParseKeyRef(ref.String())
+		tests.Assert(t, nil, err)
+		tests.Assert(t, ref.Collection, parsed.Collection)
+		tests.Assert(t, ref.Namespace, parsed.Namespace)
+		tests.Assert(t, ref.Prefix, parsed.Prefix)
+		tests.AssertEqual(t, ref.Key, parsed.Key)
+	}
+}
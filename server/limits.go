@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytedb/lib/metrics"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Size of worker queue if it's not set
+const DefaultQueueSize = 100
+
+var ErrBusy = errors.New("server is busy")
+
+var (
+	rejectedQueue    = rejected("queue")
+	rejectedInFlight = rejected("in_flight")
+	rejectedRate     = rejected("rate")
+	rejectedConns    = rejected("connections")
+)
+
+func rejected(reason string) *metrics.Counter {
+	return metrics.Default.Counter("bytedb_rejected_total", "Number of commands and connections rejected because of limits.", "reason", reason)
+}
+
+// Check limits before running command. Returned function must be
+// called when command is done, ErrBusy is returned if command can't
+// run now.
+func (s *Server) admit(conn *Conn) (func(), error) {
+	if s.RateLimit > 0 && !s.limiter.allow(clientHost(conn), s.RateLimit, s.RateBurst) {
+		rejectedRate.Inc()
+		return nil, fmt.Errorf("%w: rate limit of %g commands per second exceeded", ErrBusy, s.RateLimit)
+	}
+
+	n := s.inFlight.Add(1)
+
+	if s.MaxInFlight > 0 && n > int64(s.MaxInFlight) {
+		s.inFlight.Add(-1)
+		rejectedInFlight.Inc()
+
+		return nil, fmt.Errorf("%w: too many commands in flight, max %d", ErrBusy, s.MaxInFlight)
+	}
+
+	return func() { s.inFlight.Add(-1) }, nil
+}
+
+// Create response for command rejected by limits
+func busyResp(err error) *Resp {
+	return &Resp{Status: StatusBusy, Data: []byte(err.Error())}
+}
+
+// Return host of client, rate limits are per host.
+func clientHost(conn *Conn) string {
+	addr := conn.RemoteAddr()
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// Token bucket for each client. Client gets rate tokens per second,
+// up to burst of them, and each command takes one token.
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*tokens
+	swept   time.Time
+}
+
+type tokens struct {
+	left float64
+	last time.Time
+}
+
+func (l *rateLimiter) allow(client string, rate float64, burst int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	limit := float64(max(burst, 1))
+
+	if l.clients == nil {
+		l.clients = map[string]*tokens{}
+	}
+
+	// Forget clients whose buckets are full again.
+	if now.Sub(l.swept) > time.Minute {
+		for name, t := range l.clients {
+			if t.left+now.Sub(t.last).Seconds()*rate >= limit {
+				delete(l.clients, name)
+			}
+		}
+
+		l.swept = now
+	}
+
+	t, ok := l.clients[client]
+	if !ok {
+		t = &tokens{left: limit, last: now}
+		l.clients[client] = t
+	}
+
+	t.left = min(limit, t.left+now.Sub(t.last).Seconds()*rate)
+	t.last = now
+
+	if t.left < 1 {
+		return false
+	}
+
+	t.left--
+	return true
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytedb/db"
+	"bytedb/tests"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQueueFull(t *testing.T) {
+	database, _ := db.Open(t.TempDir())
+	defer database.Close()
+
+	srv := NewServer(database)
+	srv.Workers = []*Worker{NewWorker(database, 1)}
+
+	conn := &Conn{Resp: make(chan *Resp, 1)}
+	cmd := ref.WithString("key").cmd(CmdGet)
+
+	tests.Assert(t, nil, srv.SendToWorker(cmd, conn))
+	tests.Assert(t, true, errors.Is(srv.SendToWorker(cmd, conn), ErrBusy))
+}
+
+func TestMaxInFlight(t *testing.T) {
+	database, _ := db.Open(t.TempDir())
+
+	srv := NewServer(database)
+	srv.MaxInFlight = 1
+	defer srv.Close()
+
+	conn := &Conn{Resp: make(chan *Resp, 1)}
+
+	done, err := srv.admit(conn)
+	tests.Assert(t, nil, err)
+
+	tests.Assert(t, StatusBusy, srv.Exec(conn, &Cmd{Type: CmdPing}).Status)
+
+	done()
+	tests.Assert(t, StatusOK, srv.Exec(conn, &Cmd{Type: CmdPing}).Status)
+}
+
+func TestRateLimit(t *testing.T) {
+	srv, addr := runServer(t)
+	srv.RateLimit = 0.001
+	srv.RateBurst = 2
+
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	tests.Assert(t, nil, cli.Ping(ctx))
+	tests.Assert(t, nil, cli.Ping(ctx))
+	tests.Assert(t, true, errors.Is(cli.Ping(ctx), ErrBusy))
+
+	// Other clients have their own limit.
+	other := &Conn{Resp: make(chan *Resp, 1), addr: "10.0.0.1:1234"}
+	tests.Assert(t, StatusOK, srv.Exec(other, &Cmd{Type: CmdPing}).Status)
+}
+
+func TestMaxConns(t *testing.T) {
+	database, _ := db.Open(t.TempDir())
+
+	srv := NewServer(database)
+	srv.MaxConns = 1
+	defer srv.Close()
+
+	c1, c2 := &Conn{}, &Conn{}
+
+	tests.Assert(t, nil, srv.AddConn(c1))
+	tests.Assert(t, true, errors.Is(srv.AddConn(c2), ErrBusy))
+
+	srv.RemoveConn(c1)
+	tests.Assert(t, nil, srv.AddConn(c2))
+	srv.RemoveConn(c2)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytedb/lib/metrics"
+	"time"
+)
+
+var (
+	readBytes    = metrics.Default.Counter("bytedb_conn_read_bytes_total", "Bytes read from connections.")
+	writtenBytes = metrics.Default.Counter("bytedb_conn_written_bytes_total", "Bytes written to connections.")
+
+	// Latency and errors of each command type
+	cmdTimes  = map[uint8]*metrics.Histogram{}
+	cmdErrors = map[uint8]*metrics.Counter{}
+)
+
+func init() {
+	for typ, name := range cmdNames {
+		cmdTimes[typ] = metrics.Default.Histogram("bytedb_command_seconds", "Time of executing command.", metrics.LatencyBuckets, "cmd", name)
+		cmdErrors[typ] = metrics.Default.Counter("bytedb_command_errors_total", "Number of commands that failed with error.", "cmd", name)
+	}
+}
+
+// Record latency and status of executed command.
+func observeCmd(typ uint8, status uint8, start time.Time) {
+	h, ok := cmdTimes[typ]
+	if !ok {
+		return
+	}
+
+	h.ObserveSince(start)
+
+	if status == StatusErr {
+		cmdErrors[typ].Inc()
+	}
+}
+
+// Register server and database gauges in r.
+func (s *Server) RegisterMetrics(r *metrics.Registry) {
+	r.GaugeFunc("bytedb_uptime_seconds", "Seconds since server start.", func() float64 {
+		return time.Since(s.started).Seconds()
+	})
+
+	r.GaugeFunc("bytedb_connections", "Number of open connections.", func() float64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		return float64(len(s.conns))
+	})
+
+	r.GaugeFunc("bytedb_replicas", "Number of connected replicas.", func() float64 {
+		return float64(len(s.Replicas()))
+	})
+
+	r.GaugeFunc("bytedb_worker_queue_depth", "Number of commands waiting for workers.", func() float64 {
+		depth := 0
+		for _, w := range s.Workers {
+			depth += len(w.jobs)
+		}
+
+		return float64(depth)
+	})
+
+	r.GaugeFunc("bytedb_lsn", "Last log sequence number.", func() float64 {
+		return float64(s.DB.LSN())
+	})
+
+	// Both walk all bucket files, it's fine for scrape interval.
+	r.GaugeFunc("bytedb_bucket_files", "Number of bucket files.", func() float64 {
+		stats, err := s.DB.Stats()
+		if err != nil {
+			return 0
+		}
+
+		return float64(stats.Buckets)
+	})
+
+	r.GaugeFunc("bytedb_bucket_files_bytes", "Size of bucket files in bytes.", func() float64 {
+		stats, err := s.DB.Stats()
+		if err != nil {
+			return 0
+		}
+
+		return float64(stats.Size)
+	})
+}
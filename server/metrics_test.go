@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bytedb/lib/metrics"
+	"bytedb/tests"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	srv, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	adds := cmdTimes[CmdAdd].Count()
+	read := readBytes.Value()
+
+	cli.Add(ctx, ref.WithString("key"), []byte("val"))
+	cli.Get(ctx, ref.WithString("key"))
+
+	tests.Assert(t, adds+1, cmdTimes[CmdAdd].Count())
+	tests.Assert(t, true, readBytes.Value() > read)
+
+	r := metrics.NewRegistry()
+	srv.RegisterMetrics(r)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	tests.Assert(t, true, strings.Contains(body, "# TYPE bytedb_connections gauge\nbytedb_connections 1\n"))
+	tests.Assert(t, true, strings.Contains(body, "bytedb_lsn 1\n"))
+	tests.Assert(t, true, strings.Contains(body, "bytedb_bucket_files 1\n"))
+
+	w = httptest.NewRecorder()
+	metrics.Default.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body = w.Body.String()
+	tests.Assert(t, true, strings.Contains(body, `bytedb_command_seconds_count{cmd="get"}`))
+	tests.Assert(t, true, strings.Contains(body, "bytedb_cache_misses_total"))
+	tests.Assert(t, true, strings.Contains(body, "bytedb_wal_append_seconds_bucket"))
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytedb/db"
+	"bytedb/tests"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	_, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	users := PrefixRef("users", "eu", "active")
+
+	tests.Assert(t, nil, cli.SetQuota(ctx, "users", db.Quota{MaxKeys: 1, MaxValueSize: 5}))
+	tests.Assert(t, nil, cli.Add(ctx, users.WithString("john"), []byte("val_1")))
+
+	err := cli.Add(ctx, users.WithString("anna"), []byte("val_2"))
+	tests.Assert(t, true, errors.Is(err, db.ErrQuotaExceeded))
+	tests.Assert(t, "QUOTA_EXCEEDED: collection has max 1 keys", err.Error())
+
+	err = cli.Add(ctx, users.WithString("john"), []byte("val_11"))
+	tests.Assert(t, true, errors.Is(err, db.ErrQuotaExceeded))
+
+	info, _ := cli.Quota(ctx, "users")
+	tests.Assert(t, db.Quota{MaxKeys: 1, MaxValueSize: 5}, info.Quota)
+	tests.Assert(t, uint64(1), info.Usage.Keys)
+	tests.Assert(t, uint64(len("john")+len("val_1")), info.Usage.Bytes)
+}
+
+func TestQuotaHTTP(t *testing.T) {
+	srv, send := runHTTP(t)
+	srv.DB.SetQuota(Hash([]byte("users")), db.Quota{MaxValueSize: 3})
+
+	w := send("PUT", "/v1/users/eu/active/john", "hello")
+	tests.Assert(t, http.StatusInsufficientStorage, w.Code)
+}
+
+func TestQuotaRedis(t *testing.T) {
+	r, send := runRedis(t)
+	r.Server.DB.SetQuota(r.Collection, db.Quota{MaxKeys: 1})
+
+	tests.Assert(t, "+OK\r\n", send("SET", "key_1", "val_1"))
+	tests.Assert(t, "-QUOTA_EXCEEDED collection has max 1 keys\r\n", send("SET", "key_2", "val_2"))
+}
+
+func TestQuotaMalformed(t *testing.T) {
+	srv, _ := runServer(t)
+	conn := &Conn{Resp: make(chan *Resp, 1)}
+
+	coll := Hash([]byte("users"))
+	srv.DB.SetQuota(coll, db.Quota{MaxKeys: 1})
+
+	// Truncated quota doesn't remove limits
+	data := EncodeQuota(&db.Quota{MaxKeys: 2})
+	resp := srv.Exec(conn, &Cmd{Type: CmdSetQuota, Collection: coll, Data: data[:len(data)-1]})
+	tests.Assert(t, StatusInvalid, resp.Status)
+
+	q, _ := srv.DB.Quota(coll)
+	tests.Assert(t, db.Quota{MaxKeys: 1}, q)
+
+	resp = srv.Exec(conn, &Cmd{Type: CmdScan, Collection: coll, Data: []byte{1}})
+	tests.Assert(t, StatusInvalid, resp.Status)
+}
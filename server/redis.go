@@ -0,0 +1,880 @@
+package server
+
+import (
+	"bytedb/db"
+	bit "bytedb/lib/bitbox"
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Separator of "coll::namespace::prefix::key" keys.
+const RedisSep = "::"
+
+// Default number of keys returned by SCAN
+const RedisScanCount = 10
+
+var (
+	errSyntax    = errors.New("ERR syntax error")
+	errNotInt    = errors.New("ERR value is not an integer or out of range")
+	errNoAuth    = errors.New("NOAUTH Authentication required.")
+	errNoPerm    = errors.New("NOPERM this user has no permissions to access this key")
+	errBadExpire = errors.New("ERR invalid expire time")
+	errReadOnly  = errors.New("READONLY You can't write against a read only replica.")
+)
+
+// Redis handles connections speaking RESP2/RESP3, so existing Redis
+// clients can be used with bytedb.
+//
+// Keys in "coll::namespace::prefix::key" form are stored in given
+// collection, namespace and prefix. All other keys are stored in
+// default collection and namespace, with empty prefix. Commands are
+// run with Server.Exec, like commands of other protocols.
+type Redis struct {
+	Server *Server
+
+	Collection uint64 // default collection
+	Namespace  uint64 // default namespace
+}
+
+func NewRedis(srv *Server, collection, namespace string) *Redis {
+	return &Redis{
+		Server:     srv,
+		Collection: Hash([]byte(collection)),
+		Namespace:  Hash([]byte(namespace)),
+	}
+}
+
+// Redis client connection
+type redisConn struct {
+	*Conn
+	w *RespWriter
+}
+
+// Handle connection until client quits or connection is closed.
+func (r *Redis) HandleConn(conn *Conn) error {
+	rd := NewRespReader(conn, r.Server.MaxKeySize+r.Server.MaxValueSize)
+	c := &redisConn{Conn: conn, w: NewRespWriter(conn)}
+
+	for {
+		args, err := rd.Read()
+		if errors.Is(err, ErrProtocol) {
+			c.w.Error("ERR " + err.Error())
+			c.w.Flush()
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(string(args[0]))
+
+		err = r.exec(c, name, args[1:])
+		if err != nil {
+			c.w.Error(err.Error())
+		}
+
+		// Replies to pipelined commands are sent together.
+		if !rd.Buffered() || name == "QUIT" {
+			err = c.w.Flush()
+			if err != nil {
+				return err
+			}
+		}
+
+		if name == "QUIT" {
+			return nil
+		}
+	}
+}
+
+// Execute command and write its reply.
+// Returned error is sent to client as error reply.
+func (r *Redis) exec(c *redisConn, name string, args [][]byte) error {
+	switch name {
+	case "PING":
+		return r.ping(c, args)
+	case "HELLO":
+		return r.hello(c, args)
+	case "AUTH":
+		return r.auth(c, args)
+	case "QUIT":
+		c.w.Simple("OK")
+		return nil
+	}
+
+	if r.Server.Auth != nil {
+		err := r.Server.Auth.Refresh(c.Conn)
+		if err != nil {
+			return errors.New("ERR " + err.Error())
+		}
+
+		if c.User == nil {
+			return errNoAuth
+		}
+	}
+
+	switch name {
+	case "GET":
+		return r.get(c, args)
+	case "SET":
+		return r.set(c, args)
+	case "DEL":
+		return r.del(c, args)
+	case "EXISTS":
+		return r.exists(c, args)
+	case "MGET":
+		return r.mget(c, args)
+	case "MSET":
+		return r.mset(c, args)
+	case "SCAN":
+		return r.scan(c, args)
+	case "EXPIRE":
+		return r.expire(c, args)
+	case "TTL":
+		return r.ttl(c, args)
+	case "INCR":
+		return r.incr(c, args)
+	}
+
+	return errors.New("ERR unknown command '" + name + "'")
+}
+
+// PING [message]
+func (r *Redis) ping(c *redisConn, args [][]byte) error {
+	switch len(args) {
+	case 0:
+		c.w.Simple("PONG")
+	case 1:
+		c.w.Bulk(args[0])
+	default:
+		return arity("ping")
+	}
+
+	return nil
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (r *Redis) hello(c *redisConn, args [][]byte) error {
+	proto := c.w.Proto
+
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0]))
+		if err != nil || v < 2 || v > 3 {
+			return errors.New("NOPROTO unsupported protocol version")
+		}
+
+		proto = v
+		args = args[1:]
+	}
+
+	for len(args) > 0 {
+		opt := strings.ToUpper(string(args[0]))
+
+		switch {
+		case opt == "AUTH" && len(args) >= 3:
+			err := r.login(c, args[1], args[2])
+			if err != nil {
+				return err
+			}
+
+			args = args[3:]
+		case opt == "SETNAME" && len(args) >= 2:
+			args = args[2:]
+		default:
+			return errSyntax
+		}
+	}
+
+	if r.Server.Auth != nil && c.User == nil {
+		return errNoAuth
+	}
+
+	c.w.Proto = proto
+
+	c.w.Map(4)
+	c.w.Bulk([]byte("server"))
+	c.w.Bulk([]byte("bytedb"))
+	c.w.Bulk([]byte("proto"))
+	c.w.Int(int64(proto))
+	c.w.Bulk([]byte("mode"))
+	c.w.Bulk([]byte("standalone"))
+	c.w.Bulk([]byte("role"))
+
+	if r.Server.ReadOnly {
+		c.w.Bulk([]byte("replica"))
+	} else {
+		c.w.Bulk([]byte("master"))
+	}
+
+	return nil
+}
+
+// AUTH [username] password
+// Without username password is treated as a token.
+func (r *Redis) auth(c *redisConn, args [][]byte) error {
+	var err error
+
+	switch len(args) {
+	case 1:
+		err = r.login(c, nil, args[0])
+	case 2:
+		err = r.login(c, args[0], args[1])
+	default:
+		return arity("auth")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.w.Simple("OK")
+	return nil
+}
+
+func (r *Redis) login(c *redisConn, user, password []byte) error {
+	if r.Server.Auth == nil {
+		return errors.New("ERR AUTH called without any password configured")
+	}
+
+	err := r.Server.Auth.Authenticate(c.Conn, string(user), password)
+	if errors.Is(err, ErrAuthFailed) {
+		return errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+
+	if err != nil {
+		return errors.New("ERR " + err.Error())
+	}
+
+	return nil
+}
+
+// GET key
+func (r *Redis) get(c *redisConn, args [][]byte) error {
+	if len(args) != 1 {
+		return arity("get")
+	}
+
+	key, err := r.key(c, args[0], PermRead)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.run(c, keyCmd(key, CmdGet, nil))
+	if err != nil {
+		return err
+	}
+
+	if resp.Status == StatusNotFound {
+		c.w.Null()
+		return nil
+	}
+
+	c.w.Bulk(resp.Data)
+	return nil
+}
+
+// SET key value [NX | XX] [EX seconds | PX milliseconds]
+func (r *Redis) set(c *redisConn, args [][]byte) error {
+	if len(args) < 2 {
+		return arity("set")
+	}
+
+	key, err := r.key(c, args[0], PermWrite)
+	if err != nil {
+		return err
+	}
+
+	req := &SetReq{Value: args[1]}
+	opts := args[2:]
+
+	for len(opts) > 0 {
+		opt := strings.ToUpper(string(opts[0]))
+
+		switch {
+		case opt == "NX" && req.Cond != SetXX:
+			req.Cond = SetNX
+		case opt == "XX" && req.Cond != SetNX:
+			req.Cond = SetXX
+		case (opt == "EX" || opt == "PX") && len(opts) > 1 && req.Expire == 0:
+			n, err := strconv.ParseInt(string(opts[1]), 10, 64)
+			if err != nil {
+				return errNotInt
+			}
+
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+
+			req.Expire, err = expireAt(n, unit)
+			if err != nil {
+				return err
+			}
+
+			opts = opts[1:]
+		default:
+			return errSyntax
+		}
+
+		opts = opts[1:]
+	}
+
+	if len(req.Value) > r.Server.MaxValueSize {
+		return errors.New("ERR value too big")
+	}
+
+	resp, err := r.run(c, keyCmd(key, CmdSet, req.Encode()))
+	if err != nil {
+		return err
+	}
+
+	// Condition isn't met
+	if resp.Status == StatusNotFound {
+		c.w.Null()
+		return nil
+	}
+
+	c.w.Simple("OK")
+	return nil
+}
+
+// DEL key [key ...]
+func (r *Redis) del(c *redisConn, args [][]byte) error {
+	if len(args) == 0 {
+		return arity("del")
+	}
+
+	keys, err := r.keys(c, args, PermWrite)
+	if err != nil {
+		return err
+	}
+
+	n := int64(0)
+
+	for _, key := range keys {
+		resp, err := r.run(c, keyCmd(key, CmdDelete, nil))
+		if err != nil {
+			return err
+		}
+
+		if resp.Status == StatusOK {
+			n++
+		}
+	}
+
+	c.w.Int(n)
+	return nil
+}
+
+// EXISTS key [key ...]
+func (r *Redis) exists(c *redisConn, args [][]byte) error {
+	if len(args) == 0 {
+		return arity("exists")
+	}
+
+	keys, err := r.keys(c, args, PermRead)
+	if err != nil {
+		return err
+	}
+
+	n := int64(0)
+
+	for _, key := range keys {
+		resp, err := r.run(c, keyCmd(key, CmdGet, nil))
+		if err != nil {
+			return err
+		}
+
+		if resp.Status == StatusOK {
+			n++
+		}
+	}
+
+	c.w.Int(n)
+	return nil
+}
+
+// MGET key [key ...]
+func (r *Redis) mget(c *redisConn, args [][]byte) error {
+	if len(args) == 0 {
+		return arity("mget")
+	}
+
+	keys, err := r.keys(c, args, PermRead)
+	if err != nil {
+		return err
+	}
+
+	vals := make([][]byte, len(keys))
+
+	for i, key := range keys {
+		resp, err := r.run(c, keyCmd(key, CmdGet, nil))
+		if err != nil {
+			return err
+		}
+
+		if resp.Status == StatusOK {
+			vals[i] = resp.Data
+		}
+	}
+
+	c.w.Array(len(vals))
+
+	for _, val := range vals {
+		if val == nil {
+			c.w.Null()
+			continue
+		}
+
+		c.w.Bulk(val)
+	}
+
+	return nil
+}
+
+// MSET key value [key value ...]
+func (r *Redis) mset(c *redisConn, args [][]byte) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return arity("mset")
+	}
+
+	cmds := []*Cmd{}
+
+	for i := 0; i < len(args); i += 2 {
+		key, err := r.key(c, args[i], PermWrite)
+		if err != nil {
+			return err
+		}
+
+		if len(args[i+1]) > r.Server.MaxValueSize {
+			return errors.New("ERR value too big")
+		}
+
+		cmds = append(cmds, keyCmd(key, CmdAdd, args[i+1]))
+	}
+
+	for _, cmd := range cmds {
+		_, err := r.run(c, cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.w.Simple("OK")
+	return nil
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+//
+// Keys of single prefix are scanned. Pattern starting with
+// "coll::namespace::prefix::" selects prefix to scan, default
+// one is used otherwise.
+func (r *Redis) scan(c *redisConn, args [][]byte) error {
+	if len(args) == 0 {
+		return arity("scan")
+	}
+
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return errors.New("ERR invalid cursor")
+	}
+
+	pattern := []byte("*")
+	count := RedisScanCount
+
+	for opts := args[1:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			return errSyntax
+		}
+
+		switch strings.ToUpper(string(opts[0])) {
+		case "MATCH":
+			pattern = opts[1]
+		case "COUNT":
+			count, err = strconv.Atoi(string(opts[1]))
+			if err != nil || count < 1 {
+				return errNotInt
+			}
+		case "TYPE":
+			// All keys are strings.
+			if strings.ToLower(string(opts[1])) != "string" {
+				c.w.Array(2)
+				c.w.Bulk([]byte("0"))
+				c.w.Array(0)
+				return nil
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	key := &db.Key{Collection: r.Collection, Namespace: r.Namespace, Prefix: Hash(nil)}
+	prefix := []byte{}
+
+	parts := bytes.SplitN(pattern, []byte(RedisSep), 4)
+	if len(parts) == 4 && !isGlob(parts[0]) && !isGlob(parts[1]) && !isGlob(parts[2]) {
+		key = r.split(pattern)
+		prefix = pattern[:len(pattern)-len(parts[3])]
+		pattern = parts[3]
+	}
+
+	err = r.authorize(c, key, PermRead)
+	if err != nil {
+		return err
+	}
+
+	req := &ScanReq{Cursor: cursor, Count: uint32(min(count, ScanMaxCount))}
+
+	resp, err := r.run(c, &Cmd{Type: CmdScan, Collection: key.Collection, Namespace: key.Namespace, Prefix: key.Prefix, Data: req.Encode()})
+	if err != nil {
+		return err
+	}
+
+	scan := DecodeScanResp(resp.Data)
+	names := [][]byte{}
+
+	for _, e := range scan.Entries {
+		if globMatch(pattern, e.Key) {
+			names = append(names, append(prefix[:len(prefix):len(prefix)], e.Key...))
+		}
+	}
+
+	c.w.Array(2)
+	c.w.Bulk([]byte(strconv.FormatUint(scan.Cursor, 10)))
+	c.w.Array(len(names))
+
+	for _, name := range names {
+		c.w.Bulk(name)
+	}
+
+	return nil
+}
+
+// EXPIRE key seconds
+func (r *Redis) expire(c *redisConn, args [][]byte) error {
+	if len(args) != 2 {
+		return arity("expire")
+	}
+
+	key, err := r.key(c, args[0], PermWrite)
+	if err != nil {
+		return err
+	}
+
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+
+	// Expire time in the past deletes key.
+	expire := int64(-1)
+
+	if n > 0 {
+		expire, err = expireAt(n, time.Second)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := r.run(c, keyCmd(key, CmdExpire, bit.Encode(&expire)))
+	if err != nil {
+		return err
+	}
+
+	if resp.Status == StatusNotFound {
+		c.w.Int(0)
+		return nil
+	}
+
+	c.w.Int(1)
+	return nil
+}
+
+// TTL key
+func (r *Redis) ttl(c *redisConn, args [][]byte) error {
+	if len(args) != 1 {
+		return arity("ttl")
+	}
+
+	key, err := r.key(c, args[0], PermRead)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.run(c, keyCmd(key, CmdLookup, nil))
+	if err != nil {
+		return err
+	}
+
+	if resp.Status == StatusNotFound {
+		c.w.Int(-2)
+		return nil
+	}
+
+	kv := DecodeLookupResp(resp.Data)
+
+	if kv.Expire == 0 {
+		c.w.Int(-1)
+		return nil
+	}
+
+	ms := time.Until(time.Unix(0, kv.Expire)).Milliseconds()
+	c.w.Int((ms + 500) / 1000)
+
+	return nil
+}
+
+// INCR key
+func (r *Redis) incr(c *redisConn, args [][]byte) error {
+	if len(args) != 1 {
+		return arity("incr")
+	}
+
+	key, err := r.key(c, args[0], PermWrite)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.run(c, keyCmd(key, CmdIncr, nil))
+	if err != nil {
+		return err
+	}
+
+	n := int64(0)
+	bit.NewBuffer(resp.Data).Decode(&n)
+
+	c.w.Int(n)
+	return nil
+}
+
+// Run command with Server.Exec. Response with OK or NotFound status
+// is returned, other statuses are returned as Redis errors.
+func (r *Redis) run(c *redisConn, cmd *Cmd) (*Resp, error) {
+	resp := r.Server.Exec(c.Conn, cmd)
+
+	switch resp.Status {
+	case StatusOK, StatusNotFound:
+		return resp, nil
+	case StatusDenied:
+		if c.User == nil {
+			return nil, errNoAuth
+		}
+
+		return nil, errNoPerm
+	case StatusReadOnly:
+		return nil, errReadOnly
+	case StatusBusy:
+		return nil, errors.New("BUSY " + string(resp.Data))
+	case StatusQuota:
+		// Quota errors already start with their code.
+		return nil, errors.New(strings.Replace(string(resp.Data), ":", "", 1))
+	}
+
+	return nil, errors.New("ERR " + string(resp.Data))
+}
+
+// Return command for key.
+func keyCmd(key *db.Key, typ uint8, data []byte) *Cmd {
+	return &Cmd{
+		Type:       typ,
+		Collection: key.Collection,
+		Namespace:  key.Namespace,
+		Prefix:     key.Prefix,
+		Key:        key.Name,
+		Data:       data,
+	}
+}
+
+// Return db key for Redis key and check if user has given permission.
+func (r *Redis) key(c *redisConn, name []byte, perm uint8) (*db.Key, error) {
+	key := r.split(name)
+
+	if len(key.Name) > r.Server.MaxKeySize {
+		return nil, errors.New("ERR key too big")
+	}
+
+	if perm >= PermWrite && r.Server.ReadOnly {
+		return nil, errReadOnly
+	}
+
+	return key, r.authorize(c, key, perm)
+}
+
+// Return db keys for Redis keys and check if user has given permission.
+func (r *Redis) keys(c *redisConn, names [][]byte, perm uint8) ([]*db.Key, error) {
+	keys := []*db.Key{}
+
+	for _, name := range names {
+		key, err := r.key(c, name, perm)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Split "coll::namespace::prefix::key" into db key.
+// Other keys are placed in default collection and namespace.
+func (r *Redis) split(name []byte) *db.Key {
+	parts := bytes.SplitN(name, []byte(RedisSep), 4)
+
+	if len(parts) < 4 {
+		key := db.NewKey(name, nil)
+		key.Collection = r.Collection
+		key.Namespace = r.Namespace
+		key.Prefix = Hash(nil)
+
+		return key
+	}
+
+	key := db.NewKey(parts[3], nil)
+	key.Collection = Hash(parts[0])
+	key.Namespace = Hash(parts[1])
+	key.Prefix = Hash(parts[2])
+
+	return key
+}
+
+// Check if user can access key, everything is allowed when
+// authentication is disabled.
+func (r *Redis) authorize(c *redisConn, key *db.Key, perm uint8) error {
+	if r.Server.Auth == nil {
+		return nil
+	}
+
+	if c.User.Perm(key.Collection, key.Namespace) < perm {
+		return errNoPerm
+	}
+
+	return nil
+}
+
+// Return expiration time for ttl in given units.
+func expireAt(ttl int64, unit time.Duration) (int64, error) {
+	if ttl <= 0 || ttl > math.MaxInt64/int64(unit) {
+		return 0, errBadExpire
+	}
+
+	return time.Now().Add(time.Duration(ttl) * unit).UnixNano(), nil
+}
+
+// Error for wrong number of arguments
+func arity(cmd string) error {
+	return errors.New("ERR wrong number of arguments for '" + cmd + "' command")
+}
+
+// Check if pattern has any glob special characters.
+func isGlob(pattern []byte) bool {
+	return bytes.ContainsAny(pattern, `*?[\`)
+}
+
+// Match name against glob pattern, supports '*', '?',
+// '[abc]', '[^a-z]' and '\' escapes.
+func globMatch(pattern, name []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true
+			}
+
+			for i := 0; i <= len(name); i++ {
+				if globMatch(pattern, name[i:]) {
+					return true
+				}
+			}
+
+			return false
+
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+
+		case '[':
+			if len(name) == 0 {
+				return false
+			}
+
+			n, ok := matchClass(pattern, name[0])
+			if !ok {
+				return false
+			}
+
+			pattern, name = pattern[n:], name[1:]
+			continue
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// Match character against "[...]" class at the beginning of pattern.
+// Return class length and true if it matches.
+func matchClass(pattern []byte, ch byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+
+	match := false
+
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+
+		lo, hi := pattern[i], pattern[i]
+
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			i += 2
+		}
+
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+
+		if ch >= lo && ch <= hi {
+			match = true
+		}
+	}
+
+	// Unterminated class matches till the end of pattern.
+	if i < len(pattern) {
+		i++
+	}
+
+	return i, match != negate
+}
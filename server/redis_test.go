@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bufio"
+	"bytedb/db"
+	"bytedb/tests"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run Redis handler on one end of pipe and return function sending
+// commands on the other end. It returns raw reply.
+func runRedis(t *testing.T) (*Redis, func(args ...string) string) {
+	database, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(database)
+	srv.RunWorkers(2)
+	t.Cleanup(func() { srv.Close() })
+
+	r := NewRedis(srv, "default", "default")
+	sc, cli := net.Pipe()
+
+	go r.HandleConn(NewConn(sc))
+	t.Cleanup(func() { cli.Close() })
+
+	rd := bufio.NewReader(cli)
+
+	send := func(args ...string) string {
+		req := fmt.Sprintf("*%d\r\n", len(args))
+		for _, arg := range args {
+			req += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+		}
+
+		go cli.Write([]byte(req))
+		return readReply(t, rd)
+	}
+
+	return r, send
+}
+
+// Read single reply, including nested ones.
+func readReply(t *testing.T, rd *bufio.Reader) string {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	fmt.Sscanf(line[1:], "%d", &n)
+
+	switch line[0] {
+	case '$':
+		if n < 0 {
+			return line
+		}
+
+		data := make([]byte, n+2)
+		io.ReadFull(rd, data)
+		return line + string(data)
+	case '*', '%':
+		if line[0] == '%' {
+			n *= 2
+		}
+
+		for i := 0; i < n; i++ {
+			line += readReply(t, rd)
+		}
+	}
+
+	return line
+}
+
+func TestRedisGetSet(t *testing.T) {
+	_, send := runRedis(t)
+
+	tests.Assert(t, "+PONG\r\n", send("PING"))
+	tests.Assert(t, "+OK\r\n", send("SET", "key_1", "val_1"))
+	tests.Assert(t, "$5\r\nval_1\r\n", send("GET", "key_1"))
+	tests.Assert(t, "$-1\r\n", send("GET", "key_2"))
+
+	// NX and XX
+	tests.Assert(t, "$-1\r\n", send("SET", "key_1", "val_2", "NX"))
+	tests.Assert(t, "$-1\r\n", send("SET", "key_2", "val_2", "XX"))
+	tests.Assert(t, "+OK\r\n", send("SET", "key_1", "val_2", "XX"))
+	tests.Assert(t, "$5\r\nval_2\r\n", send("GET", "key_1"))
+
+	tests.Assert(t, "+OK\r\n", send("MSET", "key_2", "a", "key_3", "b"))
+	tests.Assert(t, "*3\r\n$1\r\na\r\n$-1\r\n$1\r\nb\r\n", send("MGET", "key_2", "key_4", "key_3"))
+	tests.Assert(t, ":2\r\n", send("EXISTS", "key_1", "key_2", "key_4"))
+	tests.Assert(t, ":2\r\n", send("DEL", "key_1", "key_2", "key_4"))
+	tests.Assert(t, ":0\r\n", send("EXISTS", "key_1"))
+}
+
+func TestRedisExpire(t *testing.T) {
+	_, send := runRedis(t)
+
+	send("SET", "key_1", "val_1", "EX", "100")
+	tests.Assert(t, ":100\r\n", send("TTL", "key_1"))
+
+	send("SET", "key_2", "val_2")
+	tests.Assert(t, ":-1\r\n", send("TTL", "key_2"))
+	tests.Assert(t, ":-2\r\n", send("TTL", "key_3"))
+
+	tests.Assert(t, ":1\r\n", send("EXPIRE", "key_2", "50"))
+	tests.Assert(t, ":50\r\n", send("TTL", "key_2"))
+
+	tests.Assert(t, ":1\r\n", send("EXPIRE", "key_2", "0"))
+	tests.Assert(t, "$-1\r\n", send("GET", "key_2"))
+
+	tests.Assert(t, "-ERR invalid expire time\r\n", send("SET", "key_1", "val_1", "PX", "0"))
+}
+
+func TestRedisIncr(t *testing.T) {
+	_, send := runRedis(t)
+
+	tests.Assert(t, ":1\r\n", send("INCR", "counter"))
+	tests.Assert(t, ":2\r\n", send("INCR", "counter"))
+
+	send("SET", "key_1", "abc")
+	tests.Assert(t, "-ERR value is not an integer or out of range\r\n", send("INCR", "key_1"))
+}
+
+func TestRedisExec(t *testing.T) {
+	r, send := runRedis(t)
+
+	r.Server.SlowThreshold = time.Nanosecond
+
+	// Key added with binary protocol is seen by conditional set
+	conn := NewConn(nil)
+	r.Server.Exec(conn, &Cmd{Type: CmdAdd, Collection: r.Collection, Namespace: r.Namespace, Prefix: Hash(nil), Key: []byte("key_1"), Data: []byte("1")})
+
+	tests.Assert(t, "$-1\r\n", send("SET", "key_1", "val_1", "NX"))
+	tests.Assert(t, ":2\r\n", send("INCR", "key_1"))
+
+	// Commands are run by Server.Exec, so they are in slow log
+	entries := r.Server.SlowLog(2)
+	tests.Assert(t, 2, len(entries))
+	tests.Assert(t, CmdIncr, entries[0].Cmd)
+	tests.Assert(t, CmdSet, entries[1].Cmd)
+	tests.AssertEqual(t, []byte("key_1"), entries[0].Key)
+}
+
+func TestRedisKeyPath(t *testing.T) {
+	r, send := runRedis(t)
+
+	send("SET", "users::eu::active::john", "1")
+
+	key := db.NewKey([]byte("john"), nil)
+	key.Collection = Hash([]byte("users"))
+	key.Namespace = Hash([]byte("eu"))
+	key.Prefix = Hash([]byte("active"))
+
+	val, _ := r.Server.DB.Get(key)
+	tests.AssertEqual(t, []byte("1"), val)
+}
+
+func TestRedisScan(t *testing.T) {
+	_, send := runRedis(t)
+
+	for i := 0; i < 20; i++ {
+		send("SET", fmt.Sprintf("users::eu::active::user_%d", i), "1")
+	}
+
+	send("SET", "users::eu::active::admin", "1")
+
+	found := 0
+	cursor := "0"
+
+	for {
+		res := send("SCAN", cursor, "MATCH", "users::eu::active::user_*", "COUNT", "5")
+		lines := strings.Split(res, "\r\n")
+
+		for _, line := range lines {
+			if strings.HasPrefix(line, "users::eu::active::user_") {
+				found++
+			}
+		}
+
+		cursor = lines[2]
+		if cursor == "0" {
+			break
+		}
+	}
+
+	tests.Assert(t, 20, found)
+}
+
+func TestRedisHello(t *testing.T) {
+	_, send := runRedis(t)
+
+	res := send("HELLO", "3")
+	tests.Assert(t, true, strings.HasPrefix(res, "%4\r\n"))
+
+	// RESP3 null
+	tests.Assert(t, "_\r\n", send("GET", "key_1"))
+}
+
+func TestRedisAuth(t *testing.T) {
+	r, send := runRedis(t)
+
+	r.Server.Auth = NewAuth(r.Server.DB.Internals())
+	r.Server.Auth.AddUser("john", "secret")
+	r.Server.Auth.Grant("john", Rule{Collection: r.Collection, Namespace: r.Namespace, Perm: PermRead})
+
+	tests.Assert(t, "-NOAUTH Authentication required.\r\n", send("GET", "key_1"))
+	tests.Assert(t, true, strings.HasPrefix(send("AUTH", "john", "wrong"), "-WRONGPASS"))
+	tests.Assert(t, "+OK\r\n", send("AUTH", "john", "secret"))
+
+	tests.Assert(t, "$-1\r\n", send("GET", "key_1"))
+	tests.Assert(t, true, strings.HasPrefix(send("SET", "key_1", "val_1"), "-NOPERM"))
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests.Assert(t, true, globMatch([]byte("*"), []byte("abc")))
+	tests.Assert(t, true, globMatch([]byte("a?c"), []byte("abc")))
+	tests.Assert(t, true, globMatch([]byte("a[a-c]c*"), []byte("abcdef")))
+	tests.Assert(t, false, globMatch([]byte("a[^b]c"), []byte("abc")))
+	tests.Assert(t, true, globMatch([]byte(`a\*`), []byte("a*")))
+	tests.Assert(t, false, globMatch([]byte("user_*"), []byte("admin")))
+}
+
+func TestRespReaderLimits(t *testing.T) {
+	// Huge argument count is rejected before anything is allocated
+	r := NewRespReader(strings.NewReader("*1048576\r\n"), 1024)
+
+	_, err := r.Read()
+	tests.Assert(t, true, errors.Is(err, ErrProtocol))
+
+	// Arguments are read as they arrive, missing ones fail the command
+	r = NewRespReader(strings.NewReader("*1000\r\n$3\r\nGET\r\n"), 1024)
+
+	_, err = r.Read()
+	tests.Assert(t, io.EOF, err)
+}
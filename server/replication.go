@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytedb/db"
+	"bytedb/db/wal"
+	bit "bytedb/lib/bitbox"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Primary sends empty response when there were no records for that
+	// long, replica reconnects if it doesn't hear from primary for
+	// ReplicateTimeout.
+	ReplicateHeartbeat = time.Second
+	ReplicateTimeout   = 5 * ReplicateHeartbeat
+
+	// How often replica reports its applied lsn.
+	ReplicateAckInterval = time.Second
+)
+
+var ErrReadOnly = errors.New("server is read-only replica")
+
+// Replica connected to primary
+type ReplicaStatus struct {
+	Addr      string
+	Internal  bool   // replica follows internal database
+	Sent      uint64 // last lsn sent to replica
+	Applied   uint64 // last lsn applied by replica
+	Connected time.Time
+}
+
+// Return status of all connected replicas.
+func (s *Server) Replicas() []ReplicaStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []ReplicaStatus{}
+	for r := range s.replicas {
+		list = append(list, *r)
+	}
+
+	return list
+}
+
+// Stream wal records to replica. Records are sent in OK responses,
+// empty response is a heartbeat. Error response ends the stream, for
+// example when replica asks for records that aren't retained anymore.
+// Replica sends its applied lsn back in frames with encoded uint64.
+// Stream runs until connection fails, so error is always returned.
+func (s *Server) Replicate(conn *Conn, cmd *Cmd) error {
+	err := s.Authorize(conn, cmd)
+	if err != nil {
+		_, err = conn.Write((&Resp{Status: StatusDenied, Data: []byte(err.Error())}).Encode())
+		if err != nil {
+			return err
+		}
+
+		return ErrForbidden
+	}
+
+	req, err := DecodeReplicateReq(cmd.Data)
+	if err != nil {
+		_, werr := conn.Write(invalidResp("%s", err).Encode())
+		if werr != nil {
+			return werr
+		}
+
+		return err
+	}
+
+	database := s.DB
+	if req.Internal == 1 {
+		database = s.DB.Internals()
+	}
+
+	status := &ReplicaStatus{
+		Addr:      conn.RemoteAddr(),
+		Internal:  req.Internal == 1,
+		Sent:      req.LSN,
+		Applied:   req.LSN,
+		Connected: time.Now(),
+	}
+
+	s.mu.Lock()
+	s.replicas[status] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.replicas, status)
+		s.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Replica sends only acks, stream stops when reading fails.
+	go func() {
+		defer cancel()
+
+		for {
+			data, err := conn.ReadFrame(8)
+			if err != nil {
+				return
+			}
+
+			lsn := uint64(0)
+			bit.NewBuffer(data).Decode(&lsn)
+
+			s.mu.Lock()
+			status.Applied = lsn
+			s.mu.Unlock()
+		}
+	}()
+
+	rc := &streamConn{conn: conn, last: time.Now()}
+	go rc.heartbeat(ctx)
+
+	err = database.Follow(ctx, req.LSN, func(logs [][]byte) error {
+		err := rc.write(&Resp{Status: StatusOK, Data: EncodeLogs(logs)})
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		status.Sent = wal.DecodeRecord(logs[len(logs)-1]).LSN
+		s.mu.Unlock()
+
+		return nil
+	})
+
+	if ctx.Err() == nil {
+		rc.write(errResp(err))
+	}
+
+	return fmt.Errorf("replication stopped: %w", err)
+}
+
+// Streaming connection, written by follower and heartbeat.
+type streamConn struct {
+	conn *Conn
+	mu   sync.Mutex
+	last time.Time // time of last write
+}
+
+func (c *streamConn) write(resp *Resp) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = time.Now()
+
+	_, err := c.conn.Write(resp.Encode())
+	return err
+}
+
+// Send empty response when there was nothing to send for a while.
+func (c *streamConn) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(ReplicateHeartbeat / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		idle := time.Since(c.last) >= ReplicateHeartbeat
+		c.mu.Unlock()
+
+		if idle && c.write(&Resp{Status: StatusOK}) != nil {
+			return
+		}
+	}
+}
+
+// Replica follows primary server and applies its wal records to local
+// database. Both main and internal database are followed, each one over
+// separate connection. Server of replica should be read-only.
+type Replica struct {
+	DB      *db.DB
+	Primary string        // address of primary
+	Retry   time.Duration // delay before reconnecting
+
+	// Called when replication of database fails, before reconnecting.
+	OnError func(internal bool, err error)
+
+	client *Client
+}
+
+// Create replica of primary. Options are used for connecting to
+// primary, user must have admin permission.
+func NewReplica(database *db.DB, primary string, opts *ClientOptions) (*Replica, error) {
+	client, err := newClient(primary, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replica{
+		DB:      database,
+		Primary: primary,
+		Retry:   time.Second,
+		client:  client,
+	}
+
+	return r, nil
+}
+
+// Run replication until ctx is done.
+func (r *Replica) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+
+	for _, internal := range []bool{false, true} {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			r.run(ctx, internal)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Follow database, reconnecting after failures.
+func (r *Replica) run(ctx context.Context, internal bool) {
+	database := r.DB
+	if internal {
+		database = r.DB.Internals()
+	}
+
+	for {
+		err := r.follow(ctx, database, internal)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if r.OnError != nil {
+			r.OnError(internal, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.Retry):
+		}
+	}
+}
+
+// Connect to primary and apply records until connection fails.
+func (r *Replica) follow(ctx context.Context, database *db.DB, internal bool) error {
+	conn, err := r.client.dial(ctx, r.client.creds)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	req := &ReplicateReq{LSN: database.LSN()}
+	if internal {
+		req.Internal = 1
+	}
+
+	_, err = conn.Write(EncodeCmd(&Cmd{Type: CmdReplicate, Data: req.Encode()}))
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go r.ack(conn.Conn, database, done)
+
+	for ctx.Err() == nil {
+		conn.SetReadDeadline(time.Now().Add(ReplicateTimeout))
+
+		data, err := conn.ReadFrame(r.client.opts.MaxRespSize)
+		if err != nil {
+			return err
+		}
+
+		resp := DecodeResp(bit.NewBuffer(data))
+		if resp.Status != StatusOK {
+			return fmt.Errorf("%s", resp.Data)
+		}
+
+		for _, log := range DecodeLogs(resp.Data) {
+			err = database.ApplyLog(log)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Periodically send applied lsn to primary.
+func (r *Replica) ack(conn *Conn, database *db.DB, done chan struct{}) {
+	ticker := time.NewTicker(ReplicateAckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		lsn := database.LSN()
+
+		_, err := conn.Write(bit.Encode(bit.Encode(&lsn)))
+		if err != nil {
+			return
+		}
+	}
+}
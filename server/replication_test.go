@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytedb/db"
+	"bytedb/tests"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Wait until fn returns true, at most for a few seconds.
+func waitFor(fn func() bool) {
+	for start := time.Now(); !fn() && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	srv, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	for i := 0; i < 10; i++ {
+		cli.Add(ctx, ref.WithString(fmt.Sprintf("key_%d", i)), []byte("val"))
+	}
+
+	database, _ := db.Open(t.TempDir())
+
+	replica, err := NewReplica(database, addr, DefaultClientOptions())
+	tests.Assert(t, nil, err)
+
+	rctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		replica.Run(rctx)
+		close(done)
+	}()
+
+	// Records written while replica is connected
+	cli.Delete(ctx, ref.WithString("key_0"))
+	cli.Add(ctx, ref.WithString("key_10"), []byte("val"))
+
+	waitFor(func() bool { return database.LSN() == srv.DB.LSN() })
+	tests.Assert(t, srv.DB.LSN(), database.LSN())
+
+	// Replica reports applied lsn
+	waitFor(func() bool {
+		for _, r := range srv.Replicas() {
+			if !r.Internal && r.Applied == srv.DB.LSN() {
+				return true
+			}
+		}
+
+		return false
+	})
+
+	tests.Assert(t, 2, len(srv.Replicas()))
+
+	cancel()
+	<-done
+
+	// Replica serves reads, but rejects writes
+	rsrv := NewServer(database)
+	rsrv.ReadOnly = true
+	rsrv.RunWorkers(1)
+	defer rsrv.Close()
+
+	conn := &Conn{Resp: make(chan *Resp, 1)}
+
+	resp := rsrv.Exec(conn, ref.WithString("key_10").cmd(CmdGet))
+	tests.Assert(t, StatusOK, resp.Status)
+	tests.AssertEqual(t, []byte("val"), resp.Data)
+
+	resp = rsrv.Exec(conn, ref.WithString("key_0").cmd(CmdGet))
+	tests.Assert(t, StatusNotFound, resp.Status)
+
+	cmd := ref.WithString("key_11").cmd(CmdAdd)
+	cmd.Data = []byte("val")
+
+	resp = rsrv.Exec(conn, cmd)
+	tests.Assert(t, StatusReadOnly, resp.Status)
+}
+
+func TestReplicationNotRetained(t *testing.T) {
+	srv, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	cli.Add(ctx, ref.WithString("key"), []byte("val"))
+	srv.DB.Checkpoint()
+
+	database, _ := db.Open(t.TempDir())
+	defer database.Close()
+
+	replica, _ := NewReplica(database, addr, DefaultClientOptions())
+
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	replica.OnError = func(internal bool, err error) {
+		if !internal {
+			errs <- err
+		}
+
+		cancel()
+	}
+
+	replica.Run(rctx)
+
+	err := <-errs
+	tests.Assert(t, true, strings.Contains(err.Error(), db.ErrNotRetained.Error()))
+}
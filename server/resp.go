@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Max number of arguments in single RESP command, it's checked before
+// client authenticates, so it limits what anyone can make server read.
+const RespMaxArgs = 1024
+
+var ErrProtocol = errors.New("protocol error")
+
+// Reads RESP commands, either arrays of bulk strings
+// or inline commands separated by spaces.
+type RespReader struct {
+	r *bufio.Reader
+
+	// Max length of single bulk string
+	MaxBulk int
+}
+
+func NewRespReader(r io.Reader, maxBulk int) *RespReader {
+	return &RespReader{r: bufio.NewReader(r), MaxBulk: maxBulk}
+}
+
+// Read next command and return its arguments.
+func (r *RespReader) Read() ([][]byte, error) {
+	line, err := r.line()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := r.length(line[1:], RespMaxArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Count comes from client, so arguments are added as they arrive.
+	args := [][]byte{}
+
+	for i := 0; i < n; i++ {
+		line, err := r.line()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got %q", ErrProtocol, line)
+		}
+
+		size, err := r.length(line[1:], r.MaxBulk)
+		if err != nil {
+			return nil, err
+		}
+
+		// Bulk string followed by CRLF
+		arg := make([]byte, size+2)
+
+		_, err = io.ReadFull(r.r, arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string is not terminated", ErrProtocol)
+		}
+
+		args = append(args, arg[:size])
+	}
+
+	return args, nil
+}
+
+// Return true if there are buffered bytes, so replies
+// to pipelined commands can be flushed together.
+func (r *RespReader) Buffered() bool {
+	return r.r.Buffered() > 0
+}
+
+// Read line without CRLF
+func (r *RespReader) line() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w: line too long", ErrProtocol)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return line, nil
+}
+
+// Parse array or bulk string length
+func (r *RespReader) length(data []byte, max int) (int, error) {
+	n, err := strconv.Atoi(string(data))
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, data)
+	}
+
+	return n, nil
+}
+
+// Writes RESP2 or RESP3 replies.
+type RespWriter struct {
+	w *bufio.Writer
+
+	// Protocol version, 2 or 3
+	Proto int
+}
+
+func NewRespWriter(w io.Writer) *RespWriter {
+	return &RespWriter{w: bufio.NewWriter(w), Proto: 2}
+}
+
+// Write simple string
+func (w *RespWriter) Simple(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+// Write error. Message should start with error code, ex. "ERR".
+func (w *RespWriter) Error(msg string) {
+	w.w.WriteString("-" + msg + "\r\n")
+}
+
+// Write integer
+func (w *RespWriter) Int(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// Write bulk string
+func (w *RespWriter) Bulk(data []byte) {
+	w.w.WriteString("$" + strconv.Itoa(len(data)) + "\r\n")
+	w.w.Write(data)
+	w.w.WriteString("\r\n")
+}
+
+// Write null
+func (w *RespWriter) Null() {
+	if w.Proto == 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+
+	w.w.WriteString("$-1\r\n")
+}
+
+// Write array header, it must be followed by n values.
+func (w *RespWriter) Array(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// Write map header, it must be followed by n key-value pairs.
+// In RESP2 maps are sent as flat arrays.
+func (w *RespWriter) Map(n int) {
+	if w.Proto == 3 {
+		w.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+
+	w.Array(2 * n)
+}
+
+// Send buffered replies
+func (w *RespWriter) Flush() error {
+	return w.w.Flush()
+}
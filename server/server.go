@@ -2,21 +2,73 @@ package server
 
 import (
 	"bytedb/db"
+	bit "bytedb/lib/bitbox"
 	"context"
-	"log"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+var ErrClosed = errors.New("server is closed")
+
 type Server struct {
-	Workers     []Worker                  // file workers
-	Collections map[uint64]*db.Collection // opened collections
+	DB      *db.DB    // main database
+	Workers []*Worker // file workers
+
+	// Users and access rules, nil when authentication is disabled.
+	Auth *Auth
+
+	// Limits for keys and values, commands exceeding them are rejected.
+	MaxKeySize   int
+	MaxValueSize int
+
+	// Replica applies changes only from primary, writes are rejected.
+	ReadOnly bool
+
+	// Limits protecting server from overload, commands exceeding them
+	// get StatusBusy. Zero means no limit, except QueueSize.
+	QueueSize   int     // commands waiting for single worker
+	MaxInFlight int     // commands running at once
+	MaxConns    int     // open connections
+	RateLimit   float64 // commands per second of single client host
+	RateBurst   int     // commands client can send at once above RateLimit
+
+	// Commands slower than SlowThreshold are kept in slow log,
+	// up to SlowLogSize of them. Zero threshold disables it.
+	SlowThreshold time.Duration
+	SlowLogSize   int
+
+	mu      sync.RWMutex
+	closed  bool
+	started time.Time
+	workers sync.WaitGroup
+
+	// opened connections
+	conns   map[*Conn]struct{}
+	connsWg sync.WaitGroup
+
+	// connected replicas
+	replicas map[*ReplicaStatus]struct{}
+
+	slow     slowLog
+	limiter  rateLimiter
+	inFlight atomic.Int64
 }
 
-func NewServer() *Server {
+func NewServer(db *db.DB) *Server {
 	s := &Server{
-		Workers:     make([]Worker, 1_000),
-		Collections: make(map[uint64]*db.Collection),
+		DB:           db,
+		MaxKeySize:   1 << 10,
+		MaxValueSize: 1 << 20,
+		QueueSize:    DefaultQueueSize,
+		SlowLogSize:  DefaultSlowLogSize,
+		started:      time.Now(),
+		conns:        make(map[*Conn]struct{}),
+		replicas:     make(map[*ReplicaStatus]struct{}),
 	}
 
 	return s
@@ -25,34 +77,428 @@ func NewServer() *Server {
 // Run workers, each one in separate goroutine
 func (s *Server) RunWorkers(n int) {
 	for i := 0; i < n; i++ {
-		w := Worker{}
+		w := NewWorker(s.DB, s.QueueSize)
 		s.Workers = append(s.Workers, w)
 
-		go w.Run()
+		s.workers.Add(1)
+		go w.Run(&s.workers)
 	}
 }
 
-// Send job to worker
-func (s *Server) SendToWorker(cmd *Cmd) error {
-	coll, err := s.Collection(cmd.Collection)
-	if err != nil {
-		return err
+// Send job to worker. All commands for given collection
+// are handled by the same worker. ErrBusy is returned if
+// worker queue is full.
+func (s *Server) SendToWorker(cmd *Cmd, conn *Conn) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	w := s.Workers[cmd.Collection%uint64(len(s.Workers))]
+
+	// Full queue means worker can't keep up, fail fast.
+	select {
+	case w.jobs <- &Job{Cmd: cmd, Resp: conn.Resp, Queued: time.Now()}:
+	default:
+		rejectedQueue.Inc()
+		return fmt.Errorf("%w: worker queue is full", ErrBusy)
 	}
 
-	log.Println(coll)
 	return nil
 }
 
+// Execute command and return its response. This is the only entry
+// point for commands, used by all protocols.
+func (s *Server) Exec(conn *Conn, cmd *Cmd) *Resp {
+	start := time.Now()
+
+	resp := s.exec(conn, cmd)
+	observeCmd(cmd.Type, resp.Status, start)
+	s.logSlow(conn, cmd, resp, start)
+
+	return resp
+}
+
+func (s *Server) exec(conn *Conn, cmd *Cmd) *Resp {
+	if len(cmd.Key) > s.MaxKeySize {
+		return invalidResp("key too big: %d bytes, max %d", len(cmd.Key), s.MaxKeySize)
+	}
+
+	size := len(cmd.Data)
+	if cmd.Type == CmdSet {
+		req, err := DecodeSetReq(cmd.Data)
+		if err != nil {
+			return invalidResp("%s", err)
+		}
+
+		if len(req.ContentType) > MaxContentType {
+			return invalidResp("content type too long: %d bytes, max %d", len(req.ContentType), MaxContentType)
+		}
+
+		size = len(req.Value)
+	}
+
+	if size > s.MaxValueSize {
+		return invalidResp("value too big: %d bytes, max %d", size, s.MaxValueSize)
+	}
+
+	err := s.Authorize(conn, cmd)
+	if err != nil {
+		return &Resp{Status: StatusDenied, Data: []byte(err.Error())}
+	}
+
+	if s.ReadOnly && isWrite(cmd.Type) {
+		return &Resp{Status: StatusReadOnly, Data: []byte(ErrReadOnly.Error())}
+	}
+
+	done, err := s.admit(conn)
+	if err != nil {
+		return busyResp(err)
+	}
+	defer done()
+
+	switch cmd.Type {
+	case CmdAdd, CmdGet, CmdDelete, CmdScan, CmdSet, CmdIncr, CmdExpire, CmdLookup:
+		// send the request to the file worker
+		err := s.SendToWorker(cmd, conn)
+		if errors.Is(err, ErrBusy) {
+			return busyResp(err)
+		}
+
+		if err != nil {
+			return errResp(err)
+		}
+
+		return <-conn.Resp
+
+	case CmdAuth, CmdUser, CmdDeleteUser, CmdToken, CmdRevokeToken, CmdGrant:
+		// handled right away, they don't touch main database
+		return s.RunAuthCmd(cmd, conn)
+
+	case CmdPing:
+		return &Resp{Status: StatusOK}
+
+	case CmdCollections:
+		hashes, err := s.DB.Collections()
+		if err != nil {
+			return errResp(err)
+		}
+
+		return &Resp{Status: StatusOK, Data: EncodeCollections(hashes)}
+
+	case CmdStats:
+		stats, err := s.Stats()
+		if err != nil {
+			return errResp(err)
+		}
+
+		return &Resp{Status: StatusOK, Data: stats.Encode()}
+
+	case CmdQuota:
+		info := &QuotaInfo{}
+
+		info.Quota, err = s.DB.Quota(cmd.Collection)
+		if err == nil {
+			info.Usage, err = s.DB.Usage(cmd.Collection)
+		}
+
+		if err != nil {
+			return errResp(err)
+		}
+
+		return &Resp{Status: StatusOK, Data: info.Encode()}
+
+	case CmdSetQuota:
+		q, err := DecodeQuota(cmd.Data)
+		if err != nil {
+			return invalidResp("%s", err)
+		}
+
+		err = s.DB.SetQuota(cmd.Collection, q)
+		if err != nil {
+			return errResp(err)
+		}
+
+		return &Resp{Status: StatusOK}
+
+	case CmdSlowLog:
+		req, err := DecodeSlowLogReq(cmd.Data)
+		if err != nil {
+			return invalidResp("%s", err)
+		}
+
+		if req.Reset == 1 {
+			s.ResetSlowLog()
+			return &Resp{Status: StatusOK}
+		}
+
+		count := int(req.Count)
+		if count == 0 {
+			count = max(s.SlowLogSize, 1)
+		}
+
+		return &Resp{Status: StatusOK, Data: EncodeSlowLog(s.SlowLog(count))}
+
+	case CmdBackup, CmdReplicate, CmdWatch:
+		return invalidResp("command is supported only by binary protocol")
+	}
+
+	return invalidResp("unknown command: %d", cmd.Type)
+}
+
+// Check if command changes data. User management is a write too,
+// users are stored in replicated internal database.
+func isWrite(typ uint8) bool {
+	switch typ {
+	case CmdAdd, CmdDelete, CmdSet, CmdIncr, CmdExpire, CmdUser, CmdDeleteUser, CmdToken, CmdRevokeToken, CmdGrant, CmdSetQuota:
+		return true
+	}
+
+	return false
+}
+
+// Return server and database statistics
+func (s *Server) Stats() (*Stats, error) {
+	dbStats, err := s.DB.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := &Stats{
+		Uptime:      int64(time.Since(s.started).Seconds()),
+		Connections: uint32(len(s.conns)),
+		Workers:     uint32(len(s.Workers)),
+		Collections: dbStats.Collections,
+		Buckets:     dbStats.Buckets,
+		Size:        dbStats.Size,
+		LSN:         dbStats.LSN,
+	}
+
+	return stats, nil
+}
+
 // Return collection for the given hash
 func (s *Server) Collection(hash uint64) (*db.Collection, error) {
-	// get collection from memory
-	col, ok := s.Collections[hash]
-	if !ok {
-		return col, nil
+	return s.DB.Collection(hash)
+}
+
+// Handle binary protocol connection, until client closes it
+// or read fails. Each command is answered before next one is read.
+func (s *Server) HandleConn(conn *Conn) error {
+	for {
+		data, err := conn.ReadFrame(CmdHeaderSize + s.MaxKeySize + s.MaxValueSize)
+		if errors.Is(err, ErrFrameTooBig) {
+			// Rest of the frame can't be skipped safely.
+			conn.Write(invalidResp("%s", err).Encode())
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Frame was read whole, so connection can go on.
+		cmd, err := DecodeCmd(bit.NewBuffer(data))
+		if err != nil {
+			_, err = conn.Write(invalidResp("%s", err).Encode())
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// Backup is answered with many responses.
+		if cmd.Type == CmdBackup {
+			err = s.Backup(conn, cmd)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// Connection is used only for replication from now on.
+		if cmd.Type == CmdReplicate {
+			return s.Replicate(conn, cmd)
+		}
+
+		// The same for watching changes.
+		if cmd.Type == CmdWatch {
+			return s.Watch(conn, cmd)
+		}
+
+		err = writeResp(conn, s.Exec(conn, cmd), cmd.Compact)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Write response using pooled buffer.
+func writeResp(conn *Conn, resp *Resp, compact bool) error {
+	e := bit.GetEncoder()
+	defer bit.PutEncoder(e)
+
+	e.Compact = compact
+	resp.Append(e)
+
+	_, err := conn.Write(e.Data())
+	return err
+}
+
+// Stream database backup to connection. Archive is sent in chunks, each
+// one in separate OK response, empty response ends the stream. Error
+// response can come at any point and ends the stream too.
+// Return error only if connection failed.
+func (s *Server) Backup(conn *Conn, cmd *Cmd) error {
+	err := s.Authorize(conn, cmd)
+	if err != nil {
+		_, err = conn.Write((&Resp{Status: StatusDenied, Data: []byte(err.Error())}).Encode())
+		return err
+	}
+
+	w := &chunkWriter{conn: conn, buf: make([]byte, 0, BackupChunkSize)}
+
+	err = s.DB.Backup(w)
+	if err == nil {
+		err = w.flush()
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
+	resp := &Resp{Status: StatusOK}
+	if err != nil {
+		resp = errResp(err)
+	}
+
+	_, err = conn.Write(resp.Encode())
+	return err
+}
+
+// Writer sending data to connection in OK responses of fixed size.
+type chunkWriter struct {
+	conn *Conn
+	buf  []byte
+	err  error // connection error, it's sticky
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		size := min(len(p), cap(w.buf)-len(w.buf))
+		w.buf = append(w.buf, p[:size]...)
+		p = p[size:]
+
+		if len(w.buf) < cap(w.buf) {
+			continue
+		}
+
+		err := w.flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
+// Send buffered data
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 || w.err != nil {
+		return w.err
+	}
+
+	_, w.err = w.conn.Write((&Resp{Status: StatusOK, Data: w.buf}).Encode())
+	w.buf = w.buf[:0]
+
+	return w.err
+}
+
+// Register connection, so it can be drained on shutdown.
+// Connection is rejected with ErrBusy if there are already
+// MaxConns of them.
+func (s *Server) AddConn(conn *Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.MaxConns > 0 && len(s.conns) >= s.MaxConns {
+		rejectedConns.Inc()
+		return fmt.Errorf("%w: too many connections, max %d", ErrBusy, s.MaxConns)
+	}
+
+	s.conns[conn] = struct{}{}
+	s.connsWg.Add(1)
+
+	return nil
+}
+
+// Unregister connection. Must be called when connection handler returns.
+func (s *Server) RemoveConn(conn *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+	s.connsWg.Done()
+}
+
+// Drain connections. Idle connections are interrupted right away, busy ones
+// can finish their current command. If ctx expires before all handlers
+// return, remaining connections are closed and ctx error is returned.
+func (s *Server) Drain(ctx context.Context) error {
+	s.mu.RLock()
+	for conn := range s.conns {
+		// Unblock pending reads, handlers will return after
+		// sending response for command they are running.
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.RUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connsWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.RLock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.RUnlock()
+
+	return ctx.Err()
+}
+
+// Stop workers, waiting for queued commands to finish, and close database.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+
+	s.closed = true
+	for _, w := range s.Workers {
+		close(w.jobs)
 	}
+	s.mu.Unlock()
 
-	// if that failed, read it from disk
-	return nil, nil
+	s.workers.Wait()
+	return s.DB.Close()
 }
 
 // Run TCP server.
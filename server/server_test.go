@@ -1,17 +1,95 @@
 package server
 
 import (
-	"fmt"
+	"bytedb/db"
+	bit "bytedb/lib/bitbox"
+	"bytedb/tests"
+	"context"
+	"strings"
 	"testing"
 )
 
-func TestAdd(t *testing.T) {
-	cli, err := NewClient("127.0.0.1:6666")
+// Run server on random port and return it with its address.
+func runServer(t *testing.T) (*Server, string) {
+	database, err := db.Open(t.TempDir())
 	if err != nil {
-		fmt.Println(err)
-		return
+		t.Fatal(err)
 	}
 
-	res, err := cli.Add("test::cmd::prefix::key_1", []byte("Hello"))
-	fmt.Println(res, err)
+	srv := NewServer(database)
+	srv.RunWorkers(2)
+
+	sock, err := Run("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		sock.Close()
+		srv.Drain(context.Background())
+		srv.Close()
+	})
+
+	go func() {
+		for {
+			c, err := sock.Accept()
+			if err != nil {
+				return
+			}
+
+			conn := NewConn(c)
+			srv.AddConn(conn)
+
+			go func() {
+				defer srv.RemoveConn(conn)
+				defer conn.Close()
+
+				srv.HandleConn(conn)
+			}()
+		}
+	}()
+
+	return srv, sock.Addr().String()
+}
+
+func TestAdd(t *testing.T) {
+	_, addr := runServer(t)
+
+	cli, err := NewClient(addr)
+	tests.Assert(t, nil, err)
+	defer cli.Close()
+
+	err = cli.Add(context.Background(), NewKeyRef("test", "cmd", "prefix", []byte("key_1")), []byte("Hello"))
+	tests.Assert(t, nil, err)
+
+	val, _ := cli.Get(context.Background(), NewKeyRef("test", "cmd", "prefix", []byte("key_1")))
+	tests.AssertEqual(t, []byte("Hello"), val)
+}
+
+func TestMalformedCmd(t *testing.T) {
+	_, addr := runServer(t)
+
+	conn, err := Connect(addr)
+	tests.Assert(t, nil, err)
+	defer conn.Close()
+
+	// Key length points past the end of frame
+	data := append([]byte{CmdGet}, make([]byte, 24)...)
+	data = append(data, 0xff, 0xff, 0xff, 0x7f)
+
+	conn.Write(bit.Encode(data))
+
+	frame, err := conn.ReadFrame(1024)
+	tests.Assert(t, nil, err)
+
+	resp := DecodeResp(bit.NewBuffer(frame))
+	tests.Assert(t, StatusInvalid, resp.Status)
+	tests.Assert(t, true, strings.HasPrefix(string(resp.Data), "malformed command"))
+
+	// Connection is still usable
+	conn.Write(EncodeCmd(&Cmd{Type: CmdGet, Key: []byte("key_1")}))
+
+	frame, err = conn.ReadFrame(1024)
+	tests.Assert(t, nil, err)
+	tests.Assert(t, StatusNotFound, DecodeResp(bit.NewBuffer(frame)).Status)
 }
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytedb/db"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSlowLogSize = 128 // number of kept slow commands
+	SlowLogMaxKey      = 128 // longer keys are truncated
+)
+
+// Bounded log of slow commands, the oldest ones are dropped.
+type slowLog struct {
+	mu      sync.Mutex
+	entries []SlowEntry
+	id      uint64
+}
+
+// Timing of command executed by worker, returned in its response.
+type cmdTrace struct {
+	queue time.Duration
+	db.Trace
+}
+
+// Add command to slow log if it took longer than threshold.
+func (s *Server) logSlow(conn *Conn, cmd *Cmd, resp *Resp, start time.Time) {
+	d := time.Since(start)
+	if s.SlowThreshold <= 0 || d < s.SlowThreshold {
+		return
+	}
+
+	e := SlowEntry{
+		Time:     start.UnixNano(),
+		Addr:     conn.RemoteAddr(),
+		Cmd:      cmd.Type,
+		Key:      slices.Clone(cmd.Key[:min(len(cmd.Key), SlowLogMaxKey)]),
+		Duration: d,
+	}
+
+	if resp.trace != nil {
+		e.Queue = resp.trace.queue
+		e.Index = resp.trace.Index
+		e.Blocks = resp.trace.Blocks
+		e.Wal = resp.trace.Wal
+	}
+
+	l := &s.slow
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.id++
+	e.ID = l.id
+
+	l.entries = append(l.entries, e)
+	if len(l.entries) > max(s.SlowLogSize, 1) {
+		l.entries = l.entries[len(l.entries)-max(s.SlowLogSize, 1):]
+	}
+}
+
+// Return up to count slow commands, the newest first.
+func (s *Server) SlowLog(count int) []SlowEntry {
+	l := &s.slow
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := []SlowEntry{}
+	for i := len(l.entries) - 1; i >= 0 && len(list) < count; i-- {
+		list = append(list, l.entries[i])
+	}
+
+	return list
+}
+
+// Remove all entries from slow log.
+func (s *Server) ResetSlowLog() {
+	s.slow.mu.Lock()
+	defer s.slow.mu.Unlock()
+
+	s.slow.entries = nil
+}
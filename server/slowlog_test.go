@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytedb/tests"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	srv, addr := runServer(t)
+	ctx := context.Background()
+
+	srv.SlowThreshold = time.Nanosecond
+	srv.SlowLogSize = 2
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	cli.Ping(ctx)
+	cli.Add(ctx, ref.WithString("key"), []byte("val"))
+	cli.Get(ctx, ref.WithString("key"))
+
+	// Only the newest commands are kept.
+	entries, err := cli.SlowLog(ctx, 0)
+	tests.Assert(t, nil, err)
+	tests.Assert(t, 2, len(entries))
+
+	get, add := entries[0], entries[1]
+
+	tests.Assert(t, CmdGet, get.Cmd)
+	tests.AssertEqual(t, []byte("key"), get.Key)
+	tests.Assert(t, true, get.Index > 0)
+	tests.Assert(t, add.ID+1, get.ID)
+
+	tests.Assert(t, CmdAdd, add.Cmd)
+	tests.Assert(t, true, add.Wal > 0)
+	tests.Assert(t, true, add.Duration >= add.Queue+add.Index+add.Blocks+add.Wal)
+	tests.AssertNot(t, "", add.Addr)
+
+	entries, _ = cli.SlowLog(ctx, 1)
+	tests.Assert(t, 1, len(entries))
+	tests.Assert(t, CmdSlowLog, entries[0].Cmd)
+
+	// Reset itself is logged after it's done.
+	tests.Assert(t, nil, cli.ResetSlowLog(ctx))
+	tests.Assert(t, 1, len(srv.SlowLog(10)))
+
+	// Disabled by zero threshold
+	srv.SlowThreshold = 0
+	cli.Ping(ctx)
+	tests.Assert(t, 1, len(srv.SlowLog(10)))
+}
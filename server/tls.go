@@ -0,0 +1,168 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// How often certificate files are checked for changes.
+var TLSReloadInterval = time.Second
+
+// TLS options for server listener
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+
+	// If set, clients must present certificate signed by one of CAs from this bundle.
+	ClientCAFile string
+}
+
+// TLS config for listener. Certificate and client CA bundle are reloaded
+// during handshake when any of their files changes, so certificates can
+// be rotated without restart.
+func NewTLSConfig(opts *TLSOptions) (*tls.Config, error) {
+	r := &tlsReloader{opts: opts}
+
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.config,
+	}
+
+	return cfg, nil
+}
+
+// Run TCP server with TLS.
+func RunTLS(address string, cfg *tls.Config) (net.Listener, error) {
+	sock, err := Run(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(sock, cfg), nil
+}
+
+// Keeps TLS config in sync with files on disk.
+type tlsReloader struct {
+	opts *TLSOptions
+
+	mu      sync.RWMutex
+	cfg     *tls.Config
+	files   []fileState // state of files config was loaded from
+	checked time.Time   // last time files were checked
+}
+
+// Modification time and size of file, any change of them means file changed.
+type fileState struct {
+	modTime int64
+	size    int64
+}
+
+// Return current config, reloading it if files changed.
+func (r *tlsReloader) config(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.RLock()
+	cfg, checked := r.cfg, r.checked
+	r.mu.RUnlock()
+
+	if time.Since(checked) < TLSReloadInterval {
+		return cfg, nil
+	}
+
+	err := r.load()
+	if err != nil {
+		// Keep serving old certificate, new one might be written partially.
+		return cfg, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cfg, nil
+}
+
+// Load certificate and CA bundle if any of files changed since last load.
+// Files replaced with older ones (ex. rollback) are reloaded as well.
+func (r *tlsReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checked = time.Now()
+
+	files, err := statFiles(r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile)
+	if err != nil {
+		return err
+	}
+
+	if r.cfg != nil && slices.Equal(files, r.files) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.opts.ClientCAFile != "" {
+		cfg.ClientCAs, err = LoadCertPool(r.opts.ClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.cfg = cfg
+	r.files = files
+
+	return nil
+}
+
+// Load PEM encoded CA bundle.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// Return state of given files, empty paths are skipped.
+func statFiles(paths ...string) ([]fileState, error) {
+	files := []fileState{}
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, fileState{info.ModTime().UnixNano(), info.Size()})
+	}
+
+	return files, nil
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytedb/tests"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Generate certificate signed by parent, self-signed if parent is nil.
+// Write cert and key to dir/name.crt and dir/name.key.
+func genCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// Run TLS server answering "ok" after successful handshake.
+func runTLS(t *testing.T, opts *TLSOptions) string {
+	cfg, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sock, err := RunTLS("127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { sock.Close() })
+
+	go func() {
+		for {
+			c, err := sock.Accept()
+			if err != nil {
+				return
+			}
+
+			if c.(*tls.Conn).Handshake() == nil {
+				c.Write([]byte("ok"))
+			}
+
+			c.Close()
+		}
+	}()
+
+	return sock.Addr().String()
+}
+
+// Connect and check if server accepted us.
+func handshake(addr string, opts *ClientOptions) bool {
+	cfg, err := opts.TLSConfig()
+	if err != nil {
+		return false
+	}
+
+	conn, err := ConnectTLS(addr, cfg)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 2)
+	_, err = conn.conn.Read(buf)
+
+	return err == nil && string(buf) == "ok"
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, caKey := genCert(t, dir, "ca", nil, nil)
+	genCert(t, dir, "server", ca, caKey)
+
+	addr := runTLS(t, &TLSOptions{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	})
+
+	ok := handshake(addr, &ClientOptions{TLS: true, CAFile: filepath.Join(dir, "ca.crt")})
+	tests.Assert(t, true, ok)
+
+	// Server is not trusted
+	ok = handshake(addr, &ClientOptions{TLS: true})
+	tests.Assert(t, false, ok)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, caKey := genCert(t, dir, "ca", nil, nil)
+	genCert(t, dir, "server", ca, caKey)
+	genCert(t, dir, "client", ca, caKey)
+
+	addr := runTLS(t, &TLSOptions{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	})
+
+	ok := handshake(addr, &ClientOptions{
+		TLS:      true,
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+	})
+	tests.Assert(t, true, ok)
+
+	// No client certificate
+	ok = handshake(addr, &ClientOptions{TLS: true, CAFile: filepath.Join(dir, "ca.crt")})
+	tests.Assert(t, false, ok)
+}
+
+func TestTLSReload(t *testing.T) {
+	TLSReloadInterval = 0
+	defer func() { TLSReloadInterval = time.Second }()
+
+	dir := t.TempDir()
+
+	ca, caKey := genCert(t, dir, "ca", nil, nil)
+	genCert(t, dir, "server", ca, caKey)
+
+	addr := runTLS(t, &TLSOptions{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	})
+
+	// Rotate CA and server certificate
+	ca, caKey = genCert(t, dir, "new_ca", nil, nil)
+	genCert(t, dir, "server", ca, caKey)
+
+	ok := handshake(addr, &ClientOptions{TLS: true, CAFile: filepath.Join(dir, "new_ca.crt")})
+	tests.Assert(t, true, ok)
+}
+
+func TestTLSReloadRollback(t *testing.T) {
+	TLSReloadInterval = 0
+	defer func() { TLSReloadInterval = time.Second }()
+
+	dir := t.TempDir()
+	now := time.Now()
+
+	ca, caKey := genCert(t, dir, "ca", nil, nil)
+	genCert(t, dir, "server", ca, caKey)
+	genCert(t, dir, "client", ca, caKey)
+
+	other, otherKey := genCert(t, dir, "other_ca", nil, nil)
+	genCert(t, dir, "other_client", other, otherKey)
+
+	// Server certificate is the newest file
+	bundle := filepath.Join(dir, "clients.crt")
+	data, _ := os.ReadFile(filepath.Join(dir, "other_ca.crt"))
+	os.WriteFile(bundle, data, 0644)
+	os.Chtimes(bundle, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+
+	for _, name := range []string{"server.crt", "server.key"} {
+		os.Chtimes(filepath.Join(dir, name), now.Add(-time.Hour), now.Add(-time.Hour))
+	}
+
+	addr := runTLS(t, &TLSOptions{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: bundle,
+	})
+
+	client := func(name string) *ClientOptions {
+		return &ClientOptions{
+			TLS:      true,
+			CAFile:   filepath.Join(dir, "ca.crt"),
+			CertFile: filepath.Join(dir, name+".crt"),
+			KeyFile:  filepath.Join(dir, name+".key"),
+		}
+	}
+
+	tests.Assert(t, true, handshake(addr, client("other_client")))
+	tests.Assert(t, false, handshake(addr, client("client")))
+
+	// Roll back CA bundle to older one, the newest file stays the same
+	data, _ = os.ReadFile(filepath.Join(dir, "ca.crt"))
+	os.WriteFile(bundle, data, 0644)
+	os.Chtimes(bundle, now.Add(-3*time.Hour), now.Add(-3*time.Hour))
+
+	tests.Assert(t, true, handshake(addr, client("client")))
+	tests.Assert(t, false, handshake(addr, client("other_client")))
+}
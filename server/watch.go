@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytedb/db"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Stream changes of keys to client. Events are sent in OK responses, empty
+// response is a heartbeat sent every ReplicateHeartbeat. Error response ends
+// the stream, for example when client asks for changes that aren't retained
+// anymore. Client doesn't send anything, it closes connection to stop.
+// Stream runs until connection fails, so error is always returned.
+func (s *Server) Watch(conn *Conn, cmd *Cmd) error {
+	err := s.Authorize(conn, cmd)
+	if err != nil {
+		_, err = conn.Write((&Resp{Status: StatusDenied, Data: []byte(err.Error())}).Encode())
+		if err != nil {
+			return err
+		}
+
+		return ErrForbidden
+	}
+
+	req, err := DecodeWatchReq(cmd.Data)
+	if err != nil {
+		_, werr := conn.Write(invalidResp("%s", err).Encode())
+		if werr != nil {
+			return werr
+		}
+
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Reading fails when client is gone.
+	go func() {
+		defer cancel()
+
+		for {
+			_, err := conn.ReadFrame(0)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	sc := &streamConn{conn: conn, last: time.Now()}
+	go sc.heartbeat(ctx)
+
+	err = s.DB.Watch(ctx, cmd.Collection, cmd.Namespace, cmd.Prefix, req.LSN, func(events []db.Event) error {
+		events, err := s.readable(conn, events)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		return sc.write(&Resp{Status: StatusOK, Data: EncodeEvents(events)})
+	})
+
+	if ctx.Err() == nil {
+		sc.write(errResp(err))
+	}
+
+	return fmt.Errorf("watch stopped: %w", err)
+}
+
+// Return events user of connection can read. Filter of watch can match
+// many collections and namespaces, so each event is checked on its own.
+// User is reloaded first, so stream stops once it's deleted.
+func (s *Server) readable(conn *Conn, events []db.Event) ([]db.Event, error) {
+	if s.Auth == nil {
+		return events, nil
+	}
+
+	err := s.Auth.Refresh(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if conn.User == nil {
+		return nil, ErrAuthRequired
+	}
+
+	allowed := events[:0]
+
+	for _, e := range events {
+		if conn.User.Perm(e.Collection, e.Namespace) >= PermRead {
+			allowed = append(allowed, e)
+		}
+	}
+
+	return allowed, nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytedb/db"
+	"bytedb/tests"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	srv, addr := runServer(t)
+	ctx := context.Background()
+
+	cli, _ := NewClient(addr)
+	defer cli.Close()
+
+	users := PrefixRef("users", "eu", "active")
+	other := PrefixRef("users", "eu", "inactive")
+
+	cli.Add(ctx, users.WithString("john"), []byte("val_1"))
+	cli.Add(ctx, other.WithString("anna"), []byte("val_2"))
+
+	wctx, cancel := context.WithCancel(ctx)
+	events := make(chan db.Event, 10)
+	done := make(chan error)
+
+	go func() {
+		done <- cli.Watch(wctx, users, 0, func(list []db.Event) error {
+			for _, e := range list {
+				events <- e
+			}
+
+			return nil
+		})
+	}()
+
+	cli.Delete(ctx, users.WithString("john"))
+
+	e := <-events
+	tests.AssertEqual(t, []byte("john"), e.Key)
+	tests.AssertEqual(t, []byte("val_1"), e.Value)
+	tests.Assert(t, false, e.Delete)
+
+	e = <-events
+	tests.AssertEqual(t, []byte("john"), e.Key)
+	tests.Assert(t, true, e.Delete)
+	tests.Assert(t, srv.DB.LSN(), e.LSN)
+
+	cancel()
+	tests.Assert(t, context.Canceled, <-done)
+
+	// Whole namespace, resumed after first event
+	stop := errors.New("stop")
+
+	err := cli.Watch(ctx, PrefixRef("users", "eu", ""), 1, func(list []db.Event) error {
+		tests.Assert(t, 2, len(list))
+		tests.AssertEqual(t, []byte("anna"), list[0].Key)
+		return stop
+	})
+
+	tests.Assert(t, stop, err)
+}
+
+func TestWatchPerm(t *testing.T) {
+	orders, secret := Hash([]byte("orders")), Hash([]byte("secret"))
+
+	u := &User{Name: "john", Rules: []Rule{
+		{Collection: Any, Namespace: Any, Perm: PermRead},
+		{Collection: secret, Namespace: Any, Perm: PermNone},
+	}}
+
+	srv := &Server{Auth: openAuth(t)}
+	conn := &Conn{User: u, auth: session{lsn: srv.Auth.db.LSN()}}
+
+	// Watching everything is allowed, denied collection is filtered out
+	tests.Assert(t, nil, srv.Authorize(conn, &Cmd{Type: CmdWatch}))
+
+	events, _ := srv.readable(conn, []db.Event{
+		{Collection: orders, Key: []byte("key_1")},
+		{Collection: secret, Key: []byte("key_2")},
+	})
+
+	tests.Assert(t, 1, len(events))
+	tests.AssertEqual(t, []byte("key_1"), events[0].Key)
+}
@@ -1,20 +1,217 @@
 package server
 
 import (
-	"log"
+	"bytedb/db"
+	"bytedb/db/wal"
+	bit "bytedb/lib/bitbox"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
 )
 
+var (
+	ErrNotInteger   = errors.New("value is not an integer or out of range")
+	ErrIncrOverflow = errors.New("increment or decrement would overflow")
+)
+
+// Single command waiting for execution
+type Job struct {
+	Cmd    *Cmd
+	Resp   chan *Resp
+	Queued time.Time // when job was sent to worker
+}
+
 // Worker responsible for file operations
 type Worker struct {
-	jobs chan []byte
+	db   *db.DB
+	jobs chan *Job
+}
+
+// Create worker with queue for given number of jobs.
+func NewWorker(db *db.DB, queue int) *Worker {
+	return &Worker{db: db, jobs: make(chan *Job, max(queue, 1))}
+}
+
+// Run worker until jobs channel is closed
+func (w *Worker) Run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for job := range w.jobs {
+		trace := &cmdTrace{queue: time.Since(job.Queued)}
+
+		resp := w.exec(job.Cmd, &trace.Trace)
+		resp.trace = trace
+
+		job.Resp <- resp
+	}
 }
 
-// Run worker
-func (w *Worker) Run() {
-	for {
-		select {
-		case job := <-w.jobs:
-			log.Println("got new job: ", job)
+// Execute command
+func (w *Worker) exec(cmd *Cmd, trace *db.Trace) *Resp {
+	key := db.NewKey(cmd.Key, cmd.Data)
+	key.Collection = cmd.Collection
+	key.Namespace = cmd.Namespace
+	key.Prefix = cmd.Prefix
+	key.Trace = trace
+
+	switch cmd.Type {
+	case CmdAdd:
+		err := w.db.Put(key)
+		if err != nil {
+			return errResp(err)
+		}
+
+		return &Resp{Status: StatusOK}
+
+	case CmdGet:
+		val, err := w.db.Get(key)
+		if err != nil {
+			return errResp(err)
+		}
+
+		if val == nil {
+			return &Resp{Status: StatusNotFound}
+		}
+
+		return &Resp{Status: StatusOK, Data: val}
+
+	case CmdDelete:
+		ok, err := w.db.DeleteKey(key)
+		if err != nil {
+			return errResp(err)
+		}
+
+		if !ok {
+			return &Resp{Status: StatusNotFound}
+		}
+
+		return &Resp{Status: StatusOK}
+
+	case CmdScan:
+		req, err := DecodeScanReq(cmd.Data)
+		if err != nil {
+			return invalidResp("%s", err)
+		}
+
+		if req.Count == 0 {
+			req.Count = ScanCount
+		}
+
+		req.Count = min(req.Count, ScanMaxCount)
+
+		keys, next, err := w.db.Scan(cmd.Collection, cmd.Namespace, cmd.Prefix, req.Cursor, int(req.Count))
+		if err != nil {
+			return errResp(err)
+		}
+
+		res := &ScanResp{Cursor: next}
+		for _, k := range keys {
+			res.Entries = append(res.Entries, Entry{Key: k.Name, Value: k.Value})
+		}
+
+		return &Resp{Status: StatusOK, Data: res.Encode()}
+
+	case CmdSet:
+		req, err := DecodeSetReq(cmd.Data)
+		if err != nil {
+			return invalidResp("%s", err)
+		}
+
+		key.Value, key.Expire, key.ContentType = req.Value, req.Expire, req.ContentType
+
+		ok, err := w.db.Update(key, func(old *db.Key) (uint8, error) {
+			if (req.Cond == SetNX && old != nil) || (req.Cond == SetXX && old == nil) {
+				return 0, nil
+			}
+
+			return wal.RecPut, nil
+		})
+
+		return updateResp(ok, err, nil)
+
+	case CmdIncr:
+		n := int64(0)
+
+		ok, err := w.db.Update(key, func(old *db.Key) (uint8, error) {
+			n, key.Expire = 0, 0
+
+			if old != nil {
+				v, err := strconv.ParseInt(string(old.Value), 10, 64)
+				if err != nil {
+					return 0, ErrNotInteger
+				}
+
+				n, key.Expire = v, old.Expire
+			}
+
+			if n == math.MaxInt64 {
+				return 0, ErrIncrOverflow
+			}
+
+			n++
+			key.Value = []byte(strconv.FormatInt(n, 10))
+
+			return wal.RecPut, nil
+		})
+
+		return updateResp(ok, err, bit.Encode(&n))
+
+	case CmdExpire:
+		expire := int64(0)
+
+		err := decodeData(cmd.Data, &expire)
+		if err != nil {
+			return invalidResp("%s", err)
 		}
+
+		ok, err := w.db.Update(key, func(old *db.Key) (uint8, error) {
+			if old == nil {
+				return 0, nil
+			}
+
+			key.Value, key.Expire, key.ContentType = old.Value, expire, old.ContentType
+
+			if key.Expired() {
+				return wal.RecDelete, nil
+			}
+
+			return wal.RecPut, nil
+		})
+
+		return updateResp(ok, err, nil)
+
+	case CmdLookup:
+		kv, err := w.db.Lookup(key)
+		if err != nil {
+			return errResp(err)
+		}
+
+		if kv == nil {
+			return &Resp{Status: StatusNotFound}
+		}
+
+		return &Resp{Status: StatusOK, Data: (&LookupResp{Value: kv.Value, Expire: kv.Expire, ContentType: kv.ContentType}).Encode()}
 	}
+
+	return errResp(fmt.Errorf("unknown command: %d", cmd.Type))
+}
+
+// Return response for db.Update, NotFound if key wasn't changed.
+func updateResp(ok bool, err error, data []byte) *Resp {
+	if errors.Is(err, ErrNotInteger) || errors.Is(err, ErrIncrOverflow) {
+		return invalidResp("%s", err)
+	}
+
+	if err != nil {
+		return errResp(err)
+	}
+
+	if !ok {
+		return &Resp{Status: StatusNotFound}
+	}
+
+	return &Resp{Status: StatusOK, Data: data}
 }